@@ -0,0 +1,107 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package buildinfo holds the version of the service it is linked into.
+// Services set it at build time with the linker, e.g.
+//
+//     go build -ldflags "\
+//         -X github.com/PhysarumSM/common/buildinfo.Service=registry \
+//         -X github.com/PhysarumSM/common/buildinfo.Version=v1.2.0 \
+//         -X github.com/PhysarumSM/common/buildinfo.Commit=$(git rev-parse HEAD) \
+//         -X github.com/PhysarumSM/common/buildinfo.BuildDate=$(date -u +%FT%TZ)"
+//
+// p2pnode reports it in the node's identify user agent, and to peers
+// querying the node's status (see p2pnode.Node.QueryStatus()).
+package buildinfo
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "runtime/debug"
+)
+
+// Set with -ldflags "-X ..." at build time
+var (
+    // Name of the service. Defaults to the executable's name.
+    Service     string
+    // Defaults to the main module's version, if built in module mode
+    Version     string
+    Commit      string
+    // Preferably in RFC 3339 format
+    BuildDate   string
+)
+
+const (
+    // Reported for fields that were not set at build time
+    Unknown = "unknown"
+)
+
+// Build information of the running service
+type Info struct {
+    Service     string
+    Version     string
+    Commit      string
+    BuildDate   string
+    GoVersion   string
+}
+
+// Returns the build information of the running service, filling in what
+// was not set at build time where possible
+func Get() Info {
+    info := Info{
+        Service:    Service,
+        Version:    Version,
+        Commit:     Commit,
+        BuildDate:  BuildDate,
+        GoVersion:  runtime.Version(),
+    }
+
+    if info.Service == "" {
+        info.Service = filepath.Base(os.Args[0])
+    }
+    if info.Version == "" {
+        if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+            info.Version = bi.Main.Version
+        }
+    }
+
+    if info.Version == "" {
+        info.Version = Unknown
+    }
+    if info.Commit == "" {
+        info.Commit = Unknown
+    }
+    if info.BuildDate == "" {
+        info.BuildDate = Unknown
+    }
+    return info
+}
+
+// Returns the build information as a user agent string, e.g.
+// "registry/v1.2.0 (3f2a1c9)"
+func (info Info) UserAgent() string {
+    commit := info.Commit
+    if len(commit) > 7 {
+        commit = commit[:7]
+    }
+    return fmt.Sprintf("%s/%s (%s)", info.Service, info.Version, commit)
+}
+
+func (info Info) String() string {
+    return fmt.Sprintf("%s %s (commit %s, built %s, %s)", info.Service,
+        info.Version, info.Commit, info.BuildDate, info.GoVersion)
+}
@@ -0,0 +1,31 @@
+package buildinfo
+
+import (
+    "testing"
+)
+
+func TestGetDefaults(test *testing.T) {
+    info := Get()
+    if info.Service == "" {
+        test.Errorf("Service should default to the executable's name")
+    }
+    if info.Commit != Unknown || info.BuildDate != Unknown {
+        test.Errorf("Unset fields should be reported as %q, got %+v", Unknown, info)
+    }
+}
+
+func TestUserAgent(test *testing.T) {
+    info := Info{
+        Service:    "registry",
+        Version:    "v1.2.0",
+        Commit:     "3f2a1c9e8d7b6a5f",
+    }
+    if ua := info.UserAgent(); ua != "registry/v1.2.0 (3f2a1c9)" {
+        test.Errorf("Unexpected user agent %q", ua)
+    }
+
+    info.Commit = Unknown
+    if ua := info.UserAgent(); ua != "registry/v1.2.0 (unknown)" {
+        test.Errorf("Unexpected user agent %q", ua)
+    }
+}
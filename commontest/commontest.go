@@ -0,0 +1,139 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package commontest provides fixtures for testing code built on this
+// repository's packages, so consumers don't each maintain their own.
+// All fixtures fail the test on error, and return a function to release
+// any resources they hold.
+package commontest
+
+import (
+    "crypto/rand"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+
+    "github.com/PhysarumSM/common/p2pnode"
+    "github.com/PhysarumSM/common/p2pnode/testutil"
+    "github.com/PhysarumSM/common/util"
+)
+
+// Creates a new key in a temporary directory, returning the key and the
+// path of the file it was stored in
+func TempKeyFile(tb testing.TB) (crypto.PrivKey, string, func()) {
+    tb.Helper()
+
+    dir, err := ioutil.TempDir("", "commontest")
+    if err != nil {
+        tb.Fatalf("Unable to create temp dir:\n%v", err)
+    }
+    cleanup := func() { os.RemoveAll(dir) }
+
+    priv, err := util.GeneratePrivKey("ECDSA", 256)
+    if err != nil {
+        cleanup()
+        tb.Fatalf("Unable to generate key:\n%v", err)
+    }
+
+    keyFile := filepath.Join(dir, util.KEY_FILE_NAME)
+    if err = util.StorePrivKeyToFile(priv, keyFile); err != nil {
+        cleanup()
+        tb.Fatalf("Unable to store key:\n%v", err)
+    }
+
+    return priv, keyFile, cleanup
+}
+
+// Returns the full multiaddrs (including /p2p) the Node is listening on
+func NodeAddrs(tb testing.TB, node p2pnode.Node) []multiaddr.Multiaddr {
+    tb.Helper()
+
+    addrs, err := util.Whoami(node.Host())
+    if err != nil {
+        tb.Fatalf("Unable to get node addresses:\n%v", err)
+    }
+    return addrs
+}
+
+// Starts a bootstrap node (based on p2pnode.NewBootstrapConfig()) on a new
+// in-memory network, returning the network, for use with TestNode(), and
+// the node. Releasing the network closes every node on it.
+func BootstrapNode(tb testing.TB) (*testutil.Network, p2pnode.Node, func()) {
+    tb.Helper()
+
+    net, cleanup := testutil.NewNetwork(tb, 1, func(i int, config *p2pnode.Config) {
+        *config = p2pnode.NewBootstrapConfig()
+    })
+    return net, net.Nodes[0], cleanup
+}
+
+// Starts a node on the network that connects to its bootstrap and
+// advertises the given rendezvous strings
+func TestNode(tb testing.TB, net *testutil.Network,
+    rendezvous ...string) (p2pnode.Node, func()) {
+
+    tb.Helper()
+
+    config := p2pnode.NewTestConfig()
+    config.Rendezvous = rendezvous
+
+    node := net.AddNode(tb, config)
+    return node, node.Close
+}
+
+// Returns an AddrInfo with a random peer ID and the given number of
+// (unroutable) addresses
+func RandomAddrInfo(tb testing.TB, numAddrs int) peer.AddrInfo {
+    tb.Helper()
+
+    _, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+    if err != nil {
+        tb.Fatalf("Unable to generate key:\n%v", err)
+    }
+
+    id, err := peer.IDFromPublicKey(pub)
+    if err != nil {
+        tb.Fatalf("Unable to create peer ID:\n%v", err)
+    }
+
+    info := peer.AddrInfo{ID: id}
+    for i := 0; i < numAddrs; i++ {
+        addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/192.0.2.1/tcp/%d", 4001 + i))
+        if err != nil {
+            tb.Fatalf("Unable to create multiaddr:\n%v", err)
+        }
+        info.Addrs = append(info.Addrs, addr)
+    }
+
+    return info
+}
+
+// Returns a closed, buffered channel yielding the given peers, in place of
+// the channel returned by discovery's FindPeers()
+func PeerChan(peers ...peer.AddrInfo) <-chan peer.AddrInfo {
+    peerChan := make(chan peer.AddrInfo, len(peers))
+    for _, p := range peers {
+        peerChan <- p
+    }
+    close(peerChan)
+    return peerChan
+}
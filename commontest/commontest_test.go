@@ -0,0 +1,57 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commontest
+
+import (
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/network"
+)
+
+func TestFixtures(test *testing.T) {
+    net, bootstrap, cleanup := BootstrapNode(test)
+    defer cleanup()
+
+    test.Run("TestNode", func(test *testing.T) {
+        node, release := TestNode(test, net, "commontest")
+        defer release()
+
+        id := bootstrap.Host().ID()
+        if node.Host().Network().Connectedness(id) != network.Connected {
+            test.Errorf("Test node is not connected to the bootstrap")
+        }
+        if len(NodeAddrs(test, node)) == 0 {
+            test.Errorf("NodeAddrs() returned no addresses")
+        }
+    })
+
+    test.Run("PeerChan", func(test *testing.T) {
+        info := RandomAddrInfo(test, 2)
+        if len(info.Addrs) != 2 {
+            test.Errorf("RandomAddrInfo() returned %d addresses, expected 2", len(info.Addrs))
+        }
+
+        var received int
+        for p := range PeerChan(info, RandomAddrInfo(test, 0)) {
+            if received == 0 && p.ID != info.ID {
+                test.Errorf("PeerChan() yielded %s first, expected %s", p.ID, info.ID)
+            }
+            received++
+        }
+        if received != 2 {
+            test.Errorf("PeerChan() yielded %d peers, expected 2", received)
+        }
+    })
+}
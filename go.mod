@@ -4,15 +4,36 @@ go 1.13
 
 require (
 	github.com/ipfs/go-blockservice v0.1.3
+	github.com/ipfs/go-cid v0.0.5
+	github.com/ipfs/go-datastore v0.4.4
+	github.com/ipfs/go-ds-leveldb v0.4.2
 	github.com/ipfs/go-ipfs v0.5.1
 	github.com/ipfs/go-ipfs-files v0.0.8
 	github.com/ipfs/go-merkledag v0.3.2
 	github.com/ipfs/go-mfs v0.1.2
 	github.com/ipfs/go-unixfs v0.2.4
 	github.com/libp2p/go-libp2p v0.9.2
+	github.com/libp2p/go-libp2p-circuit v0.2.2
+	github.com/libp2p/go-libp2p-connmgr v0.2.1
 	github.com/libp2p/go-libp2p-core v0.5.6
 	github.com/libp2p/go-libp2p-discovery v0.4.0
 	github.com/libp2p/go-libp2p-kad-dht v0.7.11
+	github.com/libp2p/go-libp2p-kbucket v0.4.1
+	github.com/libp2p/go-libp2p-mplex v0.2.3
+	github.com/libp2p/go-libp2p-peerstore v0.2.4
+	github.com/libp2p/go-libp2p-pubsub v0.2.7
+	github.com/libp2p/go-libp2p-quic-transport v0.3.7
+	github.com/libp2p/go-libp2p-record v0.1.2
+	github.com/libp2p/go-libp2p-secio v0.2.2
+	github.com/libp2p/go-libp2p-swarm v0.2.4
+	github.com/libp2p/go-libp2p-tls v0.1.3
+	github.com/libp2p/go-libp2p-yamux v0.2.7
+	github.com/libp2p/go-tcp-transport v0.2.0
+	github.com/libp2p/go-ws-transport v0.3.1
 	github.com/multiformats/go-multiaddr v0.2.2
+	github.com/multiformats/go-multiaddr-dns v0.2.0
+	github.com/multiformats/go-multiaddr-net v0.1.5
+	github.com/multiformats/go-multistream v0.1.1
+	github.com/prometheus/client_golang v1.5.1
 	golang.org/x/crypto v0.0.0-20200510223506-06a226fb4e37
 )
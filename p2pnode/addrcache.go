@@ -0,0 +1,142 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "encoding/json"
+    "log"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+const (
+    // Default time resolved DNS multiaddrs are kept in the address cache
+    DefaultDNSCacheTTL = 7 * 24 * time.Hour
+)
+
+// Persisted expansion of a DNS multiaddr of a peer
+type addrCacheRecord struct {
+    Peer        string      `json:"peer"`
+    Addr        string      `json:"addr"`
+    Resolved    []string    `json:"resolved"`
+    Expiry      time.Time   `json:"expiry"`
+}
+
+// On-disk cache of what DNS multiaddrs (/dns4, /dnsaddr, etc.) last resolved
+// to, so their last-known addresses can still be dialed when DNS is down
+type addrCache struct {
+    mutex   sync.Mutex
+    path    string
+    ttl     time.Duration
+    records map[string]addrCacheRecord
+    cipher  *stateCipher
+}
+
+func addrCacheKey(id peer.ID, addr multiaddr.Multiaddr) string {
+    return id.Pretty() + " " + addr.String()
+}
+
+// Loads the address cache from file, dropping expired records. A
+// non-existent file results in an empty cache.
+func loadAddrCache(path string, ttl time.Duration, sc *stateCipher) (*addrCache, error) {
+    if ttl <= 0 {
+        ttl = DefaultDNSCacheTTL
+    }
+
+    path, err := util.ExpandTilde(path)
+    if err != nil {
+        return nil, err
+    }
+
+    cache := &addrCache{
+        path:       path,
+        ttl:        ttl,
+        records:    make(map[string]addrCacheRecord),
+        cipher:     sc,
+    }
+
+    content, err := readStateFile(path, sc)
+    if os.IsNotExist(err) {
+        return cache, nil
+    } else if err != nil {
+        return nil, err
+    }
+
+    var records []addrCacheRecord
+    if err = json.Unmarshal(content, &records); err != nil {
+        return nil, err
+    }
+
+    now := time.Now()
+    for _, record := range records {
+        if now.Before(record.Expiry) {
+            cache.records[record.Peer + " " + record.Addr] = record
+        }
+    }
+    return cache, nil
+}
+
+// Records what the peer's DNS multiaddr resolved to, and saves the cache
+func (cache *addrCache) put(id peer.ID, addr multiaddr.Multiaddr,
+    resolved []multiaddr.Multiaddr) {
+
+    record := addrCacheRecord{
+        Peer:   id.Pretty(),
+        Addr:   addr.String(),
+        Expiry: time.Now().Add(cache.ttl),
+    }
+    for _, r := range resolved {
+        record.Resolved = append(record.Resolved, r.String())
+    }
+
+    cache.mutex.Lock()
+    cache.records[addrCacheKey(id, addr)] = record
+    records := make([]addrCacheRecord, 0, len(cache.records))
+    for _, r := range cache.records {
+        records = append(records, r)
+    }
+    cache.mutex.Unlock()
+
+    content, err := json.MarshalIndent(records, "", "    ")
+    if err == nil {
+        err = writeStateFile(cache.path, content, cache.cipher)
+    }
+    if err != nil {
+        log.Printf("ERROR: Unable to save address cache to %s\n%v\n", cache.path, err)
+    }
+}
+
+// Returns the last-known expansion of the peer's DNS multiaddr, if any
+func (cache *addrCache) get(id peer.ID, addr multiaddr.Multiaddr) []multiaddr.Multiaddr {
+    cache.mutex.Lock()
+    record, ok := cache.records[addrCacheKey(id, addr)]
+    cache.mutex.Unlock()
+    if !ok || time.Now().After(record.Expiry) {
+        return nil
+    }
+
+    addrs, err := util.StringsToMultiaddrs(record.Resolved)
+    if err != nil {
+        return nil
+    }
+    return addrs
+}
@@ -0,0 +1,156 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "sync"
+    "time"
+)
+
+const (
+    // Default delay between consecutive advertisements in a batch
+    DefaultAdvertiseBatchInterval = 500 * time.Millisecond
+)
+
+// A set of rendezvous strings being advertised with AdvertiseBatch()
+type AdvertiseBatch struct {
+    mutex   sync.Mutex
+    total   int
+    done    int
+    leases  map[string]*Lease
+    errs    map[string]error
+    doneCh  chan struct{}
+}
+
+// Records the first advertisement of a rendezvous string
+func (batch *AdvertiseBatch) finish(rendezvous string, err error) {
+    batch.mutex.Lock()
+    defer batch.mutex.Unlock()
+
+    if err != nil {
+        batch.errs[rendezvous] = err
+    }
+    batch.done++
+    if batch.done == batch.total {
+        close(batch.doneCh)
+    }
+}
+
+// Returns how many rendezvous strings have had their first advertisement
+// attempted, out of the total in the batch
+func (batch *AdvertiseBatch) Progress() (done int, total int) {
+    batch.mutex.Lock()
+    defer batch.mutex.Unlock()
+    return batch.done, batch.total
+}
+
+// Returns a channel that is closed once every rendezvous string in the
+// batch has had its first advertisement attempted
+func (batch *AdvertiseBatch) Done() <-chan struct{} {
+    return batch.doneCh
+}
+
+// Returns the errors from rendezvous strings whose first advertisement
+// failed. Their leases keep retrying in the background.
+func (batch *AdvertiseBatch) Errors() map[string]error {
+    batch.mutex.Lock()
+    defer batch.mutex.Unlock()
+
+    errs := make(map[string]error, len(batch.errs))
+    for r, err := range batch.errs {
+        errs[r] = err
+    }
+    return errs
+}
+
+// Returns the leases of the batch, keyed by rendezvous string
+func (batch *AdvertiseBatch) Leases() map[string]*Lease {
+    batch.mutex.Lock()
+    defer batch.mutex.Unlock()
+
+    leases := make(map[string]*Lease, len(batch.leases))
+    for r, lease := range batch.leases {
+        leases[r] = lease
+    }
+    return leases
+}
+
+// Advertises many rendezvous strings, spreading the advertisements out by
+// 'interval' (DefaultAdvertiseBatchInterval if 0) rather than issuing them
+// all at once, to avoid load spikes on the DHT. Duplicate strings are
+// coalesced, and strings that are already being advertised are renewed
+// immediately. Leases are created up front, so the batch can be released
+// through them before it completes.
+func (node *Node) AdvertiseBatch(rendezvous []string,
+    interval time.Duration) (*AdvertiseBatch, error) {
+
+    if interval <= 0 {
+        interval = DefaultAdvertiseBatchInterval
+    }
+
+    unique := make([]string, 0, len(rendezvous))
+    seen := make(map[string]bool)
+    for _, r := range rendezvous {
+        if !seen[r] {
+            seen[r] = true
+            unique = append(unique, r)
+        }
+    }
+
+    batch := &AdvertiseBatch{
+        total:  len(unique),
+        leases: make(map[string]*Lease),
+        errs:   make(map[string]error),
+        doneCh: make(chan struct{}),
+    }
+    if batch.total == 0 {
+        close(batch.doneCh)
+        return batch, nil
+    }
+
+    // Hold the lock until every lease is created, so that a fast first
+    // advertisement can't complete the batch early
+    batch.mutex.Lock()
+    defer batch.mutex.Unlock()
+
+    var delay time.Duration
+    var created []*Lease
+    for _, r := range unique {
+        r := r
+        lease, existing, err := node.advertise(r, delay, func(err error) {
+            batch.finish(r, err)
+        })
+        if err != nil {
+            for _, lease := range created {
+                lease.Release()
+            }
+            return nil, err
+        }
+
+        batch.leases[r] = lease
+        if existing {
+            batch.done++
+        } else {
+            created = append(created, lease)
+            delay += interval
+        }
+    }
+
+    if batch.done == batch.total {
+        close(batch.doneCh)
+    }
+
+    return batch, nil
+}
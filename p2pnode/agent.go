@@ -0,0 +1,101 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/PhysarumSM/common/buildinfo"
+)
+
+const (
+    // Separates the user agent from the application protocol version in
+    // the agent string sent to peers (see Config.AppProtocolVersion)
+    appVersionSeparator = " app-protocol/"
+)
+
+var (
+    // Returned by PeerAgent() when the peer has not identified itself yet
+    ErrAgentUnknown = errors.New("Peer has not identified itself")
+)
+
+// What a peer reported about itself through libp2p's identify protocol
+type PeerAgentInfo struct {
+    // e.g. "registry/v1.2.0 (3f2a1c9)" for Nodes using the default
+    UserAgent           string
+    // Config.AppProtocolVersion of the peer, if any
+    AppProtocolVersion  string
+    // Version of the libp2p protocols spoken by the peer
+    LibP2PVersion       string
+}
+
+// Returns the agent string the Node sends to peers: the Config's UserAgent
+// (by default the service's build information), followed by its
+// AppProtocolVersion, if any. libp2p's identify protocol reports its own
+// protocol version, so the application's travels in the agent string.
+func agentString(config *Config) string {
+    agent := config.UserAgent
+    if agent == "" {
+        agent = buildinfo.Get().UserAgent()
+    }
+    if config.AppProtocolVersion != "" {
+        agent += appVersionSeparator + config.AppProtocolVersion
+    }
+    return agent
+}
+
+// Splits an agent string into its user agent and application protocol
+// version
+func parseAgentString(agent string) (userAgent, appVersion string) {
+    i := strings.LastIndex(agent, appVersionSeparator)
+    if i < 0 {
+        return agent, ""
+    }
+    return agent[:i], agent[i+len(appVersionSeparator):]
+}
+
+// Checks the agent settings of a Config
+func validateAgent(config *Config) error {
+    if strings.ContainsAny(config.UserAgent, "\r\n") {
+        return errors.New("UserAgent cannot contain line breaks")
+    } else if strings.ContainsAny(config.AppProtocolVersion, " \t\r\n") {
+        return fmt.Errorf("AppProtocolVersion cannot contain whitespace: %q",
+            config.AppProtocolVersion)
+    }
+    return nil
+}
+
+// Returns the user agent and versions the peer reported when it last
+// connected, or ErrAgentUnknown if it has not been identified yet
+func (node *Node) PeerAgent(id peer.ID) (PeerAgentInfo, error) {
+    var info PeerAgentInfo
+
+    pstore := node.Host().Peerstore()
+    agent, err := pstore.Get(id, "AgentVersion")
+    if err != nil {
+        return info, ErrAgentUnknown
+    }
+    agentStr, _ := agent.(string)
+    info.UserAgent, info.AppProtocolVersion = parseAgentString(agentStr)
+
+    if version, err := pstore.Get(id, "ProtocolVersion"); err == nil {
+        info.LibP2PVersion, _ = version.(string)
+    }
+    return info, nil
+}
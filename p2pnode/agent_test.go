@@ -0,0 +1,43 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "testing"
+)
+
+func TestAgentString(test *testing.T) {
+    config := Config{UserAgent: "registry/v1.2.0 (3f2a1c9)", AppProtocolVersion: "2.1"}
+    agent := agentString(&config)
+    if agent != "registry/v1.2.0 (3f2a1c9) app-protocol/2.1" {
+        test.Errorf("Unexpected agent string %q", agent)
+    }
+
+    ua, version := parseAgentString(agent)
+    if ua != config.UserAgent || version != config.AppProtocolVersion {
+        test.Errorf("parseAgentString() returned %q, %q", ua, version)
+    }
+
+    // Agents of other libp2p implementations have no application version
+    ua, version = parseAgentString("go-ipfs/0.5.1")
+    if ua != "go-ipfs/0.5.1" || version != "" {
+        test.Errorf("parseAgentString() returned %q, %q", ua, version)
+    }
+
+    config.AppProtocolVersion = "2 .1"
+    if validateAgent(&config) == nil {
+        test.Errorf("validateAgent() accepted whitespace in AppProtocolVersion")
+    }
+}
@@ -0,0 +1,63 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "github.com/libp2p/go-libp2p"
+
+    "github.com/multiformats/go-multiaddr"
+    manet "github.com/multiformats/go-multiaddr-net"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+// Function deciding which addresses the node announces to peers (via
+// identify and the DHT), given the addresses it listens on
+type AddrsFactory func([]multiaddr.Multiaddr) []multiaddr.Multiaddr
+
+// Returns the libp2p option applying the Config's address announcement
+// policy, or nil if it uses libp2p's default of announcing every address.
+// Private and loopback addresses are dropped first (if AnnouncePublicOnly),
+// then AnnounceAddrs are appended, and finally AddrsFactory is applied.
+func announceOpts(config *Config) (libp2p.Option, error) {
+    if !config.AnnouncePublicOnly && len(config.AnnounceAddrs) == 0 &&
+        config.AddrsFactory == nil {
+        return nil, nil
+    }
+
+    extra, err := util.StringsToMultiaddrs(config.AnnounceAddrs)
+    if err != nil {
+        return nil, err
+    }
+
+    publicOnly := config.AnnouncePublicOnly
+    custom := config.AddrsFactory
+    factory := func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+        announced := make([]multiaddr.Multiaddr, 0, len(addrs) + len(extra))
+        for _, addr := range addrs {
+            if !publicOnly || manet.IsPublicAddr(addr) {
+                announced = append(announced, addr)
+            }
+        }
+        announced = append(announced, extra...)
+
+        if custom != nil {
+            announced = custom(announced)
+        }
+        return announced
+    }
+
+    return libp2p.AddrsFactory(factory), nil
+}
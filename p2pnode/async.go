@@ -0,0 +1,80 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+)
+
+// Stages of Node startup that happen after the host is created
+type StartupStage int
+
+const (
+    // Connected to at least one bootstrap (or none were configured)
+    StageBootstrapsConnected StartupStage = iota
+    // DHT bootstrap process started
+    StageDHTBootstrapped
+    // All configured rendezvous strings are being advertised
+    StageAdvertised
+)
+
+func (stage StartupStage) String() string {
+    switch stage {
+    case StageBootstrapsConnected:
+        return "bootstraps-connected"
+    case StageDHTBootstrapped:
+        return "dht-bootstrapped"
+    case StageAdvertised:
+        return "advertised"
+    default:
+        return "unknown"
+    }
+}
+
+// Progress of a Node started with NewNodeAsync(). If Err is set, startup
+// failed while working towards Stage, and no further events are sent.
+type StartupEvent struct {
+    Stage   StartupStage
+    Err     error
+}
+
+// Like NewNode, but returns as soon as the host is created and listening.
+// Connecting to bootstraps, bootstrapping the DHT, and advertising happen
+// in the background, with progress reported on the returned channel, which
+// is closed once startup completes or fails. Until then, the Node is usable
+// but may not be connected to, or discoverable on, the network.
+func NewNodeAsync(ctx context.Context, config Config) (Node, <-chan StartupEvent, error) {
+    node, err := newNode(ctx, &config)
+    if err != nil {
+        return *node, nil, err
+    }
+
+    // Buffered so startup never blocks on a slow (or absent) reader
+    events := make(chan StartupEvent, StageAdvertised + 1)
+    node.spawn("startup", func(context.Context) {
+        defer close(events)
+
+        next := StageBootstrapsConnected
+        err := node.start(&config, func(stage StartupStage) {
+            events <- StartupEvent{Stage: stage}
+            next = stage + 1
+        })
+        if err != nil {
+            events <- StartupEvent{Stage: next, Err: err}
+        }
+    })
+
+    return *node, events, nil
+}
@@ -0,0 +1,285 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+const (
+    // Time a peer has to present its credentials on a new stream
+    AuthTimeout = 10 * time.Second
+
+    // Upper bound on the size of presented credentials
+    maxCredentialsBytes = 16 * 1024
+
+    // Status byte sent back once credentials have been checked
+    authAccepted = byte(0x00)
+    authDenied   = byte(0x01)
+)
+
+var (
+    // Returned by NewStreamWithCredentials() when the peer refuses the
+    // credentials
+    ErrUnauthorized = errors.New("Not authorized for protocol")
+)
+
+// Who may open streams for a protocol (see Config.AuthPolicies). Every
+// requirement that is set must be met.
+type AuthPolicy struct {
+    // If non-empty, only these peers are allowed
+    AllowPeers  []peer.ID
+
+    // If set, peers must present a Capability with this name, issued to
+    // them by one of the Issuers
+    Capability  string
+    Issuers     []crypto.PubKey
+
+    // If non-empty, peers must present one of these tokens
+    Tokens      []string
+}
+
+// Returns true if peers must present Credentials
+func (policy AuthPolicy) needsCredentials() bool {
+    return policy.Capability != "" || len(policy.Tokens) > 0
+}
+
+func (policy AuthPolicy) validate() error {
+    if policy.Capability != "" && len(policy.Issuers) == 0 {
+        return fmt.Errorf("Capability %s requires at least one issuer", policy.Capability)
+    }
+    return nil
+}
+
+// Grant of a named capability to a peer, signed by its issuer
+type Capability struct {
+    Name        string
+    Holder      peer.ID
+    Expiry      time.Time
+    Issuer      peer.ID
+    Signature   []byte  `json:",omitempty"`
+}
+
+// Issues a capability to 'holder' valid for 'ttl', signed with the
+// issuer's key
+func IssueCapability(issuerKey crypto.PrivKey, name string, holder peer.ID,
+    ttl time.Duration) (Capability, error) {
+
+    issuer, err := peer.IDFromPrivateKey(issuerKey)
+    if err != nil {
+        return Capability{}, err
+    }
+
+    c := Capability{
+        Name:   name,
+        Holder: holder,
+        Expiry: time.Now().Add(ttl).UTC(),
+        Issuer: issuer,
+    }
+    c.Signature, err = util.SignJSON(issuerKey, c)
+    return c, err
+}
+
+// Checks that the capability was issued to the holder by one of the issuers
+// and has not expired
+func (c Capability) verify(name string, holder peer.ID, issuers []crypto.PubKey) error {
+    if c.Name != name || c.Holder != holder {
+        return fmt.Errorf("Capability %s for %s does not grant %s to %s",
+            c.Name, c.Holder, name, holder)
+    } else if time.Now().After(c.Expiry) {
+        return fmt.Errorf("Capability %s expired at %v", c.Name, c.Expiry)
+    }
+
+    signature := c.Signature
+    c.Signature = nil
+    for _, pub := range issuers {
+        if !c.Issuer.MatchesPublicKey(pub) {
+            continue
+        }
+        ok, err := util.VerifyJSON(pub, c, signature)
+        if err != nil {
+            return err
+        } else if !ok {
+            return fmt.Errorf("Capability %s has an invalid signature", c.Name)
+        }
+        return nil
+    }
+    return fmt.Errorf("Capability %s issued by untrusted %s", c.Name, c.Issuer)
+}
+
+// Presented when opening a stream for a protocol whose policy requires
+// them (see NewStreamWithCredentials())
+type Credentials struct {
+    Token       string      `json:",omitempty"`
+    Capability  *Capability `json:",omitempty"`
+}
+
+// Checks credentials presented by a peer against the policy
+func (policy AuthPolicy) check(id peer.ID, creds Credentials) error {
+    if len(policy.Tokens) > 0 {
+        valid := false
+        for _, token := range policy.Tokens {
+            if subtle.ConstantTimeCompare([]byte(creds.Token), []byte(token)) == 1 {
+                valid = true
+            }
+        }
+        if !valid {
+            return errors.New("Invalid token")
+        }
+    }
+
+    if policy.Capability != "" {
+        if creds.Capability == nil {
+            return fmt.Errorf("Missing capability %s", policy.Capability)
+        }
+        return creds.Capability.verify(policy.Capability, id, policy.Issuers)
+    }
+    return nil
+}
+
+// Reads single bytes from a stream, so nothing past the credentials is
+// consumed before the handler gets the stream
+type byteReader struct {
+    io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+    var b [1]byte
+    _, err := io.ReadFull(r.Reader, b[:])
+    return b[0], err
+}
+
+func readCredentials(stream network.Stream) (Credentials, error) {
+    var creds Credentials
+
+    length, err := binary.ReadUvarint(byteReader{stream})
+    if err != nil {
+        return creds, err
+    } else if length > maxCredentialsBytes {
+        return creds, fmt.Errorf("Credentials of %d bytes exceed max of %d",
+            length, maxCredentialsBytes)
+    }
+
+    data := make([]byte, length)
+    if _, err = io.ReadFull(stream, data); err != nil {
+        return creds, err
+    }
+    err = json.Unmarshal(data, &creds)
+    return creds, err
+}
+
+func writeCredentials(stream network.Stream, creds Credentials) error {
+    data, err := json.Marshal(creds)
+    if err != nil {
+        return err
+    }
+
+    buf := make([]byte, binary.MaxVarintLen64 + len(data))
+    n := binary.PutUvarint(buf, uint64(len(data)))
+    n += copy(buf[n:], data)
+    _, err = stream.Write(buf[:n])
+    return err
+}
+
+// Wraps the handler so streams are only passed to it once the remote peer
+// is authorized under the policy
+func authorizeHandler(pid protocol.ID, policy AuthPolicy,
+    handler network.StreamHandler) network.StreamHandler {
+
+    allowed := make(map[peer.ID]bool)
+    for _, id := range policy.AllowPeers {
+        allowed[id] = true
+    }
+
+    return func(stream network.Stream) {
+        id := stream.Conn().RemotePeer()
+        if len(allowed) > 0 && !allowed[id] {
+            log.Printf("Refusing %s stream from unauthorized peer %s\n", pid, id)
+            stream.Reset()
+            return
+        }
+
+        if policy.needsCredentials() {
+            stream.SetDeadline(time.Now().Add(AuthTimeout))
+            creds, err := readCredentials(stream)
+            if err == nil {
+                err = policy.check(id, creds)
+            }
+            if err != nil {
+                log.Printf("Refusing %s stream from %s\n%v\n", pid, id, err)
+                stream.Write([]byte{authDenied})
+                stream.Close()
+                return
+            }
+            if _, err = stream.Write([]byte{authAccepted}); err != nil {
+                stream.Reset()
+                return
+            }
+            stream.SetDeadline(time.Time{})
+        }
+
+        handler(stream)
+    }
+}
+
+// Opens a stream to a peer for a protocol that requires credentials (see
+// AuthPolicy), presenting them and waiting for them to be accepted.
+// Returns ErrUnauthorized if they are refused.
+func (node *Node) NewStreamWithCredentials(ctx context.Context, id peer.ID,
+    pid protocol.ID, creds Credentials) (network.Stream, error) {
+
+    stream, err := node.Host().NewStream(ctx, id, pid)
+    if err != nil {
+        return nil, err
+    }
+
+    deadline := time.Now().Add(AuthTimeout)
+    if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+        deadline = d
+    }
+    stream.SetDeadline(deadline)
+
+    if err = writeCredentials(stream, creds); err != nil {
+        stream.Reset()
+        return nil, err
+    }
+
+    status, err := byteReader{stream}.ReadByte()
+    if err != nil {
+        stream.Reset()
+        return nil, err
+    } else if status != authAccepted {
+        stream.Close()
+        return nil, ErrUnauthorized
+    }
+
+    stream.SetDeadline(time.Time{})
+    return stream, nil
+}
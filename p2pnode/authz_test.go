@@ -0,0 +1,85 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "crypto/rand"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestAuthPolicyCheck(test *testing.T) {
+    issuerKey, issuerPub, err := crypto.GenerateEd25519Key(rand.Reader)
+    if err != nil {
+        test.Fatalf("Unable to generate key:\n%v", err)
+    }
+    otherKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+    if err != nil {
+        test.Fatalf("Unable to generate key:\n%v", err)
+    }
+
+    admin := peer.ID("admin-peer")
+    policy := AuthPolicy{
+        Capability: "admin",
+        Issuers:    []crypto.PubKey{issuerPub},
+        Tokens:     []string{"secret"},
+    }
+
+    valid, err := IssueCapability(issuerKey, "admin", admin, time.Hour)
+    if err != nil {
+        test.Fatalf("IssueCapability() failed:\n%v", err)
+    }
+    expired, _ := IssueCapability(issuerKey, "admin", admin, -time.Hour)
+    untrusted, _ := IssueCapability(otherKey, "admin", admin, time.Hour)
+    wrongName, _ := IssueCapability(issuerKey, "viewer", admin, time.Hour)
+    forged := valid
+    forged.Holder = badPeer
+
+    testCases := []struct {
+        name    string
+        id      peer.ID
+        creds   Credentials
+        allowed bool
+    }{
+        {"Valid", admin, Credentials{Token: "secret", Capability: &valid}, true},
+        {"WrongToken", admin, Credentials{Token: "guess", Capability: &valid}, false},
+        {"NoCapability", admin, Credentials{Token: "secret"}, false},
+        {"Expired", admin, Credentials{Token: "secret", Capability: &expired}, false},
+        {"Untrusted", admin, Credentials{Token: "secret", Capability: &untrusted}, false},
+        {"WrongName", admin, Credentials{Token: "secret", Capability: &wrongName}, false},
+        {"OtherHolder", badPeer, Credentials{Token: "secret", Capability: &valid}, false},
+        {"Forged", badPeer, Credentials{Token: "secret", Capability: &forged}, false},
+    }
+
+    for _, testCase := range testCases {
+        testCase := testCase
+        test.Run(testCase.name, func(test *testing.T) {
+            err := policy.check(testCase.id, testCase.creds)
+            if (err == nil) != testCase.allowed {
+                test.Errorf("Expected credentials to be allowed: %v, got error: %v",
+                    testCase.allowed, err)
+            }
+        })
+    }
+
+    test.Run("MissingIssuers", func(test *testing.T) {
+        if (AuthPolicy{Capability: "admin"}).validate() == nil {
+            test.Errorf("Policy requiring a capability without issuers was valid")
+        }
+    })
+}
@@ -0,0 +1,106 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "log"
+    "sync"
+
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p-core/event"
+    "github.com/libp2p/go-libp2p-core/network"
+)
+
+// Tracks the reachability of the node as determined by AutoNAT
+type natStatus struct {
+    mutex           sync.RWMutex
+    reachability    network.Reachability
+}
+
+// Returns libp2p options configuring AutoNAT and port mapping according to
+// the Config
+func autoNATOpts(config *Config) []libp2p.Option {
+    var opts []libp2p.Option
+
+    if config.EnableNATPortMap {
+        log.Println("NAT port mapping enabled, node will ask its router to open ports")
+        opts = append(opts, libp2p.NATPortMap())
+    }
+
+    if config.EnableAutoNATService {
+        log.Println("AutoNAT service enabled, node will help peers determine their reachability")
+        opts = append(opts, libp2p.EnableNATService())
+    }
+
+    switch config.ForceReachability {
+    case network.ReachabilityPublic:
+        opts = append(opts, libp2p.ForceReachabilityPublic())
+    case network.ReachabilityPrivate:
+        opts = append(opts, libp2p.ForceReachabilityPrivate())
+    }
+
+    return opts
+}
+
+// Subscribes to reachability changes reported by AutoNAT, logging them
+// and recording the latest status for Node.Reachability()
+func (node *Node) trackReachability() error {
+    sub, err := node.Host().EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+    if err != nil {
+        return err
+    }
+
+    if node.nat == nil {
+        node.nat = &natStatus{}
+    }
+    node.nat.mutex.Lock()
+    node.nat.reachability = network.ReachabilityUnknown
+    node.nat.mutex.Unlock()
+
+    node.spawnWith(node.hostContext(), "reachability", func(ctx context.Context) {
+        defer sub.Close()
+        for {
+            select {
+            case evt, ok := <-sub.Out():
+                if !ok {
+                    return
+                }
+                reachability := evt.(event.EvtLocalReachabilityChanged).Reachability
+                log.Println("Node reachability changed to", reachability)
+
+                node.nat.mutex.Lock()
+                node.nat.reachability = reachability
+                node.nat.mutex.Unlock()
+            case <-ctx.Done():
+                return
+            }
+        }
+    })
+
+    return nil
+}
+
+// Returns whether the node is publicly reachable, as determined by AutoNAT.
+// Returns ReachabilityUnknown until AutoNAT has made a determination.
+func (node *Node) Reachability() network.Reachability {
+    if node.nat == nil {
+        return network.ReachabilityUnknown
+    }
+
+    node.nat.mutex.RLock()
+    defer node.nat.mutex.RUnlock()
+    return node.nat.reachability
+}
@@ -0,0 +1,44 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "github.com/libp2p/go-libp2p-core/metrics"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Bandwidth statistics are collected for every node, and reported in bytes
+// (totals) and bytes per second (rates).
+
+// Returns bandwidth used by the node across all peers and protocols
+func (node *Node) BandwidthTotals() metrics.Stats {
+    return node.bandwidth.GetBandwidthTotals()
+}
+
+// Returns bandwidth used by the node, broken down by protocol
+func (node *Node) BandwidthByProtocol() map[protocol.ID]metrics.Stats {
+    return node.bandwidth.GetBandwidthByProtocol()
+}
+
+// Returns bandwidth used by the node, broken down by peer
+func (node *Node) BandwidthByPeer() map[peer.ID]metrics.Stats {
+    return node.bandwidth.GetBandwidthByPeer()
+}
+
+// Returns bandwidth used by the node to communicate with a single peer
+func (node *Node) BandwidthForPeer(id peer.ID) metrics.Stats {
+    return node.bandwidth.GetBandwidthForPeer(id)
+}
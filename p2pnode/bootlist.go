@@ -0,0 +1,245 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+const (
+    // Default interval between fetches of Config.BootstrapListURL
+    DefaultBootstrapListInterval = 15 * time.Minute
+
+    // Timeout for a single fetch of the bootstrap list
+    BootstrapListTimeout = 30 * time.Second
+
+    // Upper bound on the size of a bootstrap list
+    maxBootstrapListBytes = 1024 * 1024
+)
+
+var (
+    // Returned when a bootstrap list's signature does not verify
+    ErrBootstrapListSignature = errors.New("Invalid bootstrap list signature")
+
+    // Returned when a bootstrap list was issued before the last one accepted,
+    // e.g. if an old list is replayed
+    ErrBootstrapListStale = errors.New("Bootstrap list is older than the current one")
+)
+
+// Signed list of bootstrap multiaddrs (ending in /p2p/<peer ID>), served at
+// Config.BootstrapListURL
+type BootstrapList struct {
+    Addrs       []string    `json:"addrs"`
+    Issued      time.Time   `json:"issued"`
+    Signature   []byte      `json:"signature,omitempty"`
+}
+
+// Portion of a BootstrapList covered by its signature
+type bootstrapListBody struct {
+    Addrs       []string    `json:"addrs"`
+    Issued      time.Time   `json:"issued"`
+}
+
+// Creates a bootstrap list of the addresses, signed with the key
+func SignBootstrapList(priv crypto.PrivKey,
+    addrs []multiaddr.Multiaddr) (BootstrapList, error) {
+
+    list := BootstrapList{Issued: time.Now().UTC().Round(0)}
+    for _, addr := range addrs {
+        list.Addrs = append(list.Addrs, addr.String())
+    }
+
+    sig, err := util.SignJSON(priv, bootstrapListBody{list.Addrs, list.Issued})
+    if err != nil {
+        return list, err
+    }
+    list.Signature = sig
+    return list, nil
+}
+
+// Verifies the list's signature, and returns its bootstraps
+func (list BootstrapList) Verify(pub crypto.PubKey) ([]peer.AddrInfo, error) {
+    ok, err := util.VerifyJSON(pub, bootstrapListBody{list.Addrs, list.Issued}, list.Signature)
+    if err != nil {
+        return nil, err
+    } else if !ok {
+        return nil, ErrBootstrapListSignature
+    }
+
+    addrs, err := util.StringsToMultiaddrs(list.Addrs)
+    if err != nil {
+        return nil, err
+    }
+    return peer.AddrInfosFromP2pAddrs(addrs...)
+}
+
+// Fetches a bootstrap list over HTTPS
+func FetchBootstrapList(ctx context.Context, client *http.Client,
+    listURL string) (BootstrapList, error) {
+
+    var list BootstrapList
+
+    ctx, cancel := context.WithTimeout(ctx, BootstrapListTimeout)
+    defer cancel()
+
+    req, err := http.NewRequest(http.MethodGet, listURL, nil)
+    if err != nil {
+        return list, err
+    }
+    resp, err := client.Do(req.WithContext(ctx))
+    if err != nil {
+        return list, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return list, fmt.Errorf("Fetching bootstrap list returned status %s", resp.Status)
+    }
+
+    err = json.NewDecoder(io.LimitReader(resp.Body, maxBootstrapListBytes)).Decode(&list)
+    return list, err
+}
+
+// Checks the bootstrap list settings of a Config
+func validateBootstrapList(config *Config) error {
+    if config.BootstrapListURL == "" {
+        return nil
+    }
+
+    u, err := url.Parse(config.BootstrapListURL)
+    if err != nil {
+        return err
+    } else if u.Scheme != "https" {
+        return errors.New("BootstrapListURL must use HTTPS")
+    } else if config.BootstrapListKey == nil {
+        return errors.New("BootstrapListURL requires a BootstrapListKey")
+    }
+    return nil
+}
+
+// Fetches the Node's bootstrap list, and keeps its bootstrap set in sync
+// with it
+type bootstrapListSource struct {
+    mutex   sync.Mutex
+    url     string
+    key     crypto.PubKey
+    client  *http.Client
+    issued  time.Time
+}
+
+// Returns nil if the Config has no bootstrap list
+func newBootstrapListSource(config *Config) *bootstrapListSource {
+    if config.BootstrapListURL == "" {
+        return nil
+    }
+    return &bootstrapListSource{
+        url:    config.BootstrapListURL,
+        key:    config.BootstrapListKey,
+        client: &http.Client{},
+    }
+}
+
+// Fetches and verifies the list, returning its bootstraps if it is newer
+// than the last one accepted
+func (src *bootstrapListSource) fetch(ctx context.Context) ([]peer.AddrInfo, error) {
+    list, err := FetchBootstrapList(ctx, src.client, src.url)
+    if err != nil {
+        return nil, err
+    }
+    infos, err := list.Verify(src.key)
+    if err != nil {
+        return nil, err
+    }
+
+    src.mutex.Lock()
+    defer src.mutex.Unlock()
+    if list.Issued.Before(src.issued) {
+        return nil, ErrBootstrapListStale
+    }
+    src.issued = list.Issued
+    return infos, nil
+}
+
+// Fetches the bootstrap list and merges it into the Node's bootstrap set,
+// returning the bootstraps that were added
+func (node *Node) refreshBootstrapList(ctx context.Context) ([]peer.AddrInfo, error) {
+    infos, err := node.bootstrapList.fetch(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    added, removed := node.bootstrapSet.sync(bootstrapFromRemote, infos)
+    for _, info := range added {
+        node.ProtectPeer(info.ID, BootstrapPeerTag)
+    }
+    for _, id := range removed {
+        node.UnprotectPeer(id, BootstrapPeerTag)
+    }
+    if len(added) > 0 || len(removed) > 0 {
+        log.Printf("Bootstrap list updated: %d added, %d removed\n", len(added), len(removed))
+    }
+    return added, nil
+}
+
+// Refreshes the bootstrap list every 'interval' until the Node is done,
+// connecting to any bootstraps that are added
+func (node *Node) watchBootstrapList(interval time.Duration) {
+    if interval <= 0 {
+        interval = DefaultBootstrapListInterval
+    }
+
+    node.spawn("bootstrap-list", func(ctx context.Context) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ticker.C:
+            case <-ctx.Done():
+                return
+            }
+
+            added, err := node.refreshBootstrapList(ctx)
+            if err != nil {
+                log.Printf("ERROR: Unable to refresh bootstrap list from %s\n%v\n",
+                    node.bootstrapList.url, err)
+                continue
+            }
+            for _, info := range added {
+                if err = node.connect(ctx, node.resolveAddrInfo(ctx, info)); err != nil {
+                    log.Println(err)
+                } else {
+                    log.Println("Connected to bootstrap node:", info)
+                }
+            }
+        }
+    })
+}
@@ -0,0 +1,153 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/multiformats/go-multiaddr"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+// Returns a bootstrap multiaddr for a new random peer
+func newBootstrapAddr(test *testing.T) multiaddr.Multiaddr {
+    _, pub, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+    if err != nil {
+        test.Fatalf("Unable to generate key:\n%v", err)
+    }
+    id, _ := peer.IDFromPublicKey(pub)
+    addr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/4001/p2p/" + id.Pretty())
+    if err != nil {
+        test.Fatalf("Unable to create multiaddr:\n%v", err)
+    }
+    return addr
+}
+
+func TestBootstrapSet(test *testing.T) {
+    configAddr := newBootstrapAddr(test)
+    bs, err := newBootstrapSet([]multiaddr.Multiaddr{configAddr})
+    if err != nil {
+        test.Fatalf("newBootstrapSet() failed:\n%v", err)
+    }
+    configInfo, _ := peer.AddrInfoFromP2pAddr(configAddr)
+
+    first, _ := peer.AddrInfoFromP2pAddr(newBootstrapAddr(test))
+    second, _ := peer.AddrInfoFromP2pAddr(newBootstrapAddr(test))
+
+    added, removed := bs.sync(bootstrapFromRemote, []peer.AddrInfo{*first, *second})
+    if len(added) != 2 || len(removed) != 0 || len(bs.ids()) != 3 {
+        test.Errorf("sync() added %v, removed %v; expected 2 added", added, removed)
+    }
+
+    // The config bootstrap must survive remote lists that omit it
+    added, removed = bs.sync(bootstrapFromRemote, []peer.AddrInfo{*second})
+    if len(added) != 0 || len(removed) != 1 || removed[0] != first.ID {
+        test.Errorf("sync() added %v, removed %v; expected only %s removed", added, removed, first.ID)
+    }
+    if _, ok := bs.get(configInfo.ID); !ok {
+        test.Errorf("sync() removed a bootstrap from the Config")
+    }
+
+    // Adding a remote bootstrap by hand keeps it when the remote list drops it
+    bs.add(*second, bootstrapFromManual)
+    bs.sync(bootstrapFromRemote, nil)
+    if _, ok := bs.get(second.ID); !ok {
+        test.Errorf("sync() removed a bootstrap added by hand")
+    }
+
+    if !bs.remove(configInfo.ID) || bs.remove(configInfo.ID) {
+        test.Errorf("remove() should succeed only while the bootstrap is in the set")
+    }
+    if ids := bs.ids(); len(ids) != 1 || ids[0] != second.ID {
+        test.Errorf("ids() returned %v, expected [%s]", ids, second.ID)
+    }
+}
+
+func TestBootstrapList(test *testing.T) {
+    priv, pub, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+    if err != nil {
+        test.Fatalf("Unable to generate key:\n%v", err)
+    }
+
+    var served BootstrapList
+    server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(served)
+    }))
+    defer server.Close()
+
+    src := newBootstrapListSource(&Config{BootstrapListURL: server.URL, BootstrapListKey: pub})
+    src.client = server.Client()
+    ctx := context.Background()
+
+    test.Run("Valid", func(test *testing.T) {
+        served, err = SignBootstrapList(priv, []multiaddr.Multiaddr{newBootstrapAddr(test)})
+        if err != nil {
+            test.Fatalf("SignBootstrapList() failed:\n%v", err)
+        }
+        infos, err := src.fetch(ctx)
+        if err != nil || len(infos) != 1 {
+            test.Errorf("fetch() returned %v, %v; expected one bootstrap", infos, err)
+        }
+    })
+
+    test.Run("Tampered", func(test *testing.T) {
+        served.Addrs = append(served.Addrs, newBootstrapAddr(test).String())
+        if _, err := src.fetch(ctx); !errors.Is(err, ErrBootstrapListSignature) {
+            test.Errorf("fetch() of tampered list returned %v, expected ErrBootstrapListSignature", err)
+        }
+    })
+
+    test.Run("Stale", func(test *testing.T) {
+        old, _ := SignBootstrapList(priv, []multiaddr.Multiaddr{newBootstrapAddr(test)})
+        old.Issued = old.Issued.Add(-time.Hour)
+        old, _ = resignBootstrapList(priv, old)
+        served = old
+        if _, err := src.fetch(ctx); !errors.Is(err, ErrBootstrapListStale) {
+            test.Errorf("fetch() of stale list returned %v, expected ErrBootstrapListStale", err)
+        }
+    })
+
+    test.Run("Validate", func(test *testing.T) {
+        config := Config{BootstrapListURL: "http://example.com/bootstraps.json", BootstrapListKey: pub}
+        if err := validateBootstrapList(&config); err == nil {
+            test.Errorf("validateBootstrapList() accepted a plain HTTP URL")
+        }
+    })
+}
+
+// Signs the list again after it was modified
+func resignBootstrapList(priv crypto.PrivKey, list BootstrapList) (BootstrapList, error) {
+    addrs := make([]multiaddr.Multiaddr, len(list.Addrs))
+    for i, s := range list.Addrs {
+        addrs[i], _ = multiaddr.NewMultiaddr(s)
+    }
+    issued := list.Issued
+    list, err := SignBootstrapList(priv, addrs)
+    if err != nil {
+        return list, err
+    }
+    list.Issued = issued
+    list.Signature, err = util.SignJSON(priv, bootstrapListBody{list.Addrs, list.Issued})
+    return list, err
+}
@@ -0,0 +1,222 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    // Default interval between probes of each bootstrap
+    DefaultBootstrapProbeInterval = time.Minute
+
+    // Time allowed for each probe, including reconnecting if needed
+    BootstrapProbeTimeout = 10 * time.Second
+
+    // Number of probe results kept per bootstrap
+    BootstrapHistoryLen = 60
+)
+
+// Outcome of a single probe of a bootstrap
+type BootstrapProbe struct {
+    Time        time.Time
+    Reachable   bool
+    RTT         time.Duration
+    Err         string
+}
+
+// Reachability of a bootstrap, as returned by Node.BootstrapStatus()
+type BootstrapStatus struct {
+    Peer        peer.ID
+    Connected   bool
+
+    // Outcome of the latest probe, and last time a probe succeeded
+    Reachable   bool
+    LastSeen    time.Time
+
+    // Fraction of the probes in History that succeeded
+    Availability float64
+
+    // Most recent probes, oldest first
+    History     []BootstrapProbe
+}
+
+// Periodically probes the bootstraps, keeping a history of their
+// reachability
+type bootstrapMonitor struct {
+    mutex       sync.Mutex
+    history     map[peer.ID][]BootstrapProbe
+    lastSeen    map[peer.ID]time.Time
+    allDown     bool
+}
+
+func newBootstrapMonitor() *bootstrapMonitor {
+    return &bootstrapMonitor{
+        history:    make(map[peer.ID][]BootstrapProbe),
+        lastSeen:   make(map[peer.ID]time.Time),
+    }
+}
+
+// Records a probe result. Returns whether all of the current 'bootstraps'
+// are now unreachable, and whether that changed.
+func (bm *bootstrapMonitor) record(id peer.ID, probe BootstrapProbe,
+    bootstraps []peer.ID) (allDown bool, changed bool) {
+
+    bm.mutex.Lock()
+    defer bm.mutex.Unlock()
+
+    history := append(bm.history[id], probe)
+    if len(history) > BootstrapHistoryLen {
+        history = history[len(history) - BootstrapHistoryLen:]
+    }
+    bm.history[id] = history
+    if probe.Reachable {
+        bm.lastSeen[id] = probe.Time
+    }
+
+    allDown = true
+    for _, other := range bootstraps {
+        h := bm.history[other]
+        if len(h) == 0 || h[len(h) - 1].Reachable {
+            allDown = false
+            break
+        }
+    }
+    changed = allDown != bm.allDown
+    bm.allDown = allDown
+    return allDown, changed
+}
+
+// Probes a bootstrap, reconnecting to it if needed
+func (node *Node) probeBootstrap(ctx context.Context, info peer.AddrInfo) BootstrapProbe {
+    probe := BootstrapProbe{Time: time.Now()}
+
+    ctx, cancel := context.WithTimeout(ctx, BootstrapProbeTimeout)
+    defer cancel()
+
+    h := node.Host()
+    if h.Network().Connectedness(info.ID) != network.Connected {
+        if err := node.connect(ctx, node.resolveAddrInfo(ctx, info)); err != nil {
+            probe.Err = err.Error()
+            return probe
+        }
+    }
+
+    conns := h.Network().ConnsToPeer(info.ID)
+    if len(conns) == 0 {
+        probe.Err = "Connection closed during probe"
+        return probe
+    }
+    rtt, err := measureConn(ctx, conns[0])
+    if err != nil {
+        probe.Err = err.Error()
+        return probe
+    }
+
+    probe.Reachable = true
+    probe.RTT = rtt
+    return probe
+}
+
+// Probes every bootstrap every 'interval' until the Node is done, emitting
+// EventBootstrapsUnreachable when none of them can be reached, and
+// EventBootstrapsReachable once one of them can again
+func (node *Node) monitorBootstraps(interval time.Duration) {
+    if interval <= 0 {
+        interval = DefaultBootstrapProbeInterval
+    }
+
+    node.spawn("bootstrap-monitor", func(ctx context.Context) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+            var wg sync.WaitGroup
+            bootstraps := node.bootstrapSet.list()
+            ids := node.bootstrapSet.ids()
+            for _, info := range bootstraps {
+                wg.Add(1)
+                go func(info peer.AddrInfo) {
+                    defer wg.Done()
+                    probe := node.probeBootstrap(ctx, info)
+                    if ctx.Err() != nil {
+                        return
+                    }
+
+                    allDown, changed := node.bootstrapMonitor.record(info.ID, probe, ids)
+                    if !changed {
+                        return
+                    } else if allDown {
+                        log.Println("WARNING: All bootstraps are unreachable")
+                        node.emit(Event{Type: EventBootstrapsUnreachable})
+                    } else {
+                        log.Println("Bootstraps are reachable again")
+                        node.emit(Event{Type: EventBootstrapsReachable, Peer: info.ID})
+                    }
+                }(info)
+            }
+            wg.Wait()
+
+            select {
+            case <-ticker.C:
+            case <-ctx.Done():
+                return
+            }
+        }
+    })
+}
+
+// Returns the reachability of each bootstrap, as probed in the background
+// every Config.BootstrapProbeInterval
+func (node *Node) BootstrapStatus() []BootstrapStatus {
+    bm := node.bootstrapMonitor
+    if bm == nil {
+        return nil
+    }
+
+    bootstraps := node.bootstrapSet.ids()
+
+    bm.mutex.Lock()
+    defer bm.mutex.Unlock()
+
+    statuses := make([]BootstrapStatus, 0, len(bootstraps))
+    for _, id := range bootstraps {
+        status := BootstrapStatus{
+            Peer:       id,
+            Connected:  node.Host().Network().Connectedness(id) == network.Connected,
+            LastSeen:   bm.lastSeen[id],
+            History:    append([]BootstrapProbe(nil), bm.history[id]...),
+        }
+
+        reachable := 0
+        for _, probe := range status.History {
+            if probe.Reachable {
+                reachable++
+            }
+        }
+        if n := len(status.History); n > 0 {
+            status.Reachable = status.History[n - 1].Reachable
+            status.Availability = float64(reachable) / float64(n)
+        }
+        statuses = append(statuses, status)
+    }
+    return statuses
+}
@@ -0,0 +1,69 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "time"
+)
+
+const (
+    // Interval between keep-alive pings sent by bootstrap nodes to each
+    // connected peer
+    BootstrapKeepAliveInterval = 30 * time.Second
+)
+
+// Creates a Node dedicated to bootstrapping others. The settings of
+// NewBootstrapConfig() are applied on top of the Config (connection limits
+// only if left unset): the DHT runs in server mode, the node relays
+// traffic and answers AutoNAT and status requests, and it pings its
+// connected peers every Config.KeepAliveInterval (defaulting to
+// BootstrapKeepAliveInterval) so idle connections through NATs aren't
+// dropped. Bootstrap nodes do not advertise, so the
+// Config must not have any Rendezvous strings.
+func NewBootstrapNode(ctx context.Context, config Config) (Node, error) {
+    if len(config.Rendezvous) > 0 {
+        return Node{}, errors.New("Bootstrap nodes cannot advertise Rendezvous strings")
+    } else if config.ObserverMode {
+        return Node{}, errors.New("Bootstrap nodes cannot run in observer mode")
+    }
+
+    preset := NewBootstrapConfig()
+    config.DHTClientMode = false
+    config.EnableRelayHop = preset.EnableRelayHop
+    config.EnableAutoNATService = preset.EnableAutoNATService
+    config.EnableStatus = preset.EnableStatus
+    if config.ConnMgrHighWater == 0 {
+        config.ConnMgrLowWater = preset.ConnMgrLowWater
+        config.ConnMgrHighWater = preset.ConnMgrHighWater
+        config.ConnMgrGracePeriod = preset.ConnMgrGracePeriod
+    }
+
+    // Keep every connection alive, rather than only the selected ones
+    keepAliveInterval := config.KeepAliveInterval
+    if keepAliveInterval == 0 {
+        keepAliveInterval = BootstrapKeepAliveInterval
+    }
+    config.KeepAliveInterval = 0
+
+    node, err := NewNode(ctx, config)
+    if err != nil {
+        return node, err
+    }
+
+    node.keepAlive(keepAliveInterval, node.allConns)
+    return node, nil
+}
@@ -0,0 +1,231 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+// Where a bootstrap in the Node's bootstrap set came from
+type bootstrapSource int
+
+const (
+    // Config.BootstrapPeers
+    bootstrapFromConfig bootstrapSource = iota
+    // The remote list at Config.BootstrapListURL
+    bootstrapFromRemote
+    // Node.AddBootstrap()
+    bootstrapFromManual
+)
+
+type bootstrapEntry struct {
+    info    peer.AddrInfo
+    source  bootstrapSource
+}
+
+// The bootstraps a Node connects to, reconnects to and monitors. It starts
+// with Config.BootstrapPeers, and changes as remote lists are fetched and
+// as bootstraps are added or removed at runtime.
+type bootstrapSet struct {
+    mutex   sync.Mutex
+    entries map[peer.ID]*bootstrapEntry
+    // IDs in the order they were added, so bootstraps are listed stably
+    order   []peer.ID
+}
+
+func newBootstrapSet(bootstraps []multiaddr.Multiaddr) (*bootstrapSet, error) {
+    bs := &bootstrapSet{entries: make(map[peer.ID]*bootstrapEntry)}
+
+    infos, err := peer.AddrInfosFromP2pAddrs(bootstraps...)
+    if err != nil {
+        return nil, fmt.Errorf("ERROR: Unable to parse bootstrap AddrInfos\n%w\n", err)
+    }
+    for _, info := range infos {
+        bs.add(info, bootstrapFromConfig)
+    }
+    return bs, nil
+}
+
+// Adds the bootstrap, or merges its addresses into an existing one.
+// Returns true if the bootstrap was not already in the set.
+func (bs *bootstrapSet) add(info peer.AddrInfo, source bootstrapSource) bool {
+    bs.mutex.Lock()
+    defer bs.mutex.Unlock()
+
+    if entry, ok := bs.entries[info.ID]; ok {
+        for _, addr := range info.Addrs {
+            if !containsAddr(entry.info.Addrs, addr) {
+                entry.info.Addrs = append(entry.info.Addrs, addr)
+            }
+        }
+        // Bootstraps added by hand are kept even if a remote list drops them
+        if source == bootstrapFromManual {
+            entry.source = source
+        }
+        return false
+    }
+
+    bs.entries[info.ID] = &bootstrapEntry{info: info, source: source}
+    bs.order = append(bs.order, info.ID)
+    return true
+}
+
+// Removes the bootstrap, returning true if it was in the set
+func (bs *bootstrapSet) remove(id peer.ID) bool {
+    bs.mutex.Lock()
+    defer bs.mutex.Unlock()
+
+    if _, ok := bs.entries[id]; !ok {
+        return false
+    }
+    delete(bs.entries, id)
+    for i, other := range bs.order {
+        if other == id {
+            bs.order = append(bs.order[:i], bs.order[i+1:]...)
+            break
+        }
+    }
+    return true
+}
+
+// Returns the bootstrap with the given ID, if it is in the set
+func (bs *bootstrapSet) get(id peer.ID) (peer.AddrInfo, bool) {
+    bs.mutex.Lock()
+    defer bs.mutex.Unlock()
+
+    entry, ok := bs.entries[id]
+    if !ok {
+        return peer.AddrInfo{}, false
+    }
+    return entry.info, true
+}
+
+// Returns the bootstraps in the set
+func (bs *bootstrapSet) list() []peer.AddrInfo {
+    bs.mutex.Lock()
+    defer bs.mutex.Unlock()
+
+    infos := make([]peer.AddrInfo, 0, len(bs.order))
+    for _, id := range bs.order {
+        infos = append(infos, bs.entries[id].info)
+    }
+    return infos
+}
+
+// Returns the IDs of the bootstraps in the set
+func (bs *bootstrapSet) ids() []peer.ID {
+    bs.mutex.Lock()
+    defer bs.mutex.Unlock()
+    return append([]peer.ID(nil), bs.order...)
+}
+
+// Makes the bootstraps from the given source match 'infos', leaving those
+// from other sources alone. Returns the bootstraps added to the set, and
+// the IDs of those removed from it.
+func (bs *bootstrapSet) sync(source bootstrapSource,
+    infos []peer.AddrInfo) (added []peer.AddrInfo, removed []peer.ID) {
+
+    wanted := make(map[peer.ID]bool, len(infos))
+    for _, info := range infos {
+        wanted[info.ID] = true
+        if bs.add(info, source) {
+            added = append(added, info)
+        }
+    }
+
+    bs.mutex.Lock()
+    var stale []peer.ID
+    for _, id := range bs.order {
+        if bs.entries[id].source == source && !wanted[id] {
+            stale = append(stale, id)
+        }
+    }
+    bs.mutex.Unlock()
+
+    for _, id := range stale {
+        if bs.remove(id) {
+            removed = append(removed, id)
+        }
+    }
+    return added, removed
+}
+
+var (
+    ErrBootstrapSelf = errors.New("Cannot add the Node itself as a bootstrap")
+)
+
+// Adds a bootstrap to the Node's bootstrap set and tries to connect to it
+// right away. Once added, the bootstrap is reconnected to if its
+// connection is lost, and is monitored like those in Config.BootstrapPeers.
+// If the connection attempt fails, the bootstrap stays in the set and the
+// error is returned. Adding a bootstrap already in the set merges in any
+// new addresses.
+func (node *Node) AddBootstrap(ctx context.Context, addr multiaddr.Multiaddr) error {
+    info, err := peer.AddrInfoFromP2pAddr(addr)
+    if err != nil {
+        return fmt.Errorf("ERROR: Unable to parse bootstrap AddrInfo\n%w\n", err)
+    }
+    if info.ID == node.Host().ID() {
+        return ErrBootstrapSelf
+    }
+
+    if node.bootstrapSet.add(*info, bootstrapFromManual) {
+        log.Println("Added bootstrap node:", info)
+    }
+    node.ProtectPeer(info.ID, BootstrapPeerTag)
+
+    // Dial the bootstrap's full set of known addresses
+    merged, _ := node.bootstrapSet.get(info.ID)
+    if err = node.connect(ctx, node.resolveAddrInfo(ctx, merged)); err != nil {
+        return &BootstrapConnectError{Peer: info.ID, Attempts: 1, Err: err}
+    }
+    log.Println("Connected to bootstrap node:", merged)
+    return nil
+}
+
+// Removes a bootstrap from the Node's bootstrap set, wherever it came from.
+// The Node stays connected to it, but stops reconnecting to it, monitoring
+// it and protecting its connections from pruning. Returns false if it was
+// not a bootstrap.
+func (node *Node) RemoveBootstrap(id peer.ID) bool {
+    if !node.bootstrapSet.remove(id) {
+        return false
+    }
+    node.UnprotectPeer(id, BootstrapPeerTag)
+    log.Println("Removed bootstrap node:", id)
+    return true
+}
+
+// Returns the Node's current bootstraps
+func (node *Node) Bootstraps() []peer.AddrInfo {
+    return node.bootstrapSet.list()
+}
+
+func containsAddr(addrs []multiaddr.Multiaddr, addr multiaddr.Multiaddr) bool {
+    for _, other := range addrs {
+        if other.Equal(addr) {
+            return true
+        }
+    }
+    return false
+}
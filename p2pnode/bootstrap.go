@@ -0,0 +1,167 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+    // Wrapped by BootstrapError when all MaxConnAttempts attempts failed
+    ErrNoBootstrapsReachable = errors.New("All connection attempts failed")
+)
+
+// Returned by NewNode when it cannot connect to any of its bootstraps.
+// Err is context.DeadlineExceeded if Config.BootstrapTimeout elapsed,
+// context.Canceled if the context passed to NewNode was cancelled, or
+// ErrNoBootstrapsReachable if all MaxConnAttempts attempts failed. Failures
+// holds the last error from each bootstrap.
+type BootstrapError struct {
+    Attempts    int
+    Err         error
+    Failures    []*BootstrapConnectError
+}
+
+func (e *BootstrapError) Error() string {
+    return fmt.Sprintf("Failed to connect to any bootstraps after %d attempts: %v",
+        e.Attempts, e.Err)
+}
+
+func (e *BootstrapError) Unwrap() error {
+    return e.Err
+}
+
+// Failure to connect to a single bootstrap, after the given number of
+// attempts. Err is usually a *DialError.
+type BootstrapConnectError struct {
+    Peer        peer.ID
+    Attempts    int
+    Err         error
+}
+
+func (e *BootstrapConnectError) Error() string {
+    return fmt.Sprintf("Failed to connect to bootstrap %s after %d attempts: %v",
+        e.Peer, e.Attempts, e.Err)
+}
+
+func (e *BootstrapConnectError) Unwrap() error {
+    return e.Err
+}
+
+// Records the failed attempts to connect to each bootstrap
+type bootstrapFailures struct {
+    mutex       sync.Mutex
+    failures    map[peer.ID]*BootstrapConnectError
+}
+
+func (bf *bootstrapFailures) record(id peer.ID, err error) {
+    bf.mutex.Lock()
+    defer bf.mutex.Unlock()
+
+    failure, ok := bf.failures[id]
+    if !ok {
+        failure = &BootstrapConnectError{Peer: id}
+        bf.failures[id] = failure
+    }
+    failure.Attempts++
+    failure.Err = err
+}
+
+// Returns a BootstrapError for the attempts, wrapping 'err'
+func (bf *bootstrapFailures) toError(attempts int, err error) *BootstrapError {
+    bf.mutex.Lock()
+    defer bf.mutex.Unlock()
+
+    be := &BootstrapError{Attempts: attempts, Err: err}
+    for _, failure := range bf.failures {
+        be.Failures = append(be.Failures, failure)
+    }
+    return be
+}
+
+// Connects to the bootstraps, backing off exponentially between attempts
+// until at least one connection succeeds, up to MaxConnAttempts attempts.
+// Gives up early if the Node's context is done or 'timeout' (if non-zero)
+// elapses.
+func (node *Node) connectBootstraps(addrInfos []peer.AddrInfo,
+    timeout time.Duration) error {
+
+    ctx := node.Ctx
+    if timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+        defer cancel()
+    }
+
+    failures := &bootstrapFailures{failures: make(map[peer.ID]*BootstrapConnectError)}
+    numConnected := 0
+    bootstrapAttempts := 0
+    for numConnected == 0 && bootstrapAttempts < MaxConnAttempts {
+        if bootstrapAttempts > 0 {
+            sleepDuration := time.Duration(1 << uint(bootstrapAttempts)) * time.Second
+            log.Printf("Unable to connect to any peers, retrying in %v...\n", sleepDuration)
+            select {
+            case <-time.After(sleepDuration):
+            case <-ctx.Done():
+                return failures.toError(bootstrapAttempts, ctx.Err())
+            }
+        }
+
+        bootstrapAttempts++
+
+        log.Println("Connecting to bootstrap nodes...")
+        var wg sync.WaitGroup
+        for _, addrInfo := range addrInfos {
+            wg.Add(1)
+            go func(addr peer.AddrInfo) {
+                defer wg.Done()
+                addr = node.resolveAddrInfo(ctx, addr)
+                if err := node.connect(ctx, addr); err != nil {
+                    failures.record(addr.ID, err)
+                    log.Println(err)
+                } else {
+                    log.Println("Connected to bootstrap node:", addr)
+                }
+            }(addrInfo)
+        }
+        wg.Wait()
+
+        // Count only connections whose internal state is Connected
+        for _, peerID := range node.Host().Network().Peers() {
+            if node.Host().Network().Connectedness(peerID) == network.Connected {
+                numConnected++
+            }
+        }
+
+        if numConnected == 0 && ctx.Err() != nil {
+            return failures.toError(bootstrapAttempts, ctx.Err())
+        }
+    }
+
+    if numConnected == 0 {
+        return failures.toError(bootstrapAttempts, ErrNoBootstrapsReachable)
+    }
+
+    log.Println("Connected to", numConnected, "peers!")
+    return nil
+}
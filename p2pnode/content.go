@@ -0,0 +1,75 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Parses an IPFS hash, as returned by util.IpfsHashFile() and
+// util.IpfsHashBytes(), into a CID for use with ProvideContent() and
+// FindContentProviders()
+func ContentID(hash string) (cid.Cid, error) {
+    return cid.Decode(hash)
+}
+
+// Announces on the DHT that the Node provides the content. Provider records
+// expire after a day, so this should be repeated while the content is
+// available.
+func (node *Node) ProvideContent(ctx context.Context, c cid.Cid) error {
+    if node.observer {
+        return ErrObserverMode
+    } else if node.Draining() {
+        return ErrDraining
+    }
+    return node.DHT().Provide(ctx, c, true)
+}
+
+// Finds up to 'limit' peers (or all that can be found, if 0) providing the
+// content, excluding the Node itself
+func (node *Node) FindContentProviders(ctx context.Context, c cid.Cid,
+    limit int) ([]peer.AddrInfo, error) {
+
+    self := node.Host().ID()
+
+    // Stop the lookup once enough providers are found
+    findCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    // Ask for one more in case the Node is among the providers
+    count := limit
+    if count > 0 {
+        count++
+    }
+
+    var providers []peer.AddrInfo
+    for info := range node.DHT().FindProvidersAsync(findCtx, c, count) {
+        if info.ID == self {
+            continue
+        }
+        providers = append(providers, info)
+        if limit > 0 && len(providers) >= limit {
+            break
+        }
+    }
+
+    if len(providers) == 0 && ctx.Err() != nil {
+        return nil, ctx.Err()
+    }
+    return providers, nil
+}
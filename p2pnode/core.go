@@ -0,0 +1,201 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "sync"
+
+    "github.com/libp2p/go-libp2p-core/event"
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-kad-dht"
+    mdns "github.com/libp2p/go-libp2p/p2p/discovery"
+)
+
+// Holds the libp2p objects that make up a Node. These are set during
+// construction and may be swapped out while the Node is running (e.g. when
+// its identity is rotated), so all access goes through a lock.
+type nodeCore struct {
+    mutex               sync.RWMutex
+    host                host.Host
+    dht                 *dht.IpfsDHT
+    discovery           Discovery
+    router              PeerRouter
+    events              event.Emitter
+    mdns                mdns.Service
+    gater               *PeerGater
+    callbacks           *network.NotifyBundle
+
+    // Context of the current host, derived from the Node's. Services bound
+    // to the host (e.g. its DHT and event subscriptions) are tied to it, so
+    // they stop when the host is swapped out.
+    hostCtx             context.Context
+    hostCancel          context.CancelFunc
+
+    // Serializes identity rotations
+    rotating            sync.Mutex
+}
+
+// Replaces the host's context. The previous one (if any) is left running,
+// so the previous host keeps working until the swap is complete (see
+// RotateIdentity()).
+func (core *nodeCore) setHostCtx(ctx context.Context, cancel context.CancelFunc) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.hostCtx, core.hostCancel = ctx, cancel
+}
+
+// The current host and the objects bound to it, saved before swapping in a
+// new host so that a failed swap can be undone
+type hostSnapshot struct {
+    ctx     context.Context
+    cancel  context.CancelFunc
+    host    host.Host
+    dht     *dht.IpfsDHT
+    router  PeerRouter
+    events  event.Emitter
+}
+
+func (core *nodeCore) snapshot() hostSnapshot {
+    core.mutex.RLock()
+    defer core.mutex.RUnlock()
+    return hostSnapshot{
+        ctx:    core.hostCtx,
+        cancel: core.hostCancel,
+        host:   core.host,
+        dht:    core.dht,
+        router: core.router,
+        events: core.events,
+    }
+}
+
+func (core *nodeCore) restore(snap hostSnapshot) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.hostCtx, core.hostCancel = snap.ctx, snap.cancel
+    core.host = snap.host
+    core.dht = snap.dht
+    core.router = snap.router
+    core.events = snap.events
+}
+
+func (core *nodeCore) setHost(h host.Host) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.host = h
+}
+
+func (core *nodeCore) setDHT(kdht *dht.IpfsDHT) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.dht = kdht
+}
+
+func (core *nodeCore) setRouter(router PeerRouter) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.router = router
+}
+
+func (core *nodeCore) setEvents(emitter event.Emitter) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.events = emitter
+}
+
+// Replaces the mDNS service, returning the previous one (if any)
+func (core *nodeCore) swapMDNS(service mdns.Service) mdns.Service {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    prev := core.mdns
+    core.mdns = service
+    return prev
+}
+
+func (core *nodeCore) getRouter() PeerRouter {
+    core.mutex.RLock()
+    defer core.mutex.RUnlock()
+    return core.router
+}
+
+func (core *nodeCore) getEvents() event.Emitter {
+    core.mutex.RLock()
+    defer core.mutex.RUnlock()
+    return core.events
+}
+
+func (core *nodeCore) setDiscovery(disc Discovery) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.discovery = disc
+}
+
+func (core *nodeCore) setGater(gater *PeerGater) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.gater = gater
+}
+
+func (core *nodeCore) setCallbacks(callbacks *network.NotifyBundle) {
+    core.mutex.Lock()
+    defer core.mutex.Unlock()
+    core.callbacks = callbacks
+}
+
+// Returns the Node's libp2p Host, or nil if it has not been created yet
+func (node *Node) Host() host.Host {
+    node.core.mutex.RLock()
+    defer node.core.mutex.RUnlock()
+    return node.core.host
+}
+
+// Returns the Node's DHT, or nil if it has not been created yet
+func (node *Node) DHT() *dht.IpfsDHT {
+    node.core.mutex.RLock()
+    defer node.core.mutex.RUnlock()
+    return node.core.dht
+}
+
+// Returns the context of the Node's current host
+func (node *Node) hostContext() context.Context {
+    node.core.mutex.RLock()
+    defer node.core.mutex.RUnlock()
+    return node.core.hostCtx
+}
+
+// Returns the gater enforcing Config.AllowPeers, DenyPeers, AllowSubnets and
+// DenySubnets, which can be used to block peers at runtime
+func (node *Node) Gater() *PeerGater {
+    node.core.mutex.RLock()
+    defer node.core.mutex.RUnlock()
+    return node.core.gater
+}
+
+// Returns the Node's mDNS service, or nil if Config.EnableMDNS is unset
+func (node *Node) MDNS() mdns.Service {
+    node.core.mutex.RLock()
+    defer node.core.mutex.RUnlock()
+    return node.core.mdns
+}
+
+// Returns the network callbacks registered on the Node's host, which follow
+// it to any new host (see RotateIdentity()). By default they reconnect to
+// lost bootstraps, and other callbacks can be added to them.
+func (node *Node) NetworkCallbacks() *network.NotifyBundle {
+    node.core.mutex.RLock()
+    defer node.core.mutex.RUnlock()
+    return node.core.callbacks
+}
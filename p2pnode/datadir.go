@@ -0,0 +1,75 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "log"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+// Algorithm and size of identity keys generated for a Config.DataDir,
+// matching the defaults of util.AddKeyFlags()
+const (
+    dataDirKeyAlgo = "RSA"
+    dataDirKeyBits = 2048
+)
+
+// Opens the Config's data directory, and fills in the paths of files it
+// persists, and its identity key, that are not already set
+func applyDataDir(config *Config) error {
+    dd, err := util.OpenDataDir(config.DataDir)
+    if err != nil {
+        return err
+    }
+
+    if config.StateFile == "" {
+        config.StateFile = dd.StatePath()
+    }
+    if config.IdentityHistoryFile == "" {
+        config.IdentityHistoryFile = dd.IdentityHistoryPath()
+    }
+    if config.PeerstorePath == "" {
+        config.PeerstorePath = dd.PeerstorePath()
+    }
+    if config.DNSCacheFile == "" {
+        config.DNSCacheFile = dd.DNSCachePath()
+    }
+
+    if config.PrivKey == nil {
+        config.PrivKey, err = loadOrCreateKey(dd.IdentityKeyPath())
+    }
+    return err
+}
+
+// Loads the key stored at 'keyFile', generating and storing one if the
+// file does not exist
+func loadOrCreateKey(keyFile string) (crypto.PrivKey, error) {
+    if util.FileExists(keyFile) {
+        return util.LoadPrivKeyFromFile(keyFile)
+    }
+
+    log.Println("Generating new identity key at", keyFile)
+    priv, err := util.GeneratePrivKey(dataDirKeyAlgo, dataDirKeyBits)
+    if err != nil {
+        return nil, err
+    }
+    if err = util.StorePrivKeyToFile(priv, keyFile); err != nil {
+        return nil, err
+    }
+    return priv, nil
+}
@@ -0,0 +1,64 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestApplyDataDir(test *testing.T) {
+    dir, err := ioutil.TempDir("", "p2pnode-datadir")
+    if err != nil {
+        test.Fatalf("Unable to create temp dir:\n%v", err)
+    }
+    defer os.RemoveAll(dir)
+
+    config := NewConfig()
+    config.DataDir = dir
+    config.StateFile = filepath.Join(dir, "custom.json")
+    if err = applyDataDir(&config); err != nil {
+        test.Fatalf("applyDataDir() failed:\n%v", err)
+    }
+
+    if config.StateFile != filepath.Join(dir, "custom.json") {
+        test.Errorf("applyDataDir() replaced StateFile %s, which was already set", config.StateFile)
+    }
+    for name, path := range map[string]string{
+        "IdentityHistoryFile":  config.IdentityHistoryFile,
+        "PeerstorePath":        config.PeerstorePath,
+        "DNSCacheFile":         config.DNSCacheFile,
+    } {
+        if !strings.HasPrefix(path, dir) {
+            test.Errorf("%s %q is not within the data directory", name, path)
+        }
+    }
+    if config.PrivKey == nil {
+        test.Fatalf("applyDataDir() did not set PrivKey")
+    }
+
+    // The stored identity key is loaded again on restart
+    restarted := NewConfig()
+    restarted.DataDir = dir
+    if err = applyDataDir(&restarted); err != nil {
+        test.Fatalf("applyDataDir() of existing data directory failed:\n%v", err)
+    }
+    if restarted.PrivKey == nil || !restarted.PrivKey.Equals(config.PrivKey) {
+        test.Errorf("applyDataDir() did not reload the stored identity key")
+    }
+}
@@ -0,0 +1,62 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "errors"
+    "log"
+
+    "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+// Checks the DHT validators of the Config
+func validateDHTConfig(config *Config) error {
+    // Custom validators are rejected by the DHT under the default prefix,
+    // so catch this here with a clearer error
+    if len(config.DHTValidators) > 0 && config.DHTProtocolPrefix == "" {
+        return errors.New("DHTValidators require a custom DHTProtocolPrefix")
+    }
+    for ns, validator := range config.DHTValidators {
+        if ns == "" || validator == nil {
+            return errors.New("Cannot have empty DHT validator namespace or nil validator")
+        }
+    }
+    return nil
+}
+
+// Returns the options used to construct the Node's DHT
+func dhtOpts(config *Config) ([]dht.Option, error) {
+    opts := []dht.Option{dht.Mode(dht.ModeServer)}
+    if config.DHTClientMode {
+        opts = []dht.Option{dht.Mode(dht.ModeClient)}
+    }
+
+    // Separate deployments sharing the same network should use different
+    // prefixes, so their routing tables and provider records don't mix
+    if config.DHTProtocolPrefix != "" {
+        log.Println("Using DHT protocol prefix", config.DHTProtocolPrefix)
+        opts = append(opts, dht.ProtocolPrefix(config.DHTProtocolPrefix))
+    }
+
+    if err := validateDHTConfig(config); err != nil {
+        return nil, err
+    }
+    for ns, validator := range config.DHTValidators {
+        log.Println("Registering DHT validator for namespace", ns)
+        opts = append(opts, dht.NamespacedValidator(ns, validator))
+    }
+
+    return opts, nil
+}
@@ -0,0 +1,180 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    swarm "github.com/libp2p/go-libp2p-swarm"
+
+    "github.com/multiformats/go-multiaddr"
+    manet "github.com/multiformats/go-multiaddr-net"
+)
+
+const (
+    // Timeout for each address dialed by DiagnosePath()
+    DiagnoseDialTimeout = 10 * time.Second
+)
+
+// Outcome of dialing a single address of a peer
+type AddrDiagnosis struct {
+    Addr        multiaddr.Multiaddr
+    Relay       bool
+
+    // Time to establish a secured, multiplexed connection
+    Latency     time.Duration
+
+    // Set if the dial failed
+    Err         error
+    Class       DialErrorClass
+}
+
+// Round-trip time over an open connection to the peer
+type ConnDiagnosis struct {
+    LocalAddr   multiaddr.Multiaddr
+    RemoteAddr  multiaddr.Multiaddr
+    Relay       bool
+    RTT         time.Duration
+    Err         error
+}
+
+// Report produced by DiagnosePath(), suitable for attaching to tickets
+type PathReport struct {
+    Peer                peer.ID
+    Time                time.Time
+    Connectedness       network.Connectedness
+
+    // Every known address of the peer, dialed individually
+    Addrs               []AddrDiagnosis
+
+    // Every connection to the peer that was open at the time
+    Conns               []ConnDiagnosis
+
+    // Local reachability as determined by AutoNAT. The remote's is only a
+    // guess from the addresses it advertises: private if it only has relay
+    // addresses, public if it has any public address, unknown otherwise.
+    LocalReachability   network.Reachability
+    RemoteReachability  network.Reachability
+}
+
+// Returns true if the address goes through a circuit relay
+func isRelayAddr(addr multiaddr.Multiaddr) bool {
+    _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+    return err == nil
+}
+
+// Guesses the remote peer's reachability from its advertised addresses
+func guessReachability(addrs []multiaddr.Multiaddr) network.Reachability {
+    relayOnly := len(addrs) > 0
+    for _, addr := range addrs {
+        if isRelayAddr(addr) {
+            continue
+        }
+        relayOnly = false
+        if manet.IsPublicAddr(addr) {
+            return network.ReachabilityPublic
+        }
+    }
+
+    if relayOnly {
+        return network.ReachabilityPrivate
+    }
+    return network.ReachabilityUnknown
+}
+
+// Dials a single address of the peer directly through its transport,
+// bypassing the swarm's dial backoff and connection reuse
+func (node *Node) diagnoseAddr(ctx context.Context, id peer.ID,
+    addr multiaddr.Multiaddr) AddrDiagnosis {
+
+    diag := AddrDiagnosis{Addr: addr, Relay: isRelayAddr(addr)}
+
+    s, ok := node.Host().Network().(*swarm.Swarm)
+    if !ok {
+        diag.Err = errors.New("Per-address dialing is not supported by this network")
+        diag.Class = DialClassOther
+        return diag
+    }
+
+    tpt := s.TransportForDialing(addr)
+    if tpt == nil {
+        diag.Err = swarm.ErrNoTransport
+        diag.Class = DialClassNoRoute
+        return diag
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, DiagnoseDialTimeout)
+    defer cancel()
+
+    start := time.Now()
+    conn, err := tpt.Dial(ctx, addr, id)
+    if err != nil {
+        diag.Err = err
+        diag.Class = ClassifyDialError(err, node.usesPSK)
+        return diag
+    }
+    diag.Latency = time.Since(start)
+    conn.Close()
+
+    return diag
+}
+
+// Diagnoses connectivity to a peer that is unreachable or slow. Every known
+// address of the peer is dialed individually, every open connection to it
+// is pinged, and the results are returned with the NAT status of both ends.
+func (node *Node) DiagnosePath(ctx context.Context, id peer.ID) PathReport {
+    h := node.Host()
+    addrs := h.Peerstore().Addrs(id)
+
+    report := PathReport{
+        Peer:               id,
+        Time:               time.Now(),
+        Connectedness:      h.Network().Connectedness(id),
+        Addrs:              make([]AddrDiagnosis, len(addrs)),
+        LocalReachability:  node.Reachability(),
+        RemoteReachability: guessReachability(addrs),
+    }
+
+    var wg sync.WaitGroup
+    for i, addr := range addrs {
+        wg.Add(1)
+        go func(i int, addr multiaddr.Multiaddr) {
+            defer wg.Done()
+            report.Addrs[i] = node.diagnoseAddr(ctx, id, addr)
+        }(i, addr)
+    }
+    wg.Wait()
+
+    for _, conn := range h.Network().ConnsToPeer(id) {
+        pingCtx, cancel := context.WithTimeout(ctx, pathPingTimeout)
+        rtt, err := measureConn(pingCtx, conn)
+        cancel()
+
+        report.Conns = append(report.Conns, ConnDiagnosis{
+            LocalAddr:  conn.LocalMultiaddr(),
+            RemoteAddr: conn.RemoteMultiaddr(),
+            Relay:      isRelayAddr(conn.RemoteMultiaddr()),
+            RTT:        rtt,
+            Err:        err,
+        })
+    }
+
+    return report
+}
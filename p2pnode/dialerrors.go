@@ -0,0 +1,147 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "syscall"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+    swarm "github.com/libp2p/go-libp2p-swarm"
+)
+
+// Broad cause of a failed dial, to tell configuration errors apart from
+// network outages
+type DialErrorClass string
+
+const (
+    // No route to the peer's network or host
+    DialClassNoRoute        DialErrorClass = "no-route"
+    // The peer's host actively refused the connection
+    DialClassRefused        DialErrorClass = "refused"
+    // Connected, but could not agree on security or muxer protocols
+    DialClassNegotiation    DialErrorClass = "negotiation"
+    // Negotiation failed on a node using a PSK, which is most likely due to
+    // the peer using a different PSK (or none)
+    DialClassPSKMismatch    DialErrorClass = "psk-mismatch"
+    // Blocked by a connection gater or address filter
+    DialClassGated          DialErrorClass = "gated"
+    DialClassTimeout        DialErrorClass = "timeout"
+    DialClassOther          DialErrorClass = "other"
+)
+
+// Returned by the Node's own dials (e.g. to bootstraps) when they fail
+type DialError struct {
+    Peer    peer.ID
+    Class   DialErrorClass
+    Err     error
+}
+
+func (e *DialError) Error() string {
+    return fmt.Sprintf("Dial to %s failed (%s): %v", e.Peer, e.Class, e.Err)
+}
+
+func (e *DialError) Unwrap() error {
+    return e.Err
+}
+
+// Classifies a single, unwrapped dial error
+func classifyCause(err error, usesPSK bool) DialErrorClass {
+    switch {
+    case errors.Is(err, swarm.ErrGaterDisallowedConnection),
+        errors.Is(err, swarm.ErrAddrFiltered):
+        return DialClassGated
+    case errors.Is(err, syscall.ECONNREFUSED):
+        return DialClassRefused
+    case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH),
+        errors.Is(err, swarm.ErrNoAddresses), errors.Is(err, swarm.ErrNoGoodAddresses):
+        return DialClassNoRoute
+    case errors.Is(err, swarm.ErrDialTimeout), errors.Is(err, context.DeadlineExceeded),
+        os.IsTimeout(err):
+        return DialClassTimeout
+    case strings.Contains(err.Error(), "failed to negotiate"):
+        if usesPSK {
+            return DialClassPSKMismatch
+        }
+        return DialClassNegotiation
+    default:
+        return DialClassOther
+    }
+}
+
+// Order in which classes win when different addresses of a peer failed in
+// different ways. Classes pointing at configuration errors come first.
+var dialClassPriority = []DialErrorClass{
+    DialClassGated,
+    DialClassPSKMismatch,
+    DialClassNegotiation,
+    DialClassRefused,
+    DialClassNoRoute,
+    DialClassTimeout,
+}
+
+// Returns the class of an error returned by dialing a peer (e.g. by
+// Host.Connect()). 'usesPSK' should be true if the dialing node is part of
+// a private network, in which case negotiation failures are attributed to
+// mismatched PSKs.
+func ClassifyDialError(err error, usesPSK bool) DialErrorClass {
+    var de *swarm.DialError
+    if !errors.As(err, &de) || len(de.DialErrors) == 0 {
+        return classifyCause(err, usesPSK)
+    }
+
+    classes := make(map[DialErrorClass]bool)
+    if de.Cause != nil {
+        classes[classifyCause(de.Cause, usesPSK)] = true
+    }
+    for _, te := range de.DialErrors {
+        classes[classifyCause(te.Cause, usesPSK)] = true
+    }
+
+    for _, class := range dialClassPriority {
+        if classes[class] {
+            return class
+        }
+    }
+    return DialClassOther
+}
+
+// Connects to the peer, ranking its addresses (see Config.DialStageTimeout)
+// and classifying and counting any failure
+func (node *Node) connect(ctx context.Context, ai peer.AddrInfo) error {
+    var err error
+    if stageTimeout := node.config.DialStageTimeout; stageTimeout < 0 {
+        err = node.Host().Connect(ctx, ai)
+    } else {
+        if stageTimeout == 0 {
+            stageTimeout = DefaultDialStageTimeout
+        }
+        err = node.dialRanked(ctx, ai, stageTimeout)
+    }
+    if err == nil {
+        return nil
+    }
+
+    class := ClassifyDialError(err, node.usesPSK)
+    node.metrics.dialFailures.WithLabelValues(string(class)).Inc()
+    if node.reputation != nil && class != DialClassGated {
+        node.reputation.RecordDialFailure(ai.ID)
+    }
+    return &DialError{Peer: ai.ID, Class: class, Err: err}
+}
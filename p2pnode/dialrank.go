@@ -0,0 +1,271 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    swarm "github.com/libp2p/go-libp2p-swarm"
+
+    "github.com/multiformats/go-multiaddr"
+    manet "github.com/multiformats/go-multiaddr-net"
+)
+
+const (
+    // Default time given to each stage of a ranked dial before the next
+    // stage's addresses are added (see Config.DialStageTimeout)
+    DefaultDialStageTimeout = 2 * time.Second
+
+    // Maximum number of peers whose last successful address is remembered
+    maxGoodDialAddrs = 4096
+)
+
+// Rank of an address when dialing, lower ranks being dialed first
+type dialRank int
+
+const (
+    rankPreviouslyGood dialRank = iota
+    rankPublicQUIC
+    rankPublic
+    rankPrivateQUIC
+    rankPrivate
+    rankRelay
+)
+
+// Returns the rank of the address, given the peer's last successful one
+func rankAddr(addr, good multiaddr.Multiaddr) dialRank {
+    if good != nil && addr.Equal(good) {
+        return rankPreviouslyGood
+    }
+    if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+        return rankRelay
+    }
+
+    _, err := addr.ValueForProtocol(multiaddr.P_QUIC)
+    quic := err == nil
+    // DNS addresses are most likely public, and are resolved before dialing
+    public := manet.IsPublicAddr(addr)
+    switch {
+    case public && quic:
+        return rankPublicQUIC
+    case public:
+        return rankPublic
+    case quic:
+        return rankPrivateQUIC
+    default:
+        return rankPrivate
+    }
+}
+
+// Splits the addresses into stages of equal rank, best first. 'good' (if
+// not nil) is the address last used to connect to the peer.
+func rankAddrs(addrs []multiaddr.Multiaddr, good multiaddr.Multiaddr) [][]multiaddr.Multiaddr {
+    ranked := append([]multiaddr.Multiaddr(nil), addrs...)
+    ranks := make(map[string]dialRank, len(ranked))
+    for _, addr := range ranked {
+        ranks[addr.String()] = rankAddr(addr, good)
+    }
+    sort.SliceStable(ranked, func(i, j int) bool {
+        return ranks[ranked[i].String()] < ranks[ranked[j].String()]
+    })
+
+    var stages [][]multiaddr.Multiaddr
+    for i, addr := range ranked {
+        if i == 0 || ranks[addr.String()] != ranks[ranked[i-1].String()] {
+            stages = append(stages, nil)
+        }
+        stages[len(stages)-1] = append(stages[len(stages)-1], addr)
+    }
+    return stages
+}
+
+// Remembers the last address each peer was successfully dialed on
+type goodDialAddrs struct {
+    mutex   sync.Mutex
+    addrs   map[peer.ID]multiaddr.Multiaddr
+}
+
+func newGoodDialAddrs() *goodDialAddrs {
+    return &goodDialAddrs{addrs: make(map[peer.ID]multiaddr.Multiaddr)}
+}
+
+// Records the remote address of outbound connections
+func (g *goodDialAddrs) connected(conn network.Conn) {
+    if conn.Stat().Direction != network.DirOutbound {
+        return
+    }
+
+    g.mutex.Lock()
+    defer g.mutex.Unlock()
+    if _, ok := g.addrs[conn.RemotePeer()]; !ok && len(g.addrs) >= maxGoodDialAddrs {
+        // Start over rather than track recency; these are only hints
+        g.addrs = make(map[peer.ID]multiaddr.Multiaddr)
+    }
+    g.addrs[conn.RemotePeer()] = conn.RemoteMultiaddr()
+}
+
+func (g *goodDialAddrs) get(id peer.ID) multiaddr.Multiaddr {
+    g.mutex.Lock()
+    defer g.mutex.Unlock()
+    return g.addrs[id]
+}
+
+// Addresses a single ranked dial currently allows, or any if 'all' is set
+type dialStage struct {
+    addrs   map[string]bool
+    all     bool
+}
+
+// Restricts the addresses of peers being dialed by dialRanked() to those of
+// the stages reached so far. The swarm dials every address the peerstore
+// holds for a peer (e.g. those learned through identify or peer exchange),
+// so the Node's PeerGater consults this to hold back the rest.
+type dialStages struct {
+    mutex   sync.Mutex
+    stages  map[peer.ID][]*dialStage
+}
+
+func newDialStages() *dialStages {
+    return &dialStages{stages: make(map[peer.ID][]*dialStage)}
+}
+
+// Registers a ranked dial to the peer, initially allowing no addresses
+func (ds *dialStages) start(id peer.ID) *dialStage {
+    stage := &dialStage{}
+    ds.mutex.Lock()
+    defer ds.mutex.Unlock()
+    ds.stages[id] = append(ds.stages[id], stage)
+    return stage
+}
+
+// Allows the dial to use the given addresses, or any address if 'all'
+func (ds *dialStages) allow(stage *dialStage, addrs []multiaddr.Multiaddr, all bool) {
+    allowed := make(map[string]bool, len(addrs))
+    for _, addr := range addrs {
+        allowed[addr.String()] = true
+    }
+
+    ds.mutex.Lock()
+    defer ds.mutex.Unlock()
+    stage.addrs, stage.all = allowed, all
+}
+
+// Lifts the dial's restriction on the peer's addresses
+func (ds *dialStages) finish(id peer.ID, stage *dialStage) {
+    ds.mutex.Lock()
+    defer ds.mutex.Unlock()
+    stages := ds.stages[id]
+    for i, other := range stages {
+        if other == stage {
+            stages = append(stages[:i], stages[i+1:]...)
+            break
+        }
+    }
+    if len(stages) == 0 {
+        delete(ds.stages, id)
+    } else {
+        ds.stages[id] = stages
+    }
+}
+
+// Returns true unless every ranked dial in progress to the peer holds the
+// address back. Used as the PeerGater's dial filter.
+func (ds *dialStages) allowed(id peer.ID, addr multiaddr.Multiaddr) bool {
+    ds.mutex.Lock()
+    defer ds.mutex.Unlock()
+    stages, ok := ds.stages[id]
+    if !ok {
+        return true
+    }
+    for _, stage := range stages {
+        if stage.all || stage.addrs[addr.String()] {
+            return true
+        }
+    }
+    return false
+}
+
+// Dials the peer's addresses in stages of decreasing rank: the address
+// that last worked, then public QUIC, public, private QUIC, private and
+// relay addresses. The addresses of each stage are dialed in parallel
+// (along with those of earlier stages), the first connection cancelling
+// the rest. If a stage fails or 'stageTimeout' elapses, the next stage
+// starts. Other addresses the peerstore holds for the peer are held back
+// (see dialStages) until the last stage. Returns the most telling error if
+// every stage fails.
+func (node *Node) dialRanked(ctx context.Context, ai peer.AddrInfo,
+    stageTimeout time.Duration) error {
+
+    h := node.Host()
+    if h.Network().Connectedness(ai.ID) == network.Connected {
+        return nil
+    }
+
+    stages := rankAddrs(ai.Addrs, node.goodAddrs.get(ai.ID))
+    if len(stages) <= 1 {
+        return h.Connect(ctx, ai)
+    }
+
+    allowed := node.stages.start(ai.ID)
+    defer node.stages.finish(ai.ID, allowed)
+
+    var errs []error
+    dialing := peer.AddrInfo{ID: ai.ID}
+    for i, stage := range stages {
+        dialing.Addrs = append(dialing.Addrs, stage...)
+        // The last stage may also use addresses known only to the peerstore
+        node.stages.allow(allowed, dialing.Addrs, i == len(stages) - 1)
+
+        stageCtx, cancel := ctx, context.CancelFunc(func() {})
+        if i < len(stages) - 1 {
+            stageCtx, cancel = context.WithTimeout(ctx, stageTimeout)
+        }
+        err := h.Connect(stageCtx, dialing)
+        cancel()
+        if err == nil {
+            return nil
+        } else if ctx.Err() != nil {
+            errs = append(errs, err)
+            break
+        } else if !errors.Is(err, swarm.ErrDialBackoff) {
+            errs = append(errs, err)
+        }
+    }
+
+    if len(errs) == 0 {
+        return swarm.ErrDialBackoff
+    }
+    return mostTellingDialError(errs, node.usesPSK)
+}
+
+// Returns the error whose class points most clearly at the cause
+func mostTellingDialError(errs []error, usesPSK bool) error {
+    best, bestPriority := errs[len(errs)-1], len(dialClassPriority)
+    for _, err := range errs {
+        class := ClassifyDialError(err, usesPSK)
+        for priority, other := range dialClassPriority {
+            if class == other && priority < bestPriority {
+                best, bestPriority = err, priority
+            }
+        }
+    }
+    return best
+}
@@ -0,0 +1,144 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/peerstore"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+func TestRankAddrs(test *testing.T) {
+    parse := func(s string) multiaddr.Multiaddr {
+        addr, err := multiaddr.NewMultiaddr(s)
+        if err != nil {
+            test.Fatalf("Unable to parse %s:\n%v", s, err)
+        }
+        return addr
+    }
+
+    privateTCP := parse("/ip4/192.168.1.10/tcp/4001")
+    privateQUIC := parse("/ip4/192.168.1.10/udp/4001/quic")
+    publicTCP := parse("/ip4/1.2.3.4/tcp/4001")
+    publicQUIC := parse("/ip4/1.2.3.4/udp/4001/quic")
+    otherPublicTCP := parse("/ip4/5.6.7.8/tcp/4001")
+    addrs := []multiaddr.Multiaddr{privateTCP, publicTCP, privateQUIC, otherPublicTCP, publicQUIC}
+
+    stages := rankAddrs(addrs, nil)
+    expected := [][]multiaddr.Multiaddr{
+        {publicQUIC}, {publicTCP, otherPublicTCP}, {privateQUIC}, {privateTCP},
+    }
+    if !equalStages(stages, expected) {
+        test.Errorf("rankAddrs() returned %v, expected %v", stages, expected)
+    }
+
+    // The address that last worked is dialed first, even if private
+    stages = rankAddrs(addrs, privateTCP)
+    if len(stages) == 0 || len(stages[0]) != 1 || !stages[0][0].Equal(privateTCP) {
+        test.Errorf("rankAddrs() returned %v, expected %s first", stages, privateTCP)
+    }
+}
+
+func TestDialStages(test *testing.T) {
+    id := peer.ID("peer")
+    first, _ := multiaddr.NewMultiaddr("/ip4/1.2.3.4/tcp/4001")
+    second, _ := multiaddr.NewMultiaddr("/ip4/192.168.1.10/tcp/4001")
+    ds := newDialStages()
+
+    if !ds.allowed(id, second) {
+        test.Errorf("Address held back without a ranked dial in progress")
+    }
+
+    stage := ds.start(id)
+    ds.allow(stage, []multiaddr.Multiaddr{first}, false)
+    if !ds.allowed(id, first) || ds.allowed(id, second) {
+        test.Errorf("Only the first stage's address should be allowed")
+    }
+
+    ds.allow(stage, []multiaddr.Multiaddr{first}, true)
+    if !ds.allowed(id, second) {
+        test.Errorf("Last stage should allow every address")
+    }
+
+    ds.finish(id, stage)
+    if len(ds.stages) != 0 {
+        test.Errorf("Finished dial is still tracked")
+    }
+}
+
+// A ranked dial must only use the first stage's address, even though the
+// peerstore knows the peer's other addresses (e.g. from identify)
+func TestDialRankedOrder(test *testing.T) {
+    ctx := context.Background()
+    config := NewTestConfig()
+    config.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/tcp/0"}
+    remote, err := NewNode(ctx, config)
+    if remote.Close != nil {
+        defer remote.Close()
+    }
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+
+    local, err := NewNode(ctx, NewTestConfig())
+    if local.Close != nil {
+        defer local.Close()
+    }
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+
+    id := remote.Host().ID()
+    addrs := remote.Host().Addrs()
+    if len(addrs) < 2 {
+        test.Fatalf("Expected the remote node to listen on 2 addresses, got %v", addrs)
+    }
+    good := addrs[len(addrs)-1]
+    local.goodAddrs.mutex.Lock()
+    local.goodAddrs.addrs[id] = good
+    local.goodAddrs.mutex.Unlock()
+    local.Host().Peerstore().AddAddrs(id, addrs, peerstore.PermanentAddrTTL)
+
+    if err = local.dialRanked(ctx, peer.AddrInfo{ID: id, Addrs: addrs}, time.Minute); err != nil {
+        test.Fatalf("dialRanked() failed:\n%v", err)
+    }
+
+    conns := local.Host().Network().ConnsToPeer(id)
+    if len(conns) != 1 || !conns[0].RemoteMultiaddr().Equal(good) {
+        test.Errorf("Expected a single connection over %s, got %v", good, conns)
+    }
+}
+
+func equalStages(a, b [][]multiaddr.Multiaddr) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if len(a[i]) != len(b[i]) {
+            return false
+        }
+        for j := range a[i] {
+            if !a[i][j].Equal(b[i][j]) {
+                return false
+            }
+        }
+    }
+    return true
+}
@@ -0,0 +1,71 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "time"
+
+    coredisc "github.com/libp2p/go-libp2p-core/discovery"
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-discovery"
+    "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+// Mechanism through which a Node advertises rendezvous strings and finds
+// the peers advertising them. By default this is the DHT (see
+// DHTDiscovery), but any libp2p discovery service, rendezvous-point
+// client or static registry can be used instead via Config.Discovery.
+type Discovery interface {
+    // Advertises the rendezvous string, returning how long the
+    // advertisement lasts before it must be renewed
+    Advertise(ctx context.Context, rendezvous string, opts ...coredisc.Option) (time.Duration, error)
+    // Finds peers advertising the rendezvous string
+    FindPeers(ctx context.Context, rendezvous string,
+        opts ...coredisc.Option) (<-chan peer.AddrInfo, error)
+}
+
+// Creates the Discovery of a Node once its host and DHT exist. It is
+// called again for the new host whenever the Node's identity is rotated.
+type DiscoveryConstructor func(h host.Host, kdht *dht.IpfsDHT) (Discovery, error)
+
+// Discovers peers through provider records in the DHT. This is the default
+// DiscoveryConstructor.
+func DHTDiscovery(h host.Host, kdht *dht.IpfsDHT) (Discovery, error) {
+    return discovery.NewRoutingDiscovery(kdht), nil
+}
+
+// Creates the Discovery for the host and DHT, as configured
+func newDiscovery(config *Config, h host.Host, kdht *dht.IpfsDHT) (Discovery, error) {
+    if config.Discovery != nil {
+        return config.Discovery(h, kdht)
+    }
+    return DHTDiscovery(h, kdht)
+}
+
+// Returns the Node's Discovery, or nil if it has not been created yet
+func (node *Node) Discovery() Discovery {
+    node.core.mutex.RLock()
+    defer node.core.mutex.RUnlock()
+    return node.core.discovery
+}
+
+// Returns the Node's RoutingDiscovery, or nil if it has not been created
+// yet or the Node uses another Discovery (see Config.Discovery)
+func (node *Node) RoutingDiscovery() *discovery.RoutingDiscovery {
+    rd, _ := node.Discovery().(*discovery.RoutingDiscovery)
+    return rd
+}
@@ -0,0 +1,137 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "io"
+    "io/ioutil"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+const (
+    EchoProtocolID = protocol.ID("/mtc/echo/1.0")
+
+    // Upper bounds on what the echo responder will accept or send back
+    MaxEchoBytes = 16 * 1024 * 1024
+    MaxEchoDelay = 10 * time.Second
+
+    // Largest response the echo responder sends, relative to the size of
+    // the request, so it can't be used to amplify traffic
+    MaxEchoAmplification = 16
+
+    // Request header: 4 bytes of delay (ms), 4 bytes of response size
+    echoHeaderLen = 8
+)
+
+// Options for an echo request
+type EchoOpts struct {
+    // Number of bytes the responder should send back. If 0, the request
+    // payload is echoed back as-is. Responses are capped at
+    // MaxEchoAmplification times the size of the request (header
+    // included), so large responses need a large enough payload.
+    ResponseSize    uint32
+
+    // How long the responder should wait before responding, e.g. to
+    // simulate processing time
+    Delay           time.Duration
+}
+
+// Result of an echo request
+type EchoResult struct {
+    RTT             time.Duration
+    BytesSent       int
+    BytesReceived   int
+}
+
+// Handles echo requests. Requests consist of a header followed by a
+// payload, terminated by closing the stream for writing.
+func echoHandler(stream network.Stream) {
+    request, err := ioutil.ReadAll(io.LimitReader(stream, echoHeaderLen + MaxEchoBytes))
+    if err != nil || len(request) < echoHeaderLen {
+        stream.Reset()
+        return
+    }
+
+    delay := time.Duration(binary.BigEndian.Uint32(request[0:4])) * time.Millisecond
+    size := binary.BigEndian.Uint32(request[4:8])
+    payload := request[echoHeaderLen:]
+
+    if delay > MaxEchoDelay {
+        delay = MaxEchoDelay
+    }
+    if size > MaxEchoBytes {
+        size = MaxEchoBytes
+    }
+    if limit := uint32(len(request)) * MaxEchoAmplification; size > limit {
+        size = limit
+    }
+
+    response := payload
+    if size > 0 {
+        response = make([]byte, size)
+    }
+
+    time.Sleep(delay)
+    if _, err = stream.Write(response); err != nil {
+        stream.Reset()
+        return
+    }
+    stream.Close()
+}
+
+// Sends an echo request to a peer running the echo responder, and
+// measures the time taken for the full response to arrive
+func (node *Node) Echo(ctx context.Context, id peer.ID, payload []byte,
+    opts EchoOpts) (EchoResult, error) {
+
+    var result EchoResult
+    if len(payload) > MaxEchoBytes {
+        return result, errors.New("Echo payload exceeds MaxEchoBytes")
+    }
+
+    header := make([]byte, echoHeaderLen)
+    binary.BigEndian.PutUint32(header[0:4], uint32(opts.Delay / time.Millisecond))
+    binary.BigEndian.PutUint32(header[4:8], opts.ResponseSize)
+
+    start := time.Now()
+    stream, err := node.Host().NewStream(ctx, id, EchoProtocolID)
+    if err != nil {
+        return result, err
+    }
+
+    if _, err = stream.Write(append(header, payload...)); err != nil {
+        stream.Reset()
+        return result, err
+    }
+    stream.Close()
+
+    response, err := ioutil.ReadAll(stream)
+    if err != nil {
+        stream.Reset()
+        return result, err
+    }
+
+    result.RTT = time.Since(start)
+    result.BytesSent = len(payload)
+    result.BytesReceived = len(response)
+    return result, nil
+}
@@ -0,0 +1,54 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/host"
+)
+
+func TestEchoAmplification(test *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+    defer cancel()
+
+    responder, err := NewNode(ctx, NewTestConfig())
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+    defer responder.Close()
+    node, err := NewNode(ctx, NewTestConfig())
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+    defer node.Close()
+    if err = node.Host().Connect(ctx, *host.InfoFromHost(responder.Host())); err != nil {
+        test.Fatalf("Unable to connect:\n%v", err)
+    }
+
+    payload := []byte("12345678")
+    result, err := node.Echo(ctx, responder.Host().ID(), payload,
+        EchoOpts{ResponseSize: 1024 * 1024})
+    if err != nil {
+        test.Fatalf("Echo() failed:\n%v", err)
+    }
+    limit := (echoHeaderLen + len(payload)) * MaxEchoAmplification
+    if result.BytesReceived != limit {
+        test.Errorf("Echo() received %d bytes, expected the response capped at %d",
+            result.BytesReceived, limit)
+    }
+}
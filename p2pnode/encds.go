@@ -0,0 +1,122 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    ds "github.com/ipfs/go-datastore"
+    "github.com/ipfs/go-datastore/query"
+)
+
+// Datastore that encrypts the values of another. Keys are left as-is, so
+// the peer IDs known to the peerstore remain visible.
+type encryptedDatastore struct {
+    inner   ds.Batching
+    cipher  *stateCipher
+}
+
+func newEncryptedDatastore(inner ds.Batching, sc *stateCipher) *encryptedDatastore {
+    return &encryptedDatastore{inner: inner, cipher: sc}
+}
+
+func (eds *encryptedDatastore) Get(key ds.Key) ([]byte, error) {
+    value, err := eds.inner.Get(key)
+    if err != nil {
+        return nil, err
+    }
+    return eds.cipher.open(value)
+}
+
+func (eds *encryptedDatastore) Has(key ds.Key) (bool, error) {
+    return eds.inner.Has(key)
+}
+
+func (eds *encryptedDatastore) GetSize(key ds.Key) (int, error) {
+    return ds.GetBackedSize(eds, key)
+}
+
+func (eds *encryptedDatastore) Put(key ds.Key, value []byte) error {
+    sealed, err := eds.cipher.seal(value)
+    if err != nil {
+        return err
+    }
+    return eds.inner.Put(key, sealed)
+}
+
+func (eds *encryptedDatastore) Delete(key ds.Key) error {
+    return eds.inner.Delete(key)
+}
+
+// Queries the inner datastore by prefix only, as filters and orders may
+// depend on values, and applies the rest of the query to decrypted results
+func (eds *encryptedDatastore) Query(q query.Query) (query.Results, error) {
+    inner, err := eds.inner.Query(query.Query{Prefix: q.Prefix, KeysOnly: q.KeysOnly})
+    if err != nil {
+        return nil, err
+    }
+
+    decrypted := query.ResultsFromIterator(q, query.Iterator{
+        Next: func() (query.Result, bool) {
+            result, ok := inner.NextSync()
+            if !ok || result.Error != nil || q.KeysOnly {
+                return result, ok
+            }
+            result.Value, result.Error = eds.cipher.open(result.Value)
+            result.Size = len(result.Value)
+            return result, true
+        },
+        Close: inner.Close,
+    })
+
+    q.Prefix = ""
+    return query.NaiveQueryApply(q, decrypted), nil
+}
+
+func (eds *encryptedDatastore) Sync(prefix ds.Key) error {
+    return eds.inner.Sync(prefix)
+}
+
+func (eds *encryptedDatastore) Close() error {
+    return eds.inner.Close()
+}
+
+func (eds *encryptedDatastore) Batch() (ds.Batch, error) {
+    batch, err := eds.inner.Batch()
+    if err != nil {
+        return nil, err
+    }
+    return &encryptedBatch{inner: batch, cipher: eds.cipher}, nil
+}
+
+// Batch that encrypts the values put into another
+type encryptedBatch struct {
+    inner   ds.Batch
+    cipher  *stateCipher
+}
+
+func (eb *encryptedBatch) Put(key ds.Key, value []byte) error {
+    sealed, err := eb.cipher.seal(value)
+    if err != nil {
+        return err
+    }
+    return eb.inner.Put(key, sealed)
+}
+
+func (eb *encryptedBatch) Delete(key ds.Key) error {
+    return eb.inner.Delete(key)
+}
+
+func (eb *encryptedBatch) Commit() error {
+    return eb.inner.Commit()
+}
@@ -0,0 +1,181 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+type EventType string
+
+// Number of events buffered for each Events() subscriber. Events that
+// arrive while a subscriber's buffer is full are dropped for it.
+const EventsBufferSize = 64
+
+// Node events, delivered by Node.Events() and to webhooks
+const (
+    EventPeerConnected         EventType = "peer-connected"
+    EventPeerDisconnected      EventType = "peer-disconnected"
+    EventStreamOpened          EventType = "stream-opened"
+    EventPeerBlocked           EventType = "peer-blocked"
+    EventBootstrapLost         EventType = "bootstrap-lost"
+    EventBootstrapRecovered    EventType = "bootstrap-recovered"
+    EventBootstrapsUnreachable EventType = "bootstraps-unreachable"
+    EventBootstrapsReachable   EventType = "bootstraps-reachable"
+    EventAdvertiseRefreshed    EventType = "advertise-refreshed"
+    EventAdvertiseExpired      EventType = "advertise-expired"
+    EventUnadvertised          EventType = "unadvertised"
+    EventDraining              EventType = "draining"
+    EventShutdown              EventType = "shutdown"
+    EventIdentityRotated       EventType = "identity-rotated"
+)
+
+// All event types, for validating subscriptions
+var eventTypes = map[EventType]bool{
+    EventPeerConnected: true,
+    EventPeerDisconnected: true,
+    EventStreamOpened: true,
+    EventPeerBlocked: true,
+    EventBootstrapLost: true,
+    EventBootstrapRecovered: true,
+    EventBootstrapsUnreachable: true,
+    EventBootstrapsReachable: true,
+    EventAdvertiseRefreshed: true,
+    EventAdvertiseExpired: true,
+    EventUnadvertised: true,
+    EventDraining: true,
+    EventShutdown: true,
+    EventIdentityRotated: true,
+}
+
+// Event emitted by a Node on its host's event bus. Only the fields
+// relevant to the event's Type are set.
+type Event struct {
+    Type        EventType
+    Time        time.Time
+    Peer        peer.ID
+    // Set for stream events. Inbound streams are reported as soon as they
+    // are opened, before their protocol is negotiated, so may be empty.
+    Protocol    protocol.ID
+    // Set for advertisement events
+    Rendezvous  string
+    // Set for EventUnadvertised, to when the last published record lapses
+    Expiry      time.Time
+}
+
+// Creates the emitter for the Node's events on its current host, and
+// reports peer and stream events from the host's network
+func (node *Node) startEvents() error {
+    emitter, err := node.Host().EventBus().Emitter(new(Event))
+    if err != nil {
+        return err
+    }
+    node.core.setEvents(emitter)
+
+    notifiee := &network.NotifyBundle{
+        ConnectedF: func(net network.Network, conn network.Conn) {
+            // Only report the first connection to a peer
+            if len(net.ConnsToPeer(conn.RemotePeer())) == 1 {
+                node.emit(Event{Type: EventPeerConnected, Peer: conn.RemotePeer()})
+            }
+        },
+        DisconnectedF: func(net network.Network, conn network.Conn) {
+            if net.Connectedness(conn.RemotePeer()) != network.Connected {
+                node.emit(Event{Type: EventPeerDisconnected, Peer: conn.RemotePeer()})
+            }
+        },
+        OpenedStreamF: func(_ network.Network, stream network.Stream) {
+            node.emit(Event{
+                Type:       EventStreamOpened,
+                Peer:       stream.Conn().RemotePeer(),
+                Protocol:   stream.Protocol(),
+            })
+        },
+    }
+    h := node.Host()
+    h.Network().Notify(notifiee)
+
+    node.spawnWith(node.hostContext(), "events-close", func(hostCtx context.Context) {
+        <-hostCtx.Done()
+        h.Network().StopNotify(notifiee)
+        emitter.Close()
+    })
+
+    return nil
+}
+
+// Emits the event on the event bus, and sends it to the webhook (if any)
+func (node *Node) emit(evt Event) {
+    if evt.Time.IsZero() {
+        evt.Time = time.Now()
+    }
+
+    if emitter := node.core.getEvents(); emitter != nil && node.hostContext().Err() == nil {
+        if err := emitter.Emit(evt); err != nil {
+            log.Printf("ERROR: Unable to emit %s event\n%v\n", evt.Type, err)
+        }
+    }
+    node.notifyWebhook(evt)
+}
+
+// Returns a channel of the Node's events, which is closed once the context
+// or Node is done, or the Node's identity is rotated (see RotateIdentity()).
+// Up to EventsBufferSize events are buffered. Events are emitted from the
+// host's network callbacks, which must not block, so a subscriber that falls
+// further behind misses events rather than stalling the Node.
+func (node *Node) Events(ctx context.Context) (<-chan Event, error) {
+    sub, err := node.Host().EventBus().Subscribe(new(Event))
+    if err != nil {
+        return nil, err
+    }
+
+    events := make(chan Event, EventsBufferSize)
+    node.spawnWith(node.hostContext(), "events-subscriber", func(hostCtx context.Context) {
+        defer close(events)
+        defer sub.Close()
+
+        // Keep draining the subscription, so the event bus never blocks
+        dropped := 0
+        for {
+            select {
+            case evt, ok := <-sub.Out():
+                if !ok {
+                    return
+                }
+                select {
+                case events <- evt.(Event):
+                    if dropped > 0 {
+                        log.Printf("WARNING: Dropped %d events for a slow subscriber\n", dropped)
+                        dropped = 0
+                    }
+                default:
+                    dropped++
+                }
+            case <-ctx.Done():
+                return
+            case <-hostCtx.Done():
+                return
+            }
+        }
+    })
+
+    return events, nil
+}
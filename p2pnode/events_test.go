@@ -0,0 +1,68 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/host"
+)
+
+func TestEventsSlowSubscriber(test *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+
+    node, err := NewNode(ctx, NewTestConfig())
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+    defer node.Close()
+    other, err := NewNode(ctx, NewTestConfig())
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+    defer other.Close()
+
+    // Subscribe, but never read
+    if _, err = node.Events(ctx); err != nil {
+        test.Fatalf("Events() failed:\n%v", err)
+    }
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < 10 * EventsBufferSize; i++ {
+            node.emit(Event{Type: EventAdvertiseRefreshed, Rendezvous: "service"})
+        }
+    }()
+    select {
+    case <-done:
+    case <-ctx.Done():
+        test.Fatalf("Emitting events blocked on a subscriber that never reads")
+    }
+
+    // Connections and streams, whose events are emitted from the host's
+    // network callbacks, must not stall either
+    if err = node.Host().Connect(ctx, *host.InfoFromHost(other.Host())); err != nil {
+        test.Fatalf("Unable to connect with a full event subscriber:\n%v", err)
+    }
+    stream, err := node.Host().NewStream(ctx, other.Host().ID(), EchoProtocolID)
+    if err != nil {
+        test.Fatalf("Unable to open a stream with a full event subscriber:\n%v", err)
+    }
+    stream.Reset()
+}
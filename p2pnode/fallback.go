@@ -0,0 +1,91 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Operator-supplied lookup of the peers providing a service, consulted by
+// FindPeers() when neither the DHT nor Config.StaticServices found any
+type ServiceResolver func(ctx context.Context, rendezvous string) ([]peer.AddrInfo, error)
+
+// Fallbacks used to find the providers of a service during DHT outages
+type serviceFallbacks struct {
+    static      map[string][]peer.AddrInfo
+    resolver    ServiceResolver
+}
+
+// Parses the static service map and resolver of the Config
+func newServiceFallbacks(config *Config) (*serviceFallbacks, error) {
+    fallbacks := &serviceFallbacks{
+        static:     make(map[string][]peer.AddrInfo),
+        resolver:   config.ServiceResolver,
+    }
+
+    for rendezvous, addrs := range config.StaticServices {
+        infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+        if err != nil {
+            return nil, fmt.Errorf("Invalid static peers for service %s: %w", rendezvous, err)
+        }
+        fallbacks.static[rendezvous] = infos
+    }
+
+    return fallbacks, nil
+}
+
+// Returns true if there is any fallback to consult
+func (fallbacks *serviceFallbacks) configured() bool {
+    return len(fallbacks.static) > 0 || fallbacks.resolver != nil
+}
+
+// Finds peers for the rendezvous string in the static service map, then,
+// if none are found there, with the service resolver. The same peers are
+// left out as for the DHT.
+func (node *Node) findFallbackPeers(ctx context.Context, rendezvous string,
+    options findPeersOpts) ([]peer.AddrInfo, error) {
+
+    collector := node.newPeerCollector(rendezvous, options)
+    for _, p := range node.fallbacks.static[rendezvous] {
+        if collector.add(p) {
+            break
+        }
+    }
+    if len(collector.peers) > 0 {
+        log.Printf("Using static peers for %s\n", rendezvous)
+        return collector.peers, nil
+    }
+
+    if node.fallbacks.resolver == nil {
+        return nil, nil
+    }
+    resolved, err := node.fallbacks.resolver(ctx, rendezvous)
+    if err != nil {
+        return nil, fmt.Errorf("Service resolver failed for %s: %w", rendezvous, err)
+    }
+    for _, p := range resolved {
+        if collector.add(p) {
+            break
+        }
+    }
+    if len(collector.peers) > 0 {
+        log.Printf("Using resolved peers for %s\n", rendezvous)
+    }
+    return collector.peers, nil
+}
@@ -0,0 +1,150 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+
+    coredisc "github.com/libp2p/go-libp2p-core/discovery"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+    // Returned when advertising or finding peers before the Node's routing
+    // discovery is set up
+    ErrNoDiscovery = errors.New("No Discovery object available to advertise or find peers with")
+)
+
+type findPeersOpts struct {
+    limit   int
+    filter  func(peer.AddrInfo) bool
+}
+
+// Option for Node.FindPeers()
+type FindPeersOption func(*findPeersOpts)
+
+// Stops once 'n' peers have been found
+func FindPeersLimit(n int) FindPeersOption {
+    return func(opts *findPeersOpts) {
+        opts.limit = n
+    }
+}
+
+// Only returns peers for which 'filter' returns true
+func FindPeersFilter(filter func(peer.AddrInfo) bool) FindPeersOption {
+    return func(opts *findPeersOpts) {
+        opts.filter = filter
+    }
+}
+
+// Collects the peers found for a rendezvous string, leaving out those
+// FindPeers() should not return
+type peerCollector struct {
+    node        *Node
+    rendezvous  string
+    options     findPeersOpts
+    seen        map[peer.ID]bool
+    peers       []peer.AddrInfo
+}
+
+func (node *Node) newPeerCollector(rendezvous string, options findPeersOpts) *peerCollector {
+    return &peerCollector{
+        node:       node,
+        rendezvous: rendezvous,
+        options:    options,
+        seen:       make(map[peer.ID]bool),
+    }
+}
+
+// Adds the peer if it should be returned, and returns true once the limit
+// is reached
+func (c *peerCollector) add(p peer.AddrInfo) bool {
+    if p.ID == c.node.Host().ID() || len(p.Addrs) == 0 || c.seen[p.ID] {
+        return false
+    } else if c.node.withdrawals.withdrawn(c.rendezvous, p.ID) {
+        return false
+    } else if c.options.filter != nil && !c.options.filter(p) {
+        return false
+    }
+    c.seen[p.ID] = true
+    c.peers = append(c.peers, p)
+
+    return c.options.limit > 0 && len(c.peers) >= c.options.limit
+}
+
+// Finds peers advertising the rendezvous string, returning them once the
+// search completes, the limit is reached, or the context is done. The Node
+// itself, duplicates, peers without any addresses, and peers that announced
+// they withdrew the rendezvous string (see Unadvertise()) are left out.
+//
+// If the DHT fails or finds no peers, the fallbacks in Config.StaticServices
+// and Config.ServiceResolver are consulted in turn (see findFallbackPeers()).
+func (node *Node) FindPeers(ctx context.Context, rendezvous string,
+    opts ...FindPeersOption) ([]peer.AddrInfo, error) {
+
+    if rendezvous == "" {
+        return nil, ErrEmptyRendezvous
+    }
+
+    var options findPeersOpts
+    for _, opt := range opts {
+        opt(&options)
+    }
+
+    peers, err := node.findDHTPeers(ctx, rendezvous, options)
+    if len(peers) > 0 || !node.fallbacks.configured() {
+        return peers, err
+    }
+
+    fallbackPeers, fallbackErr := node.findFallbackPeers(ctx, rendezvous, options)
+    if len(fallbackPeers) > 0 {
+        return fallbackPeers, nil
+    } else if err != nil {
+        return nil, err
+    }
+    return nil, fallbackErr
+}
+
+// Finds peers advertising the rendezvous string in the DHT
+func (node *Node) findDHTPeers(ctx context.Context, rendezvous string,
+    options findPeersOpts) ([]peer.AddrInfo, error) {
+
+    if node.Discovery() == nil {
+        return nil, ErrNoDiscovery
+    }
+
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    var discOpts []coredisc.Option
+    if options.limit > 0 {
+        discOpts = append(discOpts, coredisc.Limit(options.limit))
+    }
+
+    peerChan, err := node.Discovery().FindPeers(ctx, rendezvous, discOpts...)
+    if err != nil {
+        return nil, err
+    }
+
+    collector := node.newPeerCollector(rendezvous, options)
+    for p := range peerChan {
+        if collector.add(p) {
+            break
+        }
+    }
+
+    return collector.peers, nil
+}
@@ -0,0 +1,208 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "fmt"
+    "net"
+    "sync"
+
+    "github.com/libp2p/go-libp2p-core/connmgr"
+    "github.com/libp2p/go-libp2p-core/control"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+    manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// PeerGater blocks connections at the network layer based on allow and deny
+// lists of peer IDs and IP subnets. Deny lists always take precedence. If an
+// allow list is non-empty, anything not on it is blocked.
+//
+// An optional custom ConnectionGater may be chained after the lists, and is
+// only consulted for connections the lists allow.
+type PeerGater struct {
+    mutex           sync.RWMutex
+    allowPeers      map[peer.ID]bool
+    denyPeers       map[peer.ID]bool
+    allowSubnets    []*net.IPNet
+    denySubnets     []*net.IPNet
+    next            connmgr.ConnectionGater
+
+    // Optional callback invoked whenever BlockPeer() is called
+    onBlock         func(peer.ID)
+
+    // Optional callback invoked whenever a dial to a peer is allowed
+    onDial          func(peer.ID)
+
+    // Optional filter on the addresses a peer may be dialed on
+    dialFilter      func(peer.ID, multiaddr.Multiaddr) bool
+}
+
+// Creates a PeerGater from lists of peer IDs and subnets (in CIDR notation)
+func NewPeerGater(allowPeers, denyPeers []peer.ID,
+    allowSubnets, denySubnets []string,
+    next connmgr.ConnectionGater) (*PeerGater, error) {
+
+    gater := &PeerGater{
+        allowPeers: make(map[peer.ID]bool),
+        denyPeers:  make(map[peer.ID]bool),
+        next:       next,
+    }
+
+    for _, id := range allowPeers {
+        gater.allowPeers[id] = true
+    }
+    for _, id := range denyPeers {
+        gater.denyPeers[id] = true
+    }
+
+    var err error
+    if gater.allowSubnets, err = parseSubnets(allowSubnets); err != nil {
+        return nil, err
+    }
+    if gater.denySubnets, err = parseSubnets(denySubnets); err != nil {
+        return nil, err
+    }
+
+    return gater, nil
+}
+
+func parseSubnets(cidrs []string) ([]*net.IPNet, error) {
+    var subnets []*net.IPNet
+    for _, cidr := range cidrs {
+        _, subnet, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return nil, fmt.Errorf("Unable to parse subnet %s\n%w", cidr, err)
+        }
+        subnets = append(subnets, subnet)
+    }
+    return subnets, nil
+}
+
+// Blocks all connections to and from the given peer. The gater of a Node
+// (see Node.Gater()) also closes any connections the peer already has.
+func (gater *PeerGater) BlockPeer(id peer.ID) {
+    gater.mutex.Lock()
+    gater.denyPeers[id] = true
+    gater.mutex.Unlock()
+
+    if gater.onBlock != nil {
+        gater.onBlock(id)
+    }
+}
+
+// Removes the given peer from the deny list
+func (gater *PeerGater) UnblockPeer(id peer.ID) {
+    gater.mutex.Lock()
+    defer gater.mutex.Unlock()
+    delete(gater.denyPeers, id)
+}
+
+// Blocks all connections to and from addresses in the subnet
+func (gater *PeerGater) BlockSubnet(cidr string) error {
+    subnets, err := parseSubnets([]string{cidr})
+    if err != nil {
+        return err
+    }
+
+    gater.mutex.Lock()
+    defer gater.mutex.Unlock()
+    gater.denySubnets = append(gater.denySubnets, subnets...)
+    return nil
+}
+
+func (gater *PeerGater) peerAllowed(id peer.ID) bool {
+    gater.mutex.RLock()
+    defer gater.mutex.RUnlock()
+
+    if gater.denyPeers[id] {
+        return false
+    }
+    return len(gater.allowPeers) == 0 || gater.allowPeers[id]
+}
+
+func (gater *PeerGater) addrAllowed(addr multiaddr.Multiaddr) bool {
+    ip, err := manet.ToIP(addr)
+    if err != nil {
+        // Not an IP-based address (e.g. relay), nothing to check against
+        return true
+    }
+
+    gater.mutex.RLock()
+    defer gater.mutex.RUnlock()
+
+    for _, subnet := range gater.denySubnets {
+        if subnet.Contains(ip) {
+            return false
+        }
+    }
+
+    if len(gater.allowSubnets) == 0 {
+        return true
+    }
+    for _, subnet := range gater.allowSubnets {
+        if subnet.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+func (gater *PeerGater) InterceptPeerDial(id peer.ID) bool {
+    if !gater.peerAllowed(id) {
+        return false
+    }
+
+    allowed := gater.next == nil || gater.next.InterceptPeerDial(id)
+    if allowed && gater.onDial != nil {
+        gater.onDial(id)
+    }
+    return allowed
+}
+
+func (gater *PeerGater) InterceptAddrDial(id peer.ID, addr multiaddr.Multiaddr) bool {
+    if !gater.peerAllowed(id) || !gater.addrAllowed(addr) {
+        return false
+    }
+    if gater.dialFilter != nil && !gater.dialFilter(id, addr) {
+        return false
+    }
+    return gater.next == nil || gater.next.InterceptAddrDial(id, addr)
+}
+
+func (gater *PeerGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+    if !gater.addrAllowed(addrs.RemoteMultiaddr()) {
+        return false
+    }
+    return gater.next == nil || gater.next.InterceptAccept(addrs)
+}
+
+func (gater *PeerGater) InterceptSecured(dir network.Direction, id peer.ID,
+    addrs network.ConnMultiaddrs) bool {
+
+    if !gater.peerAllowed(id) {
+        return false
+    }
+    return gater.next == nil || gater.next.InterceptSecured(dir, id, addrs)
+}
+
+func (gater *PeerGater) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+    if gater.next == nil {
+        return true, 0
+    }
+    return gater.next.InterceptUpgraded(conn)
+}
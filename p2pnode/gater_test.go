@@ -0,0 +1,109 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+const (
+    goodPeer = peer.ID("good-peer")
+    badPeer  = peer.ID("bad-peer")
+)
+
+func TestPeerGater(test *testing.T) {
+    test.Run("NewPeerGater-BadSubnet", func(test *testing.T) {
+        _, err := NewPeerGater(nil, nil, []string{"not-a-subnet"}, nil, nil)
+        if err == nil {
+            test.Errorf("NewPeerGater() with invalid subnet succeeded, expected it to fail")
+        }
+    })
+
+    gater, err := NewPeerGater(nil, []peer.ID{badPeer},
+        []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, nil)
+    if err != nil {
+        test.Fatalf("NewPeerGater() failed:\n%v", err)
+    }
+
+    testCases := []struct {
+        name    string
+        id      peer.ID
+        addr    string
+        allowed bool
+    }{
+        {"Allowed", goodPeer, "/ip4/10.2.0.1/tcp/4001", true},
+        {"DeniedPeer", badPeer, "/ip4/10.2.0.1/tcp/4001", false},
+        {"DeniedSubnet", goodPeer, "/ip4/10.1.0.1/tcp/4001", false},
+        {"NotInAllowedSubnet", goodPeer, "/ip4/192.168.0.1/tcp/4001", false},
+    }
+
+    for _, testCase := range testCases {
+        test.Run(testCase.name, func(test *testing.T) {
+            addr := multiaddr.StringCast(testCase.addr)
+            if gater.InterceptAddrDial(testCase.id, addr) != testCase.allowed {
+                test.Errorf("Expected dial to %s at %s to be allowed: %v",
+                    testCase.id, testCase.addr, testCase.allowed)
+            }
+        })
+    }
+
+    test.Run("BlockPeer", func(test *testing.T) {
+        gater.BlockPeer(goodPeer)
+        if gater.InterceptPeerDial(goodPeer) {
+            test.Errorf("Dial to blocked peer was allowed")
+        }
+
+        gater.UnblockPeer(goodPeer)
+        if !gater.InterceptPeerDial(goodPeer) {
+            test.Errorf("Dial to unblocked peer was denied")
+        }
+    })
+}
+
+func TestBlockPeerCloses(test *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+    defer cancel()
+
+    node, err := NewNode(ctx, NewTestConfig())
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+    defer node.Close()
+    other, err := NewNode(ctx, NewTestConfig())
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+    defer other.Close()
+
+    if err = node.Host().Connect(ctx, *host.InfoFromHost(other.Host())); err != nil {
+        test.Fatalf("Unable to connect:\n%v", err)
+    }
+
+    node.Gater().BlockPeer(other.Host().ID())
+    if node.Host().Network().Connectedness(other.Host().ID()) == network.Connected {
+        test.Errorf("Blocked peer is still connected")
+    }
+    if err = node.Host().Connect(ctx, *host.InfoFromHost(other.Host())); err == nil {
+        test.Errorf("Connected to a blocked peer")
+    }
+}
@@ -0,0 +1,153 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "fmt"
+    "runtime/pprof"
+    "sort"
+    "sync"
+    "time"
+)
+
+const (
+    // pprof label holding the task a goroutine performs, so the Node's
+    // goroutines can be told apart in goroutine profiles
+    TaskLabel = "p2pnode-task"
+)
+
+// Background task being run by a Node, as returned by Node.Tasks()
+type Task struct {
+    ID          uint64
+    Name        string
+    Started     time.Time
+}
+
+// Tracks the goroutines a Node is running, by the task they perform, so
+// leaks show up in metrics, Node.Goroutines() and Node.Tasks()
+type goroutineTracker struct {
+    mutex   sync.Mutex
+    nextID  uint64
+    tasks   map[uint64]Task
+    // Closed and replaced whenever a task exits
+    exited  chan struct{}
+}
+
+func newGoroutineTracker() *goroutineTracker {
+    return &goroutineTracker{
+        tasks:  make(map[uint64]Task),
+        exited: make(chan struct{}),
+    }
+}
+
+// Records that a goroutine has started running the task, returning a
+// function to call once it exits
+func (gt *goroutineTracker) enter(task string) func() {
+    gt.mutex.Lock()
+    gt.nextID++
+    id := gt.nextID
+    gt.tasks[id] = Task{ID: id, Name: task, Started: time.Now()}
+    gt.mutex.Unlock()
+
+    return func() {
+        gt.mutex.Lock()
+        defer gt.mutex.Unlock()
+        delete(gt.tasks, id)
+        close(gt.exited)
+        gt.exited = make(chan struct{})
+    }
+}
+
+func (gt *goroutineTracker) snapshot() map[string]int {
+    gt.mutex.Lock()
+    defer gt.mutex.Unlock()
+
+    counts := make(map[string]int)
+    for _, task := range gt.tasks {
+        counts[task.Name]++
+    }
+    return counts
+}
+
+// Returns the running tasks, oldest first, and a channel that is closed
+// once any of them exits
+func (gt *goroutineTracker) list() ([]Task, <-chan struct{}) {
+    gt.mutex.Lock()
+    defer gt.mutex.Unlock()
+
+    tasks := make([]Task, 0, len(gt.tasks))
+    for _, task := range gt.tasks {
+        tasks = append(tasks, task)
+    }
+    sort.Slice(tasks, func(i, j int) bool {
+        return tasks[i].ID < tasks[j].ID
+    })
+    return tasks, gt.exited
+}
+
+// Runs f in a new goroutine that is counted under the given task. f is
+// passed a context derived from the Node's, so it must return once the
+// Node is closed.
+func (node *Node) spawn(task string, f func(ctx context.Context)) {
+    node.spawnWith(node.Ctx, task, f)
+}
+
+// Like spawn(), but derives f's context from 'parent', which must itself
+// derive from the Node's context (e.g. a host's or lease's context)
+func (node *Node) spawnWith(parent context.Context, task string,
+    f func(ctx context.Context)) {
+
+    exit := node.goroutines.enter(task)
+    go func() {
+        defer exit()
+        pprof.Do(parent, pprof.Labels(TaskLabel, task), f)
+    }()
+}
+
+// Returns the number of goroutines the Node is currently running, keyed by
+// the task they perform (e.g. "lease-refresh", "reconnect")
+func (node *Node) Goroutines() map[string]int {
+    return node.goroutines.snapshot()
+}
+
+// Returns the background tasks the Node is currently running, oldest
+// first. Tasks still listed well after the Node is closed have leaked.
+func (node *Node) Tasks() []Task {
+    tasks, _ := node.goroutines.list()
+    return tasks
+}
+
+// Waits until all of the Node's background tasks have exited, which they
+// do once the Node is closed. Returns an error naming the tasks still
+// running if the context is done first.
+func (node *Node) WaitTasks(ctx context.Context) error {
+    for {
+        tasks, exited := node.goroutines.list()
+        if len(tasks) == 0 {
+            return nil
+        }
+
+        select {
+        case <-exited:
+        case <-ctx.Done():
+            names := make([]string, len(tasks))
+            for i, task := range tasks {
+                names[i] = task.Name
+            }
+            return fmt.Errorf("%d tasks still running %v: %w", len(tasks), names, ctx.Err())
+        }
+    }
+}
@@ -0,0 +1,57 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestTasks(test *testing.T) {
+    node := &Node{goroutines: newGoroutineTracker()}
+    node.Ctx, node.Close = context.WithCancel(context.Background())
+
+    for i := 0; i < 2; i++ {
+        node.spawn("waiter", func(ctx context.Context) { <-ctx.Done() })
+    }
+    leaked := make(chan struct{})
+    node.spawn("leaker", func(context.Context) { <-leaked })
+
+    tasks := node.Tasks()
+    if len(tasks) != 3 || tasks[0].Name != "waiter" || tasks[2].Name != "leaker" {
+        test.Fatalf("Tasks() returned %v, expected two waiters and a leaker", tasks)
+    }
+    if counts := node.Goroutines(); counts["waiter"] != 2 || counts["leaker"] != 1 {
+        test.Errorf("Goroutines() returned %v, expected 2 waiters and 1 leaker", counts)
+    }
+
+    node.Close()
+    ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+    if err := node.WaitTasks(ctx); err == nil {
+        test.Errorf("WaitTasks() succeeded with a task still running")
+    }
+    if tasks = node.Tasks(); len(tasks) != 1 || tasks[0].Name != "leaker" {
+        test.Errorf("Tasks() after Close returned %v, expected only the leaker", tasks)
+    }
+
+    close(leaked)
+    ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    if err := node.WaitTasks(ctx); err != nil {
+        test.Errorf("WaitTasks() failed after all tasks exited:\n%v", err)
+    }
+}
@@ -0,0 +1,231 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Determines what happens when a handler is registered for a protocol
+// that already has one
+type HandlerPolicy int
+
+const (
+    // Registration fails with an error (default)
+    HandlerPolicyError HandlerPolicy = iota
+
+    // The new handler replaces the existing one, and OnHandlerReplaced is
+    // invoked (if set)
+    HandlerPolicyReplace
+
+    // The new handler takes over, but the existing one is kept underneath
+    // it, and is restored once the new handler is unregistered
+    HandlerPolicyChain
+)
+
+// Owner name used for handlers registered through Config
+const ConfigHandlerOwner = "config"
+
+// Owner name used for handlers registered with AddStreamHandler() and
+// AddStreamHandlerMatch()
+const DynamicHandlerOwner = "dynamic"
+
+// Callback invoked when a handler is replaced under HandlerPolicyReplace
+type HandlerReplacedCB func(pid protocol.ID, oldOwner, newOwner string)
+
+type handlerEntry struct {
+    owner   string
+    handler network.StreamHandler
+    // If set, the handler also serves any protocol it accepts
+    match   func(string) bool
+}
+
+// Sets the entry as the host's handler for the protocol, enforcing the
+// protocol's AuthPolicy (if any), protecting the peers it serves from
+// connection pruning, wrapping it in Config.StreamMiddleware, and counting
+// its streams (see Node.ProtocolStats())
+func (node *Node) applyHandler(pid protocol.ID, entry handlerEntry) {
+    handler := node.protectSession(entry.handler)
+    if policy, ok := node.authPolicies[pid]; ok {
+        handler = authorizeHandler(pid, policy, handler)
+    }
+    handler = node.protoStats.wrap(wrapMiddleware(handler, node.config.StreamMiddleware))
+
+    if entry.match != nil {
+        node.Host().SetStreamHandlerMatch(pid, entry.match, handler)
+    } else {
+        node.Host().SetStreamHandler(pid, handler)
+    }
+}
+
+// Tracks which handler (and owner) is registered for each protocol
+type handlerRegistry struct {
+    mutex       sync.Mutex
+    policy      HandlerPolicy
+    onReplaced  HandlerReplacedCB
+
+    // Handlers registered per protocol. The last entry is the active one,
+    // any earlier entries are only kept under HandlerPolicyChain.
+    handlers    map[protocol.ID][]handlerEntry
+}
+
+func newHandlerRegistry(policy HandlerPolicy, onReplaced HandlerReplacedCB) *handlerRegistry {
+    return &handlerRegistry{
+        policy:     policy,
+        onReplaced: onReplaced,
+        handlers:   make(map[protocol.ID][]handlerEntry),
+    }
+}
+
+// Registers a stream handler for the given protocol on behalf of 'owner',
+// a name identifying the component registering it. How an existing handler
+// for the same protocol is treated depends on Config.HandlerPolicy.
+func (node *Node) RegisterStreamHandler(pid protocol.ID, owner string,
+    handler network.StreamHandler) error {
+
+    return node.registerHandler(pid, handlerEntry{owner: owner, handler: handler})
+}
+
+func (node *Node) registerHandler(pid protocol.ID, entry handlerEntry) error {
+    if pid == "" || entry.handler == nil {
+        return fmt.Errorf("Cannot register empty protocol ID or nil handler")
+    } else if node.observer {
+        return ErrObserverMode
+    }
+
+    reg := node.handlers
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+
+    // Checked under the registry's lock, so a handler can't slip in after
+    // Drain() removes them
+    if node.Draining() {
+        return ErrDraining
+    }
+
+    owner := entry.owner
+    entries := reg.handlers[pid]
+    if len(entries) == 0 {
+        reg.handlers[pid] = []handlerEntry{entry}
+        node.applyHandler(pid, entry)
+        return nil
+    }
+
+    existing := entries[len(entries)-1]
+    switch reg.policy {
+    case HandlerPolicyReplace:
+        log.Printf("Replacing handler for %s (owned by %s) with one owned by %s\n",
+            pid, existing.owner, owner)
+        reg.handlers[pid] = []handlerEntry{entry}
+        if reg.onReplaced != nil {
+            go reg.onReplaced(pid, existing.owner, owner)
+        }
+    case HandlerPolicyChain:
+        reg.handlers[pid] = append(entries, entry)
+    default:
+        return fmt.Errorf("Protocol %s already has a handler (owned by %s)", pid, existing.owner)
+    }
+
+    node.applyHandler(pid, entry)
+    return nil
+}
+
+// Unregisters the handler owned by 'owner' for the given protocol. If an
+// earlier handler was chained underneath it, that handler is restored.
+func (node *Node) UnregisterStreamHandler(pid protocol.ID, owner string) error {
+    reg := node.handlers
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+
+    entries := reg.handlers[pid]
+    for i := len(entries) - 1; i >= 0; i-- {
+        if entries[i].owner != owner {
+            continue
+        }
+
+        entries = append(entries[:i], entries[i+1:]...)
+        if len(entries) == 0 {
+            delete(reg.handlers, pid)
+            node.Host().RemoveStreamHandler(pid)
+        } else {
+            reg.handlers[pid] = entries
+            node.applyHandler(pid, entries[len(entries)-1])
+        }
+        return nil
+    }
+
+    return fmt.Errorf("No handler for protocol %s owned by %s", pid, owner)
+}
+
+// Returns the owner of the active handler for the given protocol
+func (node *Node) HandlerOwner(pid protocol.ID) (string, bool) {
+    reg := node.handlers
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+
+    entries := reg.handlers[pid]
+    if len(entries) == 0 {
+        return "", false
+    }
+    return entries[len(entries)-1].owner, true
+}
+
+// Registers a stream handler for the given protocol, which can be removed
+// with RemoveStreamHandler()
+func (node *Node) AddStreamHandler(pid protocol.ID, handler network.StreamHandler) error {
+    return node.RegisterStreamHandler(pid, DynamicHandlerOwner, handler)
+}
+
+// Registers a stream handler for the given protocol, and for any other
+// protocol that 'match' accepts (e.g. other versions of the protocol).
+// It can be removed with RemoveStreamHandler().
+func (node *Node) AddStreamHandlerMatch(pid protocol.ID, match func(string) bool,
+    handler network.StreamHandler) error {
+
+    if match == nil {
+        return fmt.Errorf("Cannot register nil match function")
+    }
+    return node.registerHandler(pid, handlerEntry{
+        owner:      DynamicHandlerOwner,
+        handler:    handler,
+        match:      match,
+    })
+}
+
+// Removes a handler registered with AddStreamHandler() or
+// AddStreamHandlerMatch()
+func (node *Node) RemoveStreamHandler(pid protocol.ID) error {
+    return node.UnregisterStreamHandler(pid, DynamicHandlerOwner)
+}
+
+// Returns the protocols that have a handler registered, sorted
+func (node *Node) StreamProtocols() []protocol.ID {
+    reg := node.handlers
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+
+    pids := make([]protocol.ID, 0, len(reg.handlers))
+    for pid := range reg.handlers {
+        pids = append(pids, pid)
+    }
+    sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+    return pids
+}
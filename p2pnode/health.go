@@ -0,0 +1,73 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+)
+
+// Snapshot of a Node's health, as returned by Node.Health()
+type HealthStatus struct {
+    // Whether the node is able to serve requests (see Node.Health())
+    Ready                   bool
+
+    ConnectedPeers          int
+    BootstrapsConnected     int
+    BootstrapsTotal         int
+    RoutingTableSize        int
+
+    // Last time any advertisement was successfully renewed. Zero if the
+    // node has never advertised.
+    LastAdvertise           time.Time
+
+    // Number of goroutines the node is running (see Node.Goroutines())
+    Goroutines              int
+}
+
+// Returns the current health of the Node. The node is considered ready once
+// it is running, and is connected to at least one of its bootstraps (if it
+// has any) with a non-empty DHT routing table. It is never ready while
+// draining.
+func (node *Node) Health() HealthStatus {
+    var status HealthStatus
+    if node.Host() == nil || node.Ctx.Err() != nil {
+        return status
+    }
+
+    net := node.Host().Network()
+    status.ConnectedPeers = len(net.Peers())
+    bootstraps := node.bootstrapSet.ids()
+    status.BootstrapsTotal = len(bootstraps)
+    for _, id := range bootstraps {
+        if net.Connectedness(id) == network.Connected {
+            status.BootstrapsConnected++
+        }
+    }
+
+    if node.DHT() != nil {
+        status.RoutingTableSize = node.DHT().RoutingTable().Size()
+    }
+    status.LastAdvertise = node.leases.lastRenewal()
+    for _, count := range node.Goroutines() {
+        status.Goroutines += count
+    }
+
+    status.Ready = !node.Draining() && (status.BootstrapsTotal == 0 ||
+        (status.BootstrapsConnected > 0 && status.RoutingTableSize > 0))
+
+    return status
+}
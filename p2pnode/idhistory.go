@@ -0,0 +1,227 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+// Signed record of a node rotating from one identity to the next. It is
+// signed by both keys, proving that the same operator held both.
+type IdentityLink struct {
+    Old         peer.ID     `json:"old"`
+    New         peer.ID     `json:"new"`
+    Time        time.Time   `json:"time"`
+    OldKey      []byte      `json:"oldKey"`
+    NewKey      []byte      `json:"newKey"`
+    OldSig      []byte      `json:"oldSig"`
+    NewSig      []byte      `json:"newSig"`
+}
+
+// Portion of an IdentityLink covered by its signatures
+type identityLinkBody struct {
+    Old         peer.ID     `json:"old"`
+    New         peer.ID     `json:"new"`
+    Time        time.Time   `json:"time"`
+    OldKey      []byte      `json:"oldKey"`
+    NewKey      []byte      `json:"newKey"`
+}
+
+func (link IdentityLink) body() identityLinkBody {
+    return identityLinkBody{
+        Old:    link.Old,
+        New:    link.New,
+        Time:   link.Time,
+        OldKey: link.OldKey,
+        NewKey: link.NewKey,
+    }
+}
+
+// Creates a link from the identity of 'oldKey' to that of 'newKey'
+func NewIdentityLink(oldKey, newKey crypto.PrivKey) (IdentityLink, error) {
+    var link IdentityLink
+    var err error
+
+    if link.Old, err = peer.IDFromPrivateKey(oldKey); err != nil {
+        return link, err
+    }
+    if link.New, err = peer.IDFromPrivateKey(newKey); err != nil {
+        return link, err
+    }
+    if link.OldKey, err = crypto.MarshalPublicKey(oldKey.GetPublic()); err != nil {
+        return link, err
+    }
+    if link.NewKey, err = crypto.MarshalPublicKey(newKey.GetPublic()); err != nil {
+        return link, err
+    }
+    link.Time = time.Now().UTC().Round(0)
+
+    if link.OldSig, err = util.SignJSON(oldKey, link.body()); err != nil {
+        return link, err
+    }
+    if link.NewSig, err = util.SignJSON(newKey, link.body()); err != nil {
+        return link, err
+    }
+    return link, nil
+}
+
+// Checks that a signature over the link was made by the key of peer 'id'
+func verifyLinkSig(body identityLinkBody, id peer.ID, keyBytes, sig []byte) error {
+    pub, err := crypto.UnmarshalPublicKey(keyBytes)
+    if err != nil {
+        return err
+    }
+    if !id.MatchesPublicKey(pub) {
+        return fmt.Errorf("Key does not match peer ID %s", id)
+    }
+
+    ok, err := util.VerifyJSON(pub, body, sig)
+    if err != nil {
+        return err
+    } else if !ok {
+        return fmt.Errorf("Invalid signature by %s", id)
+    }
+    return nil
+}
+
+// Verifies that the link was signed by both of its identities
+func (link IdentityLink) Verify() error {
+    body := link.body()
+    if err := verifyLinkSig(body, link.Old, link.OldKey, link.OldSig); err != nil {
+        return err
+    }
+    return verifyLinkSig(body, link.New, link.NewKey, link.NewSig)
+}
+
+// Chain of identity rotations of a node, oldest first
+type IdentityHistory []IdentityLink
+
+// Verifies every link of the history, and that they form an unbroken chain
+// ending at peer 'current'
+func (history IdentityHistory) Verify(current peer.ID) error {
+    for i, link := range history {
+        if err := link.Verify(); err != nil {
+            return fmt.Errorf("Identity link %d is invalid: %w", i, err)
+        }
+        if i > 0 && history[i-1].New != link.Old {
+            return fmt.Errorf("Identity link %d does not follow from link %d", i, i-1)
+        }
+    }
+
+    if len(history) > 0 && history[len(history)-1].New != current {
+        return errors.New("Identity history does not lead to the current peer ID")
+    }
+    return nil
+}
+
+// Returns the peer IDs the node held before its current one, oldest first
+func (history IdentityHistory) PreviousIDs() []peer.ID {
+    ids := make([]peer.ID, len(history))
+    for i, link := range history {
+        ids[i] = link.Old
+    }
+    return ids
+}
+
+// Returns true if the history includes the peer ID, current or previous
+func (history IdentityHistory) Contains(id peer.ID) bool {
+    for _, link := range history {
+        if link.Old == id || link.New == id {
+            return true
+        }
+    }
+    return false
+}
+
+// The Node's identity history, persisted to a file if one is configured
+type identityHistory struct {
+    mutex   sync.Mutex
+    file    string
+    links   IdentityHistory
+    cipher  *stateCipher
+}
+
+// Loads the identity history from file, if one is configured. A
+// non-existent file is not an error, and starts an empty history.
+func loadIdentityHistory(file string, sc *stateCipher) (*identityHistory, error) {
+    history := &identityHistory{cipher: sc}
+    if file == "" {
+        return history, nil
+    }
+
+    file, err := util.ExpandTilde(file)
+    if err != nil {
+        return nil, err
+    }
+    history.file = file
+
+    content, err := readStateFile(file, sc)
+    if os.IsNotExist(err) {
+        return history, nil
+    } else if err != nil {
+        return nil, err
+    }
+
+    if err = json.Unmarshal(content, &history.links); err != nil {
+        return nil, err
+    }
+    return history, nil
+}
+
+// Returns a copy of the links in the history
+func (history *identityHistory) get() IdentityHistory {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+
+    links := make(IdentityHistory, len(history.links))
+    copy(links, history.links)
+    return links
+}
+
+// Appends a link to the history and saves it
+func (history *identityHistory) add(link IdentityLink) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+
+    history.links = append(history.links, link)
+    if history.file == "" {
+        return
+    }
+
+    content, err := json.MarshalIndent(history.links, "", "    ")
+    if err == nil {
+        err = writeStateFile(history.file, content, history.cipher)
+    }
+    if err != nil {
+        log.Printf("ERROR: Unable to save identity history to %s\n%v\n", history.file, err)
+    }
+}
+
+// Returns the signed history of the Node's identity rotations (see
+// RotateIdentity()), so its previous peer IDs can be tied to the current one
+func (node *Node) IdentityHistory() IdentityHistory {
+    return node.identities.get()
+}
@@ -0,0 +1,100 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestIdentityHistory(test *testing.T) {
+    keys := make([]crypto.PrivKey, 3)
+    ids := make([]peer.ID, 3)
+    for i := range keys {
+        var err error
+        keys[i], _, err = crypto.GenerateKeyPair(crypto.Ed25519, -1)
+        if err != nil {
+            test.Fatalf("Unable to generate key:\n%v", err)
+        }
+        ids[i], _ = peer.IDFromPrivateKey(keys[i])
+    }
+
+    var history IdentityHistory
+    for i := 1; i < len(keys); i++ {
+        link, err := NewIdentityLink(keys[i-1], keys[i])
+        if err != nil {
+            test.Fatalf("NewIdentityLink() failed:\n%v", err)
+        }
+        history = append(history, link)
+    }
+
+    test.Run("Verify", func(test *testing.T) {
+        if err := history.Verify(ids[2]); err != nil {
+            test.Errorf("Verify() rejected a valid history: %v", err)
+        }
+        if err := history.Verify(ids[1]); err == nil {
+            test.Errorf("Verify() accepted a history not leading to the current ID")
+        }
+        if !history.Contains(ids[0]) || len(history.PreviousIDs()) != 2 {
+            test.Errorf("History does not include the previous IDs")
+        }
+    })
+
+    test.Run("Verify-Tampered", func(test *testing.T) {
+        tampered := make(IdentityHistory, len(history))
+        copy(tampered, history)
+        tampered[0].Time = tampered[0].Time.Add(1)
+        if err := tampered.Verify(ids[2]); err == nil {
+            test.Errorf("Verify() accepted a tampered link")
+        }
+    })
+
+    test.Run("Verify-Broken", func(test *testing.T) {
+        broken := IdentityHistory{history[1], history[0]}
+        if err := broken.Verify(ids[1]); err == nil {
+            test.Errorf("Verify() accepted an out-of-order history")
+        }
+    })
+
+    test.Run("SaveLoad", func(test *testing.T) {
+        dir, err := ioutil.TempDir("", "p2pnode-idhistory")
+        if err != nil {
+            test.Fatalf("Unable to create temp dir:\n%v", err)
+        }
+        defer os.RemoveAll(dir)
+        file := filepath.Join(dir, "identities.json")
+
+        saved, err := loadIdentityHistory(file, nil)
+        if err != nil {
+            test.Fatalf("loadIdentityHistory() of non-existent file failed:\n%v", err)
+        }
+        for _, link := range history {
+            saved.add(link)
+        }
+
+        loaded, err := loadIdentityHistory(file, nil)
+        if err != nil {
+            test.Fatalf("loadIdentityHistory() failed:\n%v", err)
+        }
+        if err = loaded.get().Verify(ids[2]); err != nil || len(loaded.get()) != 2 {
+            test.Errorf("Loaded history does not match saved history: %v", err)
+        }
+    })
+}
@@ -0,0 +1,138 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    // Connection manager tag marking peers as important, so connections to
+    // them are kept alive (see Config.KeepAliveInterval)
+    ImportantPeerTag = "important"
+
+    // Consecutive failed keep-alive pings after which a connection is
+    // considered dead and closed
+    KeepAliveMaxFailures = 2
+
+    // Maximum number of keep-alive pings in flight at once
+    maxKeepAlivePings = 32
+)
+
+// Returns the connections to the peers kept alive by Config.KeepAliveInterval:
+// bootstraps, static peers and peers tagged with ImportantPeerTag
+func (node *Node) keepAliveConns() []network.Conn {
+    h := node.Host()
+    selected := make(map[peer.ID]bool)
+    for _, id := range node.bootstrapSet.ids() {
+        selected[id] = true
+    }
+    if node.staticPeers != nil {
+        for _, info := range node.staticPeers.peers {
+            selected[info.ID] = true
+        }
+    }
+
+    var conns []network.Conn
+    for _, conn := range h.Network().Conns() {
+        id := conn.RemotePeer()
+        if !selected[id] {
+            info := h.ConnManager().GetTagInfo(id)
+            if info == nil {
+                continue
+            } else if _, ok := info.Tags[ImportantPeerTag]; !ok {
+                continue
+            }
+        }
+        conns = append(conns, conn)
+    }
+    return conns
+}
+
+// Returns every connection of the Node
+func (node *Node) allConns() []network.Conn {
+    return node.Host().Network().Conns()
+}
+
+// Pings the connections returned by 'conns' every 'interval' until the
+// Node is done, so idle connections through NATs aren't dropped. A
+// connection whose pings fail KeepAliveMaxFailures times in a row is
+// closed, letting reconnection kick in sooner than TCP would notice.
+func (node *Node) keepAlive(interval time.Duration, conns func() []network.Conn) {
+    node.spawn("keepalive", func(ctx context.Context) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        var mutex sync.Mutex
+        failures := make(map[network.Conn]int)
+
+        for {
+            select {
+            case <-ticker.C:
+            case <-ctx.Done():
+                return
+            }
+
+            current := conns()
+            var wg sync.WaitGroup
+            sem := make(chan struct{}, maxKeepAlivePings)
+            for _, conn := range current {
+                conn := conn
+                wg.Add(1)
+                sem <- struct{}{}
+                go func() {
+                    defer wg.Done()
+                    defer func() { <-sem }()
+
+                    pingCtx, cancel := context.WithTimeout(ctx, pathPingTimeout)
+                    defer cancel()
+                    _, err := measureConn(pingCtx, conn)
+
+                    mutex.Lock()
+                    defer mutex.Unlock()
+                    if err == nil || ctx.Err() != nil {
+                        delete(failures, conn)
+                        return
+                    }
+                    failures[conn]++
+                    if failures[conn] >= KeepAliveMaxFailures {
+                        log.Printf("Keep-alive to %s failed %d times, closing connection\n%v\n",
+                            conn.RemotePeer(), failures[conn], err)
+                        delete(failures, conn)
+                        conn.Close()
+                    }
+                }()
+            }
+            wg.Wait()
+
+            // Forget connections that have since closed
+            live := make(map[network.Conn]bool, len(current))
+            for _, conn := range current {
+                live[conn] = true
+            }
+            for conn := range failures {
+                if !live[conn] {
+                    delete(failures, conn)
+                }
+            }
+        }
+    })
+}
@@ -0,0 +1,329 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+)
+
+const (
+    // How long to wait before retrying a failed advertisement
+    LeaseRetryInterval = 2 * time.Minute
+)
+
+var (
+    // Returned by Lease.Renew() once the lease is released
+    ErrLeaseReleased = errors.New("Cannot renew a released lease")
+)
+
+// Lease represents an active advertisement of a rendezvous string.
+//
+// A Lease is kept alive in the background by re-advertising shortly before
+// its expiry, until Release() is called or the Node is closed.
+type Lease struct {
+    Rendezvous  string
+
+    mutex       sync.RWMutex
+    expiry      time.Time
+    node        *Node
+    ctx         context.Context
+    cancel      context.CancelFunc
+}
+
+// Returns the time at which the current advertisement expires.
+// A zero-value is returned if the rendezvous has not yet been advertised.
+func (lease *Lease) Expiry() time.Time {
+    lease.mutex.RLock()
+    defer lease.mutex.RUnlock()
+    return lease.expiry
+}
+
+// Returns how long until the current advertisement expires
+func (lease *Lease) TTL() time.Duration {
+    ttl := time.Until(lease.Expiry())
+    if ttl < 0 {
+        return 0
+    }
+    return ttl
+}
+
+// Returns true if the lease has been released or its Node closed
+func (lease *Lease) Released() bool {
+    return lease.ctx.Err() != nil
+}
+
+// Immediately re-advertises the rendezvous string and extends the expiry
+func (lease *Lease) Renew() error {
+    if lease.Released() {
+        return ErrLeaseReleased
+    }
+
+    ttl, err := lease.node.Discovery().Advertise(lease.ctx, lease.Rendezvous)
+    if err != nil {
+        return err
+    }
+
+    lease.mutex.Lock()
+    lease.expiry = time.Now().Add(ttl)
+    lease.mutex.Unlock()
+
+    lease.node.metrics.advertiseRefreshes.Inc()
+    lease.node.emit(Event{Type: EventAdvertiseRefreshed, Rendezvous: lease.Rendezvous})
+    lease.node.leases.renewed()
+    lease.node.leases.save()
+    return nil
+}
+
+// Stops refreshing the advertisement. The provider record itself cannot be
+// revoked from the DHT, and will lapse on its own once it expires.
+func (lease *Lease) Release() {
+    lease.cancel()
+    lease.node.leases.remove(lease)
+}
+
+// Background goroutine that renews the lease shortly before it expires
+func (lease *Lease) refresh(delay time.Duration, firstAttempt func(error)) {
+    if delay > 0 {
+        select {
+        case <-time.After(delay):
+        case <-lease.ctx.Done():
+            if firstAttempt != nil {
+                firstAttempt(lease.ctx.Err())
+            }
+            return
+        }
+    }
+
+    expired := false
+    for {
+        var wait time.Duration
+        err := lease.Renew()
+        if firstAttempt != nil {
+            firstAttempt(err)
+            firstAttempt = nil
+        }
+
+        if err != nil {
+            if lease.Released() {
+                return
+            }
+            log.Printf("ERROR: Unable to advertise %s\n%v\n", lease.Rendezvous, err)
+            wait = LeaseRetryInterval
+
+            // Only notify once per lapse, rather than on every retry
+            if !expired && time.Now().After(lease.Expiry()) {
+                expired = true
+                lease.node.emit(Event{Type: EventAdvertiseExpired, Rendezvous: lease.Rendezvous})
+            }
+        } else {
+            expired = false
+            // Same ratio as libp2p's discovery.Advertise(), unless the
+            // Node was configured with a fixed interval
+            wait = 7 * lease.TTL() / 8
+            if interval := lease.node.leases.interval; interval > 0 {
+                wait = interval
+            }
+        }
+
+        select {
+        case <-time.After(wait):
+        case <-lease.ctx.Done():
+            return
+        }
+    }
+}
+
+// Tracks all leases held by a Node, and persists them to the Node's
+// state file (if configured) whenever they change.
+type leaseTable struct {
+    mutex       sync.Mutex
+    // Held across snapshotting and writing the state file, so an older
+    // snapshot can never overwrite a newer one
+    saveMutex   sync.Mutex
+    leases      map[string]*Lease
+    stateFile   string
+    cipher      *stateCipher
+    interval    time.Duration
+    lastRenewed time.Time
+}
+
+func newLeaseTable(stateFile string, sc *stateCipher,
+    interval time.Duration) *leaseTable {
+
+    return &leaseTable{
+        leases:     make(map[string]*Lease),
+        stateFile:  stateFile,
+        cipher:     sc,
+        interval:   interval,
+    }
+}
+
+// Records that a lease was successfully renewed
+func (table *leaseTable) renewed() {
+    table.mutex.Lock()
+    defer table.mutex.Unlock()
+    table.lastRenewed = time.Now()
+}
+
+// Returns when any lease was last successfully renewed
+func (table *leaseTable) lastRenewal() time.Time {
+    table.mutex.Lock()
+    defer table.mutex.Unlock()
+    return table.lastRenewed
+}
+
+func (table *leaseTable) get(rendezvous string) (*Lease, bool) {
+    table.mutex.Lock()
+    defer table.mutex.Unlock()
+    lease, ok := table.leases[rendezvous]
+    return lease, ok
+}
+
+func (table *leaseTable) add(lease *Lease) {
+    table.mutex.Lock()
+    table.leases[lease.Rendezvous] = lease
+    table.mutex.Unlock()
+    table.save()
+}
+
+func (table *leaseTable) remove(lease *Lease) {
+    table.mutex.Lock()
+    if table.leases[lease.Rendezvous] == lease {
+        delete(table.leases, lease.Rendezvous)
+    }
+    table.mutex.Unlock()
+    table.save()
+}
+
+// Returns all active leases
+func (table *leaseTable) list() []*Lease {
+    table.mutex.Lock()
+    defer table.mutex.Unlock()
+    leases := make([]*Lease, 0, len(table.leases))
+    for _, lease := range table.leases {
+        leases = append(leases, lease)
+    }
+    return leases
+}
+
+// Writes the current leases to the state file, if one is configured
+func (table *leaseTable) save() {
+    if table.stateFile == "" {
+        return
+    }
+
+    table.saveMutex.Lock()
+    defer table.saveMutex.Unlock()
+
+    var state stateSnapshot
+    for _, lease := range table.list() {
+        state.Leases = append(state.Leases, leaseRecord{
+            Rendezvous: lease.Rendezvous,
+            Expiry:     lease.Expiry(),
+        })
+    }
+
+    if err := saveState(table.stateFile, state, table.cipher); err != nil {
+        log.Printf("ERROR: Unable to save node state to %s\n%v\n", table.stateFile, err)
+    }
+}
+
+// Advertises the rendezvous string, returning a Lease that keeps the
+// advertisement alive until released. If the rendezvous is already being
+// advertised, the existing lease is renewed and returned.
+func (node *Node) Advertise(rendezvous string) (*Lease, error) {
+    lease, _, err := node.advertise(rendezvous, 0, nil)
+    return lease, err
+}
+
+// Starts advertising the rendezvous string after the given delay, calling
+// 'firstAttempt' (if not nil) with the result of the first advertisement.
+// If a lease already exists, it is renewed, returned with 'existing' set,
+// and 'firstAttempt' is not called.
+func (node *Node) advertise(rendezvous string, delay time.Duration,
+    firstAttempt func(error)) (lease *Lease, existing bool, err error) {
+
+    if rendezvous == "" {
+        log.Printf("ERROR: Empty rendezvous string")
+        return nil, false, ErrEmptyRendezvous
+    } else if node.Discovery() == nil {
+        log.Printf("ERROR: Discovery does not exist")
+        return nil, false, ErrNoDiscovery
+    } else if node.observer {
+        return nil, false, ErrObserverMode
+    } else if node.Draining() {
+        return nil, false, ErrDraining
+    }
+
+    if lease, ok := node.leases.get(rendezvous); ok && !lease.Released() {
+        node.spawn("lease-renew", func(context.Context) { lease.Renew() })
+        return lease, true, nil
+    }
+
+    lease = &Lease{
+        Rendezvous: rendezvous,
+        node:       node,
+    }
+    lease.ctx, lease.cancel = context.WithCancel(node.Ctx)
+    node.leases.add(lease)
+    node.announceWithdrawal(rendezvous, time.Time{})
+    node.spawnWith(lease.ctx, "lease-refresh", func(context.Context) {
+        lease.refresh(delay, firstAttempt)
+    })
+
+    return lease, false, nil
+}
+
+// Returns all leases currently held by the node
+func (node *Node) Leases() []*Lease {
+    return node.leases.list()
+}
+
+// Stops re-advertising the rendezvous string (see Lease.Release())
+func (node *Node) StopAdvertise(rendezvous string) error {
+    _, err := node.Unadvertise(rendezvous)
+    return err
+}
+
+// Withdraws the Node from discovery under the rendezvous string. Refreshing
+// stops immediately and the lease is dropped from the state file, so it is
+// not resumed on restart. An EventUnadvertised is emitted, so watchers
+// (e.g. via Node.Events() or a webhook) can stop selecting the node right
+// away. If PubSub is enabled, the withdrawal is also announced on
+// WithdrawTopic, and other nodes' FindPeers() skip this node for the
+// rendezvous string until its records lapse.
+//
+// NOTE: The DHT has no way of revoking provider records, so ones already
+//       published remain discoverable until they lapse. The returned time is
+//       when the last published record expires.
+func (node *Node) Unadvertise(rendezvous string) (time.Time, error) {
+    lease, ok := node.leases.get(rendezvous)
+    if !ok || lease.Released() {
+        return time.Time{}, fmt.Errorf("Not advertising %s", rendezvous)
+    }
+
+    expiry := lease.Expiry()
+    lease.Release()
+    log.Printf("Withdrew advertisement of %s, published records lapse at %v\n",
+        rendezvous, expiry)
+    node.emit(Event{Type: EventUnadvertised, Rendezvous: rendezvous, Expiry: expiry})
+    node.announceWithdrawal(rendezvous, expiry)
+    return expiry, nil
+}
@@ -0,0 +1,180 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "log"
+    "sync"
+
+    "github.com/libp2p/go-libp2p-core/connmgr"
+    "github.com/libp2p/go-libp2p-core/control"
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+// Kinds of resources limited by Config.MaxStreamsPerPeer and
+// Config.MaxInboundConns, as reported in metrics
+const (
+    limitStreams = "streams"
+    limitConns   = "conns"
+)
+
+// Enforces per-peer stream and inbound connection limits. Inbound
+// connections past the limit are refused before being upgraded, and
+// inbound streams past a peer's limit are reset as soon as they open.
+// It wraps the custom ConnectionGater (if any), and is chained after the
+// Node's PeerGater.
+type resourceLimiter struct {
+    maxStreamsPerPeer   int
+    maxInboundConns     int
+    next                connmgr.ConnectionGater
+
+    mutex               sync.Mutex
+    streams             map[peer.ID]int
+    inbound             int
+
+    // Optional callback invoked whenever a stream or connection is refused
+    onReject            func(kind string, id peer.ID)
+}
+
+func newResourceLimiter(maxStreamsPerPeer, maxInboundConns int,
+    next connmgr.ConnectionGater) *resourceLimiter {
+
+    return &resourceLimiter{
+        maxStreamsPerPeer:  maxStreamsPerPeer,
+        maxInboundConns:    maxInboundConns,
+        next:               next,
+        streams:            make(map[peer.ID]int),
+    }
+}
+
+// Tracks the connections and streams of the host. Counts start from the
+// host's own connections, as those of a previous host (see
+// RotateIdentity()) are closed.
+func (rl *resourceLimiter) attach(h host.Host) {
+    rl.recount(h)
+
+    h.Network().Notify(&network.NotifyBundle{
+        ConnectedF: func(_ network.Network, conn network.Conn) {
+            if conn.Stat().Direction == network.DirInbound {
+                rl.mutex.Lock()
+                rl.inbound++
+                rl.mutex.Unlock()
+            }
+        },
+        DisconnectedF: func(_ network.Network, conn network.Conn) {
+            if conn.Stat().Direction == network.DirInbound {
+                rl.mutex.Lock()
+                rl.inbound--
+                rl.mutex.Unlock()
+            }
+        },
+        OpenedStreamF: func(_ network.Network, stream network.Stream) {
+            if stream.Stat().Direction != network.DirInbound {
+                return
+            }
+            id := stream.Conn().RemotePeer()
+
+            rl.mutex.Lock()
+            rl.streams[id]++
+            exceeded := rl.maxStreamsPerPeer > 0 && rl.streams[id] > rl.maxStreamsPerPeer
+            rl.mutex.Unlock()
+
+            if exceeded {
+                stream.Reset()
+                rl.reject(limitStreams, id)
+            }
+        },
+        ClosedStreamF: func(_ network.Network, stream network.Stream) {
+            if stream.Stat().Direction != network.DirInbound {
+                return
+            }
+            id := stream.Conn().RemotePeer()
+
+            rl.mutex.Lock()
+            defer rl.mutex.Unlock()
+            if rl.streams[id]--; rl.streams[id] <= 0 {
+                delete(rl.streams, id)
+            }
+        },
+    })
+}
+
+// Resets the counts to the host's current inbound connections and streams
+func (rl *resourceLimiter) recount(h host.Host) {
+    streams := make(map[peer.ID]int)
+    inbound := 0
+    for _, conn := range h.Network().Conns() {
+        if conn.Stat().Direction == network.DirInbound {
+            inbound++
+        }
+        for _, stream := range conn.GetStreams() {
+            if stream.Stat().Direction == network.DirInbound {
+                streams[conn.RemotePeer()]++
+            }
+        }
+    }
+
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+    rl.streams = streams
+    rl.inbound = inbound
+}
+
+func (rl *resourceLimiter) reject(kind string, id peer.ID) {
+    if rl.onReject != nil {
+        rl.onReject(kind, id)
+    }
+}
+
+func (rl *resourceLimiter) InterceptPeerDial(id peer.ID) bool {
+    return rl.next == nil || rl.next.InterceptPeerDial(id)
+}
+
+func (rl *resourceLimiter) InterceptAddrDial(id peer.ID, addr multiaddr.Multiaddr) bool {
+    return rl.next == nil || rl.next.InterceptAddrDial(id, addr)
+}
+
+func (rl *resourceLimiter) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+    if rl.maxInboundConns > 0 {
+        rl.mutex.Lock()
+        full := rl.inbound >= rl.maxInboundConns
+        rl.mutex.Unlock()
+
+        if full {
+            log.Printf("Refusing connection from %s, at MaxInboundConns\n",
+                addrs.RemoteMultiaddr())
+            rl.reject(limitConns, "")
+            return false
+        }
+    }
+    return rl.next == nil || rl.next.InterceptAccept(addrs)
+}
+
+func (rl *resourceLimiter) InterceptSecured(dir network.Direction, id peer.ID,
+    addrs network.ConnMultiaddrs) bool {
+
+    return rl.next == nil || rl.next.InterceptSecured(dir, id, addrs)
+}
+
+func (rl *resourceLimiter) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+    if rl.next == nil {
+        return true, 0
+    }
+    return rl.next.InterceptUpgraded(conn)
+}
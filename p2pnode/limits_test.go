@@ -0,0 +1,73 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+type testConnAddrs struct {
+    remote multiaddr.Multiaddr
+}
+
+func (addrs testConnAddrs) LocalMultiaddr() multiaddr.Multiaddr {
+    return multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+}
+
+func (addrs testConnAddrs) RemoteMultiaddr() multiaddr.Multiaddr {
+    return addrs.remote
+}
+
+func TestResourceLimiterInbound(test *testing.T) {
+    addrs := testConnAddrs{multiaddr.StringCast("/ip4/10.2.0.1/tcp/4001")}
+
+    rejected := 0
+    limiter := newResourceLimiter(0, 2, nil)
+    limiter.onReject = func(kind string, _ peer.ID) {
+        if kind != limitConns {
+            test.Errorf("Expected rejection of kind %s, got %s", limitConns, kind)
+        }
+        rejected++
+    }
+
+    limiter.inbound = 1
+    if !limiter.InterceptAccept(addrs) {
+        test.Errorf("Connection below MaxInboundConns was refused")
+    }
+
+    limiter.inbound = 2
+    if limiter.InterceptAccept(addrs) {
+        test.Errorf("Connection at MaxInboundConns was accepted")
+    }
+    if rejected != 1 {
+        test.Errorf("Expected 1 rejection to be reported, got %d", rejected)
+    }
+
+    test.Run("ChainedGater", func(test *testing.T) {
+        gater, err := NewPeerGater(nil, nil, nil, []string{"10.0.0.0/8"}, nil)
+        if err != nil {
+            test.Fatalf("NewPeerGater() failed:\n%v", err)
+        }
+
+        limiter := newResourceLimiter(0, 2, gater)
+        if limiter.InterceptAccept(addrs) {
+            test.Errorf("Connection denied by the chained gater was accepted")
+        }
+    })
+}
@@ -0,0 +1,182 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "io/ioutil"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+const (
+    LogShipProtocolID = protocol.ID("/mtc/logs/1.0")
+
+    // Defaults used when LogShipOpts fields are left as zero-values
+    DefaultLogShipMaxBytes    = 256 * 1024
+    DefaultLogShipMinInterval = 10 * time.Second
+)
+
+// LogBuffer holds the most recent log output of a node, up to a fixed
+// number of bytes. It implements io.Writer, so it can be attached to the
+// standard logger, e.g.
+//  buf, err := NewLogBuffer(1024 * 1024)
+//  if err != nil {
+//      log.Fatal(err)
+//  }
+//  log.SetOutput(io.MultiWriter(os.Stderr, buf))
+type LogBuffer struct {
+    mutex   sync.Mutex
+    // Ring of 'size' bytes, holding 'length' bytes of log output starting
+    // at 'start'
+    data    []byte
+    start   int
+    length  int
+}
+
+func NewLogBuffer(size int) (*LogBuffer, error) {
+    if size <= 0 {
+        return nil, errors.New("LogBuffer size must be greater than 0")
+    }
+
+    return &LogBuffer{data: make([]byte, size)}, nil
+}
+
+// Appends to the buffer, overwriting the oldest data once full
+func (buf *LogBuffer) Write(p []byte) (int, error) {
+    buf.mutex.Lock()
+    defer buf.mutex.Unlock()
+
+    n := len(p)
+    size := len(buf.data)
+    if len(p) >= size {
+        copy(buf.data, p[len(p)-size:])
+        buf.start, buf.length = 0, size
+        return n, nil
+    }
+
+    end := (buf.start + buf.length) % size
+    copied := copy(buf.data[end:], p)
+    copy(buf.data, p[copied:])
+
+    buf.length += len(p)
+    if buf.length > size {
+        buf.start = (buf.start + buf.length - size) % size
+        buf.length = size
+    }
+    return n, nil
+}
+
+// Returns up to the last 'max' bytes of the buffer
+func (buf *LogBuffer) Tail(max int) []byte {
+    buf.mutex.Lock()
+    defer buf.mutex.Unlock()
+
+    n := buf.length
+    if max > 0 && n > max {
+        n = max
+    }
+
+    tail := make([]byte, n)
+    from := (buf.start + buf.length - n) % len(buf.data)
+    copied := copy(tail, buf.data[from:])
+    copy(tail[copied:], buf.data)
+    return tail
+}
+
+// Options for serving logs to collector peers
+type LogShipOpts struct {
+    // Peers allowed to request logs. Requests from any other peer are reset.
+    AuthorizedPeers []peer.ID
+
+    // Maximum number of bytes sent per request
+    MaxBytes        int
+
+    // Minimum time between two requests from the same peer
+    MinInterval     time.Duration
+}
+
+// Registers a handler that serves the tail of 'buf' to authorized
+// collector peers over LogShipProtocolID
+func (node *Node) EnableLogShipping(buf *LogBuffer, opts LogShipOpts) error {
+    if buf == nil {
+        return errors.New("Cannot ship logs from a nil LogBuffer")
+    } else if len(opts.AuthorizedPeers) == 0 {
+        return errors.New("Must authorize at least one collector peer")
+    }
+
+    if opts.MaxBytes <= 0 {
+        opts.MaxBytes = DefaultLogShipMaxBytes
+    }
+    if opts.MinInterval <= 0 {
+        opts.MinInterval = DefaultLogShipMinInterval
+    }
+
+    authorized := make(map[peer.ID]bool)
+    for _, id := range opts.AuthorizedPeers {
+        authorized[id] = true
+    }
+
+    var mutex sync.Mutex
+    lastServed := make(map[peer.ID]time.Time)
+
+    return node.RegisterStreamHandler(LogShipProtocolID, "logship", func(stream network.Stream) {
+        remote := stream.Conn().RemotePeer()
+        if !authorized[remote] {
+            log.Printf("ERROR: Unauthorized peer %s requested logs\n", remote)
+            stream.Reset()
+            return
+        }
+
+        mutex.Lock()
+        if time.Since(lastServed[remote]) < opts.MinInterval {
+            mutex.Unlock()
+            log.Printf("ERROR: Peer %s requested logs too frequently\n", remote)
+            stream.Reset()
+            return
+        }
+        lastServed[remote] = time.Now()
+        mutex.Unlock()
+
+        if _, err := stream.Write(buf.Tail(opts.MaxBytes)); err != nil {
+            stream.Reset()
+            return
+        }
+        stream.Close()
+    })
+}
+
+// Requests the recent logs of a peer that has log shipping enabled
+func (node *Node) FetchLogs(ctx context.Context, id peer.ID) ([]byte, error) {
+    stream, err := node.Host().NewStream(ctx, id, LogShipProtocolID)
+    if err != nil {
+        return nil, err
+    }
+
+    data, err := ioutil.ReadAll(stream)
+    if err != nil {
+        stream.Reset()
+        return nil, err
+    }
+
+    stream.Close()
+    return data, nil
+}
@@ -0,0 +1,65 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "testing"
+)
+
+func TestLogBuffer(test *testing.T) {
+    test.Run("NewLogBuffer-ZeroSize", func(test *testing.T) {
+        if buf, err := NewLogBuffer(0); err == nil || buf != nil {
+            test.Errorf("NewLogBuffer() with size 0 succeeded, expected it to fail")
+        }
+    })
+
+    buf, err := NewLogBuffer(8)
+    if err != nil {
+        test.Fatalf("NewLogBuffer() failed:\n%v", err)
+    }
+
+    test.Run("Write-Overflow", func(test *testing.T) {
+        buf.Write([]byte("hello "))
+        buf.Write([]byte("world"))
+        if tail := string(buf.Tail(0)); tail != "lo world" {
+            test.Errorf("Expected buffer to hold \"lo world\", got \"%s\"", tail)
+        }
+    })
+
+    test.Run("Tail-Max", func(test *testing.T) {
+        if tail := string(buf.Tail(5)); tail != "world" {
+            test.Errorf("Expected Tail(5) to return \"world\", got \"%s\"", tail)
+        }
+    })
+
+    test.Run("Write-Wrap", func(test *testing.T) {
+        for _, line := range []string{"ab", "cde", "f", "ghijk"} {
+            buf.Write([]byte(line))
+        }
+        if tail := string(buf.Tail(0)); tail != "defghijk" {
+            test.Errorf("Expected buffer to hold \"defghijk\", got \"%s\"", tail)
+        }
+        if tail := string(buf.Tail(3)); tail != "ijk" {
+            test.Errorf("Expected Tail(3) to return \"ijk\", got \"%s\"", tail)
+        }
+    })
+
+    test.Run("Write-Oversized", func(test *testing.T) {
+        buf.Write([]byte("0123456789"))
+        if tail := string(buf.Tail(0)); tail != "23456789" {
+            test.Errorf("Expected buffer to hold \"23456789\", got \"%s\"", tail)
+        }
+    })
+}
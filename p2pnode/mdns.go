@@ -0,0 +1,77 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+    mdns "github.com/libp2p/go-libp2p/p2p/discovery"
+)
+
+const (
+    // Default service tag used to find other nodes over mDNS
+    DefaultMDNSServiceTag = "physarumsm-mdns"
+
+    // Default interval between mDNS queries
+    DefaultMDNSInterval = 10 * time.Second
+)
+
+// Connects to any peers found over mDNS on the local network, and records
+// them for MDNSPeerRouter
+type mdnsNotifee struct {
+    node *Node
+}
+
+func (n *mdnsNotifee) HandlePeerFound(addrInfo peer.AddrInfo) {
+    if addrInfo.ID == n.node.Host().ID() {
+        return
+    }
+    if n.node.mdnsPeers != nil {
+        n.node.mdnsPeers.found(addrInfo)
+    }
+
+    n.node.spawn("mdns-connect", func(ctx context.Context) {
+        if err := n.node.connect(ctx, addrInfo); err != nil {
+            log.Printf("ERROR: Unable to connect to mDNS peer %s\n%v\n", addrInfo.ID, err)
+        } else {
+            log.Println("Connected to mDNS peer:", addrInfo)
+        }
+    })
+}
+
+// Starts an mDNS service that announces the node on the local network,
+// and connects to other nodes announcing the same service tag.
+func (node *Node) startMDNS(serviceTag string, interval time.Duration) error {
+    if serviceTag == "" {
+        serviceTag = DefaultMDNSServiceTag
+    }
+    if interval <= 0 {
+        interval = DefaultMDNSInterval
+    }
+
+    service, err := mdns.NewMdnsService(node.hostContext(), node.Host(), interval, serviceTag)
+    if err != nil {
+        return err
+    }
+
+    service.RegisterNotifee(&mdnsNotifee{node: node})
+    if prev := node.core.swapMDNS(service); prev != nil {
+        prev.Close()
+    }
+    return nil
+}
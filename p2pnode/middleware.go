@@ -0,0 +1,49 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "log"
+    "runtime/debug"
+
+    "github.com/libp2p/go-libp2p-core/network"
+)
+
+// Wraps the handler in the middleware, the first of which sees each stream
+// first
+func wrapMiddleware(handler network.StreamHandler,
+    middleware []func(network.StreamHandler) network.StreamHandler) network.StreamHandler {
+
+    for i := len(middleware) - 1; i >= 0; i-- {
+        handler = middleware[i](handler)
+    }
+    return handler
+}
+
+// Stream middleware (see Config.StreamMiddleware) that recovers from panics
+// in the handlers it wraps, logging the panic and resetting the stream
+// rather than crashing the service
+func RecoverStreams(next network.StreamHandler) network.StreamHandler {
+    return func(stream network.Stream) {
+        defer func() {
+            if r := recover(); r != nil {
+                log.Printf("ERROR: Handler for %s from %s panicked: %v\n%s\n",
+                    stream.Protocol(), stream.Conn().RemotePeer(), r, debug.Stack())
+                stream.Reset()
+            }
+        }()
+        next(stream)
+    }
+}
@@ -0,0 +1,44 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/network"
+)
+
+func TestWrapMiddleware(test *testing.T) {
+    var calls []string
+    record := func(name string) func(network.StreamHandler) network.StreamHandler {
+        return func(next network.StreamHandler) network.StreamHandler {
+            return func(stream network.Stream) {
+                calls = append(calls, name)
+                next(stream)
+            }
+        }
+    }
+
+    handler := wrapMiddleware(func(network.Stream) {
+        calls = append(calls, "handler")
+    }, []func(network.StreamHandler) network.StreamHandler{record("first"), record("second")})
+    handler(nil)
+
+    expected := []string{"first", "second", "handler"}
+    if !reflect.DeepEqual(calls, expected) {
+        test.Errorf("Middleware ran as %v, expected %v", calls, expected)
+    }
+}
@@ -0,0 +1,97 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/libp2p/go-libp2p"
+    mplex "github.com/libp2p/go-libp2p-mplex"
+    yamux "github.com/libp2p/go-libp2p-yamux"
+)
+
+// Names of stream muxers that can be listed in Config.Muxers
+const (
+    MuxerYamux = "yamux"
+    MuxerMplex = "mplex"
+)
+
+// Protocol IDs of the stream muxers, keyed by name
+var muxerIDs = map[string]string{
+    MuxerYamux: "/yamux/1.0.0",
+    MuxerMplex: "/mplex/6.7.0",
+}
+
+const (
+    // Smallest window yamux accepts (its initial window size)
+    MinYamuxWindowSize = 256 * 1024
+)
+
+// Returns a yamux transport using libp2p's defaults, with the window and
+// message sizes overridden by the Config where set
+func yamuxTransport(config *Config) (*yamux.Transport, error) {
+    tpt := *yamux.DefaultTransport
+    if config.YamuxWindowSize != 0 {
+        if config.YamuxWindowSize < MinYamuxWindowSize {
+            return nil, fmt.Errorf("YamuxWindowSize must be at least %d bytes", MinYamuxWindowSize)
+        }
+        tpt.MaxStreamWindowSize = config.YamuxWindowSize
+    }
+    if config.YamuxMaxMessageSize != 0 {
+        tpt.MaxMessageSize = config.YamuxMaxMessageSize
+    }
+    return &tpt, nil
+}
+
+// Returns libp2p options enabling the stream muxers listed in the Config,
+// in order of preference. If none are listed but yamux is tuned, yamux and
+// mplex are enabled in libp2p's default order. Otherwise, no options are
+// returned and libp2p's defaults are used.
+func muxerOpts(config *Config) ([]libp2p.Option, error) {
+    names := config.Muxers
+    if len(names) == 0 {
+        if config.YamuxWindowSize == 0 && config.YamuxMaxMessageSize == 0 {
+            return nil, nil
+        }
+        names = []string{MuxerYamux, MuxerMplex}
+    }
+
+    var opts []libp2p.Option
+    seen := make(map[string]bool)
+    for _, name := range names {
+        name = strings.ToLower(name)
+        id, ok := muxerIDs[name]
+        if !ok {
+            return nil, fmt.Errorf("Unknown stream muxer %s", name)
+        } else if seen[name] {
+            return nil, fmt.Errorf("Stream muxer %s listed more than once", name)
+        }
+        seen[name] = true
+
+        switch name {
+        case MuxerYamux:
+            tpt, err := yamuxTransport(config)
+            if err != nil {
+                return nil, err
+            }
+            opts = append(opts, libp2p.Muxer(id, tpt))
+        case MuxerMplex:
+            opts = append(opts, libp2p.Muxer(id, mplex.DefaultTransport))
+        }
+    }
+
+    return opts, nil
+}
@@ -0,0 +1,62 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "errors"
+    "fmt"
+
+    "github.com/libp2p/go-libp2p"
+)
+
+var (
+    // Returned when attempting to handle streams or advertise in observer mode
+    ErrObserverMode = errors.New("Not permitted on a node in observer mode")
+)
+
+// Checks that a Config in observer mode does not enable anything that would
+// make the node serve or advertise, and returns the libp2p options needed
+// to run as an observer. The Config is adjusted to use a client-mode DHT.
+func observerOpts(config *Config) ([]libp2p.Option, error) {
+    switch {
+    case len(config.StreamHandlers) > 0 || len(config.HandlerProtocolIDs) > 0:
+        return nil, fmt.Errorf("%w: cannot register StreamHandlers", ErrObserverMode)
+    case len(config.Rendezvous) > 0:
+        return nil, fmt.Errorf("%w: cannot advertise Rendezvous strings", ErrObserverMode)
+    case config.EnableEcho:
+        return nil, fmt.Errorf("%w: cannot enable echo", ErrObserverMode)
+    case config.EnableStatus:
+        return nil, fmt.Errorf("%w: cannot answer status queries", ErrObserverMode)
+    case config.EnablePEX:
+        return nil, fmt.Errorf("%w: cannot exchange peers", ErrObserverMode)
+    case config.EnableMDNS:
+        return nil, fmt.Errorf("%w: cannot announce itself via mDNS", ErrObserverMode)
+    case config.EnableRelayHop || config.EnableAutoNATService:
+        return nil, fmt.Errorf("%w: cannot provide relay or AutoNAT services", ErrObserverMode)
+    case config.EnablePubSub:
+        return nil, fmt.Errorf("%w: cannot relay PubSub messages", ErrObserverMode)
+    }
+
+    config.DHTClientMode = true
+
+    // Identify remains enabled, as it is needed to interact with peers at
+    // all, but the node does not answer pings
+    return []libp2p.Option{libp2p.Ping(false)}, nil
+}
+
+// Returns true if the Node was created in observer mode
+func (node *Node) IsObserver() bool {
+    return node.observer
+}
@@ -16,25 +16,30 @@ package p2pnode
 
 import (
     "context"
-    "errors"
     "fmt"
     "log"
-    "math"
-    "sync"
     "time"
 
     "github.com/libp2p/go-libp2p"
+    circuit "github.com/libp2p/go-libp2p-circuit"
+    "github.com/libp2p/go-libp2p-connmgr"
+    corecm "github.com/libp2p/go-libp2p-core/connmgr"
     "github.com/libp2p/go-libp2p-core/crypto"
     "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/metrics"
     "github.com/libp2p/go-libp2p-core/network"
     "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/peerstore"
     "github.com/libp2p/go-libp2p-core/pnet"
     "github.com/libp2p/go-libp2p-core/protocol"
-    "github.com/libp2p/go-libp2p-discovery"
+    "github.com/libp2p/go-libp2p-core/routing"
     "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p-record"
 
     "github.com/multiformats/go-multiaddr"
+    madns "github.com/multiformats/go-multiaddr-dns"
 
+    "github.com/PhysarumSM/common/reputation"
     "github.com/PhysarumSM/common/util"
 )
 
@@ -52,6 +57,325 @@ type Config struct {
     HandlerProtocolIDs []protocol.ID
     Rendezvous         []string
     PSK                pnet.PSK
+
+    // Refuses to create the node unless a PSK is provided, so a
+    // misconfigured node can never join the public network. Also enabled
+    // by setting the LIBP2P_FORCE_PNET environment variable to "1".
+    ForcePrivateNetwork bool
+
+    // Optional versioned directory (see util.DataDir) holding everything
+    // the node persists. It is created or migrated by NewNode, which places
+    // StateFile, IdentityHistoryFile, PeerstorePath and DNSCacheFile in it
+    // unless they are set. If PrivKey is not set, the identity key is loaded
+    // from the directory, or generated and stored there on first use.
+    // Node.RotateIdentity() does not update the stored key.
+    DataDir            string
+
+    // Optional file used to persist node state (e.g. advertisement leases)
+    // across restarts. Leases found in the file are re-advertised by NewNode.
+    StateFile          string
+
+    // Optional file used to persist the signed history of the node's
+    // identity rotations (see Node.IdentityHistory()) across restarts
+    IdentityHistoryFile string
+
+    // Encrypts everything the node stores on disk (StateFile,
+    // IdentityHistoryFile, DNSCacheFile and the PeerstorePath datastore)
+    // with AES-256-GCM, for devices at risk of physical theft. The key is
+    // derived from StateSecret if set, otherwise from PrivKey, which must
+    // then be set. The key is not changed by Node.RotateIdentity(), so set
+    // StateSecret if identities are rotated. Existing plaintext state is
+    // encrypted the next time it is written. Peerstore keys (peer IDs)
+    // remain in plaintext.
+    EncryptState       bool
+    StateSecret        []byte
+
+    // Enables discovery of peers on the local network via mDNS, allowing
+    // nodes to find each other without bootstraps. Tag and interval are
+    // optional, and fall back to DefaultMDNSServiceTag/DefaultMDNSInterval.
+    EnableMDNS         bool
+    MDNSServiceTag     string
+    MDNSInterval       time.Duration
+
+    // EnableRelay allows the node to be reached through circuit relays when
+    // it is not directly dialable (e.g. behind a symmetric NAT), by
+    // discovering relays and advertising relay addresses (AutoRelay).
+    // EnableRelayHop lets the node act as a relay for other peers, and
+    // should only be set on well-connected, publicly reachable nodes.
+    EnableRelay        bool
+    EnableRelayHop     bool
+
+    // EnableAutoNATService lets the node help other peers determine their
+    // reachability by dialing back to them. Independently of this, the node
+    // always uses AutoNAT to determine its own reachability, which can be
+    // queried with Node.Reachability(). Setting ForceReachability to public
+    // or private overrides detection; the zero-value (unknown) auto-detects.
+    EnableAutoNATService bool
+    ForceReachability    network.Reachability
+
+    // Upgrades relayed connections to direct ones through hole punching
+    // (DCUtR). NOTE: The version of libp2p currently in use does not
+    //       support hole punching, so setting this fails validation with
+    //       ErrHolePunchingUnsupported; NAT'd nodes should rely on
+    //       EnableRelay until libp2p is upgraded.
+    EnableHolePunching   bool
+
+    // Asks the local router to forward the node's listen ports via UPnP or
+    // NAT-PMP, so nodes behind home or edge routers can be dialed directly
+    EnableNATPortMap     bool
+
+    // Which addresses the node announces to peers. AnnouncePublicOnly drops
+    // private and loopback addresses, AnnounceAddrs (e.g. the address of a
+    // load balancer in front of the node) are announced in addition, and a
+    // custom AddrsFactory may rewrite the result. By default, every listen
+    // address is announced.
+    AnnouncePublicOnly   bool
+    AnnounceAddrs        []string
+    AddrsFactory         AddrsFactory
+
+    // Transports to listen on and dial with (see TransportTCP, etc.).
+    // If empty, libp2p's default transports are used (TCP and WebSocket).
+    // Remember to include matching ListenAddrs, e.g.
+    // "/ip4/0.0.0.0/udp/4001/quic" for QUIC, or "/ip4/0.0.0.0/tcp/4002/ws"
+    // for WebSocket.
+    Transports         []string
+
+    // Security transports to secure connections with (see SecurityTLS,
+    // etc.), in order of preference. If empty, libp2p's defaults are used
+    // (secio, then TLS). Peers must share at least one to connect.
+    Security           []string
+
+    // Stream muxers to multiplex connections with (see MuxerYamux, etc.),
+    // in order of preference. If empty, libp2p's defaults are used (yamux,
+    // then mplex). YamuxWindowSize is the maximum receive window of each
+    // yamux stream (libp2p defaults to 16 MiB), which bounds a stream's
+    // throughput to roughly the window size per round trip, and
+    // YamuxMaxMessageSize the largest frame sent before other streams get
+    // a turn. Both are in bytes, and use libp2p's defaults if 0.
+    Muxers             []string
+    YamuxWindowSize    uint32
+    YamuxMaxMessageSize uint32
+
+    // User agent sent to peers, defaulting to the service's build
+    // information (see buildinfo.Info.UserAgent()), and the version of the
+    // application's own protocols, if any. Peers see both through
+    // Node.PeerAgent().
+    UserAgent          string
+    AppProtocolVersion string
+
+    // Registers a diagnostic echo responder (EchoProtocolID), allowing
+    // other nodes to measure stream performance to this node via Echo()
+    EnableEcho         bool
+
+    // Answers status queries (StatusProtocolID) from other nodes with this
+    // node's build information and health (see Node.QueryStatus())
+    EnableStatus       bool
+
+    // Periodically swaps a signed list of connected peers with other nodes
+    // (PEXProtocolID) every PEXInterval (DefaultPEXInterval if 0). Each
+    // exchange carries up to PEXMaxPeers peers (DefaultPEXMaxPeers if 0),
+    // and those learned are dialed while connected to fewer than
+    // PEXMaxConns peers (DefaultPEXMaxConns if 0). This keeps small
+    // networks meshed when their bootstraps are lost.
+    EnablePEX          bool
+    PEXInterval        time.Duration
+    PEXMaxPeers        int
+    PEXMaxConns        int
+
+    // Connection manager watermarks. Once the number of connections exceeds
+    // ConnMgrHighWater, connections are pruned down to ConnMgrLowWater,
+    // sparing any connections younger than ConnMgrGracePeriod. Leaving
+    // ConnMgrHighWater as 0 disables connection management.
+    ConnMgrLowWater    int
+    ConnMgrHighWater   int
+    ConnMgrGracePeriod time.Duration
+
+    // Wraps every stream handler the Node registers, e.g. to add logging,
+    // metrics or panic recovery (see RecoverStreams) in one place. The
+    // first middleware sees each stream first, before any AuthPolicy is
+    // enforced.
+    StreamMiddleware   []func(network.StreamHandler) network.StreamHandler
+
+    // How to treat a handler registered for a protocol that already has
+    // one (see HandlerPolicy). Defaults to returning an error. The callback
+    // is optional, and invoked whenever HandlerPolicyReplace kicks in.
+    HandlerPolicy      HandlerPolicy
+    OnHandlerReplaced  HandlerReplacedCB
+
+    // Authorization policies, keyed by protocol. Streams for a protocol
+    // with a policy only reach its handler once the remote peer is
+    // authorized, e.g. to restrict admin protocols to operators. Peers
+    // present credentials with Node.NewStreamWithCredentials().
+    AuthPolicies       map[protocol.ID]AuthPolicy
+
+    // Peer IDs and IP subnets (CIDR notation, e.g. "10.0.0.0/8") to allow or
+    // deny connections to/from. A custom ConnectionGater may also be given,
+    // which is consulted after the lists. The resulting gater is accessible
+    // via Node.Gater(), and can be used to block peers at runtime.
+    AllowPeers         []peer.ID
+    DenyPeers          []peer.ID
+    AllowSubnets       []string
+    DenySubnets        []string
+    ConnectionGater    corecm.ConnectionGater
+
+    // Limits on the inbound streams each peer may have open at once, and
+    // on the inbound connections the node accepts. Streams past a peer's
+    // limit are reset, and connections past the limit are refused. 0
+    // leaves the resource unlimited.
+    MaxStreamsPerPeer  int
+    MaxInboundConns    int
+
+    // Tracks the reputation of peers from the node's connections and dials.
+    // Connections to and from peers with an unacceptable reputation are
+    // refused, and p2putil.SortPeers() skips them.
+    Reputation         *reputation.Tracker
+
+    // Directory of an on-disk datastore backing the peerstore, so known
+    // peer addresses survive restarts. If empty, an in-memory peerstore is
+    // used.
+    PeerstorePath      string
+
+    // Protocol prefix for the DHT (e.g. "/staging"). Nodes only form a DHT
+    // with peers using the same prefix. Defaults to libp2p's "/ipfs".
+    DHTProtocolPrefix  protocol.ID
+
+    // Validators for DHT records, keyed by namespace (e.g. "service" to
+    // validate records stored under "/service/..."). These are added to
+    // libp2p's default "pk" and "ipns" validators, and require
+    // DHTProtocolPrefix to be set.
+    DHTValidators      map[string]record.Validator
+
+    // Runs the DHT in client mode, where the node queries the DHT but does
+    // not answer queries or store records for others. Suited to nodes that
+    // are short-lived or not publicly reachable.
+    DHTClientMode      bool
+
+    // How often to re-measure the connections to peers that streams were
+    // opened to with NewStreamFastest(). Defaults to DefaultPathEvalInterval.
+    PathEvalInterval   time.Duration
+
+    // Address (e.g. ":9100") to serve Prometheus metrics on, at MetricsPath.
+    // If empty, metrics are still collected and available via
+    // Node.MetricsHandler(), but not served.
+    MetricsAddr        string
+
+    // URL to POST JSON payloads (see WebhookPayload) to when selected node
+    // events occur (see EventType). If WebhookEvents is empty,
+    // DefaultWebhookEvents are sent. Events are delivered one at a time,
+    // and dropped if WebhookQueueSize are already waiting.
+    WebhookURL         string
+    WebhookEvents      []EventType
+
+    // Runs the node as a read-only observer, for monitoring and auditing
+    // tools. The node connects to peers and can query the DHT and discover
+    // peers, but uses a client-mode DHT, handles no streams, and never
+    // advertises, so it is never selected as a service provider.
+    ObserverMode       bool
+
+    // Resolver used for DNS multiaddrs (/dns4, /dnsaddr, etc.) of peers
+    // this package connects to, e.g. bootstraps. See NewDNSResolver() and
+    // NewCachingDNSResolver(). If nil, the system resolver is used.
+    DNSResolver        *madns.Resolver
+
+    // File to cache what DNS multiaddrs resolved to in, so the last-known
+    // addresses of bootstraps can still be dialed if DNS is unavailable.
+    // Entries are kept for DNSCacheTTL (defaults to DefaultDNSCacheTTL).
+    DNSCacheFile       string
+    DNSCacheTTL        time.Duration
+
+    // Sources consulted, in order, to find the addresses of peers by ID
+    // (see PeerRouter). The DHT is consulted last unless DHTPeerRouter is
+    // placed elsewhere in the list.
+    PeerRouters        []PeerRouter
+
+    // Time given to each stage of the Node's own dials (e.g. to bootstraps)
+    // before moving on to worse addresses. Addresses are dialed in stages:
+    // the one that last worked, then public QUIC, public, private QUIC,
+    // private and relay addresses, so dead private addresses of
+    // multi-homed peers don't hold up dials. Defaults to
+    // DefaultDialStageTimeout. Negative values dial every address at once.
+    DialStageTimeout   time.Duration
+
+    // Creates the Node's libp2p host in place of libp2p.New(), e.g. to run
+    // Nodes on an in-process network in tests (see the testutil package).
+    // It is given the options the Node would have used, which it may
+    // ignore; the Node's DHT is then created on the returned host.
+    HostConstructor    func(ctx context.Context, opts ...libp2p.Option) (host.Host, error)
+
+    // Creates the Discovery used to advertise Rendezvous strings and find
+    // peers (see DiscoveryConstructor). Defaults to DHTDiscovery.
+    Discovery          DiscoveryConstructor
+
+    // Fallbacks consulted, in order, by Node.FindPeers() when the DHT fails
+    // or finds no peers for a rendezvous string, so critical services stay
+    // reachable during DHT outages: a static map of rendezvous strings to
+    // provider multiaddrs (ending in /p2p/<peer ID>), then a callback.
+    StaticServices     map[string][]multiaddr.Multiaddr
+    ServiceResolver    ServiceResolver
+
+    // How often to re-advertise each rendezvous string. If 0, each one is
+    // re-advertised shortly before its provider record expires.
+    AdvertiseInterval  time.Duration
+
+    // Delay between the initial advertisements of each rendezvous string
+    // at startup (see Node.AdvertiseBatch()). Defaults to
+    // DefaultAdvertiseBatchInterval.
+    AdvertiseBatchInterval time.Duration
+
+    // NTP servers to verify the system clock against before advertising,
+    // since a badly skewed clock results in records with bad TTLs. If the
+    // clock is off by more than MaxClockSkew (defaults to
+    // DefaultMaxClockSkew), ClockSkewPolicy decides whether to refuse to
+    // start or only warn. No check is done if no servers are given.
+    NTPServers         []string
+    MaxClockSkew       time.Duration
+    ClockSkewPolicy    ClockSkewPolicy
+
+    // Maximum time NewNode spends trying to connect to BootstrapPeers
+    // before returning a *BootstrapError. If 0, NewNode gives up after
+    // MaxConnAttempts attempts or once its context is cancelled.
+    BootstrapTimeout   time.Duration
+
+    // How to reconnect to bootstraps that disconnect. The zero-value retries
+    // forever (see ReconnectPolicy).
+    ReconnectPolicy    ReconnectPolicy
+
+    // How often to probe each bootstrap in the background (see
+    // Node.BootstrapStatus()). Defaults to DefaultBootstrapProbeInterval.
+    BootstrapProbeInterval time.Duration
+
+    // HTTPS URL of a BootstrapList signed by BootstrapListKey, fetched at
+    // startup and every BootstrapListInterval (DefaultBootstrapListInterval
+    // if 0) thereafter. Its bootstraps are used alongside BootstrapPeers,
+    // and are added and removed as the list changes.
+    BootstrapListURL      string
+    BootstrapListKey      crypto.PubKey
+    BootstrapListInterval time.Duration
+
+    // Peers (multiaddrs ending in /p2p/<peer ID>) to stay connected to at
+    // all times, e.g. for fixed links between gateways. Unlike bootstraps,
+    // they are not needed to start, are reconnected to indefinitely (backing
+    // off per ReconnectPolicy, ignoring MaxAttempts), and their connections
+    // are protected from the connection manager.
+    StaticPeers        []multiaddr.Multiaddr
+
+    // Pings bootstraps, static peers and peers tagged with ImportantPeerTag
+    // in the connection manager every KeepAliveInterval, so NATs and other
+    // middleboxes don't drop their connections while idle. Connections
+    // that stop answering are closed, so they are reconnected to sooner.
+    // Disabled if 0.
+    KeepAliveInterval  time.Duration
+
+    // Shuts the node down (see Node.Shutdown()) on SIGTERM or SIGINT, giving
+    // open streams up to ShutdownGracePeriod (defaults to
+    // DefaultShutdownGracePeriod) to finish. The application should exit
+    // once the node's context is done.
+    HandleSignals      bool
+    ShutdownGracePeriod time.Duration
+
+    // Starts a gossipsub router, enabling Node.Publish() and
+    // Node.Subscribe() for topic-based broadcast between nodes
+    EnablePubSub       bool
 }
 
 // Config constructor that returns default configuration
@@ -65,10 +389,40 @@ func NewConfig() Config {
 type Node struct {
     Ctx                context.Context
     Close              context.CancelFunc
-    Host               host.Host
-    DHT                *dht.IpfsDHT
-    RoutingDiscovery   *discovery.RoutingDiscovery
-    NetworkCallbacks   *network.NotifyBundle
+
+    core               *nodeCore
+    leases             *leaseTable
+    nat                *natStatus
+    handlers           *handlerRegistry
+    bandwidth          *metrics.BandwidthCounter
+    paths              *pathSelector
+    metrics            *nodeMetrics
+    webhook            *webhookNotifier
+    bootstrapSet       *bootstrapSet
+    bootstrapList      *bootstrapListSource
+    observer           bool
+    dials              *dialTracker
+    goodAddrs          *goodDialAddrs
+    stages             *dialStages
+    protections        *peerProtections
+    protoStats         *protocolStats
+    resolver           *madns.Resolver
+    goroutines         *goroutineTracker
+    usesPSK            bool
+    lifecycle          *lifecycle
+    pubsub             *pubsubState
+    addrCache          *addrCache
+    mdnsPeers          *mdnsPeerRouter
+    config             *Config
+    staticPeers        *staticPeers
+    withdrawals        *withdrawals
+    fallbacks          *serviceFallbacks
+    limits             *resourceLimiter
+    reputation         *reputation.Tracker
+    authPolicies       map[protocol.ID]AuthPolicy
+    bootstrapMonitor   *bootstrapMonitor
+    identities         *identityHistory
+    stateCipher        *stateCipher
 }
 
 const (
@@ -76,28 +430,54 @@ const (
 
     // 512 seconds = 8 mins 32 secs
     MaxBackoffSecs = 512
+
+    // Fraction by which reconnection delays are randomly adjusted, so nodes
+    // that lost the same bootstrap don't all retry at once
+    ReconnectJitter = 0.2
 )
 
-func (node *Node) Advertise(rendezvous string) error {
-    if rendezvous == "" {
-        log.Printf("ERROR: Empty rendezvous string")
-        return errors.New("Cannot have empty Rendezvous string")
-    } else if node.RoutingDiscovery == nil {
-        log.Printf("ERROR: RoutingDiscovery does not exist")
-        return errors.New("No Discovery object available to advertise from")
-    }
+// Controls how ReconnectCB retries lost bootstraps. Zero-value fields fall
+// back to the defaults noted below.
+type ReconnectPolicy struct {
+    // Gives up after this many failed attempts. 0 retries forever.
+    MaxAttempts     int
 
-    discovery.Advertise(node.Ctx, node.RoutingDiscovery, rendezvous)
+    // Backoff starts at InitialBackoff (default 2s) and doubles after each
+    // failed attempt, up to MaxBackoff (default MaxBackoffSecs).
+    InitialBackoff  time.Duration
+    MaxBackoff      time.Duration
 
-    return nil
+    // Fraction by which delays are randomly adjusted (default
+    // ReconnectJitter). Negative values disable jitter.
+    Jitter          float64
+
+    // Called (in its own goroutine) when giving up on a bootstrap
+    OnGiveUp        func(peer.ID)
+}
+
+// Returns a copy of the policy with defaults filled in
+func (policy ReconnectPolicy) withDefaults() ReconnectPolicy {
+    if policy.InitialBackoff <= 0 {
+        policy.InitialBackoff = 2 * time.Second
+    }
+    if policy.MaxBackoff <= 0 {
+        policy.MaxBackoff = MaxBackoffSecs * time.Second
+    }
+    if policy.MaxBackoff < policy.InitialBackoff {
+        policy.MaxBackoff = policy.InitialBackoff
+    }
+    if policy.Jitter == 0 {
+        policy.Jitter = ReconnectJitter
+    }
+    return policy
 }
 
 // Returns a callback function for peer disconnection events
 //
-// Given the Node and the original Config used to create it, always try to
+// Given the Node and the original Config used to create it, try to
 // maintain its connectivity to the original bootstraps (i.e. reconnect to
-// them if they are disconnected. Upon reconnection, re-advertise any
-// services and/or content.
+// them if they are disconnected), as dictated by Config.ReconnectPolicy.
+// Upon reconnection, re-advertise any services and/or content.
 func ReconnectCB(node *Node, cfg *Config) func(network.Network, network.Conn) {
 
     return func(net network.Network, conn network.Conn) {
@@ -106,81 +486,275 @@ func ReconnectCB(node *Node, cfg *Config) func(network.Network, network.Conn) {
             return
         }
 
-        var err error
-        var addrInfo *peer.AddrInfo
-        isBootstrap := false
-        for _, peerAddr := range cfg.BootstrapPeers {
-            addrInfo, err = peer.AddrInfoFromP2pAddr(peerAddr)
-            if err != nil {
-                log.Printf("ERROR: Unable to parse AddrInfo from %s\n%w\n", peerAddr, err)
-                continue
-            }
-
-            if conn.RemotePeer() == addrInfo.ID {
-                isBootstrap = true
-                break
-            }
-        }
-
+        addrInfo, isBootstrap := node.bootstrapSet.get(conn.RemotePeer())
         if !isBootstrap {
             return
         }
 
+        defer node.goroutines.enter("reconnect")()
+
         log.Printf("Connection to %s lost, attempting to reconnect...\n", conn.RemotePeer())
+        node.emit(Event{Type: EventBootstrapLost, Peer: conn.RemotePeer()})
 
         // The disconnecting peer is a bootstrap, attempt reconnect
-        // Perform exponential backoff until MaxBackoffSecs, then continue trying
-        // forever once every MaxBackoffSecs until success.
+        // Perform jittered exponential backoff until the policy's MaxBackoff,
+        // then continue trying once every MaxBackoff until success, or until
+        // the policy's MaxAttempts is reached.
+        policy := cfg.ReconnectPolicy.withDefaults()
+        eb, _ := util.NewExpoBackoff(policy.InitialBackoff, policy.MaxBackoff)
         connAttempts := 0
-        sleepDuration := 0
 
         for net.Connectedness(conn.RemotePeer()) != network.Connected {
-            // Perform simple exponential backoff
-            // TODO: Move this to helper function
+            // Stop once the peer is no longer a bootstrap
+            if _, ok := node.bootstrapSet.get(conn.RemotePeer()); !ok {
+                return
+            }
+            if policy.MaxAttempts > 0 && connAttempts >= policy.MaxAttempts {
+                log.Printf("Giving up on reconnecting to %s after %d attempts\n",
+                    conn.RemotePeer(), connAttempts)
+                if policy.OnGiveUp != nil {
+                    go policy.OnGiveUp(conn.RemotePeer())
+                }
+                return
+            }
+
             if connAttempts > 0 {
-                sleepDuration = int(math.Pow(2, float64(connAttempts)))
-                log.Printf("Reconnection to %s failed.\n", conn.RemotePeer())
-				for i := 0; i < sleepDuration; i++ {
-                    fmt.Printf("\rRetrying again in %d seconds...     ", sleepDuration - i)
-                    time.Sleep(time.Second)
-
-                    // Check if context has been cancelled, abort attempts
-                    if node.Ctx.Err() != nil {
-                        return
-                    }
+                delay := util.Jitter(eb.Next(), policy.Jitter)
+                log.Printf("Reconnection to %s failed, retrying in %v\n",
+                    conn.RemotePeer(), delay.Round(time.Second))
+
+                // Abort attempts once the context has been cancelled
+                if util.SleepContext(node.Ctx, delay) != nil {
+                    return
                 }
-                fmt.Println()
             }
+            connAttempts++
 
-            if err := node.Host.Connect(node.Ctx, *addrInfo); err != nil {
+            node.metrics.reconnectAttempts.Inc()
+            resolved := node.resolveAddrInfo(node.Ctx, addrInfo)
+            if err := node.connect(node.Ctx, resolved); err != nil {
                 log.Println(err)
             } else {
                 log.Println("Reconnected to node:", addrInfo)
             }
-
-            // connAttempts used to calculate sleep duration
-            // Avoid incrementing when exceeding MaxBackoffSecs
-            if sleepDuration < MaxBackoffSecs {
-                connAttempts++
-            }
         }
 
-        // Re-advertise any rendezvous srings
-        for _, r := range cfg.Rendezvous {
-            node.Advertise(r)
+        node.emit(Event{Type: EventBootstrapRecovered, Peer: conn.RemotePeer()})
+
+        // Renew any advertisements
+        for _, lease := range node.Leases() {
+            lease := lease
+            node.spawn("lease-renew", func(context.Context) { lease.Renew() })
         }
     }
 }
 
-// Node constructor
-func NewNode(ctx context.Context, config Config) (Node, error) {
+// Creates the Node's host and local services, without contacting the
+// network. The Config may be adjusted (e.g. by observer mode).
+func newNode(ctx context.Context, config *Config) (*Node, error) {
     var err error
 
     // Populate new node instance
-    var node Node
+    node := &Node{}
 
     node.Ctx, node.Close = context.WithCancel(ctx)
-    nodeOpts := []libp2p.Option{}
+
+    // Catch every problem with the Config before any network activity
+    if err = config.Validate(); err != nil {
+        return node, err
+    }
+
+    if config.DataDir != "" {
+        if err = applyDataDir(config); err != nil {
+            return node, err
+        }
+    }
+
+    node.core = &nodeCore{}
+    node.goroutines = newGoroutineTracker()
+    node.lifecycle = &lifecycle{started: time.Now()}
+    node.dials = newDialTracker()
+    node.goodAddrs = newGoodDialAddrs()
+    node.stages = newDialStages()
+    node.protections = newPeerProtections()
+    node.protoStats = newProtocolStats()
+    node.resolver = config.DNSResolver
+    node.stateCipher, err = newStateCipher(config)
+    if err != nil {
+        return node, err
+    }
+    if config.EnableMDNS {
+        node.mdnsPeers = newMDNSPeerRouter()
+    }
+    if config.DNSCacheFile != "" {
+        node.addrCache, err = loadAddrCache(config.DNSCacheFile, config.DNSCacheTTL,
+            node.stateCipher)
+        if err != nil {
+            return node, err
+        }
+    }
+    node.leases = newLeaseTable(config.StateFile, node.stateCipher, config.AdvertiseInterval)
+    node.identities, err = loadIdentityHistory(config.IdentityHistoryFile, node.stateCipher)
+    if err != nil {
+        return node, err
+    }
+    node.withdrawals = newWithdrawals()
+    node.fallbacks, err = newServiceFallbacks(config)
+    if err != nil {
+        return node, err
+    }
+    node.handlers = newHandlerRegistry(config.HandlerPolicy, config.OnHandlerReplaced)
+    node.authPolicies = make(map[protocol.ID]AuthPolicy)
+    for pid, policy := range config.AuthPolicies {
+        if err = policy.validate(); err != nil {
+            return node, fmt.Errorf("Invalid AuthPolicy for %s: %w", pid, err)
+        }
+        node.authPolicies[pid] = policy
+    }
+    node.bandwidth = metrics.NewBandwidthCounter()
+    node.paths = newPathSelector(config.PathEvalInterval)
+    node.metrics = newNodeMetrics(node)
+    node.webhook, err = newWebhookNotifier(config.WebhookURL, config.WebhookEvents)
+    if err != nil {
+        return node, err
+    } else if node.webhook != nil {
+        node.spawn("webhook", node.webhook.run)
+    }
+    node.observer = config.ObserverMode
+    if err = checkPrivateNetwork(config); err != nil {
+        return node, err
+    }
+    node.usesPSK = config.PSK != nil
+
+    // Gate connections according to allow/deny lists, then resource limits
+    nextGater := config.ConnectionGater
+    if config.MaxStreamsPerPeer > 0 || config.MaxInboundConns > 0 {
+        node.limits = newResourceLimiter(config.MaxStreamsPerPeer,
+            config.MaxInboundConns, config.ConnectionGater)
+        node.limits.onReject = func(kind string, _ peer.ID) {
+            node.metrics.limitRejections.WithLabelValues(kind).Inc()
+        }
+        nextGater = node.limits
+    }
+    if config.Reputation != nil {
+        node.reputation = config.Reputation
+        nextGater = node.reputation.Gater(nextGater)
+    }
+    gater, err := NewPeerGater(config.AllowPeers, config.DenyPeers,
+        config.AllowSubnets, config.DenySubnets, nextGater)
+    if err != nil {
+        return node, err
+    }
+    gater.onBlock = func(id peer.ID) {
+        // The gater only sees new connections, so drop existing ones
+        if h := node.Host(); h != nil {
+            if err := h.Network().ClosePeer(id); err != nil {
+                log.Printf("ERROR: Unable to close connections to blocked peer %s\n%v\n", id, err)
+            }
+        }
+        node.emit(Event{Type: EventPeerBlocked, Peer: id})
+    }
+    gater.onDial = node.dials.started
+    gater.dialFilter = node.stages.allowed
+    node.core.setGater(gater)
+
+    if len(config.StaticPeers) > 0 {
+        node.staticPeers, err = newStaticPeers(config.StaticPeers)
+        if err != nil {
+            return node, err
+        }
+        for _, info := range node.staticPeers.peers {
+            node.ProtectPeer(info.ID, StaticPeerTag)
+        }
+    }
+
+    hostCtx, hostCancel := context.WithCancel(node.Ctx)
+    h, kdht, router, err := node.newHost(hostCtx, config, nil)
+    if err != nil {
+        hostCancel()
+        return node, err
+    }
+    if err = node.startHost(hostCtx, hostCancel, h, kdht, router, config); err != nil {
+        return node, err
+    }
+
+    node.config = config
+
+    if config.HandleSignals {
+        node.handleSignals(config.ShutdownGracePeriod)
+    }
+    node.spawn("path-eval", node.reevaluatePaths)
+
+    if config.MetricsAddr != "" {
+        if err = node.serveMetrics(config.MetricsAddr); err != nil {
+            return node, err
+        }
+    }
+
+    // Register Stream Handlers and corresponding Protocol IDs
+    log.Println("Setting stream handlers")
+    for i := range config.HandlerProtocolIDs {
+        err = node.RegisterStreamHandler(config.HandlerProtocolIDs[i],
+            ConfigHandlerOwner, config.StreamHandlers[i])
+        if err != nil {
+            return node, err
+        }
+    }
+    if config.EnableEcho {
+        if err = node.RegisterStreamHandler(EchoProtocolID, "echo", echoHandler); err != nil {
+            return node, err
+        }
+    }
+    if config.EnableStatus {
+        if err = node.RegisterStreamHandler(StatusProtocolID, "status", node.statusHandler); err != nil {
+            return node, err
+        }
+    }
+    if config.EnablePEX {
+        if err = node.RegisterStreamHandler(PEXProtocolID, "pex", node.pexHandler); err != nil {
+            return node, err
+        }
+    }
+
+    node.bootstrapSet, err = newBootstrapSet(config.BootstrapPeers)
+    if err != nil {
+        return node, err
+    }
+    for _, id := range node.bootstrapSet.ids() {
+        node.ProtectPeer(id, BootstrapPeerTag)
+    }
+    node.bootstrapList = newBootstrapListSource(config)
+    node.bootstrapMonitor = newBootstrapMonitor()
+
+    // Create network callbacks. Use a disconnection notifier to monitor
+    // when bootstraps disconnect, and attempt to reconnect. Users can
+    // override or add any other callbacks they want, either directly to the
+    // NotifyBundle created here, or register their own.
+    netCBs := network.NotifyBundle{}
+    netCBs.DisconnectedF = ReconnectCB(node, config)
+    node.core.setCallbacks(&netCBs)
+
+    return node, nil
+}
+
+// Creates a libp2p host and its DHT according to the Config, tied to the
+// given context. The host uses 'pstore' as its peerstore, or creates one
+// if it is nil.
+func (node *Node) newHost(ctx context.Context, config *Config,
+    pstore peerstore.Peerstore) (host.Host, *dht.IpfsDHT, PeerRouter, error) {
+
+    nodeOpts := []libp2p.Option{
+        libp2p.BandwidthReporter(node.bandwidth),
+        libp2p.UserAgent(agentString(config)),
+    }
+
+    if config.ObserverMode {
+        observerOpts, err := observerOpts(config)
+        if err != nil {
+            return nil, nil, nil, err
+        }
+        nodeOpts = append(nodeOpts, observerOpts...)
+    }
 
     // Set private key (for identity) if it exists
     if (config.PrivKey != nil) {
@@ -191,131 +765,298 @@ func NewNode(ctx context.Context, config Config) (Node, error) {
     if len(config.ListenAddrs) != 0 {
         listenAddrs, err := util.StringsToMultiaddrs(config.ListenAddrs)
         if err != nil {
-            return node, err
+            return nil, nil, nil, err
         }
 
         nodeOpts = append(nodeOpts, libp2p.ListenAddrs(listenAddrs...))
     }
 
+    announceOpt, err := announceOpts(config)
+    if err != nil {
+        return nil, nil, nil, err
+    } else if announceOpt != nil {
+        nodeOpts = append(nodeOpts, announceOpt)
+    }
+
     // Set pre-sharked key (for private network) if it exists
     if (config.PSK != nil) {
         log.Println("Pre-shared key detected, node will belong to a private network")
         nodeOpts = append(nodeOpts, libp2p.PrivateNetwork(config.PSK))
     }
 
+    // Enable circuit relay if requested
+    if config.EnableRelay || config.EnableRelayHop {
+        var relayOpts []circuit.RelayOpt
+        if config.EnableRelayHop {
+            log.Println("Relay hop enabled, node will relay traffic for other peers")
+            relayOpts = append(relayOpts, circuit.OptHop)
+        }
+        nodeOpts = append(nodeOpts, libp2p.EnableRelay(relayOpts...))
+    }
+    if config.EnableRelay {
+        nodeOpts = append(nodeOpts, libp2p.EnableAutoRelay())
+    }
+
+    nodeOpts = append(nodeOpts, autoNATOpts(config)...)
+
+    // Prune idle connections past the high watermark
+    if config.ConnMgrHighWater > 0 {
+        if config.ConnMgrLowWater > config.ConnMgrHighWater {
+            return nil, nil, nil, ErrConnMgrWatermarks
+        }
+
+        log.Printf("Connection manager enabled with watermarks %d-%d\n",
+            config.ConnMgrLowWater, config.ConnMgrHighWater)
+        connMgr := connmgr.NewConnManager(config.ConnMgrLowWater,
+            config.ConnMgrHighWater, config.ConnMgrGracePeriod)
+        nodeOpts = append(nodeOpts, libp2p.ConnectionManager(connMgr))
+    }
+
+    if pstore != nil {
+        nodeOpts = append(nodeOpts, libp2p.Peerstore(pstore))
+    } else if config.PeerstorePath != "" {
+        pstoreOpt, err := node.persistentPeerstore(config.PeerstorePath)
+        if err != nil {
+            return nil, nil, nil, err
+        }
+        nodeOpts = append(nodeOpts, pstoreOpt)
+    }
+
+    nodeOpts = append(nodeOpts, libp2p.ConnectionGater(node.Gater()))
+
+    tptOpts, err := transportOpts(config)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    nodeOpts = append(nodeOpts, tptOpts...)
+
+    secOpts, err := securityOpts(config)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    nodeOpts = append(nodeOpts, secOpts...)
+
+    muxOpts, err := muxerOpts(config)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    nodeOpts = append(nodeOpts, muxOpts...)
+
+    // Create a libp2p DHT instance alongside the host, so subsystems that
+    // depend on routing (e.g. AutoRelay) are able to use it
+    dhtOptions, err := dhtOpts(config)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    var kdht *dht.IpfsDHT
+    var router PeerRouter
+    newRouting := func(h host.Host) (routing.PeerRouting, error) {
+        log.Println("Creating DHT")
+        kdht, err = dht.New(ctx, h, dhtOptions...)
+        if err != nil {
+            return nil, err
+        }
+
+        chain, err := newPeerRouterChain(config.PeerRouters, kdht, node.mdnsPeers)
+        if err != nil {
+            return nil, err
+        }
+        router = chain
+        return &nodeRouting{chain, kdht}, nil
+    }
+    nodeOpts = append(nodeOpts, libp2p.Routing(newRouting))
+
     // Create a libp2p Host instance
     log.Println("Creating new p2p host")
-    node.Host, err = libp2p.New(node.Ctx, nodeOpts...)
+    newHost := libp2p.New
+    if config.HostConstructor != nil {
+        newHost = config.HostConstructor
+    }
+    h, err := newHost(ctx, nodeOpts...)
     if err != nil {
-        return node, err
+        return nil, nil, nil, err
     }
 
-    // Register Stream Handlers and corresponding Protocol IDs
-    if len(config.HandlerProtocolIDs) != len(config.StreamHandlers) {
-        return node, errors.New("StreamHandlers and HandlerProtocolIDs must map one-to-one")
+    // Hosts that ignore the options still need a DHT
+    if kdht == nil {
+        if _, err = newRouting(h); err != nil {
+            h.Close()
+            return nil, nil, nil, err
+        }
     }
-    log.Println("Setting stream handlers")
-    for i := range config.HandlerProtocolIDs {
-        if config.HandlerProtocolIDs[i] != "" && config.StreamHandlers[i] != nil {
-            node.Host.SetStreamHandler(config.HandlerProtocolIDs[i], config.StreamHandlers[i])
-        } else {
-            return node, errors.New("Cannot have empty StreamHandler/HandlerProtocolID element")
+    return h, kdht, router, nil
+}
+
+// Makes the host the Node's current one, and starts the services bound to
+// it. The host's context is cancelled once it is replaced. The previous
+// host's context is left to the caller, which can undo a failed start.
+func (node *Node) startHost(ctx context.Context, cancel context.CancelFunc,
+    h host.Host, kdht *dht.IpfsDHT, router PeerRouter, config *Config) error {
+
+    if node.usesPSK {
+        if err := verifyPrivateNetwork(h); err != nil {
+            return err
         }
     }
 
-    // Create a libp2p DHT instance
-    log.Println("Creating DHT")
-    node.DHT, err = dht.New(node.Ctx, node.Host, dht.Mode(dht.ModeServer))
-    if err != nil {
-        return node, err
+    node.core.setHostCtx(ctx, cancel)
+    node.core.setHost(h)
+    node.core.setDHT(kdht)
+    node.core.setRouter(router)
+    node.protections.attach(h)
+    node.protoStats.attach(h)
+    h.Network().Notify(&network.NotifyBundle{
+        ConnectedF: func(_ network.Network, conn network.Conn) {
+            node.dials.finished(conn.RemotePeer())
+            node.goodAddrs.connected(conn)
+        },
+    })
+    if node.staticPeers != nil {
+        node.staticPeers.attach(h)
+    }
+    if node.limits != nil {
+        node.limits.attach(h)
+    }
+    if node.reputation != nil {
+        node.reputation.Attach(h)
     }
 
-    // If bootstraps provided, ensure at least 1 must connect
-    // If none provided, no intention to connect to bootstraps, so move on
-    if len(config.BootstrapPeers) > 0 {
-        numConnected := 0
-        bootstrapAttempts := 0
-
-        // Connect to bootstrap nodes
-        // Perform exponential backoff until at least one successful connection,
-        // is made, up to MaxConnAttempts attempts
-        for numConnected == 0 && bootstrapAttempts < MaxConnAttempts {
-            // Perform simple exponential backoff
-            // TODO: Move this to helper function
-            if bootstrapAttempts > 0 {
-                sleepDuration := int(math.Pow(2, float64(bootstrapAttempts)))
-                for i := 0; i < sleepDuration; i++ {
-                    fmt.Printf("\rUnable to connect to any peers, retrying in %d seconds...     ", sleepDuration - i)
-                    time.Sleep(time.Second)
-                }
-                fmt.Println()
-            }
+    if err := node.startEvents(); err != nil {
+        return err
+    }
 
-            bootstrapAttempts++
+    if config.EnablePubSub {
+        if err := node.startPubSub(); err != nil {
+            return err
+        }
+        if err := node.watchWithdrawals(); err != nil {
+            return err
+        }
+    }
 
-            log.Println("Connecting to bootstrap nodes...")
-            var wg sync.WaitGroup
-            for _, peerAddr := range config.BootstrapPeers {
-                peerinfo, err := peer.AddrInfoFromP2pAddr(peerAddr)
-                if err != nil {
-                    return node, fmt.Errorf("ERROR: Unable to parse AddrInfo from %s\n%w\n", peerAddr, err)
-                }
+    if err := node.trackReachability(); err != nil {
+        return err
+    }
 
-                wg.Add(1)
-                go func(addr peer.AddrInfo) {
-                    defer wg.Done()
-                    if err := node.Host.Connect(node.Ctx, addr); err != nil {
-                        log.Println(err)
-                    } else {
-                        log.Println("Connected to bootstrap node:", addr)
-                    }
-                }(*peerinfo)
-            }
-            wg.Wait()
+    // Start local network discovery
+    if config.EnableMDNS {
+        log.Println("Starting mDNS discovery")
+        if err := node.startMDNS(config.MDNSServiceTag, config.MDNSInterval); err != nil {
+            return err
+        }
+    }
 
-            // Count only connections whose internal state is Connected
-            for _, peerID := range node.Host.Network().Peers() {
-                if node.Host.Network().Connectedness(peerID) == network.Connected {
-                    numConnected++
-                }
+    return nil
+}
+
+// Connects the Node to the network: connects to bootstraps, bootstraps the
+// DHT and starts advertising. Each stage is reported to 'progress' (if not
+// nil) once complete.
+func (node *Node) start(config *Config, progress func(StartupStage)) error {
+    var err error
+    if progress == nil {
+        progress = func(StartupStage) {}
+    }
+
+    // Add the bootstraps from the remote list, if any. A stale list is
+    // better than none, so only fail if there are no other bootstraps.
+    if node.bootstrapList != nil {
+        if _, err = node.refreshBootstrapList(node.Ctx); err != nil {
+            log.Printf("ERROR: Unable to fetch bootstrap list from %s\n%v\n",
+                config.BootstrapListURL, err)
+            if len(node.bootstrapSet.ids()) == 0 {
+                return err
             }
         }
+        node.watchBootstrapList(config.BootstrapListInterval)
+    }
 
-        if numConnected == 0 {
-            return node, errors.New("Failed to connect to any bootstraps")
+    // If bootstraps provided, ensure at least 1 must connect
+    // If none provided, no intention to connect to bootstraps, so move on
+    if bootstraps := node.bootstrapSet.list(); len(bootstraps) > 0 {
+        if err = node.connectBootstraps(bootstraps, config.BootstrapTimeout); err != nil {
+            return err
         }
-
-        log.Println("Connected to", numConnected, "peers!")
+        node.monitorBootstraps(config.BootstrapProbeInterval)
     } else {
         log.Println("No bootstraps provided, not connecting to any peers")
     }
+    if node.staticPeers != nil {
+        node.maintainStaticPeers(config.ReconnectPolicy)
+    }
+    if config.EnablePEX {
+        node.exchangePeers(config.PEXInterval)
+    }
+    if config.KeepAliveInterval > 0 {
+        node.keepAlive(config.KeepAliveInterval, node.keepAliveConns)
+    }
+    progress(StageBootstrapsConnected)
 
-    if err = node.DHT.Bootstrap(node.Ctx); err != nil {
-        return node, err
+    if err = node.DHT().Bootstrap(node.Ctx); err != nil {
+        return err
     }
+    progress(StageDHTBootstrapped)
 
-    // Create and register network callbacks. Use a disconnection notifier
-    // to monitor when bootstraps disconnect, and attempt to reconnect.
-    // Users can override or add any other callbacks they want, either
-    // directly to the NotifyBundle created here, or register their own.
-    netCBs := network.NotifyBundle{}
-    netCBs.DisconnectedF = ReconnectCB(&node, &config)
-    node.NetworkCallbacks = &netCBs
-    node.Host.Network().Notify(node.NetworkCallbacks)
+    if err = node.IdentityHistory().Verify(node.Host().ID()); err != nil {
+        log.Printf("WARNING: Identity history is not valid for %s\n%v\n",
+            node.Host().ID(), err)
+    }
+
+    // Register the network callbacks created with the Node
+    node.Host().Network().Notify(node.NetworkCallbacks())
+
+    // Create the Discovery used to advertise and find peers
+    log.Println("Creating Discovery")
+    disc, err := newDiscovery(config, node.Host(), node.DHT())
+    if err != nil {
+        return err
+    }
+    node.core.setDiscovery(disc)
 
-    // Create a libp2p Routing Discovery instance
-    log.Println("Creating Routing Discovery")
-    node.RoutingDiscovery = discovery.NewRoutingDiscovery(node.DHT)
-    for _, rendezvous := range config.Rendezvous {
-        if rendezvous != "" {
-            discovery.Advertise(node.Ctx, node.RoutingDiscovery, rendezvous)
-        } else {
-            return node, errors.New("Cannot have empty Rendezvous element")
+    if len(config.NTPServers) > 0 {
+        err = checkClock(node.Ctx, config.NTPServers, config.MaxClockSkew, config.ClockSkewPolicy)
+        if err != nil {
+            return err
         }
     }
 
+    // Resume any leases held before the last restart, along with the
+    // rendezvous strings provided in the config
+    rendezvous := config.Rendezvous
+    if config.StateFile != "" && !node.observer {
+        state, err := loadState(config.StateFile, node.stateCipher)
+        if err != nil {
+            return err
+        }
+        for _, record := range state.Leases {
+            log.Println("Resuming advertisement of", record.Rendezvous)
+            rendezvous = append(rendezvous, record.Rendezvous)
+        }
+    }
+    if _, err = node.AdvertiseBatch(rendezvous, config.AdvertiseBatchInterval); err != nil {
+        return err
+    }
+    progress(StageAdvertised)
+
     // node initialization finished
-    log.Println("Finished setting up libp2p Node with PID", node.Host.ID(),
-                "and Multiaddresses", node.Host.Addrs())
-    return node, nil
+    log.Println("Finished setting up libp2p Node with PID", node.Host().ID(),
+                "and Multiaddresses", node.Host().Addrs())
+    return nil
+}
+
+// Node constructor
+func NewNode(ctx context.Context, config Config) (Node, error) {
+    node, err := newNode(ctx, &config)
+    if err != nil {
+        return *node, err
+    }
+
+    err = node.start(&config, nil)
+    return *node, err
+}
+
+// Returns the Node's reputation tracker (see Config.Reputation), or nil if
+// it has none
+func (node *Node) Reputation() *reputation.Tracker {
+    return node.reputation
 }
@@ -0,0 +1,244 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "errors"
+    "io"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+    "github.com/libp2p/go-libp2p/p2p/protocol/ping"
+
+    msmux "github.com/multiformats/go-multistream"
+)
+
+const (
+    // Default interval between re-measuring the paths to a peer
+    DefaultPathEvalInterval = time.Minute
+
+    // How long to wait for a single path measurement
+    pathPingTimeout = 5 * time.Second
+
+    // How long to wait for a peer to agree on a protocol, if the context
+    // has no earlier deadline
+    pathNegotiateTimeout = 10 * time.Second
+)
+
+// Fastest known connection to a peer
+type bestPath struct {
+    conn        network.Conn
+    rtt         time.Duration
+    measured    time.Time
+}
+
+// Tracks the fastest connection to each peer that streams have been opened
+// to with NewStreamFastest(), and periodically re-measures them
+type pathSelector struct {
+    mutex       sync.Mutex
+    paths       map[peer.ID]bestPath
+    interval    time.Duration
+}
+
+func newPathSelector(interval time.Duration) *pathSelector {
+    if interval <= 0 {
+        interval = DefaultPathEvalInterval
+    }
+
+    return &pathSelector{
+        paths:      make(map[peer.ID]bestPath),
+        interval:   interval,
+    }
+}
+
+// Measures the RTT of a single connection by pinging over it
+func measureConn(ctx context.Context, conn network.Conn) (time.Duration, error) {
+    stream, err := conn.NewStream()
+    if err != nil {
+        return 0, err
+    }
+    defer stream.Reset()
+
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        deadline = time.Now().Add(pathPingTimeout)
+    }
+    stream.SetDeadline(deadline)
+
+    if err = msmux.SelectProtoOrFail(ping.ID, stream); err != nil {
+        return 0, err
+    }
+
+    buf := make([]byte, ping.PingSize)
+    if _, err = rand.Read(buf); err != nil {
+        return 0, err
+    }
+
+    start := time.Now()
+    if _, err = stream.Write(buf); err != nil {
+        return 0, err
+    }
+
+    rbuf := make([]byte, ping.PingSize)
+    if _, err = io.ReadFull(stream, rbuf); err != nil {
+        return 0, err
+    } else if !bytes.Equal(buf, rbuf) {
+        return 0, errors.New("Received incorrect ping response")
+    }
+
+    return time.Since(start), nil
+}
+
+// Measures every open connection to the peer, and records the fastest one
+func (node *Node) evaluatePaths(ctx context.Context, id peer.ID) (bestPath, error) {
+    var best bestPath
+    for _, conn := range node.Host().Network().ConnsToPeer(id) {
+        rtt, err := measureConn(ctx, conn)
+        if err != nil {
+            continue
+        }
+
+        if best.conn == nil || rtt < best.rtt {
+            best = bestPath{conn: conn, rtt: rtt, measured: time.Now()}
+        }
+    }
+
+    if best.conn == nil {
+        return best, errors.New("No measurable connection to peer")
+    }
+
+    node.paths.mutex.Lock()
+    node.paths.paths[id] = best
+    node.paths.mutex.Unlock()
+    return best, nil
+}
+
+// Returns true if the connection is still open
+func (node *Node) connOpen(conn network.Conn) bool {
+    for _, c := range node.Host().Network().ConnsToPeer(conn.RemotePeer()) {
+        if c == conn {
+            return true
+        }
+    }
+    return false
+}
+
+// Opens a new stream to the peer over the fastest of its open connections
+// (e.g. preferring a QUIC connection on the LAN over a relayed one). If the
+// peer isn't connected yet, a connection is made first. If no connection
+// can be measured, falls back to libp2p's default choice of connection.
+func (node *Node) NewStreamFastest(ctx context.Context, id peer.ID,
+    pids ...protocol.ID) (network.Stream, error) {
+
+    if len(pids) == 0 {
+        return nil, errors.New("Must provide at least one protocol ID")
+    }
+
+    if node.Host().Network().Connectedness(id) != network.Connected {
+        addrInfo := node.resolveAddrInfo(ctx, node.Host().Peerstore().PeerInfo(id))
+        if err := node.connect(ctx, addrInfo); err != nil {
+            return nil, err
+        }
+    }
+
+    node.paths.mutex.Lock()
+    best, ok := node.paths.paths[id]
+    node.paths.mutex.Unlock()
+
+    if !ok || !node.connOpen(best.conn) || time.Since(best.measured) > node.paths.interval {
+        var err error
+        if best, err = node.evaluatePaths(ctx, id); err != nil {
+            return node.Host().NewStream(ctx, id, pids...)
+        }
+    }
+
+    stream, err := best.conn.NewStream()
+    if err != nil {
+        return nil, err
+    }
+
+    protos := make([]string, len(pids))
+    for i, pid := range pids {
+        protos[i] = string(pid)
+    }
+
+    deadline := time.Now().Add(pathNegotiateTimeout)
+    if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+        deadline = d
+    }
+    stream.SetDeadline(deadline)
+
+    // Unblock negotiation if the context is cancelled before the deadline
+    negotiated := make(chan struct{})
+    go func() {
+        select {
+        case <-ctx.Done():
+            stream.Reset()
+        case <-negotiated:
+        }
+    }()
+
+    selected, err := msmux.SelectOneOf(protos, stream)
+    close(negotiated)
+    if err == nil {
+        err = ctx.Err()
+    }
+    if err != nil {
+        stream.Reset()
+        return nil, err
+    }
+    stream.SetProtocol(protocol.ID(selected))
+    stream.SetDeadline(time.Time{})
+
+    return stream, nil
+}
+
+// Background goroutine that periodically re-measures the paths to peers
+// tracked by NewStreamFastest(), and forgets disconnected peers
+func (node *Node) reevaluatePaths(ctx context.Context) {
+    ticker := time.NewTicker(node.paths.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            return
+        }
+
+        node.paths.mutex.Lock()
+        var ids []peer.ID
+        for id := range node.paths.paths {
+            if node.Host().Network().Connectedness(id) == network.Connected {
+                ids = append(ids, id)
+            } else {
+                delete(node.paths.paths, id)
+            }
+        }
+        node.paths.mutex.Unlock()
+
+        for _, id := range ids {
+            ctx, cancel := context.WithTimeout(node.Ctx, pathPingTimeout)
+            node.evaluatePaths(ctx, id)
+            cancel()
+        }
+    }
+}
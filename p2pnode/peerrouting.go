@@ -0,0 +1,262 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/routing"
+
+    "github.com/multiformats/go-multiaddr"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+const (
+    // Timeout for requests made by HTTP peer routers
+    HTTPPeerRouterTimeout = 10 * time.Second
+)
+
+// Source that can find the addresses of a peer by its ID. Routers given in
+// Config.PeerRouters are consulted in order by the Node's host whenever it
+// needs to dial a peer it has no addresses for, and by Node.FindPeer().
+type PeerRouter interface {
+    FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error)
+}
+
+// Placeholders for routers backed by the Node itself, which can be placed
+// in Config.PeerRouters to set their priority. The DHT is consulted last if
+// DHTPeerRouter is not given. MDNSPeerRouter requires Config.EnableMDNS.
+var (
+    DHTPeerRouter   PeerRouter = &placeholderRouter{"DHT"}
+    MDNSPeerRouter  PeerRouter = &placeholderRouter{"mDNS"}
+)
+
+type placeholderRouter struct {
+    name string
+}
+
+func (r *placeholderRouter) FindPeer(context.Context, peer.ID) (peer.AddrInfo, error) {
+    return peer.AddrInfo{}, fmt.Errorf("%s peer router used outside of a Node", r.name)
+}
+
+// Consults each router in order, returning the first peer found
+type peerRouterChain []PeerRouter
+
+func (chain peerRouterChain) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+    err := routing.ErrNotFound
+    for _, router := range chain {
+        ai, rerr := router.FindPeer(ctx, id)
+        if rerr == nil && len(ai.Addrs) > 0 {
+            return ai, nil
+        } else if rerr != nil && rerr != routing.ErrNotFound {
+            err = rerr
+        }
+
+        if ctx.Err() != nil {
+            return peer.AddrInfo{}, ctx.Err()
+        }
+    }
+    return peer.AddrInfo{}, err
+}
+
+// Routing handed to libp2p. Peers are found through the Node's chain of
+// peer routers, while content routing, which AutoRelay needs to discover
+// relays, goes to the DHT.
+type nodeRouting struct {
+    peerRouterChain
+    routing.ContentRouting
+}
+
+// Builds the router used by the Node, substituting its own routers for the
+// placeholders
+func newPeerRouterChain(routers []PeerRouter, kdht PeerRouter,
+    mdnsPeers *mdnsPeerRouter) (peerRouterChain, error) {
+
+    chain := make(peerRouterChain, 0, len(routers) + 1)
+    usesDHT := false
+    for _, router := range routers {
+        switch router {
+        case nil:
+            return nil, errors.New("Cannot have nil PeerRouter")
+        case DHTPeerRouter:
+            usesDHT = true
+            router = kdht
+        case MDNSPeerRouter:
+            if mdnsPeers == nil {
+                return nil, errors.New("MDNSPeerRouter requires EnableMDNS")
+            }
+            router = mdnsPeers
+        }
+        chain = append(chain, router)
+    }
+
+    if !usesDHT {
+        chain = append(chain, kdht)
+    }
+    return chain, nil
+}
+
+// Routes to a fixed set of peers
+type staticPeerRouter struct {
+    peers map[peer.ID]peer.AddrInfo
+}
+
+// Returns a router for the peers listed in a file, one multiaddr (ending in
+// /p2p/<peer ID>) per line. Blank lines and lines starting with '#' are
+// ignored. The file is only read once.
+func NewStaticPeerRouter(path string) (PeerRouter, error) {
+    path, err := util.ExpandTilde(path)
+    if err != nil {
+        return nil, err
+    }
+
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var addrs []multiaddr.Multiaddr
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        addr, err := multiaddr.NewMultiaddr(line)
+        if err != nil {
+            return nil, fmt.Errorf("Invalid multiaddr %s in %s: %w", line, path, err)
+        }
+        addrs = append(addrs, addr)
+    }
+    if err = scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+    if err != nil {
+        return nil, err
+    }
+
+    router := &staticPeerRouter{peers: make(map[peer.ID]peer.AddrInfo)}
+    for _, info := range infos {
+        router.peers[info.ID] = info
+    }
+    return router, nil
+}
+
+func (r *staticPeerRouter) FindPeer(_ context.Context, id peer.ID) (peer.AddrInfo, error) {
+    if ai, ok := r.peers[id]; ok {
+        return ai, nil
+    }
+    return peer.AddrInfo{}, routing.ErrNotFound
+}
+
+// Routes by querying a directory service over HTTP
+type httpPeerRouter struct {
+    baseURL string
+    client  *http.Client
+}
+
+// Returns a router that looks peers up at '<baseURL>/<peer ID>'. The
+// service should respond with a JSON-encoded peer.AddrInfo, or with 404
+// if the peer is unknown. A nil client uses one with HTTPPeerRouterTimeout.
+func NewHTTPPeerRouter(baseURL string, client *http.Client) PeerRouter {
+    if client == nil {
+        client = &http.Client{Timeout: HTTPPeerRouterTimeout}
+    }
+    return &httpPeerRouter{
+        baseURL:    strings.TrimSuffix(baseURL, "/"),
+        client:     client,
+    }
+}
+
+func (r *httpPeerRouter) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+    var ai peer.AddrInfo
+
+    req, err := http.NewRequest(http.MethodGet, r.baseURL + "/" + id.Pretty(), nil)
+    if err != nil {
+        return ai, err
+    }
+
+    resp, err := r.client.Do(req.WithContext(ctx))
+    if err != nil {
+        return ai, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusNotFound {
+        return ai, routing.ErrNotFound
+    } else if resp.StatusCode != http.StatusOK {
+        return ai, fmt.Errorf("Peer directory responded with %s", resp.Status)
+    }
+
+    if err = json.NewDecoder(resp.Body).Decode(&ai); err != nil {
+        return ai, err
+    } else if ai.ID != id {
+        return peer.AddrInfo{}, fmt.Errorf("Peer directory returned %s for %s", ai.ID, id)
+    }
+    return ai, nil
+}
+
+// Routes to peers found over mDNS
+type mdnsPeerRouter struct {
+    mutex sync.RWMutex
+    peers map[peer.ID]peer.AddrInfo
+}
+
+func newMDNSPeerRouter() *mdnsPeerRouter {
+    return &mdnsPeerRouter{peers: make(map[peer.ID]peer.AddrInfo)}
+}
+
+func (r *mdnsPeerRouter) found(ai peer.AddrInfo) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    r.peers[ai.ID] = ai
+}
+
+func (r *mdnsPeerRouter) FindPeer(_ context.Context, id peer.ID) (peer.AddrInfo, error) {
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+    if ai, ok := r.peers[id]; ok {
+        return ai, nil
+    }
+    return peer.AddrInfo{}, routing.ErrNotFound
+}
+
+// Looks up the addresses of a peer, first in the peerstore and then through
+// the Node's peer routers
+func (node *Node) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+    if ai := node.Host().Peerstore().PeerInfo(id); len(ai.Addrs) > 0 {
+        return ai, nil
+    }
+    router := node.core.getRouter()
+    if router == nil {
+        return peer.AddrInfo{}, routing.ErrNotFound
+    }
+    return router.FindPeer(ctx, id)
+}
@@ -0,0 +1,44 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/routing"
+)
+
+func TestNodeRoutingRelay(test *testing.T) {
+    var _ routing.ContentRouting = &nodeRouting{}
+
+    edge := NewEdgeConfig()
+    edge.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+    relay := NewTestConfig()
+    relay.EnableRelay = true
+
+    // AutoRelay requires the routing given to libp2p to route content
+    for name, config := range map[string]Config{"EnableRelay": relay, "Edge": edge} {
+        test.Run(name, func(test *testing.T) {
+            node, err := NewNode(context.Background(), config)
+            if node.Close != nil {
+                defer node.Close()
+            }
+            if err != nil {
+                test.Fatalf("NewNode() failed:\n%v", err)
+            }
+        })
+    }
+}
@@ -0,0 +1,66 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "log"
+    "os"
+
+    ds "github.com/ipfs/go-datastore"
+    leveldb "github.com/ipfs/go-ds-leveldb"
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p-peerstore/pstoreds"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+// Returns a libp2p option that backs the host's peerstore with a LevelDB
+// datastore at the given path, so known peers and their addresses survive
+// restarts. The datastore is closed once the Node's context is cancelled.
+func (node *Node) persistentPeerstore(path string) (libp2p.Option, error) {
+    path, err := util.ExpandTilde(path)
+    if err != nil {
+        return nil, err
+    }
+
+    if err = os.MkdirAll(path, 0700); err != nil {
+        return nil, err
+    }
+
+    var store ds.Batching
+    store, err = leveldb.NewDatastore(path, nil)
+    if err != nil {
+        return nil, err
+    }
+    if node.stateCipher != nil {
+        store = newEncryptedDatastore(store, node.stateCipher)
+    }
+
+    pstore, err := pstoreds.NewPeerstore(node.Ctx, store, pstoreds.DefaultOpts())
+    if err != nil {
+        store.Close()
+        return nil, err
+    }
+
+    log.Println("Using persistent peerstore at", path)
+    node.spawn("peerstore-close", func(ctx context.Context) {
+        <-ctx.Done()
+        pstore.Close()
+        store.Close()
+    })
+
+    return libp2p.Peerstore(pstore), nil
+}
@@ -0,0 +1,325 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "math/rand"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+const (
+    PEXProtocolID = protocol.ID("/mtc/pex/1.0")
+
+    // Default interval between rounds of peer exchange
+    DefaultPEXInterval = 5 * time.Minute
+
+    // Default cap on the peers sent in a single exchange
+    DefaultPEXMaxPeers = 16
+
+    // Default number of connections below which peers learned through
+    // peer exchange are dialed
+    DefaultPEXMaxConns = 8
+
+    // Number of connected peers swapped with each round
+    PEXFanout = 3
+
+    // Timeout for a single exchange made in the background
+    PEXTimeout = 30 * time.Second
+
+    // How long addresses learned through peer exchange are kept
+    PEXAddrTTL = 30 * time.Minute
+
+    // Exchanges issued longer ago than this are rejected as replays
+    maxPEXAge = 5 * time.Minute
+
+    // Upper bound on the size of a peer exchange
+    maxPEXBytes = 64 * 1024
+)
+
+var (
+    // Returned when a peer exchange is not signed by the peer that sent it
+    ErrPEXSignature = errors.New("Invalid peer exchange signature")
+
+    // Returned when a peer exchange is too old, e.g. if one is replayed
+    ErrPEXStale = errors.New("Peer exchange is too old")
+)
+
+// A known peer, as sent in a PeerExchange
+type PEXPeer struct {
+    ID          peer.ID     `json:"id"`
+    Addrs       []string    `json:"addrs"`
+}
+
+// Signed list of peers a node is connected to, swapped with other nodes
+// over PEXProtocolID (see Config.EnablePEX)
+type PeerExchange struct {
+    Peers       []PEXPeer   `json:"peers"`
+    Issued      time.Time   `json:"issued"`
+    Signature   []byte      `json:"signature,omitempty"`
+}
+
+// Portion of a PeerExchange covered by its signature
+type peerExchangeBody struct {
+    Peers       []PEXPeer   `json:"peers"`
+    Issued      time.Time   `json:"issued"`
+}
+
+// Verifies that the exchange was signed with the key and is recent, and
+// returns its peers
+func (pex PeerExchange) Verify(pub crypto.PubKey) ([]peer.AddrInfo, error) {
+    ok, err := util.VerifyJSON(pub, peerExchangeBody{pex.Peers, pex.Issued}, pex.Signature)
+    if err != nil {
+        return nil, err
+    } else if !ok {
+        return nil, ErrPEXSignature
+    }
+    if time.Since(pex.Issued) > maxPEXAge {
+        return nil, ErrPEXStale
+    }
+
+    infos := make([]peer.AddrInfo, 0, len(pex.Peers))
+    for _, p := range pex.Peers {
+        addrs, err := util.StringsToMultiaddrs(p.Addrs)
+        if err != nil {
+            return nil, err
+        }
+        infos = append(infos, peer.AddrInfo{ID: p.ID, Addrs: addrs})
+    }
+    return infos, nil
+}
+
+// Returns a signed exchange of up to 'max' peers the Node is connected to,
+// leaving out 'exclude' (the peer it is sent to)
+func (node *Node) peerExchange(exclude peer.ID, max int) (PeerExchange, error) {
+    h := node.Host()
+    pex := PeerExchange{Issued: time.Now().UTC().Round(0)}
+
+    for _, id := range shufflePeers(h.Network().Peers()) {
+        if len(pex.Peers) >= max {
+            break
+        }
+        if id == exclude || h.Network().Connectedness(id) != network.Connected {
+            continue
+        }
+        if node.reputation != nil && !node.reputation.Acceptable(id) {
+            continue
+        }
+        addrs := h.Peerstore().Addrs(id)
+        if len(addrs) == 0 {
+            continue
+        }
+        p := PEXPeer{ID: id}
+        for _, addr := range addrs {
+            p.Addrs = append(p.Addrs, addr.String())
+        }
+        pex.Peers = append(pex.Peers, p)
+    }
+
+    sig, err := util.SignJSON(h.Peerstore().PrivKey(h.ID()), peerExchangeBody{pex.Peers, pex.Issued})
+    if err != nil {
+        return pex, err
+    }
+    pex.Signature = sig
+    return pex, nil
+}
+
+// Reads and verifies the exchange sent by the other end of the stream
+func readPeerExchange(stream network.Stream, max int) ([]peer.AddrInfo, error) {
+    var pex PeerExchange
+    err := json.NewDecoder(io.LimitReader(stream, maxPEXBytes)).Decode(&pex)
+    if err != nil {
+        return nil, err
+    }
+    infos, err := pex.Verify(stream.Conn().RemotePublicKey())
+    if err != nil {
+        return nil, err
+    }
+    if len(infos) > max {
+        infos = infos[:max]
+    }
+    return infos, nil
+}
+
+// Remembers the learned peers' addresses, and dials those the Node is not
+// connected to while it has fewer than PEXMaxConns connections
+func (node *Node) mergePeerExchange(ctx context.Context, infos []peer.AddrInfo) {
+    max := node.pexMaxConns()
+    h := node.Host()
+    for _, info := range infos {
+        if info.ID == h.ID() {
+            continue
+        }
+        h.Peerstore().AddAddrs(info.ID, info.Addrs, PEXAddrTTL)
+    }
+
+    for _, info := range infos {
+        if len(h.Network().Peers()) >= max {
+            return
+        }
+        if info.ID == h.ID() || h.Network().Connectedness(info.ID) == network.Connected {
+            continue
+        }
+        if err := node.connect(ctx, info); err != nil {
+            log.Println(err)
+        } else {
+            log.Println("Connected to peer learned through peer exchange:", info.ID)
+        }
+    }
+}
+
+// Answers peer exchanges with the Node's own
+func (node *Node) pexHandler(stream network.Stream) {
+    // Don't let a peer hold the handler open by never finishing its exchange
+    stream.SetDeadline(time.Now().Add(PEXTimeout))
+
+    max := node.pexMaxPeers()
+    infos, err := readPeerExchange(stream, max)
+    if err != nil {
+        log.Printf("ERROR: Invalid peer exchange from %s\n%v\n", stream.Conn().RemotePeer(), err)
+        if node.reputation != nil {
+            node.reputation.RecordProtocolError(stream.Conn().RemotePeer())
+        }
+        stream.Reset()
+        return
+    }
+
+    pex, err := node.peerExchange(stream.Conn().RemotePeer(), max)
+    if err == nil {
+        err = json.NewEncoder(stream).Encode(pex)
+    }
+    if err != nil {
+        stream.Reset()
+        return
+    }
+    stream.Close()
+
+    node.spawn("pex-merge", func(ctx context.Context) {
+        node.mergePeerExchange(ctx, infos)
+    })
+}
+
+// Swaps known peers with a peer serving PEXProtocolID (see Config.EnablePEX),
+// returning the peers it sent. The peers' addresses are added to the
+// peerstore, but they are not dialed.
+func (node *Node) ExchangePeers(ctx context.Context, id peer.ID) ([]peer.AddrInfo, error) {
+    max := node.pexMaxPeers()
+    pex, err := node.peerExchange(id, max)
+    if err != nil {
+        return nil, err
+    }
+
+    stream, err := node.Host().NewStream(ctx, id, PEXProtocolID)
+    if err != nil {
+        return nil, err
+    }
+    defer stream.Close()
+
+    if deadline, ok := ctx.Deadline(); ok {
+        stream.SetDeadline(deadline)
+    }
+    if err = json.NewEncoder(stream).Encode(pex); err != nil {
+        stream.Reset()
+        return nil, err
+    }
+    infos, err := readPeerExchange(stream, max)
+    if err != nil {
+        stream.Reset()
+        return nil, err
+    }
+
+    for _, info := range infos {
+        if info.ID != node.Host().ID() {
+            node.Host().Peerstore().AddAddrs(info.ID, info.Addrs, PEXAddrTTL)
+        }
+    }
+    return infos, nil
+}
+
+// Swaps peers with up to PEXFanout random connected peers every 'interval'
+// until the Node is done, dialing those learned while under the cap
+func (node *Node) exchangePeers(interval time.Duration) {
+    if interval <= 0 {
+        interval = DefaultPEXInterval
+    }
+
+    node.spawn("pex", func(ctx context.Context) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ticker.C:
+            case <-ctx.Done():
+                return
+            }
+
+            h := node.Host()
+            swapped := 0
+            for _, id := range shufflePeers(h.Network().Peers()) {
+                if swapped >= PEXFanout {
+                    break
+                }
+                if ok, _ := h.Peerstore().SupportsProtocols(id, string(PEXProtocolID)); len(ok) == 0 {
+                    continue
+                }
+                swapped++
+
+                exCtx, cancel := context.WithTimeout(ctx, PEXTimeout)
+                infos, err := node.ExchangePeers(exCtx, id)
+                cancel()
+                if err != nil {
+                    log.Printf("ERROR: Peer exchange with %s failed\n%v\n", id, err)
+                    continue
+                }
+                node.mergePeerExchange(ctx, infos)
+            }
+        }
+    })
+}
+
+func (node *Node) pexMaxPeers() int {
+    if node.config.PEXMaxPeers > 0 {
+        return node.config.PEXMaxPeers
+    }
+    return DefaultPEXMaxPeers
+}
+
+func (node *Node) pexMaxConns() int {
+    if node.config.PEXMaxConns > 0 {
+        return node.config.PEXMaxConns
+    }
+    return DefaultPEXMaxConns
+}
+
+// Returns a shuffled copy of the peers
+func shufflePeers(ids []peer.ID) []peer.ID {
+    shuffled := append([]peer.ID(nil), ids...)
+    rand.Shuffle(len(shuffled), func(i, j int) {
+        shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+    })
+    return shuffled
+}
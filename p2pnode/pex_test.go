@@ -0,0 +1,66 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+func TestPeerExchangeVerify(test *testing.T) {
+    priv, pub, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+    if err != nil {
+        test.Fatalf("Unable to generate key:\n%v", err)
+    }
+    _, otherPub, _ := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+
+    sign := func(pex PeerExchange) PeerExchange {
+        pex.Signature, err = util.SignJSON(priv, peerExchangeBody{pex.Peers, pex.Issued})
+        if err != nil {
+            test.Fatalf("Unable to sign peer exchange:\n%v", err)
+        }
+        return pex
+    }
+
+    pex := sign(PeerExchange{
+        Peers:  []PEXPeer{{Addrs: []string{"/ip4/10.0.0.1/tcp/4001"}}},
+        Issued: time.Now().UTC().Round(0),
+    })
+
+    infos, err := pex.Verify(pub)
+    if err != nil || len(infos) != 1 || len(infos[0].Addrs) != 1 {
+        test.Errorf("Verify() returned %v, %v; expected one peer", infos, err)
+    }
+
+    if _, err = pex.Verify(otherPub); !errors.Is(err, ErrPEXSignature) {
+        test.Errorf("Verify() with the wrong key returned %v, expected ErrPEXSignature", err)
+    }
+
+    tampered := pex
+    tampered.Peers = append(tampered.Peers, PEXPeer{Addrs: []string{"/ip4/10.0.0.2/tcp/4001"}})
+    if _, err = tampered.Verify(pub); !errors.Is(err, ErrPEXSignature) {
+        test.Errorf("Verify() of tampered exchange returned %v, expected ErrPEXSignature", err)
+    }
+
+    stale := sign(PeerExchange{Issued: time.Now().Add(-2 * maxPEXAge).UTC().Round(0)})
+    if _, err = stale.Verify(pub); !errors.Is(err, ErrPEXStale) {
+        test.Errorf("Verify() of stale exchange returned %v, expected ErrPEXStale", err)
+    }
+}
@@ -0,0 +1,90 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "errors"
+    "fmt"
+    "log"
+
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/pnet"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+var (
+    // Returned by NewNode when a private network is required (see
+    // Config.ForcePrivateNetwork) but no PSK was given
+    ErrPrivateNetworkRequired = errors.New("Private network required, but no PSK was provided")
+
+    // Returned for transports and addresses whose connections bypass the PSK
+    ErrPSKUnsupported = errors.New("Does not support private networks (PSK)")
+)
+
+// Returns true if a private network is required by the Config, or by the
+// LIBP2P_FORCE_PNET environment variable
+func privateNetworkRequired(config *Config) bool {
+    return config.ForcePrivateNetwork || pnet.ForcePrivateNetwork
+}
+
+// Checks that the Config joins a private network if it is required to
+func checkPrivateNetwork(config *Config) error {
+    if len(config.PSK) == 0 {
+        if privateNetworkRequired(config) {
+            return ErrPrivateNetworkRequired
+        }
+        return nil
+    }
+
+    if len(config.PSK) != 32 {
+        return fmt.Errorf("PSK must be 32 bytes, got %d", len(config.PSK))
+    }
+    return nil
+}
+
+// Returns true if connections over the address are protected by the PSK.
+// QUIC has its own handshake, which bypasses it.
+func honorsPSK(addr multiaddr.Multiaddr) bool {
+    _, err := addr.ValueForProtocol(multiaddr.P_QUIC)
+    return err != nil
+}
+
+// Verifies that every address the host listens on protects connections
+// with the PSK, and closes any connection that somehow does not
+func verifyPrivateNetwork(h host.Host) error {
+    for _, addr := range h.Network().ListenAddresses() {
+        if !honorsPSK(addr) {
+            return fmt.Errorf("Listen address %s: %w", addr, ErrPSKUnsupported)
+        }
+    }
+
+    h.Network().Notify(&network.NotifyBundle{
+        ConnectedF: func(_ network.Network, conn network.Conn) {
+            if !honorsPSK(conn.RemoteMultiaddr()) {
+                log.Printf("ERROR: Closing connection to %s over %s, which bypasses the PSK\n",
+                    conn.RemotePeer(), conn.RemoteMultiaddr())
+                conn.Close()
+            }
+        },
+    })
+    return nil
+}
+
+// Returns true if the Node is part of a private network
+func (node *Node) PrivateNetwork() bool {
+    return node.usesPSK
+}
@@ -0,0 +1,103 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "time"
+)
+
+// Preset Config constructors for common node roles. Each returns a Config
+// with recommended settings for the role, which callers then extend with
+// their own keys, bootstraps, handlers, rendezvous, etc. The echo responder
+// lets any peer make the node send traffic, so only the test preset
+// enables it. QUIC does not support private networks, so remove
+// TransportQUIC and its listen addresses before setting a PSK.
+
+// Config for nodes at the edge of the network, which are likely behind NAT
+// and resource-constrained. Peers on the same LAN are found over mDNS, ports
+// are mapped on the local router where possible, and the DHT is used as a
+// client only, so the node doesn't serve DHT queries. Edge nodes come and
+// go, so they give up on unreachable bootstraps quickly rather than
+// retrying in the background forever.
+func NewEdgeConfig() Config {
+    config := NewConfig()
+    config.Transports = []string{TransportTCP, TransportQUIC}
+    config.ListenAddrs = []string{"/ip4/0.0.0.0/tcp/0", "/ip4/0.0.0.0/udp/0/quic"}
+    config.ReconnectPolicy = ReconnectPolicy{
+        MaxAttempts:    5,
+        InitialBackoff: time.Second,
+        MaxBackoff:     30 * time.Second,
+    }
+    config.EnableMDNS = true
+    config.EnableRelay = true
+    config.EnableNATPortMap = true
+    config.DHTClientMode = true
+    config.ConnMgrLowWater = 50
+    config.ConnMgrHighWater = 100
+    config.ConnMgrGracePeriod = time.Minute
+    return config
+}
+
+// Config for publicly reachable service nodes, listening on well-known
+// ports and retrying their bootstraps forever
+func NewServerConfig() Config {
+    config := NewConfig()
+    config.Transports = []string{TransportTCP, TransportQUIC}
+    config.ListenAddrs = []string{"/ip4/0.0.0.0/tcp/4001", "/ip4/0.0.0.0/udp/4001/quic"}
+    config.ReconnectPolicy = ReconnectPolicy{
+        InitialBackoff: 2 * time.Second,
+        MaxBackoff:     2 * time.Minute,
+    }
+    config.EnableAutoNATService = true
+    config.EnableStatus = true
+    config.ConnMgrLowWater = 400
+    config.ConnMgrHighWater = 600
+    config.ConnMgrGracePeriod = time.Minute
+    return config
+}
+
+// Config for dedicated bootstrap nodes, which accept many connections and
+// relay traffic for NAT'd peers. WebSocket is accepted too, so that
+// clients limited to it (e.g. behind HTTP proxies) can join the network.
+// Connections to other bootstraps are retried forever.
+func NewBootstrapConfig() Config {
+    config := NewConfig()
+    config.Transports = []string{TransportTCP, TransportQUIC, TransportWS}
+    config.ListenAddrs = []string{
+        "/ip4/0.0.0.0/tcp/4001",
+        "/ip4/0.0.0.0/udp/4001/quic",
+        "/ip4/0.0.0.0/tcp/4002/ws",
+    }
+    config.ReconnectPolicy = ReconnectPolicy{
+        InitialBackoff: 2 * time.Second,
+        MaxBackoff:     time.Minute,
+    }
+    config.EnableAutoNATService = true
+    config.EnableRelayHop = true
+    config.EnableStatus = true
+    config.ConnMgrLowWater = 1000
+    config.ConnMgrHighWater = 2000
+    config.ConnMgrGracePeriod = 5 * time.Minute
+    return config
+}
+
+// Config for nodes in tests, listening only on the loopback interface
+// at a random port
+func NewTestConfig() Config {
+    config := NewConfig()
+    config.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+    config.EnableEcho = true
+    return config
+}
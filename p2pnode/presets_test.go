@@ -0,0 +1,73 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestPresets(test *testing.T) {
+    presets := map[string]Config{
+        "Edge":      NewEdgeConfig(),
+        "Server":    NewServerConfig(),
+        "Bootstrap": NewBootstrapConfig(),
+        "Test":      NewTestConfig(),
+    }
+
+    // Whether each role should give up on unreachable bootstraps
+    givesUp := map[string]bool{
+        "Edge":      true,
+        "Server":    false,
+        "Bootstrap": false,
+    }
+
+    for name, config := range presets {
+        test.Run(name, func(test *testing.T) {
+            if config.EnableEcho && name != "Test" {
+                test.Errorf("%s preset enables the echo responder", name)
+            }
+
+            if expected, ok := givesUp[name]; ok {
+                if len(config.Transports) == 0 {
+                    test.Errorf("%s preset does not set Transports", name)
+                }
+                if _, err := transportOpts(&config); err != nil {
+                    test.Errorf("%s preset Transports don't match its ListenAddrs:\n%v", name, err)
+                }
+                if config.ReconnectPolicy.InitialBackoff == 0 {
+                    test.Errorf("%s preset does not set ReconnectPolicy", name)
+                }
+                if (config.ReconnectPolicy.MaxAttempts > 0) != expected {
+                    test.Errorf("%s preset ReconnectPolicy has MaxAttempts %d",
+                                name, config.ReconnectPolicy.MaxAttempts)
+                }
+            }
+
+            ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+            defer cancel()
+
+            config.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+            node, err := NewNode(ctx, config)
+            if node.Close != nil {
+                defer node.Close()
+            }
+            if err != nil {
+                test.Fatalf("NewNode() with the %s preset failed:\n%v", name, err)
+            }
+        })
+    }
+}
@@ -0,0 +1,168 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "log"
+    "net"
+    "net/http"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+    // Namespace prefixed to all metric names
+    MetricsNamespace = "physarumsm"
+
+    // Path the metrics are served on
+    MetricsPath = "/metrics"
+)
+
+// Prometheus collectors for a Node. Each Node has its own registry, so
+// multiple Nodes can coexist in one process (e.g. in tests).
+type nodeMetrics struct {
+    registry            *prometheus.Registry
+    reconnectAttempts   prometheus.Counter
+    advertiseRefreshes  prometheus.Counter
+    dialFailures        *prometheus.CounterVec
+    limitRejections     *prometheus.CounterVec
+}
+
+func newNodeMetrics(node *Node) *nodeMetrics {
+    m := &nodeMetrics{
+        registry: prometheus.NewRegistry(),
+        reconnectAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+            Namespace:  MetricsNamespace,
+            Name:       "reconnect_attempts_total",
+            Help:       "Number of attempts to reconnect to lost bootstraps",
+        }),
+        advertiseRefreshes: prometheus.NewCounter(prometheus.CounterOpts{
+            Namespace:  MetricsNamespace,
+            Name:       "advertise_refreshes_total",
+            Help:       "Number of successful advertisement renewals",
+        }),
+        dialFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace:  MetricsNamespace,
+            Name:       "dial_failures_total",
+            Help:       "Number of failed dials made by the node, by class of failure",
+        }, []string{"class"}),
+        limitRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace:  MetricsNamespace,
+            Name:       "limit_rejections_total",
+            Help:       "Number of inbound streams and connections refused for exceeding resource limits",
+        }, []string{"resource"}),
+    }
+
+    peers := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Namespace:  MetricsNamespace,
+        Name:       "peers",
+        Help:       "Number of connected peers",
+    }, func() float64 {
+        if node.Host() == nil {
+            return 0
+        }
+        return float64(len(node.Host().Network().Peers()))
+    })
+
+    streams := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Namespace:  MetricsNamespace,
+        Name:       "streams",
+        Help:       "Number of open streams",
+    }, func() float64 {
+        if node.Host() == nil {
+            return 0
+        }
+        count := 0
+        for _, conn := range node.Host().Network().Conns() {
+            count += len(conn.GetStreams())
+        }
+        return float64(count)
+    })
+
+    routingTable := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Namespace:  MetricsNamespace,
+        Name:       "dht_routing_table_size",
+        Help:       "Number of peers in the DHT routing table",
+    }, func() float64 {
+        if node.DHT() == nil {
+            return 0
+        }
+        return float64(node.DHT().RoutingTable().Size())
+    })
+
+    m.registry.MustRegister(m.reconnectAttempts, m.advertiseRefreshes, m.dialFailures,
+        m.limitRejections, peers, streams, routingTable, &goroutineCollector{node: node})
+    return m
+}
+
+var goroutinesDesc = prometheus.NewDesc(
+    prometheus.BuildFQName(MetricsNamespace, "", "goroutines"),
+    "Number of goroutines run by the node, by task",
+    []string{"task"}, nil,
+)
+
+// Reports the Node's goroutine counts, whose tasks aren't known in advance
+type goroutineCollector struct {
+    node *Node
+}
+
+func (gc *goroutineCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- goroutinesDesc
+}
+
+func (gc *goroutineCollector) Collect(ch chan<- prometheus.Metric) {
+    for task, count := range gc.node.Goroutines() {
+        ch <- prometheus.MustNewConstMetric(goroutinesDesc,
+            prometheus.GaugeValue, float64(count), task)
+    }
+}
+
+// Returns an HTTP handler serving the Node's metrics in the Prometheus
+// exposition format, for services that want to mount it on their own server
+func (node *Node) MetricsHandler() http.Handler {
+    return promhttp.HandlerFor(node.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// Serves the Node's metrics on MetricsPath at the given address until the
+// Node's context is cancelled
+func (node *Node) serveMetrics(addr string) error {
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+
+    mux := http.NewServeMux()
+    mux.Handle(MetricsPath, node.MetricsHandler())
+    server := &http.Server{Handler: mux}
+
+    node.spawn("metrics-shutdown", func(ctx context.Context) {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+        defer cancel()
+        server.Shutdown(shutdownCtx)
+    })
+
+    node.spawn("metrics-server", func(context.Context) {
+        if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+            log.Printf("ERROR: Metrics server stopped\n%v\n", err)
+        }
+    })
+
+    log.Printf("Serving metrics on http://%s%s\n", listener.Addr(), MetricsPath)
+    return nil
+}
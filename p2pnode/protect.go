@@ -0,0 +1,162 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "sync"
+
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    // Connection manager tag protecting connections to bootstraps
+    BootstrapPeerTag = "bootstrap"
+
+    // Connection manager tag protecting connections to peers while one of
+    // the Node's stream handlers is serving them
+    SessionPeerTag = "session"
+)
+
+// Connection manager protections held by the Node. They are kept here as
+// well as in the connection manager, so they carry over to the new host
+// when the Node's identity is rotated.
+type peerProtections struct {
+    mutex       sync.Mutex
+    tags        map[peer.ID]map[string]bool
+    // Number of handlers serving each peer (see SessionPeerTag)
+    sessions    map[peer.ID]int
+}
+
+func newPeerProtections() *peerProtections {
+    return &peerProtections{
+        tags:       make(map[peer.ID]map[string]bool),
+        sessions:   make(map[peer.ID]int),
+    }
+}
+
+func (pp *peerProtections) protect(h host.Host, id peer.ID, tag string) {
+    pp.mutex.Lock()
+    defer pp.mutex.Unlock()
+
+    if pp.tags[id] == nil {
+        pp.tags[id] = make(map[string]bool)
+    }
+    pp.tags[id][tag] = true
+    if h != nil {
+        h.ConnManager().Protect(id, tag)
+    }
+}
+
+// Returns true if the peer is still protected by other tags
+func (pp *peerProtections) unprotect(h host.Host, id peer.ID, tag string) bool {
+    pp.mutex.Lock()
+    defer pp.mutex.Unlock()
+
+    delete(pp.tags[id], tag)
+    if len(pp.tags[id]) == 0 {
+        delete(pp.tags, id)
+    }
+    if h != nil {
+        h.ConnManager().Unprotect(id, tag)
+    }
+    return len(pp.tags[id]) > 0
+}
+
+func (pp *peerProtections) isProtected(id peer.ID, tag string) bool {
+    pp.mutex.Lock()
+    defer pp.mutex.Unlock()
+
+    if tag == "" {
+        return len(pp.tags[id]) > 0
+    }
+    return pp.tags[id][tag]
+}
+
+// Protects the peer with SessionPeerTag until the returned function is
+// called. Sessions are counted, so the tag is only removed once the last
+// one ends. 'current' returns the host at the time, which may change while
+// the session lasts.
+func (pp *peerProtections) hold(current func() host.Host, id peer.ID) func() {
+    pp.mutex.Lock()
+    pp.sessions[id]++
+    first := pp.sessions[id] == 1
+    pp.mutex.Unlock()
+    if first {
+        pp.protect(current(), id, SessionPeerTag)
+    }
+
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            pp.mutex.Lock()
+            pp.sessions[id]--
+            last := pp.sessions[id] == 0
+            if last {
+                delete(pp.sessions, id)
+            }
+            pp.mutex.Unlock()
+            if last {
+                pp.unprotect(current(), id, SessionPeerTag)
+            }
+        })
+    }
+}
+
+// Applies every protection to the host's connection manager
+func (pp *peerProtections) attach(h host.Host) {
+    pp.mutex.Lock()
+    defer pp.mutex.Unlock()
+
+    for id, tags := range pp.tags {
+        for tag := range tags {
+            h.ConnManager().Protect(id, tag)
+        }
+    }
+}
+
+// Protects connections to the peer from being pruned by the connection
+// manager (see Config.ConnMgrHighWater) until UnprotectPeer() is called
+// with the same tag. Like the connection manager's, protections are not
+// counted: one call to UnprotectPeer() revokes any number of calls to
+// ProtectPeer() with the same tag. Bootstraps (BootstrapPeerTag), static
+// peers (StaticPeerTag) and peers being served by a stream handler
+// (SessionPeerTag) are protected automatically.
+func (node *Node) ProtectPeer(id peer.ID, tag string) {
+    node.protections.protect(node.Host(), id, tag)
+}
+
+// Removes the protection added with the tag, returning true if the peer
+// is still protected by other tags
+func (node *Node) UnprotectPeer(id peer.ID, tag string) bool {
+    return node.protections.unprotect(node.Host(), id, tag)
+}
+
+// Returns true if the peer is protected with the tag, or with any tag if
+// 'tag' is empty
+func (node *Node) IsProtected(id peer.ID, tag string) bool {
+    return node.protections.isProtected(id, tag)
+}
+
+// Wraps the handler to protect the remote peer while it is being served.
+// Handlers that keep using the stream after returning should protect the
+// peer themselves.
+func (node *Node) protectSession(handler network.StreamHandler) network.StreamHandler {
+    return func(stream network.Stream) {
+        defer node.protections.hold(node.Host, stream.Conn().RemotePeer())()
+        handler(stream)
+    }
+}
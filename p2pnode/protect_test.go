@@ -0,0 +1,54 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestPeerProtections(test *testing.T) {
+    pp := newPeerProtections()
+    info, err := peer.AddrInfoFromP2pAddr(newBootstrapAddr(test))
+    if err != nil {
+        test.Fatalf("Unable to parse AddrInfo:\n%v", err)
+    }
+    id := info.ID
+    noHost := func() host.Host { return nil }
+
+    pp.protect(nil, id, BootstrapPeerTag)
+    pp.protect(nil, id, "custom")
+    if !pp.unprotect(nil, id, "custom") {
+        test.Errorf("unprotect() should report the peer is still protected by %s", BootstrapPeerTag)
+    }
+    if pp.unprotect(nil, id, BootstrapPeerTag) || pp.isProtected(id, "") {
+        test.Errorf("Peer should no longer be protected")
+    }
+
+    // Sessions are counted, unlike tags
+    first := pp.hold(noHost, id)
+    second := pp.hold(noHost, id)
+    first()
+    first()
+    if !pp.isProtected(id, SessionPeerTag) {
+        test.Errorf("Peer should be protected while a session remains")
+    }
+    second()
+    if pp.isProtected(id, SessionPeerTag) {
+        test.Errorf("Peer should not be protected once every session has ended")
+    }
+}
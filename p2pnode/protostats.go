@@ -0,0 +1,202 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Upper bounds of the handler latency histogram buckets. Latencies above
+// the last bound fall into a final, unbounded bucket.
+var HandlerLatencyBuckets = []time.Duration{
+    time.Millisecond,
+    5 * time.Millisecond,
+    10 * time.Millisecond,
+    50 * time.Millisecond,
+    100 * time.Millisecond,
+    500 * time.Millisecond,
+    time.Second,
+    5 * time.Second,
+    30 * time.Second,
+}
+
+// Distribution of the time the Node's handlers took to serve streams
+type LatencyHistogram struct {
+    // Counts[i] is the number of latencies at most HandlerLatencyBuckets[i]
+    // (and above the previous bound). The last count is for latencies
+    // above every bound.
+    Counts  []uint64
+    Count   uint64
+    Sum     time.Duration
+}
+
+func (hist *LatencyHistogram) observe(latency time.Duration) {
+    if hist.Counts == nil {
+        hist.Counts = make([]uint64, len(HandlerLatencyBuckets) + 1)
+    }
+    i := 0
+    for i < len(HandlerLatencyBuckets) && latency > HandlerLatencyBuckets[i] {
+        i++
+    }
+    hist.Counts[i]++
+    hist.Count++
+    hist.Sum += latency
+}
+
+// Returns the mean latency, or 0 if none were observed
+func (hist LatencyHistogram) Mean() time.Duration {
+    if hist.Count == 0 {
+        return 0
+    }
+    return hist.Sum / time.Duration(hist.Count)
+}
+
+// Statistics of a single protocol, as returned by Node.ProtocolStats()
+type ProtocolStats struct {
+    // Streams opened in either direction, including those still open
+    StreamsOpened   uint64
+    StreamsClosed   uint64
+    StreamsOpen     int
+    // Inbound streams passed to the Node's handler for the protocol
+    StreamsHandled  uint64
+    // Handled streams that were reset by the handler (or its middleware),
+    // or whose handler panicked
+    Errors          uint64
+    BytesIn         int64
+    BytesOut        int64
+    HandlerLatency  LatencyHistogram
+}
+
+// Per-protocol counters of a Node. They are kept across identity rotations.
+type protocolStats struct {
+    mutex   sync.Mutex
+    stats   map[protocol.ID]*ProtocolStats
+}
+
+func newProtocolStats() *protocolStats {
+    return &protocolStats{stats: make(map[protocol.ID]*ProtocolStats)}
+}
+
+// Returns the stats of the protocol, creating them if needed. The lock
+// must be held.
+func (ps *protocolStats) get(pid protocol.ID) *ProtocolStats {
+    stats, ok := ps.stats[pid]
+    if !ok {
+        stats = &ProtocolStats{}
+        ps.stats[pid] = stats
+    }
+    return stats
+}
+
+// Counts streams closed on the host
+func (ps *protocolStats) attach(h host.Host) {
+    h.Network().Notify(&network.NotifyBundle{
+        ClosedStreamF: func(_ network.Network, stream network.Stream) {
+            // Streams that never agreed on a protocol aren't counted
+            if stream.Protocol() == "" {
+                return
+            }
+            ps.mutex.Lock()
+            defer ps.mutex.Unlock()
+            ps.get(stream.Protocol()).StreamsClosed++
+        },
+    })
+}
+
+// Stream passed to handlers, noting whether it was reset
+type statsStream struct {
+    network.Stream
+    reset   bool
+}
+
+func (s *statsStream) Reset() error {
+    s.reset = true
+    return s.Stream.Reset()
+}
+
+// Wraps the handler to count the streams it serves, its errors and its
+// latency
+func (ps *protocolStats) wrap(handler network.StreamHandler) network.StreamHandler {
+    return func(stream network.Stream) {
+        wrapped := &statsStream{Stream: stream}
+        start := time.Now()
+        panicked := true
+        defer func() {
+            latency := time.Since(start)
+            ps.mutex.Lock()
+            stats := ps.get(stream.Protocol())
+            stats.StreamsHandled++
+            if wrapped.reset || panicked {
+                stats.Errors++
+            }
+            stats.HandlerLatency.observe(latency)
+            ps.mutex.Unlock()
+        }()
+
+        handler(wrapped)
+        panicked = false
+    }
+}
+
+// Returns statistics for every protocol the Node has served or spoken,
+// combining the Node's stream counters with its bandwidth counters
+func (node *Node) ProtocolStats() map[protocol.ID]ProtocolStats {
+    open := make(map[protocol.ID]int)
+    if h := node.Host(); h != nil {
+        for _, conn := range h.Network().Conns() {
+            for _, stream := range conn.GetStreams() {
+                if stream.Protocol() != "" {
+                    open[stream.Protocol()]++
+                }
+            }
+        }
+    }
+    bandwidth := node.bandwidth.GetBandwidthByProtocol()
+
+    ps := node.protoStats
+    ps.mutex.Lock()
+    defer ps.mutex.Unlock()
+
+    result := make(map[protocol.ID]ProtocolStats)
+    for pid, stats := range ps.stats {
+        result[pid] = *stats
+    }
+    for pid := range open {
+        if _, ok := result[pid]; !ok {
+            result[pid] = ProtocolStats{}
+        }
+    }
+    for pid, bw := range bandwidth {
+        if _, ok := result[pid]; !ok {
+            result[pid] = ProtocolStats{}
+        }
+        stats := result[pid]
+        stats.BytesIn, stats.BytesOut = bw.TotalIn, bw.TotalOut
+        result[pid] = stats
+    }
+
+    for pid, stats := range result {
+        stats.StreamsOpen = open[pid]
+        stats.StreamsOpened = stats.StreamsClosed + uint64(stats.StreamsOpen)
+        stats.HandlerLatency.Counts = append([]uint64(nil), stats.HandlerLatency.Counts...)
+        result[pid] = stats
+    }
+    return result
+}
@@ -0,0 +1,41 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "testing"
+    "time"
+)
+
+func TestLatencyHistogram(test *testing.T) {
+    var hist LatencyHistogram
+    hist.observe(500 * time.Microsecond)
+    hist.observe(time.Millisecond)
+    hist.observe(20 * time.Millisecond)
+    hist.observe(time.Minute)
+
+    last := len(HandlerLatencyBuckets)
+    if hist.Counts[0] != 2 || hist.Counts[3] != 1 || hist.Counts[last] != 1 {
+        test.Errorf("Unexpected bucket counts %v", hist.Counts)
+    }
+    if hist.Count != 4 {
+        test.Errorf("Count is %d, expected 4", hist.Count)
+    }
+
+    expected := (500 * time.Microsecond + time.Millisecond + 20 * time.Millisecond + time.Minute) / 4
+    if mean := hist.Mean(); mean != expected {
+        test.Errorf("Mean() returned %v, expected %v", mean, expected)
+    }
+}
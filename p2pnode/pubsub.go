@@ -0,0 +1,137 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "errors"
+    "sync"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+    pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+var (
+    // Returned by Publish() and Subscribe() if Config.EnablePubSub is unset
+    ErrPubSubDisabled = errors.New("PubSub is not enabled on this node")
+
+    ErrEmptyTopic = errors.New("Cannot have empty topic")
+)
+
+// Gossipsub router and the topics joined through it. A topic can only be
+// joined once per router, so joined topics are shared by all callers.
+type pubsubState struct {
+    mutex   sync.Mutex
+    ps      *pubsub.PubSub
+    topics  map[string]*pubsub.Topic
+}
+
+// Router and topics of a previous host, saved while starting a new one
+type pubsubSnapshot struct {
+    ps      *pubsub.PubSub
+    topics  map[string]*pubsub.Topic
+}
+
+func (node *Node) savePubSub() pubsubSnapshot {
+    if node.pubsub == nil {
+        return pubsubSnapshot{}
+    }
+    node.pubsub.mutex.Lock()
+    defer node.pubsub.mutex.Unlock()
+    return pubsubSnapshot{ps: node.pubsub.ps, topics: node.pubsub.topics}
+}
+
+func (node *Node) restorePubSub(snap pubsubSnapshot) {
+    if node.pubsub == nil {
+        return
+    }
+    node.pubsub.mutex.Lock()
+    defer node.pubsub.mutex.Unlock()
+    node.pubsub.ps, node.pubsub.topics = snap.ps, snap.topics
+}
+
+// Starts the gossipsub router on the Node's current host, replacing the
+// router of any previous host
+func (node *Node) startPubSub() error {
+    ps, err := pubsub.NewGossipSub(node.hostContext(), node.Host())
+    if err != nil {
+        return err
+    }
+
+    if node.pubsub == nil {
+        node.pubsub = &pubsubState{}
+    }
+    state := node.pubsub
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+    state.ps = ps
+    state.topics = make(map[string]*pubsub.Topic)
+    return nil
+}
+
+// Returns the joined topic, joining it if needed
+func (node *Node) topic(name string) (*pubsub.Topic, error) {
+    if node.pubsub == nil {
+        return nil, ErrPubSubDisabled
+    } else if name == "" {
+        return nil, ErrEmptyTopic
+    }
+
+    state := node.pubsub
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+
+    if t, ok := state.topics[name]; ok {
+        return t, nil
+    }
+
+    t, err := state.ps.Join(name)
+    if err != nil {
+        return nil, err
+    }
+    state.topics[name] = t
+    return t, nil
+}
+
+// Broadcasts the data to all peers subscribed to the topic
+func (node *Node) Publish(topic string, data []byte) error {
+    t, err := node.topic(topic)
+    if err != nil {
+        return err
+    }
+    return t.Publish(node.Ctx, data)
+}
+
+// Subscribes to the topic. Messages are read with Subscription.Next(), and
+// Subscription.Cancel() unsubscribes. Subscriptions end if the Node's
+// identity is rotated (see RotateIdentity()), and must be renewed.
+func (node *Node) Subscribe(topic string) (*pubsub.Subscription, error) {
+    t, err := node.topic(topic)
+    if err != nil {
+        return nil, err
+    }
+    return t.Subscribe()
+}
+
+// Returns the peers the Node knows to be subscribed to the topic
+func (node *Node) TopicPeers(topic string) []peer.ID {
+    if node.pubsub == nil {
+        return nil
+    }
+
+    state := node.pubsub
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+    return state.ps.ListPeers(topic)
+}
@@ -0,0 +1,172 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "net"
+    "sync"
+
+    "github.com/libp2p/go-libp2p-core/helpers"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+// Owner name used for handlers registered with ListenConn()
+const ConnListenerOwner = "conn-listener"
+
+var (
+    // Returned by Accept() once a ConnListener is closed
+    ErrListenerClosed = errors.New("Listener closed")
+)
+
+// Address of one end of a Conn: a peer, and the multiaddr of the
+// connection it was reached over
+type PeerAddr struct {
+    Peer    peer.ID
+    Addr    multiaddr.Multiaddr
+}
+
+func (addr PeerAddr) Network() string {
+    return "libp2p"
+}
+
+func (addr PeerAddr) String() string {
+    if addr.Addr == nil {
+        return "/p2p/" + addr.Peer.Pretty()
+    }
+    return addr.Addr.String() + "/p2p/" + addr.Peer.Pretty()
+}
+
+// A dedicated stream to a peer exposed as a net.Conn, so it can carry
+// external protocols (e.g. an SSH or database client). Closing the Node
+// closes all of its Conns.
+type Conn struct {
+    network.Stream
+    once    sync.Once
+    done    chan struct{}
+}
+
+func (node *Node) newConn(stream network.Stream) *Conn {
+    conn := &Conn{Stream: stream, done: make(chan struct{})}
+    node.spawn("raw-conn", func(ctx context.Context) {
+        select {
+        case <-ctx.Done():
+            conn.Stream.Reset()
+        case <-conn.done:
+        }
+    })
+    return conn
+}
+
+// Closes the connection. The remote end reads EOF once pending data has
+// been delivered.
+func (conn *Conn) Close() error {
+    conn.once.Do(func() {
+        close(conn.done)
+        go helpers.FullClose(conn.Stream)
+    })
+    return nil
+}
+
+func (conn *Conn) LocalAddr() net.Addr {
+    c := conn.Stream.Conn()
+    return PeerAddr{Peer: c.LocalPeer(), Addr: c.LocalMultiaddr()}
+}
+
+func (conn *Conn) RemoteAddr() net.Addr {
+    c := conn.Stream.Conn()
+    return PeerAddr{Peer: c.RemotePeer(), Addr: c.RemoteMultiaddr()}
+}
+
+// Opens a Conn to the peer over the given protocol, which the peer should
+// be accepting with ListenConn()
+func (node *Node) DialConn(ctx context.Context, id peer.ID, pid protocol.ID) (*Conn, error) {
+    stream, err := node.Host().NewStream(ctx, id, pid)
+    if err != nil {
+        return nil, err
+    }
+    return node.newConn(stream), nil
+}
+
+// Accepts Conns opened with DialConn(), as a net.Listener
+type ConnListener struct {
+    node    *Node
+    pid     protocol.ID
+    // Accepted streams, wrapped into Conns by Accept() so nothing is
+    // started for streams that are never accepted
+    streams chan network.Stream
+    once    sync.Once
+    done    chan struct{}
+}
+
+// Listens for Conns over the given protocol until the listener or the Node
+// is closed
+func (node *Node) ListenConn(pid protocol.ID) (*ConnListener, error) {
+    listener := &ConnListener{
+        node:       node,
+        pid:        pid,
+        streams:    make(chan network.Stream),
+        done:       make(chan struct{}),
+    }
+
+    err := node.RegisterStreamHandler(pid, ConnListenerOwner, func(stream network.Stream) {
+        select {
+        case listener.streams <- stream:
+        case <-listener.done:
+            stream.Reset()
+        case <-node.Ctx.Done():
+            stream.Reset()
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    node.spawn("conn-listener", func(ctx context.Context) {
+        select {
+        case <-ctx.Done():
+            listener.Close()
+        case <-listener.done:
+        }
+    })
+    return listener, nil
+}
+
+func (listener *ConnListener) Accept() (net.Conn, error) {
+    select {
+    case stream := <-listener.streams:
+        return listener.node.newConn(stream), nil
+    case <-listener.done:
+        return nil, ErrListenerClosed
+    }
+}
+
+func (listener *ConnListener) Close() error {
+    var err error
+    listener.once.Do(func() {
+        close(listener.done)
+        err = listener.node.UnregisterStreamHandler(listener.pid, ConnListenerOwner)
+    })
+    return err
+}
+
+func (listener *ConnListener) Addr() net.Addr {
+    return PeerAddr{Peer: listener.node.Host().ID()}
+}
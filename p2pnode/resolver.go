@@ -0,0 +1,219 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+    madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+const (
+    // Port used for DNS servers given without one
+    defaultDNSPort = "53"
+
+    // How long failed lookups are cached for by NewCachingDNSResolver(), if
+    // shorter than its TTL
+    DNSNegativeCacheTTL = 5 * time.Second
+
+    // Cache size past which expired entries are pruned on insertion
+    dnsCachePruneSize = 1024
+)
+
+// Lookups needed to resolve /dns, /dns4, /dns6 and /dnsaddr multiaddrs.
+// Implemented by *net.Resolver, and can be implemented by custom backends
+// (e.g. DNS-over-HTTPS clients).
+type DNSBackend interface {
+    LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error)
+    LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Returns a resolver that queries the given DNS servers ("host" or
+// "host:port") instead of the system's configured ones, rotating between
+// them on each query.
+func NewDNSResolver(servers []string) (*madns.Resolver, error) {
+    if len(servers) == 0 {
+        return nil, errors.New("Must provide at least one DNS server")
+    }
+
+    addrs := make([]string, len(servers))
+    for i, server := range servers {
+        if _, _, err := net.SplitHostPort(server); err != nil {
+            server = net.JoinHostPort(server, defaultDNSPort)
+        }
+        addrs[i] = server
+    }
+
+    var next uint32
+    backend := &net.Resolver{
+        PreferGo: true,
+        Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+            i := atomic.AddUint32(&next, 1)
+            var dialer net.Dialer
+            return dialer.DialContext(ctx, network, addrs[int(i) % len(addrs)])
+        },
+    }
+
+    return &madns.Resolver{Backend: backend}, nil
+}
+
+type dnsCacheEntry struct {
+    ips     []net.IPAddr
+    txts    []string
+    err     error
+    expiry  time.Time
+}
+
+// Caches the results of another DNSBackend. Failures are cached briefly
+// (see DNSNegativeCacheTTL), except those caused by the caller's context
+// ending, which say nothing about the name.
+type cachingDNSBackend struct {
+    backend DNSBackend
+    ttl     time.Duration
+    mutex   sync.Mutex
+    ips     map[string]dnsCacheEntry
+    txts    map[string]dnsCacheEntry
+}
+
+// Returns a resolver that caches lookups made through the given backend for
+// the given duration, or failed lookups for at most DNSNegativeCacheTTL. A
+// nil backend uses the system resolver.
+func NewCachingDNSResolver(backend DNSBackend, ttl time.Duration) (*madns.Resolver, error) {
+    if ttl <= 0 {
+        return nil, errors.New("Cache TTL must be greater than 0")
+    }
+    if backend == nil {
+        backend = net.DefaultResolver
+    }
+
+    return &madns.Resolver{Backend: &cachingDNSBackend{
+        backend:    backend,
+        ttl:        ttl,
+        ips:        make(map[string]dnsCacheEntry),
+        txts:       make(map[string]dnsCacheEntry),
+    }}, nil
+}
+
+func (cache *cachingDNSBackend) lookup(ctx context.Context, entries map[string]dnsCacheEntry,
+    name string, miss func() dnsCacheEntry) dnsCacheEntry {
+
+    cache.mutex.Lock()
+    entry, ok := entries[name]
+    cache.mutex.Unlock()
+    if ok && time.Now().Before(entry.expiry) {
+        return entry
+    }
+
+    entry = miss()
+    if entry.err != nil && (ctx.Err() != nil || errors.Is(entry.err, context.Canceled) ||
+        errors.Is(entry.err, context.DeadlineExceeded)) {
+        return entry
+    }
+
+    now := time.Now()
+    ttl := cache.ttl
+    if entry.err != nil && ttl > DNSNegativeCacheTTL {
+        ttl = DNSNegativeCacheTTL
+    }
+    entry.expiry = now.Add(ttl)
+
+    cache.mutex.Lock()
+    defer cache.mutex.Unlock()
+    if len(entries) >= dnsCachePruneSize {
+        for other, e := range entries {
+            if !now.Before(e.expiry) {
+                delete(entries, other)
+            }
+        }
+    }
+    entries[name] = entry
+    return entry
+}
+
+func (cache *cachingDNSBackend) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+    entry := cache.lookup(ctx, cache.ips, name, func() dnsCacheEntry {
+        ips, err := cache.backend.LookupIPAddr(ctx, name)
+        return dnsCacheEntry{ips: ips, err: err}
+    })
+    return entry.ips, entry.err
+}
+
+func (cache *cachingDNSBackend) LookupTXT(ctx context.Context, name string) ([]string, error) {
+    entry := cache.lookup(ctx, cache.txts, name, func() dnsCacheEntry {
+        txts, err := cache.backend.LookupTXT(ctx, name)
+        return dnsCacheEntry{txts: txts, err: err}
+    })
+    return entry.txts, entry.err
+}
+
+// Resolves any DNS multiaddrs of the peer using the Node's configured
+// resolver. libp2p falls back to the system resolver for any addresses that
+// are left unresolved, so addresses that fail to resolve are dropped, unless
+// the address cache has their last-known expansion. If neither a resolver
+// nor a cache is configured, the AddrInfo is returned unchanged.
+func (node *Node) resolveAddrInfo(ctx context.Context, ai peer.AddrInfo) peer.AddrInfo {
+    resolver := node.resolver
+    if resolver == nil {
+        if node.addrCache == nil {
+            return ai
+        }
+        resolver = madns.DefaultResolver
+    }
+
+    p2pAddr, err := multiaddr.NewMultiaddr("/p2p/" + ai.ID.Pretty())
+    if err != nil {
+        return ai
+    }
+
+    resolved := peer.AddrInfo{ID: ai.ID}
+    for _, addr := range ai.Addrs {
+        if !madns.Matches(addr) {
+            resolved.Addrs = append(resolved.Addrs, addr)
+            continue
+        }
+
+        var addrs []multiaddr.Multiaddr
+        results, err := resolver.Resolve(ctx, addr.Encapsulate(p2pAddr))
+        if err == nil {
+            for _, result := range results {
+                info, err := peer.AddrInfoFromP2pAddr(result)
+                if err != nil || info.ID != ai.ID {
+                    continue
+                }
+                addrs = append(addrs, info.Addrs...)
+            }
+        }
+
+        if node.addrCache != nil {
+            if len(addrs) > 0 {
+                node.addrCache.put(ai.ID, addr, addrs)
+            } else if addrs = node.addrCache.get(ai.ID, addr); len(addrs) > 0 {
+                log.Printf("Unable to resolve %s, using last-known addresses %v\n", addr, addrs)
+            }
+        }
+        resolved.Addrs = append(resolved.Addrs, addrs...)
+    }
+
+    return resolved
+}
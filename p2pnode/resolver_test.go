@@ -0,0 +1,98 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "testing"
+    "time"
+)
+
+// Backend failing with 'err' (if set), counting lookups
+type testDNSBackend struct {
+    err     error
+    lookups int
+}
+
+func (b *testDNSBackend) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+    b.lookups++
+    if b.err != nil {
+        return nil, b.err
+    }
+    return []net.IPAddr{{IP: net.IPv4(10, 0, 0, 1)}}, nil
+}
+
+func (b *testDNSBackend) LookupTXT(ctx context.Context, name string) ([]string, error) {
+    b.lookups++
+    return nil, b.err
+}
+
+func TestCachingDNSBackend(test *testing.T) {
+    backend := &testDNSBackend{}
+    cache := &cachingDNSBackend{
+        backend:    backend,
+        ttl:        time.Hour,
+        ips:        make(map[string]dnsCacheEntry),
+        txts:       make(map[string]dnsCacheEntry),
+    }
+
+    test.Run("Hit", func(test *testing.T) {
+        cache.LookupIPAddr(context.Background(), "hit.example")
+        cache.LookupIPAddr(context.Background(), "hit.example")
+        if backend.lookups != 1 {
+            test.Errorf("Expected 1 backend lookup, got %d", backend.lookups)
+        }
+    })
+
+    test.Run("ContextError", func(test *testing.T) {
+        backend.lookups, backend.err = 0, context.DeadlineExceeded
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+        cache.LookupIPAddr(ctx, "ctx.example")
+
+        backend.err = nil
+        if _, err := cache.LookupIPAddr(context.Background(), "ctx.example"); err != nil {
+            test.Errorf("Failure from a done context was cached: %v", err)
+        }
+        if backend.lookups != 2 {
+            test.Errorf("Expected 2 backend lookups, got %d", backend.lookups)
+        }
+    })
+
+    test.Run("NegativeTTL", func(test *testing.T) {
+        backend.err = errors.New("no such host")
+        cache.LookupIPAddr(context.Background(), "missing.example")
+
+        entry := cache.ips["missing.example"]
+        if entry.err == nil || time.Until(entry.expiry) > DNSNegativeCacheTTL {
+            test.Errorf("Failure cached until %v, expected at most %v", entry.expiry,
+                DNSNegativeCacheTTL)
+        }
+        backend.err = nil
+    })
+
+    test.Run("Prune", func(test *testing.T) {
+        for i := 0; i < dnsCachePruneSize; i++ {
+            cache.ips[fmt.Sprintf("expired-%d.example", i)] = dnsCacheEntry{expiry: time.Now()}
+        }
+        cache.LookupIPAddr(context.Background(), "new.example")
+        if len(cache.ips) >= dnsCachePruneSize {
+            test.Errorf("Expired entries were not pruned, %d remain", len(cache.ips))
+        }
+    })
+}
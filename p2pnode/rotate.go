@@ -0,0 +1,154 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "log"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Replaces the Node's host with one using the new identity, returning the
+// new peer ID. The new host keeps the old one's peerstore, stream handlers
+// and network callbacks, reconnects to the bootstraps, and re-advertises
+// all rendezvous strings under the new ID. The old host is then closed.
+// A link between the old and new IDs, signed by both keys, is added to the
+// Node's IdentityHistory().
+//
+// If the new host fails to start, it is closed and the Node carries on
+// with the old one. Failures after the old host is closed (e.g. reaching
+// the bootstraps) are returned along with the new peer ID.
+//
+// NOTE: Channels returned by Events() and PubSub subscriptions are tied to
+//       the old host, and end once it is closed.
+func (node *Node) RotateIdentity(newKey crypto.PrivKey) (peer.ID, error) {
+    if newKey == nil {
+        return "", errors.New("Cannot rotate to a nil key")
+    } else if node.Ctx.Err() != nil {
+        return "", node.Ctx.Err()
+    }
+
+    node.core.rotating.Lock()
+    defer node.core.rotating.Unlock()
+
+    prev := node.core.snapshot()
+    oldHost, oldDHT := prev.host, prev.dht
+    config := *node.config
+    config.PrivKey = newKey
+
+    hostCtx, hostCancel := context.WithCancel(node.Ctx)
+    h, kdht, router, err := node.newHost(hostCtx, &config, oldHost.Peerstore())
+    if err != nil {
+        hostCancel()
+        return "", err
+    }
+    log.Println("Rotating identity from", oldHost.ID(), "to", h.ID())
+
+    link, err := NewIdentityLink(oldHost.Peerstore().PrivKey(oldHost.ID()), newKey)
+    if err != nil {
+        h.Close()
+        kdht.Close()
+        hostCancel()
+        return "", err
+    }
+
+    // The old host's bootstraps are about to disconnect, which should not
+    // trigger reconnection attempts
+    callbacks := node.NetworkCallbacks()
+    if callbacks != nil {
+        oldHost.Network().StopNotify(callbacks)
+    }
+
+    prevPubSub := node.savePubSub()
+    if err = node.startHost(hostCtx, hostCancel, h, kdht, router, &config); err != nil {
+        log.Printf("ERROR: Unable to start new host, keeping %s\n%v\n", oldHost.ID(), err)
+        node.restoreHost(prev, prevPubSub)
+        hostCancel()
+        kdht.Close()
+        h.Close()
+        return "", err
+    }
+    node.config.PrivKey = newKey
+    node.identities.add(link)
+
+    if !node.Draining() {
+        reg := node.handlers
+        reg.mutex.Lock()
+        for pid, entries := range reg.handlers {
+            node.applyHandler(pid, entries[len(entries)-1])
+        }
+        reg.mutex.Unlock()
+    }
+
+    if callbacks != nil {
+        h.Network().Notify(callbacks)
+    }
+
+    prev.cancel()
+    oldDHT.Close()
+    oldHost.Close()
+
+    // The old host is gone, so finish moving discovery and leases over to
+    // the new one even if a step fails, then report the first failure
+    var firstErr error
+    if bootstraps := node.bootstrapSet.list(); len(bootstraps) > 0 {
+        firstErr = node.connectBootstraps(bootstraps, config.BootstrapTimeout)
+    }
+    if err = kdht.Bootstrap(node.Ctx); err != nil && firstErr == nil {
+        firstErr = err
+    }
+    disc, err := newDiscovery(&config, h, kdht)
+    if err != nil {
+        log.Printf("ERROR: Unable to create discovery, falling back to the DHT\n%v\n", err)
+        if firstErr == nil {
+            firstErr = err
+        }
+        disc, _ = DHTDiscovery(h, kdht)
+    }
+    node.core.setDiscovery(disc)
+
+    for _, lease := range node.leases.list() {
+        lease := lease
+        if !lease.Released() {
+            node.spawn("lease-renew", func(context.Context) { lease.Renew() })
+        }
+    }
+
+    node.emit(Event{Type: EventIdentityRotated, Peer: h.ID()})
+    return h.ID(), firstErr
+}
+
+// Makes the previous host current again after startHost() failed to start
+// a new one. The previous host's context was never cancelled, so only the
+// objects replaced by startHost() need to be put back. The caller closes
+// the new host.
+func (node *Node) restoreHost(prev hostSnapshot, prevPubSub pubsubSnapshot) {
+    node.core.restore(prev)
+    node.restorePubSub(prevPubSub)
+    if node.limits != nil {
+        node.limits.recount(prev.host)
+    }
+    if node.config.EnableMDNS {
+        if err := node.startMDNS(node.config.MDNSServiceTag, node.config.MDNSInterval); err != nil {
+            log.Printf("ERROR: Unable to restart mDNS discovery\n%v\n", err)
+        }
+    }
+    if callbacks := node.NetworkCallbacks(); callbacks != nil {
+        prev.host.Network().Notify(callbacks)
+    }
+}
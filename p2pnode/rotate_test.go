@@ -0,0 +1,114 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/event"
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+)
+
+// Host whose event bus refuses to create emitters, failing startHost()
+type brokenBusHost struct {
+    host.Host
+}
+
+func (h *brokenBusHost) EventBus() event.Bus {
+    return brokenBus{h.Host.EventBus()}
+}
+
+type brokenBus struct {
+    event.Bus
+}
+
+func (bus brokenBus) Emitter(interface{}, ...event.EmitterOpt) (event.Emitter, error) {
+    return nil, errors.New("broken event bus")
+}
+
+func TestRotateIdentityFailure(test *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+    defer cancel()
+
+    var created []host.Host
+    config := NewTestConfig()
+    config.HostConstructor = func(ctx context.Context, opts ...libp2p.Option) (host.Host, error) {
+        h, err := libp2p.New(ctx, opts...)
+        if err != nil {
+            return nil, err
+        }
+        created = append(created, h)
+        // Only the first host works
+        if len(created) > 1 {
+            return &brokenBusHost{h}, nil
+        }
+        return h, nil
+    }
+    node, err := NewNode(ctx, config)
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+    defer node.Close()
+    oldID := node.Host().ID()
+    connected := make(chan struct{}, 1)
+    node.NetworkCallbacks().ConnectedF = func(network.Network, network.Conn) {
+        select {
+        case connected <- struct{}{}:
+        default:
+        }
+    }
+
+    newKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+    if err != nil {
+        test.Fatalf("Unable to generate key:\n%v", err)
+    }
+    if _, err = node.RotateIdentity(newKey); err == nil {
+        test.Fatalf("RotateIdentity() succeeded with a broken host")
+    }
+
+    if node.Host().ID() != oldID {
+        test.Errorf("Node switched to %s after a failed rotation, expected %s",
+            node.Host().ID(), oldID)
+    }
+    select {
+    case <-created[1].Network().Process().Closing():
+    default:
+        test.Errorf("New host was left open after a failed rotation")
+    }
+
+    // The old host must still work, with the network callbacks registered
+    other, err := NewNode(ctx, NewTestConfig())
+    if err != nil {
+        test.Fatalf("NewNode() failed:\n%v", err)
+    }
+    defer other.Close()
+    if err = other.Host().Connect(ctx, *host.InfoFromHost(node.Host())); err != nil {
+        test.Errorf("Unable to connect to the old host after a failed rotation:\n%v", err)
+    }
+    select {
+    case <-connected:
+    case <-time.After(5 * time.Second):
+        test.Errorf("Network callbacks were not restored after a failed rotation")
+    }
+    if _, err = other.Echo(ctx, oldID, []byte("hello"), EchoOpts{}); err != nil {
+        test.Errorf("Old host stopped serving after a failed rotation:\n%v", err)
+    }
+}
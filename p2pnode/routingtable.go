@@ -0,0 +1,117 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// A peer in the DHT routing table
+type RoutingTableEntry struct {
+    Peer                    peer.ID
+    // Number of leading bits the peer's DHT key shares with the Node's
+    CommonPrefixLen         int
+    // When the peer last answered a query usefully, or successfully
+    LastUsefulAt            time.Time
+    LastSuccessfulQueryAt   time.Time
+    Connected               bool
+}
+
+// Peers of the DHT routing table that share the same common prefix length
+// with the Node. The DHT keeps its deepest bucket unsplit until it fills,
+// so these correspond to its buckets except near the end of the table.
+type RoutingTableBucket struct {
+    CommonPrefixLen int
+    Peers           []RoutingTableEntry
+}
+
+// Returns the peers in the Node's DHT routing table
+func (node *Node) RoutingTablePeers() []peer.ID {
+    return node.DHT().RoutingTable().ListPeers()
+}
+
+// Returns the number of peers in the Node's DHT routing table
+func (node *Node) RoutingTableSize() int {
+    return node.DHT().RoutingTable().Size()
+}
+
+// Returns the contents of the Node's DHT routing table, grouped into
+// buckets ordered by increasing common prefix length
+func (node *Node) RoutingTableBuckets() []RoutingTableBucket {
+    h := node.Host()
+    self := kb.ConvertPeerID(h.ID())
+
+    byCPL := make(map[int][]RoutingTableEntry)
+    for _, info := range node.DHT().RoutingTable().GetPeerInfos() {
+        cpl := kb.CommonPrefixLen(self, kb.ConvertPeerID(info.Id))
+        byCPL[cpl] = append(byCPL[cpl], RoutingTableEntry{
+            Peer:                   info.Id,
+            CommonPrefixLen:        cpl,
+            LastUsefulAt:           info.LastUsefulAt,
+            LastSuccessfulQueryAt:  info.LastSuccessfulOutboundQueryAt,
+            Connected:              h.Network().Connectedness(info.Id) == network.Connected,
+        })
+    }
+
+    buckets := make([]RoutingTableBucket, 0, len(byCPL))
+    for cpl, entries := range byCPL {
+        buckets = append(buckets, RoutingTableBucket{CommonPrefixLen: cpl, Peers: entries})
+    }
+    sort.Slice(buckets, func(i, j int) bool {
+        return buckets[i].CommonPrefixLen < buckets[j].CommonPrefixLen
+    })
+    return buckets
+}
+
+// Writes a human-readable dump of the Node's DHT routing table, for
+// debugging discovery problems
+func (node *Node) DumpRoutingTable(w io.Writer) error {
+    buckets := node.RoutingTableBuckets()
+    _, err := fmt.Fprintf(w, "Routing table of %s: %d peers\n",
+        node.Host().ID(), node.RoutingTableSize())
+    if err != nil {
+        return err
+    }
+
+    formatTime := func(t time.Time) string {
+        if t.IsZero() {
+            return "never"
+        }
+        return time.Since(t).Round(time.Second).String() + " ago"
+    }
+
+    for _, bucket := range buckets {
+        _, err = fmt.Fprintf(w, "Bucket %d (%d peers):\n", bucket.CommonPrefixLen, len(bucket.Peers))
+        if err != nil {
+            return err
+        }
+        for _, entry := range bucket.Peers {
+            _, err = fmt.Fprintf(w, "    %s connected=%v useful=%s queried=%s\n",
+                entry.Peer, entry.Connected, formatTime(entry.LastUsefulAt),
+                formatTime(entry.LastSuccessfulQueryAt))
+            if err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,94 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p-core/protocol"
+    secio "github.com/libp2p/go-libp2p-secio"
+    libp2ptls "github.com/libp2p/go-libp2p-tls"
+)
+
+// Names of security transports that can be listed in Config.Security
+const (
+    SecurityTLS   = "tls"
+    SecuritySecio = "secio"
+    SecurityNoise = "noise"
+)
+
+// A security transport's protocol ID and constructor
+type securityTransport struct {
+    id  protocol.ID
+    tpt interface{}
+}
+
+// Security transports, keyed by name
+var securityTransports = map[string]securityTransport{
+    SecurityTLS:   {id: libp2ptls.ID, tpt: libp2ptls.New},
+    SecuritySecio: {id: secio.ID, tpt: secio.New},
+}
+
+// Security protocols negotiated by libp2p when Config.Security is empty,
+// in order of preference
+var defaultSecurity = []string{SecuritySecio, SecurityTLS}
+
+// Returns libp2p options enabling the security transports listed in the
+// Config, in order of preference. If none are listed, no options are
+// returned and libp2p's defaults are used.
+//
+// NOTE: Noise is not available in the version of libp2p currently in use,
+//       so SecurityNoise is rejected until it is upgraded.
+func securityOpts(config *Config) ([]libp2p.Option, error) {
+    var opts []libp2p.Option
+
+    seen := make(map[string]bool)
+    for _, name := range config.Security {
+        name = strings.ToLower(name)
+        if name == SecurityNoise {
+            return nil, errors.New("Noise security transport is not supported by this version of libp2p")
+        }
+        sec, ok := securityTransports[name]
+        if !ok {
+            return nil, fmt.Errorf("Unknown security transport %s", name)
+        } else if seen[name] {
+            return nil, fmt.Errorf("Security transport %s listed more than once", name)
+        }
+        seen[name] = true
+
+        opts = append(opts, libp2p.Security(string(sec.id), sec.tpt))
+    }
+
+    return opts, nil
+}
+
+// Returns the IDs of the security protocols the Node negotiates over
+// TCP and WebSocket connections, in order of preference, so deployments
+// can verify their policy. QUIC always uses its own TLS 1.3 handshake.
+func (node *Node) SecurityProtocols() []protocol.ID {
+    names := node.config.Security
+    if len(names) == 0 {
+        names = defaultSecurity
+    }
+
+    ids := make([]protocol.ID, 0, len(names))
+    for _, name := range names {
+        ids = append(ids, securityTransports[strings.ToLower(name)].id)
+    }
+    return ids
+}
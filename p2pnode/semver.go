@@ -0,0 +1,199 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Semantic version at the end of a protocol ID (e.g. "/myservice/1.2.0")
+type ProtocolVersion struct {
+    Major   int
+    Minor   int
+    Patch   int
+}
+
+func (v ProtocolVersion) String() string {
+    return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Returns true if v is an earlier version than other
+func (v ProtocolVersion) Less(other ProtocolVersion) bool {
+    if v.Major != other.Major {
+        return v.Major < other.Major
+    } else if v.Minor != other.Minor {
+        return v.Minor < other.Minor
+    }
+    return v.Patch < other.Patch
+}
+
+// Splits a protocol ID into its base and the version in its last component.
+// Missing minor and patch versions are taken as 0, so "/myservice/1" is
+// version 1.0.0.
+func ParseProtocolVersion(pid string) (string, ProtocolVersion, error) {
+    var v ProtocolVersion
+
+    i := strings.LastIndex(pid, "/")
+    if i < 0 || i == len(pid) - 1 {
+        return "", v, fmt.Errorf("Protocol %s has no version", pid)
+    }
+    base, version := pid[:i], pid[i+1:]
+
+    parts := strings.Split(version, ".")
+    if len(parts) > 3 {
+        return "", v, fmt.Errorf("Invalid version %s in protocol %s", version, pid)
+    }
+    nums := []*int{&v.Major, &v.Minor, &v.Patch}
+    for j, part := range parts {
+        n, err := strconv.Atoi(part)
+        if err != nil || n < 0 {
+            return "", v, fmt.Errorf("Invalid version %s in protocol %s", version, pid)
+        }
+        *nums[j] = n
+    }
+
+    return base, v, nil
+}
+
+// Returns a match function (see AddStreamHandlerMatch()) for a range of
+// protocol versions. The last component of the range is either a version
+// with 'x' wildcards (e.g. "/myservice/1.x" or "/myservice/1.2.x"), or a
+// version prefixed by '^' to match it and any later version with the same
+// major version (e.g. "/myservice/^1.2").
+func MatchSemver(versionRange string) (func(string) bool, error) {
+    i := strings.LastIndex(versionRange, "/")
+    if i < 0 || i == len(versionRange) - 1 {
+        return nil, fmt.Errorf("Range %s has no version", versionRange)
+    }
+    base, spec := versionRange[:i], versionRange[i+1:]
+
+    if strings.HasPrefix(spec, "^") {
+        _, min, err := ParseProtocolVersion(base + "/" + spec[1:])
+        if err != nil {
+            return nil, err
+        }
+        return func(pid string) bool {
+            b, v, err := ParseProtocolVersion(pid)
+            return err == nil && b == base && v.Major == min.Major && !v.Less(min)
+        }, nil
+    }
+
+    // Each component is either a number or a wildcard (-1)
+    parts := strings.Split(spec, ".")
+    if len(parts) > 3 {
+        return nil, fmt.Errorf("Invalid version range %s", versionRange)
+    }
+    want := []int{-1, -1, -1}
+    for j, part := range parts {
+        if part == "x" || part == "*" {
+            continue
+        }
+        n, err := strconv.Atoi(part)
+        if err != nil || n < 0 {
+            return nil, fmt.Errorf("Invalid version range %s", versionRange)
+        }
+        want[j] = n
+    }
+
+    return func(pid string) bool {
+        b, v, err := ParseProtocolVersion(pid)
+        if err != nil || b != base {
+            return false
+        }
+        for j, n := range []int{v.Major, v.Minor, v.Patch} {
+            if want[j] >= 0 && want[j] != n {
+                return false
+            }
+        }
+        return true
+    }, nil
+}
+
+// Registers a handler for a versioned protocol (e.g. "/myservice/1.4.0")
+// that also serves requests for compatible earlier versions, i.e. any with
+// the same major version that is not later than pid's (e.g. "/myservice/1.2").
+// It can be removed with RemoveStreamHandler().
+func (node *Node) AddVersionedStreamHandler(pid protocol.ID, handler network.StreamHandler) error {
+    base, max, err := ParseProtocolVersion(string(pid))
+    if err != nil {
+        return err
+    }
+
+    match := func(requested string) bool {
+        b, v, err := ParseProtocolVersion(requested)
+        return err == nil && b == base && v.Major == max.Major && !max.Less(v)
+    }
+    return node.AddStreamHandlerMatch(pid, match, handler)
+}
+
+// Sorts versioned protocol IDs from the latest version to the earliest.
+// Protocol IDs without a valid version are placed last.
+func sortByVersion(pids []protocol.ID) []protocol.ID {
+    sorted := make([]protocol.ID, len(pids))
+    copy(sorted, pids)
+    sort.SliceStable(sorted, func(i, j int) bool {
+        _, vi, erri := ParseProtocolVersion(string(sorted[i]))
+        _, vj, errj := ParseProtocolVersion(string(sorted[j]))
+        if erri != nil || errj != nil {
+            return erri == nil
+        }
+        return vj.Less(vi)
+    })
+    return sorted
+}
+
+// Opens a stream to the peer using the latest version among 'pids' that the
+// peer supports, so the stream's Protocol() is the highest mutually
+// supported one. If the peer's protocols are known (via identify), the
+// latest one it lists is used. Otherwise, versions are tried from the
+// latest down.
+func (node *Node) NewStreamVersioned(ctx context.Context, id peer.ID,
+    pids ...protocol.ID) (network.Stream, error) {
+
+    if len(pids) == 0 {
+        return nil, errors.New("Must provide at least one protocol")
+    }
+    sorted := sortByVersion(pids)
+
+    // The host picks from the peer's known protocols in arbitrary order,
+    // so pick the latest one here
+    candidates := make([]string, len(sorted))
+    for i, pid := range sorted {
+        candidates[i] = string(pid)
+    }
+    supported, err := node.Host().Peerstore().SupportsProtocols(id, candidates...)
+    if err == nil && len(supported) > 0 {
+        known := make(map[string]bool, len(supported))
+        for _, pid := range supported {
+            known[pid] = true
+        }
+        for _, pid := range sorted {
+            if known[string(pid)] {
+                return node.Host().NewStream(ctx, id, pid)
+            }
+        }
+    }
+
+    return node.Host().NewStream(ctx, id, sorted...)
+}
@@ -0,0 +1,66 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/protocol"
+)
+
+func TestMatchSemver(test *testing.T) {
+    cases := []struct {
+        versionRange    string
+        pid             string
+        expected        bool
+    }{
+        {"/svc/1.x", "/svc/1.0.0", true},
+        {"/svc/1.x", "/svc/1.7", true},
+        {"/svc/1.x", "/svc/2.0.0", false},
+        {"/svc/1.x", "/other/1.0.0", false},
+        {"/svc/1.2.x", "/svc/1.2.9", true},
+        {"/svc/1.2.x", "/svc/1.3.0", false},
+        {"/svc/^1.2", "/svc/1.2.0", true},
+        {"/svc/^1.2", "/svc/1.9.1", true},
+        {"/svc/^1.2", "/svc/1.1.9", false},
+        {"/svc/^1.2", "/svc/2.0.0", false},
+        {"/svc/1.x", "/svc/latest", false},
+    }
+
+    for _, c := range cases {
+        match, err := MatchSemver(c.versionRange)
+        if err != nil {
+            test.Fatalf("MatchSemver(%s) failed with error:\n%v", c.versionRange, err)
+        }
+        if match(c.pid) != c.expected {
+            test.Errorf("Range %s matching %s returned %v, expected %v",
+                c.versionRange, c.pid, !c.expected, c.expected)
+        }
+    }
+
+    if _, err := MatchSemver("/svc/1.y"); err == nil {
+        test.Errorf("MatchSemver() with invalid range succeeded, expected it to fail")
+    }
+}
+
+func TestSortByVersion(test *testing.T) {
+    pids := []protocol.ID{"/svc/1.2.0", "/svc/unversioned", "/svc/1.10.0", "/svc/1.9"}
+    expected := []protocol.ID{"/svc/1.10.0", "/svc/1.9", "/svc/1.2.0", "/svc/unversioned"}
+
+    if sorted := sortByVersion(pids); !reflect.DeepEqual(sorted, expected) {
+        test.Errorf("Got %v, expected %v", sorted, expected)
+    }
+}
@@ -0,0 +1,171 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "errors"
+    "log"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+)
+
+const (
+    // Default time Shutdown() lets open streams finish before closing
+    DefaultShutdownGracePeriod = 10 * time.Second
+
+    // How often Drain() checks whether open streams have finished
+    drainPollInterval = 100 * time.Millisecond
+)
+
+var (
+    // Returned when attempting to handle streams or advertise on a node
+    // that is draining or shut down
+    ErrDraining = errors.New("Not permitted on a draining node")
+)
+
+// Tracks whether a Node is draining or shut down
+type lifecycle struct {
+    mutex       sync.Mutex
+    draining    bool
+    shutdown    bool
+    started     time.Time
+}
+
+func (lc *lifecycle) isDraining() bool {
+    lc.mutex.Lock()
+    defer lc.mutex.Unlock()
+    return lc.draining
+}
+
+// Returns true if the Node is draining or shut down
+func (node *Node) Draining() bool {
+    return node.lifecycle.isDraining()
+}
+
+// Returns the number of streams currently open on the Node
+func (node *Node) openStreams() int {
+    count := 0
+    for _, conn := range node.Host().Network().Conns() {
+        count += len(conn.GetStreams())
+    }
+    return count
+}
+
+// Takes the Node out of service without closing it. Advertisements stop
+// being refreshed (but are kept in the state file, so they resume on
+// restart), stream handlers are removed so new streams are refused, and
+// further handlers and advertisements are rejected with ErrDraining. Then
+// waits until all open streams finish, or the context is done.
+func (node *Node) Drain(ctx context.Context) error {
+    lc := node.lifecycle
+    lc.mutex.Lock()
+    alreadyDraining := lc.draining
+    lc.draining = true
+    lc.mutex.Unlock()
+
+    if !alreadyDraining {
+        log.Println("Draining node")
+        node.emit(Event{Type: EventDraining})
+
+        for _, lease := range node.leases.list() {
+            lease.cancel()
+        }
+
+        reg := node.handlers
+        reg.mutex.Lock()
+        for pid := range reg.handlers {
+            node.Host().RemoveStreamHandler(pid)
+        }
+        reg.mutex.Unlock()
+    }
+
+    for node.openStreams() > 0 {
+        select {
+        case <-time.After(drainPollInterval):
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+    return nil
+}
+
+// Drains the Node (see Drain()), giving open streams up to 'grace' (or
+// DefaultShutdownGracePeriod if 0) to finish, then closes it. Streams still
+// open after the grace period are reset.
+func (node *Node) Shutdown(grace time.Duration) error {
+    lc := node.lifecycle
+    lc.mutex.Lock()
+    if lc.shutdown {
+        lc.mutex.Unlock()
+        return nil
+    }
+    lc.shutdown = true
+    lc.mutex.Unlock()
+
+    if grace <= 0 {
+        grace = DefaultShutdownGracePeriod
+    }
+    ctx, cancel := context.WithTimeout(node.Ctx, grace)
+    defer cancel()
+
+    if err := node.Drain(ctx); err != nil {
+        log.Printf("Grace period ended with %d streams still open\n", node.openStreams())
+    }
+
+    log.Println("Shutting down node")
+    node.emit(Event{Type: EventShutdown})
+    node.Close()
+    return node.Host().Close()
+}
+
+// Shuts the Node down when the process receives SIGTERM or SIGINT. A
+// second signal during the grace period closes the Node immediately.
+//
+// NOTE: Once a signal is handled the process no longer exits on its own.
+//       Applications should exit once the Node's context is done.
+func (node *Node) handleSignals(grace time.Duration) {
+    sigs := make(chan os.Signal, 2)
+    signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+    node.spawn("signals", func(ctx context.Context) {
+        defer signal.Stop(sigs)
+
+        select {
+        case sig := <-sigs:
+            log.Printf("Received %v, shutting down\n", sig)
+        case <-ctx.Done():
+            return
+        }
+
+        done := make(chan struct{})
+        node.spawn("shutdown", func(context.Context) {
+            defer close(done)
+            if err := node.Shutdown(grace); err != nil {
+                log.Printf("ERROR: Unable to shut down cleanly\n%v\n", err)
+            }
+        })
+
+        select {
+        case sig := <-sigs:
+            log.Printf("Received %v again, closing immediately\n", sig)
+            node.Close()
+        case <-done:
+        }
+    })
+}
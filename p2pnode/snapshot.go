@@ -0,0 +1,136 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+    "github.com/libp2p/go-libp2p-core/transport"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+// Point-in-time view of a Node's connectivity, as returned by Node.Snapshot()
+type NodeSnapshot struct {
+    Time            time.Time
+    ID              peer.ID
+    Addrs           []multiaddr.Multiaddr
+    Peers           []PeerSnapshot
+
+    // Peers currently being dialed. libp2p does not report dial failures,
+    // so a failed dial is listed until transport.DialTimeout elapses.
+    PendingDials    []peer.ID
+}
+
+type PeerSnapshot struct {
+    ID              peer.ID
+    Connectedness   network.Connectedness
+    Addrs           []multiaddr.Multiaddr
+    Conns           []ConnSnapshot
+}
+
+type ConnSnapshot struct {
+    LocalAddr       multiaddr.Multiaddr
+    RemoteAddr      multiaddr.Multiaddr
+    Direction       network.Direction
+    Streams         []StreamSnapshot
+}
+
+type StreamSnapshot struct {
+    Protocol        protocol.ID
+    Direction       network.Direction
+}
+
+// Tracks dials that have started but not yet produced a connection
+type dialTracker struct {
+    mutex   sync.Mutex
+    dials   map[peer.ID]time.Time
+}
+
+func newDialTracker() *dialTracker {
+    return &dialTracker{dials: make(map[peer.ID]time.Time)}
+}
+
+func (dt *dialTracker) started(id peer.ID) {
+    dt.mutex.Lock()
+    defer dt.mutex.Unlock()
+    dt.dials[id] = time.Now()
+}
+
+func (dt *dialTracker) finished(id peer.ID) {
+    dt.mutex.Lock()
+    defer dt.mutex.Unlock()
+    delete(dt.dials, id)
+}
+
+// Returns peers with dials in progress, dropping dials that have timed out
+func (dt *dialTracker) pending() []peer.ID {
+    dt.mutex.Lock()
+    defer dt.mutex.Unlock()
+
+    var ids []peer.ID
+    for id, start := range dt.dials {
+        if time.Since(start) > transport.DialTimeout {
+            delete(dt.dials, id)
+        } else {
+            ids = append(ids, id)
+        }
+    }
+    return ids
+}
+
+// Returns the Node's connected peers, their connections and open streams,
+// and any dials in progress. Intended for debugging and introspection tools.
+func (node *Node) Snapshot() NodeSnapshot {
+    h := node.Host()
+    snapshot := NodeSnapshot{
+        Time:           time.Now(),
+        ID:             h.ID(),
+        Addrs:          h.Addrs(),
+        PendingDials:   node.dials.pending(),
+    }
+
+    net := h.Network()
+    for _, id := range net.Peers() {
+        ps := PeerSnapshot{
+            ID:             id,
+            Connectedness:  net.Connectedness(id),
+            Addrs:          h.Peerstore().Addrs(id),
+        }
+
+        for _, conn := range net.ConnsToPeer(id) {
+            cs := ConnSnapshot{
+                LocalAddr:  conn.LocalMultiaddr(),
+                RemoteAddr: conn.RemoteMultiaddr(),
+                Direction:  conn.Stat().Direction,
+            }
+            for _, stream := range conn.GetStreams() {
+                cs.Streams = append(cs.Streams, StreamSnapshot{
+                    Protocol:   stream.Protocol(),
+                    Direction:  stream.Stat().Direction,
+                })
+            }
+            ps.Conns = append(ps.Conns, cs)
+        }
+
+        snapshot.Peers = append(snapshot.Peers, ps)
+    }
+
+    return snapshot
+}
@@ -0,0 +1,99 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+// Persisted record of a Lease
+type leaseRecord struct {
+    Rendezvous  string      `json:"rendezvous"`
+    Expiry      time.Time   `json:"expiry"`
+}
+
+// State snapshot of a Node that survives restarts
+type stateSnapshot struct {
+    Leases      []leaseRecord   `json:"leases"`
+}
+
+// Serializes writes to state and cache files
+var stateMutex sync.Mutex
+
+// Reads a state snapshot from file. A non-existent file is not an error,
+// and returns an empty snapshot.
+func loadState(stateFile string, sc *stateCipher) (stateSnapshot, error) {
+    var state stateSnapshot
+
+    stateFile, err := util.ExpandTilde(stateFile)
+    if err != nil {
+        return state, err
+    }
+
+    content, err := readStateFile(stateFile, sc)
+    if os.IsNotExist(err) {
+        return state, nil
+    } else if err != nil {
+        return state, err
+    }
+
+    err = json.Unmarshal(content, &state)
+    return state, err
+}
+
+// Writes a state snapshot to file, encrypted if the cipher is not nil
+func saveState(stateFile string, state stateSnapshot, sc *stateCipher) error {
+    stateFile, err := util.ExpandTilde(stateFile)
+    if err != nil {
+        return err
+    }
+
+    content, err := json.MarshalIndent(state, "", "    ")
+    if err != nil {
+        return err
+    }
+
+    return writeStateFile(stateFile, content, sc)
+}
+
+// Writes to a temporary file first and then renames it, so a crash
+// mid-write won't corrupt the existing file
+func writeFileAtomic(path string, content []byte) error {
+    stateMutex.Lock()
+    defer stateMutex.Unlock()
+
+    tmpFile, err := ioutil.TempFile(filepath.Dir(path), ".state-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmpFile.Name())
+
+    if _, err = tmpFile.Write(content); err != nil {
+        tmpFile.Close()
+        return err
+    }
+    if err = tmpFile.Close(); err != nil {
+        return err
+    }
+
+    return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,102 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestState(test *testing.T) {
+    dir, err := ioutil.TempDir("", "p2pnode-state")
+    if err != nil {
+        test.Fatalf("Unable to create temp dir:\n%v", err)
+    }
+    defer os.RemoveAll(dir)
+
+    stateFile := filepath.Join(dir, "state.json")
+
+    test.Run("LoadState-NonExistent", func(test *testing.T) {
+        state, err := loadState(stateFile, nil)
+        if err != nil || len(state.Leases) != 0 {
+            test.Errorf("loadState() of non-existent file returned %v, %v; expected empty state", state, err)
+        }
+    })
+
+    test.Run("SaveLoadState", func(test *testing.T) {
+        expiry := time.Now().Add(time.Hour).Round(0)
+        saved := stateSnapshot{
+            Leases: []leaseRecord{{Rendezvous: "hello", Expiry: expiry}},
+        }
+
+        if err := saveState(stateFile, saved, nil); err != nil {
+            test.Fatalf("saveState() failed:\n%v", err)
+        }
+
+        loaded, err := loadState(stateFile, nil)
+        if err != nil {
+            test.Fatalf("loadState() failed:\n%v", err)
+        }
+
+        if len(loaded.Leases) != 1 || loaded.Leases[0].Rendezvous != "hello" ||
+            !loaded.Leases[0].Expiry.Equal(expiry) {
+
+            test.Errorf("Loaded state %v does not match saved state %v", loaded, saved)
+        }
+    })
+
+    test.Run("LoadState-Corrupt", func(test *testing.T) {
+        if err := ioutil.WriteFile(stateFile, []byte("{not json"), 0600); err != nil {
+            test.Fatalf("Unable to write corrupt state file:\n%v", err)
+        }
+
+        if _, err := loadState(stateFile, nil); err == nil {
+            test.Errorf("loadState() of corrupt file succeeded, expected it to fail")
+        }
+    })
+
+    test.Run("LeaseTable-ConcurrentSave", func(test *testing.T) {
+        table := newLeaseTable(stateFile, nil, 0)
+
+        var wg sync.WaitGroup
+        for i := 0; i < 32; i++ {
+            wg.Add(1)
+            go func(i int) {
+                defer wg.Done()
+                lease := &Lease{Rendezvous: fmt.Sprint("rendezvous-", i)}
+                table.add(lease)
+                if i%2 == 0 {
+                    table.remove(lease)
+                }
+            }(i)
+        }
+        wg.Wait()
+
+        loaded, err := loadState(stateFile, nil)
+        if err != nil {
+            test.Fatalf("loadState() failed:\n%v", err)
+        }
+
+        if len(loaded.Leases) != len(table.list()) {
+            test.Errorf("State file has %d leases, expected %d",
+                        len(loaded.Leases), len(table.list()))
+        }
+    })
+}
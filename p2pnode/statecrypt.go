@@ -0,0 +1,129 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "errors"
+    "io"
+    "io/ioutil"
+)
+
+var (
+    // Returned when reading an encrypted file without Config.EncryptState
+    ErrStateEncrypted = errors.New("State is encrypted, but state encryption is not enabled")
+
+    // Prefix of encrypted files and datastore values, followed by the nonce
+    // and AES-256-GCM ciphertext
+    encryptedStateMagic = []byte("MTCENC1\n")
+
+    // Domain separation for deriving the state key from a secret
+    stateKeyLabel = []byte("physarumsm-state-encryption-v1")
+)
+
+// Encrypts and decrypts data the Node stores on disk. A nil stateCipher
+// leaves data in plaintext.
+type stateCipher struct {
+    aead cipher.AEAD
+}
+
+// Returns the cipher for the Config's state encryption settings, or nil if
+// state encryption is disabled
+func newStateCipher(config *Config) (*stateCipher, error) {
+    if !config.EncryptState {
+        return nil, nil
+    }
+
+    secret := config.StateSecret
+    if len(secret) == 0 {
+        // A generated key would make the state unreadable after a restart
+        if config.PrivKey == nil {
+            return nil, errors.New("EncryptState requires a StateSecret or PrivKey")
+        }
+        raw, err := config.PrivKey.Raw()
+        if err != nil {
+            return nil, err
+        }
+        secret = raw
+    }
+
+    mac := hmac.New(sha256.New, secret)
+    mac.Write(stateKeyLabel)
+    block, err := aes.NewCipher(mac.Sum(nil))
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    return &stateCipher{aead: aead}, nil
+}
+
+// Encrypts the data, or returns it as-is if the cipher is nil
+func (sc *stateCipher) seal(plaintext []byte) ([]byte, error) {
+    if sc == nil {
+        return plaintext, nil
+    }
+
+    nonce := make([]byte, sc.aead.NonceSize())
+    if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+        return nil, err
+    }
+
+    sealed := append([]byte{}, encryptedStateMagic...)
+    sealed = append(sealed, nonce...)
+    return sc.aead.Seal(sealed, nonce, plaintext, encryptedStateMagic), nil
+}
+
+// Decrypts data produced by seal(). Plaintext data is returned as-is, so
+// existing state is encrypted the next time it is written.
+func (sc *stateCipher) open(data []byte) ([]byte, error) {
+    if !bytes.HasPrefix(data, encryptedStateMagic) {
+        return data, nil
+    } else if sc == nil {
+        return nil, ErrStateEncrypted
+    }
+
+    data = data[len(encryptedStateMagic):]
+    if len(data) < sc.aead.NonceSize() {
+        return nil, errors.New("Encrypted state is truncated")
+    }
+    nonce, ciphertext := data[:sc.aead.NonceSize()], data[sc.aead.NonceSize():]
+    return sc.aead.Open(nil, nonce, ciphertext, encryptedStateMagic)
+}
+
+// Reads a file written by writeStateFile()
+func readStateFile(path string, sc *stateCipher) ([]byte, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return sc.open(content)
+}
+
+// Atomically writes a file, encrypted if the cipher is not nil
+func writeStateFile(path string, content []byte, sc *stateCipher) error {
+    sealed, err := sc.seal(content)
+    if err != nil {
+        return err
+    }
+    return writeFileAtomic(path, sealed)
+}
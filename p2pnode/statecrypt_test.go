@@ -0,0 +1,122 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "bytes"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    ds "github.com/ipfs/go-datastore"
+    "github.com/ipfs/go-datastore/query"
+    dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func TestStateCipher(test *testing.T) {
+    sc, err := newStateCipher(&Config{EncryptState: true, StateSecret: []byte("secret")})
+    if err != nil {
+        test.Fatalf("newStateCipher() failed:\n%v", err)
+    }
+    plaintext := []byte(`{"leases":[]}`)
+
+    test.Run("SealOpen", func(test *testing.T) {
+        sealed, err := sc.seal(plaintext)
+        if err != nil {
+            test.Fatalf("seal() failed:\n%v", err)
+        }
+        if bytes.Contains(sealed, plaintext) {
+            test.Errorf("Sealed data contains the plaintext")
+        }
+
+        opened, err := sc.open(sealed)
+        if err != nil || !bytes.Equal(opened, plaintext) {
+            test.Errorf("open() returned %q, %v; expected %q", opened, err, plaintext)
+        }
+
+        other, _ := newStateCipher(&Config{EncryptState: true, StateSecret: []byte("other")})
+        if _, err = other.open(sealed); err == nil {
+            test.Errorf("open() with a different secret succeeded, expected it to fail")
+        }
+
+        var none *stateCipher
+        if _, err = none.open(sealed); err != ErrStateEncrypted {
+            test.Errorf("open() without a cipher returned %v, expected ErrStateEncrypted", err)
+        }
+    })
+
+    test.Run("OpenPlaintext", func(test *testing.T) {
+        opened, err := sc.open(plaintext)
+        if err != nil || !bytes.Equal(opened, plaintext) {
+            test.Errorf("open() of plaintext returned %q, %v; expected it as-is", opened, err)
+        }
+    })
+
+    test.Run("RequiresKey", func(test *testing.T) {
+        if _, err := newStateCipher(&Config{EncryptState: true}); err == nil {
+            test.Errorf("newStateCipher() without a secret or key succeeded, expected it to fail")
+        }
+    })
+
+    test.Run("StateFile", func(test *testing.T) {
+        dir, err := ioutil.TempDir("", "p2pnode-statecrypt")
+        if err != nil {
+            test.Fatalf("Unable to create temp dir:\n%v", err)
+        }
+        defer os.RemoveAll(dir)
+        stateFile := filepath.Join(dir, "state.json")
+
+        saved := stateSnapshot{Leases: []leaseRecord{{Rendezvous: "hello"}}}
+        if err = saveState(stateFile, saved, sc); err != nil {
+            test.Fatalf("saveState() failed:\n%v", err)
+        }
+
+        content, _ := ioutil.ReadFile(stateFile)
+        if bytes.Contains(content, []byte("hello")) {
+            test.Errorf("Encrypted state file contains plaintext")
+        }
+
+        loaded, err := loadState(stateFile, sc)
+        if err != nil || len(loaded.Leases) != 1 || loaded.Leases[0].Rendezvous != "hello" {
+            test.Errorf("loadState() returned %v, %v; expected %v", loaded, err, saved)
+        }
+    })
+
+    test.Run("Datastore", func(test *testing.T) {
+        inner := dssync.MutexWrap(ds.NewMapDatastore())
+        store := newEncryptedDatastore(inner, sc)
+        key := ds.NewKey("/peers/a")
+
+        if err := store.Put(key, plaintext); err != nil {
+            test.Fatalf("Put() failed:\n%v", err)
+        }
+        if raw, _ := inner.Get(key); bytes.Equal(raw, plaintext) {
+            test.Errorf("Inner datastore holds the plaintext")
+        }
+        if value, err := store.Get(key); err != nil || !bytes.Equal(value, plaintext) {
+            test.Errorf("Get() returned %q, %v; expected %q", value, err, plaintext)
+        }
+
+        results, err := store.Query(query.Query{Prefix: "/peers"})
+        if err != nil {
+            test.Fatalf("Query() failed:\n%v", err)
+        }
+        entries, err := results.Rest()
+        if err != nil || len(entries) != 1 || !bytes.Equal(entries[0].Value, plaintext) {
+            test.Errorf("Query() returned %v, %v; expected one decrypted entry", entries, err)
+        }
+    })
+}
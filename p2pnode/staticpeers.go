@@ -0,0 +1,119 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+const (
+    // Connection manager tag protecting connections to static peers
+    StaticPeerTag = "static-peer"
+)
+
+// Peers the Node keeps permanently connected to (see Config.StaticPeers)
+type staticPeers struct {
+    peers   []peer.AddrInfo
+    // Signalled whenever the Node loses its last connection to the peer
+    lost    map[peer.ID]chan struct{}
+}
+
+func newStaticPeers(addrs []multiaddr.Multiaddr) (*staticPeers, error) {
+    infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+    if err != nil {
+        return nil, err
+    }
+
+    sp := &staticPeers{
+        peers:  infos,
+        lost:   make(map[peer.ID]chan struct{}, len(infos)),
+    }
+    for _, info := range infos {
+        sp.lost[info.ID] = make(chan struct{}, 1)
+    }
+    return sp, nil
+}
+
+// Watches the host for disconnections from the static peers
+func (sp *staticPeers) attach(h host.Host) {
+    h.Network().Notify(&network.NotifyBundle{
+        DisconnectedF: func(net network.Network, conn network.Conn) {
+            lost, ok := sp.lost[conn.RemotePeer()]
+            if !ok || net.Connectedness(conn.RemotePeer()) == network.Connected {
+                return
+            }
+            select {
+            case lost <- struct{}{}:
+            default:
+            }
+        },
+    })
+}
+
+// Starts keeping the Node connected to each static peer
+func (node *Node) maintainStaticPeers(policy ReconnectPolicy) {
+    policy = policy.withDefaults()
+    for _, info := range node.staticPeers.peers {
+        info := info
+        node.spawn("static-peer", func(ctx context.Context) {
+            node.keepConnected(ctx, info, node.staticPeers.lost[info.ID], policy)
+        })
+    }
+}
+
+// Connects to the peer, and reconnects whenever the connection is lost,
+// backing off according to the policy (but never giving up) until the
+// context is done
+func (node *Node) keepConnected(ctx context.Context, info peer.AddrInfo,
+    lost <-chan struct{}, policy ReconnectPolicy) {
+
+    for {
+        eb, _ := util.NewExpoBackoff(policy.InitialBackoff, policy.MaxBackoff)
+        for attempts := 0; node.Host().Network().Connectedness(info.ID) != network.Connected; attempts++ {
+            if attempts > 0 {
+                delay := util.Jitter(eb.Next(), policy.Jitter)
+                log.Printf("Connection to static peer %s failed, retrying in %v\n",
+                    info.ID, delay.Round(time.Second))
+                if util.SleepContext(ctx, delay) != nil {
+                    return
+                }
+            }
+
+            resolved := node.resolveAddrInfo(ctx, info)
+            if err := node.connect(ctx, resolved); err != nil {
+                log.Println(err)
+            } else {
+                log.Println("Connected to static peer:", info)
+            }
+        }
+
+        select {
+        case <-lost:
+            log.Printf("Connection to static peer %s lost, reconnecting...\n", info.ID)
+        case <-ctx.Done():
+            return
+        }
+    }
+}
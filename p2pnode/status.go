@@ -0,0 +1,93 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/PhysarumSM/common/buildinfo"
+)
+
+const (
+    StatusProtocolID = protocol.ID("/mtc/status/1.0")
+
+    // Upper bound on the size of a status response
+    maxStatusBytes = 64 * 1024
+)
+
+// Status of a node, as returned by Node.QueryStatus()
+type NodeStatus struct {
+    Peer        peer.ID
+    Build       buildinfo.Info
+    Health      HealthStatus
+    Uptime      time.Duration
+    // Rotations from the node's previous peer IDs to Peer
+    History     IdentityHistory
+}
+
+// Returns the status of this Node
+func (node *Node) Status() NodeStatus {
+    return NodeStatus{
+        Peer:       node.Host().ID(),
+        Build:      buildinfo.Get(),
+        Health:     node.Health(),
+        Uptime:     time.Since(node.lifecycle.started),
+        History:    node.IdentityHistory(),
+    }
+}
+
+// Responds to status queries with the Node's status as JSON
+func (node *Node) statusHandler(stream network.Stream) {
+    if err := json.NewEncoder(stream).Encode(node.Status()); err != nil {
+        stream.Reset()
+        return
+    }
+    stream.Close()
+}
+
+// Queries the status of a peer serving StatusProtocolID (see
+// Config.EnableStatus), e.g. to inventory which versions of each service
+// are running. Fails if the peer's identity history does not verify.
+func (node *Node) QueryStatus(ctx context.Context, id peer.ID) (NodeStatus, error) {
+    var status NodeStatus
+
+    stream, err := node.Host().NewStream(ctx, id, StatusProtocolID)
+    if err != nil {
+        return status, err
+    }
+    defer stream.Close()
+
+    if deadline, ok := ctx.Deadline(); ok {
+        stream.SetReadDeadline(deadline)
+    }
+    err = json.NewDecoder(io.LimitReader(stream, maxStatusBytes)).Decode(&status)
+    if err != nil {
+        stream.Reset()
+        return status, err
+    }
+
+    // Only trust a history that leads to the peer that answered
+    if err = status.History.Verify(id); err != nil {
+        return status, err
+    }
+    return status, nil
+}
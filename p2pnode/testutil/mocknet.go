@@ -0,0 +1,214 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package testutil runs p2pnode Nodes on libp2p's in-process mocknet, so
+// tests of discovery, reconnection and the like don't need real sockets.
+// Like commontest, helpers fail the test on error, and return a function
+// to release any resources they hold.
+package testutil
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "testing"
+
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/peer"
+    mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+
+    "github.com/multiformats/go-multiaddr"
+
+    "github.com/PhysarumSM/common/p2pnode"
+)
+
+// Set of Nodes on a shared mocknet. The first Node is the bootstrap of
+// the others, and every pair of Nodes is linked (able to connect) unless
+// the network is partitioned.
+type Network struct {
+    Mocknet mocknet.Mocknet
+    Nodes   []p2pnode.Node
+
+    ctx     context.Context
+    mutex   sync.Mutex
+    // Number of hosts created, used to give each a distinct address
+    hosts   int
+}
+
+// Starts 'n' Nodes on a new mocknet, based on p2pnode.NewTestConfig().
+// 'configure' (if not nil) may adjust the Config of each Node before it
+// is created; node 0 is the bootstrap of the others.
+func NewNetwork(tb testing.TB, n int,
+    configure func(i int, config *p2pnode.Config)) (*Network, func()) {
+
+    tb.Helper()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    net := &Network{Mocknet: mocknet.New(ctx), ctx: ctx}
+    cleanup := func() {
+        for _, node := range net.Nodes {
+            node.Close()
+        }
+        cancel()
+    }
+
+    for i := 0; i < n; i++ {
+        config := p2pnode.NewTestConfig()
+        if configure != nil {
+            configure(i, &config)
+        }
+        if _, err := net.addNode(config); err != nil {
+            cleanup()
+            tb.Fatalf("Unable to create node %d:\n%v", i, err)
+        }
+    }
+
+    return net, cleanup
+}
+
+// Starts another Node on the network, bootstrapping from node 0
+func (net *Network) AddNode(tb testing.TB, config p2pnode.Config) p2pnode.Node {
+    tb.Helper()
+
+    node, err := net.addNode(config)
+    if err != nil {
+        tb.Fatalf("Unable to create node:\n%v", err)
+    }
+    return node
+}
+
+func (net *Network) addNode(config p2pnode.Config) (p2pnode.Node, error) {
+    config.HostConstructor = net.newHost
+    config.BootstrapPeers = nil
+    if len(net.Nodes) > 0 {
+        addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(net.Nodes[0].Host()))
+        if err != nil {
+            return p2pnode.Node{}, err
+        }
+        config.BootstrapPeers = addrs
+    }
+
+    node, err := p2pnode.NewNode(net.ctx, config)
+    if err != nil {
+        if node.Close != nil {
+            node.Close()
+        }
+        return node, err
+    }
+
+    net.mutex.Lock()
+    net.Nodes = append(net.Nodes, node)
+    net.mutex.Unlock()
+    return node, nil
+}
+
+// Creates a host on the mocknet and links it to every other host. The
+// libp2p options are ignored. Used as Config.HostConstructor, so hosts
+// created when a Node rotates its identity join the mocknet too.
+func (net *Network) newHost(ctx context.Context, opts ...libp2p.Option) (host.Host, error) {
+    priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+    if err != nil {
+        return nil, err
+    }
+
+    net.mutex.Lock()
+    net.hosts++
+    addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/10.%d.%d.%d/tcp/4001",
+        (net.hosts >> 16) & 0xff, (net.hosts >> 8) & 0xff, net.hosts & 0xff))
+    net.mutex.Unlock()
+    if err != nil {
+        return nil, err
+    }
+
+    h, err := net.Mocknet.AddPeer(priv, addr)
+    if err != nil {
+        return nil, err
+    }
+    for _, other := range net.Mocknet.Peers() {
+        if other == h.ID() {
+            continue
+        }
+        if _, err = net.Mocknet.LinkPeers(h.ID(), other); err != nil {
+            h.Close()
+            return nil, err
+        }
+    }
+    return h, nil
+}
+
+// Returns the current peer ID of node 'i'
+func (net *Network) Peer(i int) peer.ID {
+    return net.Nodes[i].Host().ID()
+}
+
+// Connects nodes 'i' and 'j'
+func (net *Network) Connect(tb testing.TB, i, j int) {
+    tb.Helper()
+
+    if _, err := net.Mocknet.ConnectPeers(net.Peer(i), net.Peer(j)); err != nil {
+        tb.Fatalf("Unable to connect node %d to node %d:\n%v", i, j, err)
+    }
+}
+
+// Splits the network into the given groups of node indices. Nodes in
+// different groups are disconnected and can no longer reach each other.
+// A node left out of every group is cut off from all others.
+func (net *Network) Partition(tb testing.TB, groups ...[]int) {
+    tb.Helper()
+
+    group := make(map[int]int)
+    for g, members := range groups {
+        for _, i := range members {
+            group[i] = g + 1
+        }
+    }
+
+    for i := range net.Nodes {
+        for j := i + 1; j < len(net.Nodes); j++ {
+            if group[i] != 0 && group[i] == group[j] {
+                continue
+            }
+            a, b := net.Peer(i), net.Peer(j)
+            for _, link := range net.Mocknet.LinksBetweenPeers(a, b) {
+                if err := net.Mocknet.Unlink(link); err != nil {
+                    tb.Fatalf("Unable to unlink node %d from node %d:\n%v", i, j, err)
+                }
+            }
+            if err := net.Mocknet.DisconnectPeers(a, b); err != nil {
+                tb.Fatalf("Unable to disconnect node %d from node %d:\n%v", i, j, err)
+            }
+        }
+    }
+}
+
+// Links every pair of nodes again after a Partition(). Nodes reconnect on
+// their own (e.g. to their bootstraps), or through Connect().
+func (net *Network) Heal(tb testing.TB) {
+    tb.Helper()
+
+    for i := range net.Nodes {
+        for j := i + 1; j < len(net.Nodes); j++ {
+            a, b := net.Peer(i), net.Peer(j)
+            if len(net.Mocknet.LinksBetweenPeers(a, b)) > 0 {
+                continue
+            }
+            if _, err := net.Mocknet.LinkPeers(a, b); err != nil {
+                tb.Fatalf("Unable to link node %d to node %d:\n%v", i, j, err)
+            }
+        }
+    }
+}
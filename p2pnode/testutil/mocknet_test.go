@@ -0,0 +1,74 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package testutil
+
+import (
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/network"
+
+    "github.com/PhysarumSM/common/p2pnode"
+)
+
+func TestNetwork(test *testing.T) {
+    net, cleanup := NewNetwork(test, 3, nil)
+    defer cleanup()
+
+    connected := func(i, j int) bool {
+        return net.Nodes[i].Host().Network().Connectedness(net.Peer(j)) == network.Connected
+    }
+
+    test.Run("Bootstrap", func(test *testing.T) {
+        for i := 1; i < len(net.Nodes); i++ {
+            if !connected(i, 0) {
+                test.Errorf("Node %d is not connected to its bootstrap", i)
+            }
+        }
+    })
+
+    test.Run("Connect", func(test *testing.T) {
+        net.Connect(test, 1, 2)
+        if !connected(1, 2) || !connected(2, 1) {
+            test.Errorf("Node 1 and node 2 are not connected after Connect()")
+        }
+    })
+
+    test.Run("Partition", func(test *testing.T) {
+        net.Partition(test, []int{0, 1}, []int{2})
+        for _, i := range []int{0, 1} {
+            if connected(i, 2) || connected(2, i) {
+                test.Errorf("Node %d and node 2 are still connected after Partition()", i)
+            }
+            if _, err := net.Mocknet.ConnectPeers(net.Peer(i), net.Peer(2)); err == nil {
+                test.Errorf("Node %d connected to node 2 across a partition", i)
+            }
+        }
+    })
+
+    test.Run("Heal", func(test *testing.T) {
+        net.Heal(test)
+        net.Connect(test, 1, 2)
+        if !connected(1, 2) {
+            test.Errorf("Node 1 and node 2 are not connected after Heal()")
+        }
+    })
+
+    test.Run("AddNode", func(test *testing.T) {
+        net.AddNode(test, p2pnode.NewTestConfig())
+        if len(net.Nodes) != 4 || !connected(3, 0) {
+            test.Errorf("Added node is not connected to its bootstrap")
+        }
+    })
+}
@@ -0,0 +1,144 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "time"
+)
+
+// What to do when the system clock is found to be skewed
+type ClockSkewPolicy int
+
+const (
+    // Refuse to start the node
+    ClockSkewRefuse ClockSkewPolicy = iota
+
+    // Log a warning and continue
+    ClockSkewWarn
+)
+
+const (
+    // Default maximum tolerated difference from NTP time
+    DefaultMaxClockSkew = 30 * time.Second
+
+    // Timeout for querying a single NTP server
+    ntpTimeout = 5 * time.Second
+
+    ntpPort = "123"
+
+    // Seconds between the NTP epoch (1900) and the Unix epoch (1970)
+    ntpEpochOffset = 2208988800
+)
+
+// Converts a 64-bit NTP timestamp into a time.Time
+func ntpToTime(b []byte) time.Time {
+    secs := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpochOffset
+    frac := int64(binary.BigEndian.Uint32(b[4:8]))
+    nsecs := (frac * 1e9) >> 32
+    return time.Unix(secs, nsecs)
+}
+
+// Queries an NTP server ("host" or "host:port") using SNTP, returning the
+// offset of the local clock from the server's (positive if behind)
+func QueryClockOffset(ctx context.Context, server string) (time.Duration, error) {
+    if _, _, err := net.SplitHostPort(server); err != nil {
+        server = net.JoinHostPort(server, ntpPort)
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, ntpTimeout)
+    defer cancel()
+
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "udp", server)
+    if err != nil {
+        return 0, err
+    }
+    defer conn.Close()
+
+    deadline, _ := ctx.Deadline()
+    conn.SetDeadline(deadline)
+
+    // LI = 0, Version = 3, Mode = 3 (client)
+    req := make([]byte, 48)
+    req[0] = 0x1B
+
+    sent := time.Now()
+    if _, err = conn.Write(req); err != nil {
+        return 0, err
+    }
+
+    resp := make([]byte, 48)
+    n, err := conn.Read(resp)
+    if err != nil {
+        return 0, err
+    }
+    received := time.Now()
+
+    if n < 48 || resp[0] & 0x7 != 4 {
+        return 0, fmt.Errorf("Invalid NTP response from %s", server)
+    } else if resp[1] == 0 {
+        return 0, fmt.Errorf("NTP server %s sent kiss-of-death", server)
+    }
+
+    serverRecv := ntpToTime(resp[32:40])
+    serverSent := ntpToTime(resp[40:48])
+    offset := (serverRecv.Sub(sent) + serverSent.Sub(received)) / 2
+    return offset, nil
+}
+
+// Checks the system clock against the first NTP server that responds.
+// Returns an error if none respond, or the clock is skewed by more than
+// 'maxSkew' and the policy is ClockSkewRefuse.
+func checkClock(ctx context.Context, servers []string, maxSkew time.Duration,
+    policy ClockSkewPolicy) error {
+
+    if maxSkew <= 0 {
+        maxSkew = DefaultMaxClockSkew
+    }
+
+    var err error
+    for _, server := range servers {
+        var offset time.Duration
+        offset, err = QueryClockOffset(ctx, server)
+        if err != nil {
+            log.Printf("Unable to query NTP server %s\n%v\n", server, err)
+            continue
+        }
+
+        if offset > maxSkew || offset < -maxSkew {
+            msg := fmt.Sprintf("System clock is off by %v according to %s", offset, server)
+            if policy == ClockSkewWarn {
+                log.Println("WARNING:", msg)
+                return nil
+            }
+            return errors.New(msg)
+        }
+
+        log.Printf("System clock within %v of %s\n", offset, server)
+        return nil
+    }
+
+    if policy == ClockSkewWarn {
+        log.Println("WARNING: Unable to verify system clock against any NTP server")
+        return nil
+    }
+    return fmt.Errorf("Unable to verify system clock against any NTP server\n%w", err)
+}
@@ -0,0 +1,79 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/libp2p/go-libp2p"
+    quic "github.com/libp2p/go-libp2p-quic-transport"
+    "github.com/libp2p/go-tcp-transport"
+    ws "github.com/libp2p/go-ws-transport"
+)
+
+// Names of transports that can be listed in Config.Transports
+const (
+    TransportTCP  = "tcp"
+    TransportQUIC = "quic"
+    TransportWS   = "ws"
+)
+
+// Transport constructors, keyed by name
+var transports = map[string]interface{}{
+    TransportTCP:  tcp.NewTCPTransport,
+    TransportQUIC: quic.NewTransport,
+    TransportWS:   ws.New,
+}
+
+// Returns libp2p options enabling the transports listed in the Config.
+// If none are listed, no options are returned and libp2p's default
+// transports are used.
+func transportOpts(config *Config) ([]libp2p.Option, error) {
+    var opts []libp2p.Option
+
+    for _, name := range config.Transports {
+        name = strings.ToLower(name)
+        tpt, ok := transports[name]
+        if !ok {
+            return nil, fmt.Errorf("Unknown transport %s", name)
+        }
+
+        if name == TransportQUIC && config.PSK != nil {
+            return nil, fmt.Errorf("QUIC transport: %w", ErrPSKUnsupported)
+        }
+
+        opts = append(opts, libp2p.Transport(tpt))
+    }
+
+    // Catch listen addresses that none of the chosen transports can use,
+    // rather than have libp2p fail with a less obvious error
+    if len(config.Transports) > 0 {
+        enabled := make(map[string]bool)
+        for _, name := range config.Transports {
+            enabled[strings.ToLower(name)] = true
+        }
+
+        for _, addr := range config.ListenAddrs {
+            if strings.Contains(addr, "/ws") && !enabled[TransportWS] {
+                return nil, fmt.Errorf("Listen address %s requires the %s transport", addr, TransportWS)
+            } else if strings.Contains(addr, "/quic") && !enabled[TransportQUIC] {
+                return nil, fmt.Errorf("Listen address %s requires the %s transport", addr, TransportQUIC)
+            }
+        }
+    }
+
+    return opts, nil
+}
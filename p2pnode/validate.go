@@ -0,0 +1,216 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "crypto/rsa"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/libp2p/go-libp2p-core/crypto"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+var (
+    ErrHandlerMismatch         = errors.New("StreamHandlers and HandlerProtocolIDs must map one-to-one")
+    ErrEmptyHandler            = errors.New("Cannot have empty StreamHandler/HandlerProtocolID element")
+    ErrEmptyRendezvous         = errors.New("Cannot have empty Rendezvous element")
+    ErrKeyTooSmall             = fmt.Errorf("RSA keys must be at least %d bits", crypto.MinRsaKeyBits)
+    ErrConnMgrWatermarks       = errors.New("ConnMgrLowWater cannot exceed ConnMgrHighWater")
+    ErrHolePunchingUnsupported = errors.New("Hole punching is not supported by this version of libp2p")
+)
+
+// A problem with a single field of a Config
+type ConfigFieldError struct {
+    Field   string
+    Err     error
+}
+
+func (e *ConfigFieldError) Error() string {
+    return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *ConfigFieldError) Unwrap() error {
+    return e.Err
+}
+
+// Returned by Config.Validate() (and so NewNode) listing every problem
+// found with a Config
+type ConfigError struct {
+    Problems []*ConfigFieldError
+}
+
+func (e *ConfigError) Error() string {
+    msgs := make([]string, len(e.Problems))
+    for i, problem := range e.Problems {
+        msgs[i] = problem.Error()
+    }
+    return fmt.Sprintf("Invalid Config (%d problems): %s",
+        len(e.Problems), strings.Join(msgs, "; "))
+}
+
+// Returns true if any problem matches the target, as with errors.Is()
+func (e *ConfigError) Is(target error) bool {
+    for _, problem := range e.Problems {
+        if errors.Is(problem.Err, target) {
+            return true
+        }
+    }
+    return false
+}
+
+// Returns the size in bits of an RSA key, or 0 for other key types
+func rsaKeyBits(key crypto.PrivKey) (int, error) {
+    if key.Type() != crypto.RSA {
+        return 0, nil
+    }
+
+    raw, err := key.GetPublic().Raw()
+    if err != nil {
+        return 0, err
+    }
+    pub, err := x509.ParsePKIXPublicKey(raw)
+    if err != nil {
+        return 0, err
+    }
+    rsaPub, ok := pub.(*rsa.PublicKey)
+    if !ok {
+        return 0, errors.New("Not an RSA public key")
+    }
+    return rsaPub.N.BitLen(), nil
+}
+
+// Checks the Config for problems without any network activity, returning
+// a *ConfigError listing all of them, or nil if there are none
+func (config Config) Validate() error {
+    var problems []*ConfigFieldError
+    check := func(field string, err error) {
+        if err != nil {
+            problems = append(problems, &ConfigFieldError{Field: field, Err: err})
+        }
+    }
+
+    if config.PrivKey != nil {
+        bits, err := rsaKeyBits(config.PrivKey)
+        if err == nil && bits > 0 && bits < crypto.MinRsaKeyBits {
+            err = ErrKeyTooSmall
+        }
+        check("PrivKey", err)
+    }
+
+    if len(config.ListenAddrs) > 0 {
+        _, err := util.StringsToMultiaddrs(config.ListenAddrs)
+        check("ListenAddrs", err)
+    }
+
+    if len(config.HandlerProtocolIDs) != len(config.StreamHandlers) {
+        check("StreamHandlers", ErrHandlerMismatch)
+    } else {
+        for i := range config.HandlerProtocolIDs {
+            if config.HandlerProtocolIDs[i] == "" || config.StreamHandlers[i] == nil {
+                check(fmt.Sprintf("StreamHandlers[%d]", i), ErrEmptyHandler)
+            }
+        }
+    }
+
+    for i, rendezvous := range config.Rendezvous {
+        if rendezvous == "" {
+            check(fmt.Sprintf("Rendezvous[%d]", i), ErrEmptyRendezvous)
+        }
+    }
+
+    for i, addr := range config.BootstrapPeers {
+        _, err := peer.AddrInfoFromP2pAddr(addr)
+        check(fmt.Sprintf("BootstrapPeers[%d]", i), err)
+    }
+    check("BootstrapListURL", validateBootstrapList(&config))
+
+    if len(config.StaticPeers) > 0 {
+        _, err := newStaticPeers(config.StaticPeers)
+        check("StaticPeers", err)
+    }
+
+    _, err := newServiceFallbacks(&config)
+    check("StaticServices", err)
+
+    check("PSK", checkPrivateNetwork(&config))
+
+    for pid, policy := range config.AuthPolicies {
+        check(fmt.Sprintf("AuthPolicies[%s]", pid), policy.validate())
+    }
+
+    check("UserAgent", validateAgent(&config))
+
+    for i, middleware := range config.StreamMiddleware {
+        if middleware == nil {
+            check(fmt.Sprintf("StreamMiddleware[%d]", i), errors.New("Cannot be nil"))
+        }
+    }
+
+    if config.KeepAliveInterval < 0 {
+        check("KeepAliveInterval", fmt.Errorf("Cannot be negative: %v", config.KeepAliveInterval))
+    }
+    if config.PEXInterval < 0 {
+        check("PEXInterval", fmt.Errorf("Cannot be negative: %v", config.PEXInterval))
+    }
+    if config.PEXMaxPeers < 0 {
+        check("PEXMaxPeers", fmt.Errorf("Cannot be negative: %d", config.PEXMaxPeers))
+    }
+    if config.PEXMaxConns < 0 {
+        check("PEXMaxConns", fmt.Errorf("Cannot be negative: %d", config.PEXMaxConns))
+    }
+
+    if config.EnableHolePunching {
+        check("EnableHolePunching", ErrHolePunchingUnsupported)
+    }
+
+    if config.ConnMgrHighWater > 0 && config.ConnMgrLowWater > config.ConnMgrHighWater {
+        check("ConnMgrLowWater", ErrConnMgrWatermarks)
+    }
+
+    _, err = NewPeerGater(config.AllowPeers, config.DenyPeers,
+        config.AllowSubnets, config.DenySubnets, nil)
+    check("AllowSubnets/DenySubnets", err)
+
+    _, err = newWebhookNotifier(config.WebhookURL, config.WebhookEvents)
+    check("WebhookURL", err)
+
+    _, err = newStateCipher(&config)
+    check("EncryptState", err)
+
+    _, err = announceOpts(&config)
+    check("AnnounceAddrs", err)
+    _, err = transportOpts(&config)
+    check("Transports", err)
+    _, err = securityOpts(&config)
+    check("Security", err)
+    _, err = muxerOpts(&config)
+    check("Muxers", err)
+    check("DHTValidators", validateDHTConfig(&config))
+
+    if config.ObserverMode {
+        _, err = observerOpts(&config)
+        check("ObserverMode", err)
+    }
+
+    if len(problems) > 0 {
+        return &ConfigError{Problems: problems}
+    }
+    return nil
+}
@@ -0,0 +1,78 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+    "github.com/multiformats/go-multiaddr"
+)
+
+func TestConfigValidate(test *testing.T) {
+    if err := NewConfig().Validate(); err != nil {
+        test.Errorf("Validate() of default Config failed:\n%v", err)
+    }
+
+    noPeerID, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+    config := NewConfig()
+    config.HandlerProtocolIDs = []protocol.ID{"/a", "/b"}
+    config.StreamHandlers = []network.StreamHandler{func(network.Stream) {}}
+    config.Rendezvous = []string{"service", ""}
+    config.BootstrapPeers = []multiaddr.Multiaddr{noPeerID}
+
+    err := config.Validate()
+    var configErr *ConfigError
+    if !errors.As(err, &configErr) {
+        test.Fatalf("Validate() returned %v, expected a *ConfigError", err)
+    }
+    if len(configErr.Problems) != 3 {
+        test.Errorf("Validate() found %d problems, expected 3:\n%v", len(configErr.Problems), err)
+    }
+    if !errors.Is(err, ErrHandlerMismatch) || !errors.Is(err, ErrEmptyRendezvous) {
+        test.Errorf("Validate() error %v does not match the expected problems", err)
+    }
+
+    config = NewConfig()
+    config.EnableHolePunching = true
+    if err := config.Validate(); !errors.Is(err, ErrHolePunchingUnsupported) {
+        test.Errorf("Validate() with EnableHolePunching returned %v, expected ErrHolePunchingUnsupported", err)
+    }
+}
+
+func TestSentinelErrors(test *testing.T) {
+    config := NewConfig()
+    config.ObserverMode = true
+    config.EnableEcho = true
+    if _, err := observerOpts(&config); !errors.Is(err, ErrObserverMode) {
+        test.Errorf("observerOpts() returned %v, expected it to match ErrObserverMode", err)
+    }
+
+    failures := &bootstrapFailures{failures: make(map[peer.ID]*BootstrapConnectError)}
+    failures.record("bootstrap", errors.New("refused"))
+    failures.record("bootstrap", errors.New("refused"))
+
+    var err error = failures.toError(2, ErrNoBootstrapsReachable)
+    if !errors.Is(err, ErrNoBootstrapsReachable) {
+        test.Errorf("BootstrapError %v does not match ErrNoBootstrapsReachable", err)
+    }
+    var be *BootstrapError
+    if !errors.As(err, &be) || len(be.Failures) != 1 || be.Failures[0].Attempts != 2 {
+        test.Errorf("BootstrapError %v does not record 2 attempts to one bootstrap", err)
+    }
+}
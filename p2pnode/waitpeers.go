@@ -0,0 +1,86 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    // Interval at which waits re-check the condition, in case a change was
+    // missed (e.g. while the Node's identity was rotated)
+    waitForPeersRecheck = time.Second
+)
+
+// Blocks until the Node is connected to at least 'minPeers' peers, or the
+// context is done
+func (node *Node) WaitForPeers(ctx context.Context, minPeers int) error {
+    return node.waitConnected(ctx, func(net network.Network) bool {
+        return len(net.Peers()) >= minPeers
+    })
+}
+
+// Blocks until the Node is connected to the peer, or the context is done.
+// This does not dial the peer itself (see Host().Connect()).
+func (node *Node) WaitForPeer(ctx context.Context, id peer.ID) error {
+    return node.waitConnected(ctx, func(net network.Network) bool {
+        return net.Connectedness(id) == network.Connected
+    })
+}
+
+// Blocks until 'done' holds for the host's network, checking it whenever a
+// connection is opened
+func (node *Node) waitConnected(ctx context.Context, done func(network.Network) bool) error {
+    changed := make(chan struct{}, 1)
+    signal := func(network.Network, network.Conn) {
+        select {
+        case changed <- struct{}{}:
+        default:
+        }
+    }
+    notifee := &network.NotifyBundle{ConnectedF: signal}
+
+    net := node.Host().Network()
+    net.Notify(notifee)
+    defer func() { net.StopNotify(notifee) }()
+
+    ticker := time.NewTicker(waitForPeersRecheck)
+    defer ticker.Stop()
+
+    for {
+        // Follow the current host if it was swapped out
+        if current := node.Host().Network(); current != net {
+            net.StopNotify(notifee)
+            net = current
+            net.Notify(notifee)
+        }
+        if done(net) {
+            return nil
+        }
+
+        select {
+        case <-changed:
+        case <-ticker.C:
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-node.Ctx.Done():
+            return node.Ctx.Err()
+        }
+    }
+}
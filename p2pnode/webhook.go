@@ -0,0 +1,169 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/PhysarumSM/common/util"
+)
+
+const (
+    // Timeout for a single webhook POST
+    WebhookTimeout = 10 * time.Second
+
+    // Delivery is attempted this many times, backing off exponentially
+    // between WebhookRetryInterval and WebhookMaxRetryInterval
+    WebhookMaxAttempts      = 5
+    WebhookRetryInterval    = time.Second
+    WebhookMaxRetryInterval = 30 * time.Second
+
+    // Number of events waiting to be delivered, past which new events are
+    // dropped rather than delaying the Node
+    WebhookQueueSize = 64
+)
+
+// Events sent to the webhook if Config.WebhookEvents is empty. Per-peer and
+// per-stream events are left out, as a busy node has too many of them.
+var DefaultWebhookEvents = []EventType{
+    EventBootstrapLost,
+    EventBootstrapRecovered,
+    EventPeerBlocked,
+    EventAdvertiseExpired,
+}
+
+// JSON payload POSTed to the webhook
+type WebhookPayload struct {
+    Event       EventType   `json:"event"`
+    Node        string      `json:"node"`
+    Peer        string      `json:"peer,omitempty"`
+    Protocol    string      `json:"protocol,omitempty"`
+    Rendezvous  string      `json:"rendezvous,omitempty"`
+    Time        time.Time   `json:"time"`
+}
+
+// Delivers selected node events to an HTTP endpoint, one at a time
+type webhookNotifier struct {
+    url     string
+    events  map[EventType]bool
+    client  *http.Client
+    queue   chan WebhookPayload
+}
+
+// Returns nil if no URL is given, in which case events are dropped
+func newWebhookNotifier(url string, events []EventType) (*webhookNotifier, error) {
+    if url == "" {
+        return nil, nil
+    }
+
+    wn := &webhookNotifier{
+        url:    url,
+        events: make(map[EventType]bool),
+        client: &http.Client{Timeout: WebhookTimeout},
+        queue:  make(chan WebhookPayload, WebhookQueueSize),
+    }
+
+    if len(events) == 0 {
+        events = DefaultWebhookEvents
+    }
+    for _, event := range events {
+        if !eventTypes[event] {
+            return nil, fmt.Errorf("Unknown webhook event %q", event)
+        }
+        wn.events[event] = true
+    }
+
+    return wn, nil
+}
+
+// Delivers queued payloads until the context is done
+func (wn *webhookNotifier) run(ctx context.Context) {
+    for {
+        select {
+        case payload := <-wn.queue:
+            wn.deliver(ctx, payload)
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// POSTs the payload, retrying with backoff on failure until the context is
+// done
+func (wn *webhookNotifier) deliver(ctx context.Context, payload WebhookPayload) {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        log.Printf("ERROR: Unable to encode webhook payload\n%v\n", err)
+        return
+    }
+
+    eba, _ := util.NewExpoBackoffAttempts(WebhookRetryInterval,
+        WebhookMaxRetryInterval, WebhookMaxAttempts)
+    for eba.AttemptContext(ctx) {
+        var req *http.Request
+        req, err = http.NewRequest(http.MethodPost, wn.url, bytes.NewReader(body))
+        if err != nil {
+            break
+        }
+        req.Header.Set("Content-Type", "application/json")
+
+        var resp *http.Response
+        resp, err = wn.client.Do(req.WithContext(ctx))
+        if err == nil {
+            resp.Body.Close()
+            if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+                return
+            }
+            err = fmt.Errorf("Webhook returned status %s", resp.Status)
+        }
+    }
+    if err == nil {
+        err = ctx.Err()
+    }
+
+    log.Printf("ERROR: Unable to deliver %s webhook\n%v\n", payload.Event, err)
+}
+
+// Queues the event for the webhook, if one is configured and subscribed to
+// the event. Never blocks, as events are emitted from network callbacks.
+func (node *Node) notifyWebhook(evt Event) {
+    wn := node.webhook
+    if wn == nil || !wn.events[evt.Type] {
+        return
+    }
+
+    payload := WebhookPayload{
+        Event:      evt.Type,
+        Node:       node.Host().ID().Pretty(),
+        Protocol:   string(evt.Protocol),
+        Rendezvous: evt.Rendezvous,
+        Time:       evt.Time,
+    }
+    if evt.Peer != "" {
+        payload.Peer = evt.Peer.Pretty()
+    }
+
+    select {
+    case wn.queue <- payload:
+    default:
+        log.Printf("WARNING: Webhook queue is full, dropping %s event\n", evt.Type)
+    }
+}
@@ -0,0 +1,96 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestWebhookNotifier(test *testing.T) {
+    received := make(chan WebhookPayload, 1)
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var payload WebhookPayload
+        if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        received <- payload
+    }))
+    defer server.Close()
+
+    wn, err := newWebhookNotifier(server.URL, nil)
+    if err != nil {
+        test.Fatalf("newWebhookNotifier() failed:\n%v", err)
+    }
+    for _, event := range DefaultWebhookEvents {
+        if !wn.events[event] {
+            test.Errorf("Default webhook events are missing %s", event)
+        }
+    }
+    if wn.events[EventStreamOpened] || wn.events[EventPeerConnected] {
+        test.Errorf("Per-stream and per-peer events should not be sent by default")
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        wn.run(ctx)
+    }()
+
+    wn.queue <- WebhookPayload{Event: EventBootstrapLost}
+    select {
+    case payload := <-received:
+        if payload.Event != EventBootstrapLost {
+            test.Errorf("Webhook received %s, expected %s", payload.Event, EventBootstrapLost)
+        }
+    case <-time.After(5 * time.Second):
+        test.Fatalf("Webhook payload was not delivered")
+    }
+
+    cancel()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        test.Fatalf("Webhook worker did not stop with its context")
+    }
+}
+
+func TestWebhookRetriesStop(test *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusInternalServerError)
+    }))
+    defer server.Close()
+
+    wn, err := newWebhookNotifier(server.URL, nil)
+    if err != nil {
+        test.Fatalf("newWebhookNotifier() failed:\n%v", err)
+    }
+
+    // Retries back off for far longer than this, so deliver() must give up
+    // as soon as the context is done
+    ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    wn.deliver(ctx, WebhookPayload{Event: EventBootstrapLost})
+    if time.Since(start) > 5 * time.Second {
+        test.Errorf("deliver() kept retrying after its context was done")
+    }
+}
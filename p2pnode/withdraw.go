@@ -0,0 +1,141 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2pnode
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    // PubSub topic on which nodes announce rendezvous strings they stopped
+    // advertising, so peers can ignore their lingering provider records
+    WithdrawTopic = "/physarumsm/withdrawn"
+)
+
+// Announcement published on WithdrawTopic. A zero Expiry announces that
+// the sender is advertising the rendezvous string again.
+type withdrawal struct {
+    Rendezvous  string      `json:"rendezvous"`
+    Expiry      time.Time   `json:"expiry"`
+}
+
+// Tracks rendezvous strings withdrawn by this and other nodes
+type withdrawals struct {
+    mutex   sync.Mutex
+    // Rendezvous strings this node withdrew
+    own     map[string]bool
+    // Peers that withdrew each rendezvous string, until their records lapse
+    peers   map[string]map[peer.ID]time.Time
+}
+
+func newWithdrawals() *withdrawals {
+    return &withdrawals{
+        own:    make(map[string]bool),
+        peers:  make(map[string]map[peer.ID]time.Time),
+    }
+}
+
+func (w *withdrawals) record(id peer.ID, wd withdrawal) {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    if wd.Expiry.IsZero() || time.Now().After(wd.Expiry) {
+        delete(w.peers[wd.Rendezvous], id)
+        return
+    }
+    if w.peers[wd.Rendezvous] == nil {
+        w.peers[wd.Rendezvous] = make(map[peer.ID]time.Time)
+    }
+    w.peers[wd.Rendezvous][id] = wd.Expiry
+}
+
+// Returns true if the peer withdrew the rendezvous string and its records
+// have not lapsed yet
+func (w *withdrawals) withdrawn(rendezvous string, id peer.ID) bool {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    expiry, ok := w.peers[rendezvous][id]
+    if ok && time.Now().After(expiry) {
+        delete(w.peers[rendezvous], id)
+        return false
+    }
+    return ok
+}
+
+// Announces over PubSub (if enabled) that the Node withdrew the rendezvous
+// string, or with a zero expiry, that it is advertising it again
+func (node *Node) announceWithdrawal(rendezvous string, expiry time.Time) {
+    w := node.withdrawals
+    w.mutex.Lock()
+    wasWithdrawn := w.own[rendezvous]
+    if expiry.IsZero() {
+        delete(w.own, rendezvous)
+    } else {
+        w.own[rendezvous] = true
+    }
+    w.mutex.Unlock()
+
+    // Only announce re-advertisements of previously withdrawn strings
+    if node.pubsub == nil || (expiry.IsZero() && !wasWithdrawn) {
+        return
+    }
+
+    data, err := json.Marshal(withdrawal{Rendezvous: rendezvous, Expiry: expiry})
+    if err == nil {
+        err = node.Publish(WithdrawTopic, data)
+    }
+    if err != nil {
+        log.Printf("ERROR: Unable to announce withdrawal of %s\n%v\n", rendezvous, err)
+    }
+}
+
+// Records withdrawals announced by other nodes, so FindPeers() skips them
+func (node *Node) watchWithdrawals() error {
+    sub, err := node.Subscribe(WithdrawTopic)
+    if err != nil {
+        return err
+    }
+
+    node.spawnWith(node.hostContext(), "withdrawals", func(ctx context.Context) {
+        defer sub.Cancel()
+        for {
+            msg, err := sub.Next(ctx)
+            if err != nil {
+                return
+            }
+
+            // Messages are signed, so the sender can't withdraw for others
+            from := msg.GetFrom()
+            if from == node.Host().ID() {
+                continue
+            }
+
+            var wd withdrawal
+            if err = json.Unmarshal(msg.Data, &wd); err != nil || wd.Rendezvous == "" {
+                continue
+            }
+            node.withdrawals.record(from, wd)
+        }
+    })
+
+    return nil
+}
@@ -0,0 +1,193 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/PhysarumSM/common/p2pnode"
+    "github.com/PhysarumSM/common/util"
+)
+
+const (
+    // Byte written back by the receiver once a message has been handled
+    ackByte = byte(0x06)
+
+    // Defaults used when BroadcastOpts fields are left as zero-values
+    DefaultBroadcastTimeout       = 5 * time.Second
+    DefaultBroadcastRetryInterval = time.Second
+    DefaultBroadcastMaxRetryInterval = 30 * time.Second
+)
+
+// A named set of peers that messages can be broadcast to
+type PeerGroup struct {
+    Name    string
+    Peers   []peer.ID
+}
+
+type BroadcastOpts struct {
+    // How long to wait for each attempt to be acknowledged
+    Timeout         time.Duration
+
+    // Number of times to retry unacknowledged peers. Retries back off
+    // exponentially, starting from RetryInterval, up to MaxRetryInterval.
+    Retries             int
+    RetryInterval       time.Duration
+    MaxRetryInterval    time.Duration
+}
+
+// Outcome of a broadcast to a PeerGroup
+type DeliveryReport struct {
+    Group       string
+    Acked       []peer.ID
+    Failed      map[peer.ID]error
+    Attempts    map[peer.ID]int
+}
+
+// Returns true if every member of the group acknowledged the message
+func (report DeliveryReport) Complete() bool {
+    return len(report.Failed) == 0
+}
+
+// Returns a stream handler for receiving broadcasts. The callback is invoked
+// with the sender and message, and an acknowledgement is only sent back if
+// it returns without error.
+func AckHandler(handle func(peer.ID, []byte) error) network.StreamHandler {
+    return func(stream network.Stream) {
+        msg, err := ReadMsg(stream)
+        if err != nil {
+            return
+        }
+
+        if err = handle(stream.Conn().RemotePeer(), msg); err != nil {
+            log.Printf("ERROR: Unable to handle broadcast message\n%v\n", err)
+            stream.Reset()
+            return
+        }
+
+        WriteMsg(stream, []byte{ackByte})
+    }
+}
+
+// Sends the message once, and waits for an acknowledgement
+func sendWithAck(ctx context.Context, node p2pnode.Node, id peer.ID,
+    pid protocol.ID, msg []byte, timeout time.Duration) error {
+
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    stream, err := node.Host().NewStream(ctx, id, pid)
+    if err != nil {
+        return err
+    }
+
+    deadline, _ := ctx.Deadline()
+    stream.SetDeadline(deadline)
+
+    if _, err = stream.Write(msg); err != nil {
+        stream.Reset()
+        return err
+    }
+    stream.Close()
+
+    ack, err := ioutil.ReadAll(io.LimitReader(stream, 1))
+    if err != nil {
+        stream.Reset()
+        return err
+    } else if len(ack) != 1 || ack[0] != ackByte {
+        return errors.New("Peer did not acknowledge message")
+    }
+
+    return nil
+}
+
+// Sends a message to every member of a group using the given protocol
+// (whose handler should be created with AckHandler), retrying members that
+// do not acknowledge it, and returns a report of who received it. Retries
+// stop as soon as the context is done. An error is returned, without
+// sending anything, if the retry options are invalid.
+func Broadcast(ctx context.Context, node p2pnode.Node, group PeerGroup,
+    pid protocol.ID, msg []byte, opts BroadcastOpts) (DeliveryReport, error) {
+
+    if opts.Timeout <= 0 {
+        opts.Timeout = DefaultBroadcastTimeout
+    }
+    if opts.Retries < 0 {
+        opts.Retries = 0
+    }
+    if opts.RetryInterval <= 0 {
+        opts.RetryInterval = DefaultBroadcastRetryInterval
+    }
+    if opts.MaxRetryInterval <= 0 {
+        opts.MaxRetryInterval = DefaultBroadcastMaxRetryInterval
+    }
+
+    // Check the options once, rather than in every peer's goroutine
+    newBackoff := func() (*util.ExpoBackoffAttempts, error) {
+        return util.NewExpoBackoffAttempts(opts.RetryInterval,
+            opts.MaxRetryInterval, opts.Retries + 1)
+    }
+    if _, err := newBackoff(); err != nil {
+        return DeliveryReport{Group: group.Name}, fmt.Errorf("Invalid broadcast options: %w", err)
+    }
+
+    report := DeliveryReport{
+        Group:      group.Name,
+        Failed:     make(map[peer.ID]error),
+        Attempts:   make(map[peer.ID]int),
+    }
+
+    var mutex sync.Mutex
+    var wg sync.WaitGroup
+    for _, id := range group.Peers {
+        wg.Add(1)
+        go func(id peer.ID) {
+            defer wg.Done()
+
+            err := ctx.Err()
+            attempts := 0
+            eba, _ := newBackoff()
+            for eba.AttemptContext(ctx) {
+                attempts++
+                if err = sendWithAck(ctx, node, id, pid, msg, opts.Timeout); err == nil {
+                    break
+                }
+            }
+
+            mutex.Lock()
+            defer mutex.Unlock()
+            report.Attempts[id] = attempts
+            if err != nil {
+                report.Failed[id] = err
+            } else {
+                report.Acked = append(report.Acked, id)
+            }
+        }(id)
+    }
+    wg.Wait()
+
+    return report, nil
+}
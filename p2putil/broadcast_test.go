@@ -0,0 +1,97 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/PhysarumSM/common/p2pnode/testutil"
+)
+
+const testBroadcastProtocol = protocol.ID("/test/broadcast/1.0")
+
+func TestBroadcastRetries(test *testing.T) {
+    net, cleanup := testutil.NewNetwork(test, 2, nil)
+    defer cleanup()
+
+    // The receiver fails to handle the first two deliveries
+    var mutex sync.Mutex
+    received := 0
+    net.Nodes[1].Host().SetStreamHandler(testBroadcastProtocol,
+        AckHandler(func(peer.ID, []byte) error {
+            mutex.Lock()
+            defer mutex.Unlock()
+            received++
+            if received <= 2 {
+                return errors.New("not yet")
+            }
+            return nil
+        }))
+    group := PeerGroup{Name: "group", Peers: []peer.ID{net.Peer(1)}}
+
+    test.Run("Retried", func(test *testing.T) {
+        opts := BroadcastOpts{Timeout: time.Second, Retries: 2, RetryInterval: 10 * time.Millisecond}
+        report, err := Broadcast(context.Background(), net.Nodes[0], group,
+            testBroadcastProtocol, []byte("msg"), opts)
+        if err != nil {
+            test.Fatalf("Broadcast() failed:\n%v", err)
+        }
+        if !report.Complete() || report.Attempts[net.Peer(1)] != 3 {
+            test.Errorf("Expected delivery on the 3rd attempt, got %+v", report)
+        }
+    })
+
+    test.Run("ManyRetries", func(test *testing.T) {
+        opts := BroadcastOpts{Timeout: time.Second, Retries: 40, RetryInterval: time.Millisecond}
+        report, err := Broadcast(context.Background(), net.Nodes[0], group,
+            testBroadcastProtocol, []byte("msg"), opts)
+        if err != nil || !report.Complete() {
+            test.Errorf("Broadcast() with many retries returned %+v, %v", report, err)
+        }
+    })
+
+    test.Run("InvalidOpts", func(test *testing.T) {
+        opts := BroadcastOpts{RetryInterval: time.Minute, MaxRetryInterval: time.Second}
+        if _, err := Broadcast(context.Background(), net.Nodes[0], group,
+            testBroadcastProtocol, []byte("msg"), opts); err == nil {
+            test.Errorf("Broadcast() accepted MaxRetryInterval below RetryInterval")
+        }
+    })
+
+    test.Run("Cancelled", func(test *testing.T) {
+        unhandled := PeerGroup{Name: "unhandled", Peers: []peer.ID{net.Peer(1)}}
+        ctx, cancel := context.WithTimeout(context.Background(), 200 * time.Millisecond)
+        defer cancel()
+
+        start := time.Now()
+        opts := BroadcastOpts{Timeout: 100 * time.Millisecond, Retries: 5, RetryInterval: time.Hour}
+        report, err := Broadcast(ctx, net.Nodes[0], unhandled, "/test/unhandled/1.0",
+            []byte("msg"), opts)
+        if err != nil {
+            test.Fatalf("Broadcast() failed:\n%v", err)
+        }
+        if report.Complete() || time.Since(start) > 5 * time.Second {
+            test.Errorf("Expected failure soon after cancellation, got %+v after %v",
+                report, time.Since(start))
+        }
+    })
+}
@@ -0,0 +1,191 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p/p2p/protocol/ping"
+
+    "github.com/PhysarumSM/common/p2pnode"
+)
+
+const (
+    // Defaults used when BudgetOpts fields are left as zero-values
+    DefaultBudgetLookupFraction = 0.5
+    DefaultBudgetMaxProbes      = 3
+)
+
+var (
+    // Returned by FindPeerWithinBudget() if no peer was found in time
+    ErrBudgetExhausted = errors.New("No peer found within latency budget")
+)
+
+// Controls how FindPeerWithinBudget() spends its budget
+type BudgetOpts struct {
+    // Fraction of the budget spent on the DHT lookup. The rest is spent
+    // probing candidates.
+    LookupFraction  float64
+
+    // Maximum number of candidates probed, picked from those most likely to
+    // be good: already connected peers, then peers with the lowest
+    // previously observed latency
+    MaxProbes       int
+
+    // Measurements are taken from, and probe results stored in, this
+    // cache. Defaults to the node's NodePerfCache().
+    Cache           *PerfCache
+
+    // If set, the monitor's smoothed measurements are used instead of
+    // Cache's, and probed peers are tracked by it
+    Monitor         *PerfMonitor
+}
+
+// A candidate peer and what is known of it without probing
+type budgetCandidate struct {
+    info        peer.AddrInfo
+    // Fresh measurement from the cache, if 'cached' is set
+    perf        PerfInd
+    cached      bool
+    connected   bool
+    latency     time.Duration
+}
+
+// Orders candidates from most to least likely to be good: those with a
+// cached measurement by their performance, then connected peers, then by
+// the latency last observed by the peerstore
+func rankCandidates(candidates []budgetCandidate) {
+    sort.SliceStable(candidates, func(i, j int) bool {
+        ci, cj := candidates[i], candidates[j]
+        if ci.cached != cj.cached {
+            return ci.cached
+        } else if ci.cached {
+            return comparePerf(ci.perf, cj.perf, PerfScore) < 0
+        } else if ci.connected != cj.connected {
+            return ci.connected
+        } else if (ci.latency > 0) != (cj.latency > 0) {
+            return ci.latency > 0
+        }
+        return ci.latency < cj.latency
+    })
+}
+
+// Finds the best peer advertising the rendezvous string that can be found
+// within 'budget', for interactive requests that can't afford a full
+// search. Part of the budget is spent on the DHT lookup. If any candidate
+// has a fresh measurement in the cache (see BudgetOpts.Cache), the best of
+// them is returned without probing (PerfMethodCache). Otherwise only the
+// most promising candidates are pinged with what remains. If no probe
+// succeeds in time, the most promising candidate is returned with an
+// Unknown performance, or the latency observed previously (PerfMethodNone).
+func FindPeerWithinBudget(ctx context.Context, node p2pnode.Node, rendezvous string,
+    budget time.Duration, opts BudgetOpts) (PeerInfo, error) {
+
+    if opts.LookupFraction <= 0 || opts.LookupFraction >= 1 {
+        opts.LookupFraction = DefaultBudgetLookupFraction
+    }
+    if opts.MaxProbes <= 0 {
+        opts.MaxProbes = DefaultBudgetMaxProbes
+    }
+    cache := opts.Cache
+    if opts.Monitor != nil {
+        cache = opts.Monitor.Cache()
+    } else if cache == nil {
+        cache = NodePerfCache(node)
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, budget)
+    defer cancel()
+    lookupCtx, lookupCancel := context.WithTimeout(ctx,
+        time.Duration(float64(budget) * opts.LookupFraction))
+    defer lookupCancel()
+
+    // Keep whatever the lookup found once its slice of the budget is spent
+    found, _ := node.FindPeers(lookupCtx, rendezvous)
+    if len(found) == 0 {
+        return PeerInfo{}, ErrBudgetExhausted
+    }
+
+    h := node.Host()
+    candidates := make([]budgetCandidate, len(found))
+    for i, info := range found {
+        perf, cached := cache.GetPerf(info.ID)
+        candidates[i] = budgetCandidate{
+            info:       info,
+            perf:       perf,
+            cached:     cached,
+            connected:  h.Network().Connectedness(info.ID) == network.Connected,
+            latency:    h.Peerstore().LatencyEWMA(info.ID),
+        }
+    }
+    rankCandidates(candidates)
+
+    if candidates[0].cached {
+        best := candidates[0]
+        return PeerInfo{ID: best.info.ID, Perf: best.perf, PerfMethod: PerfMethodCache}, nil
+    }
+    if len(candidates) > opts.MaxProbes {
+        candidates = candidates[:opts.MaxProbes]
+    }
+
+    // Probe the candidates in parallel with the rest of the budget
+    type probe struct {
+        id  peer.ID
+        rtt time.Duration
+    }
+    probes := make(chan probe, len(candidates))
+    for _, c := range candidates {
+        go func(id peer.ID) {
+            var rtt time.Duration
+            result, ok := <-ping.Ping(ctx, h, id)
+            if ok && result.Error == nil {
+                rtt = result.RTT
+            }
+            probes <- probe{id: id, rtt: rtt}
+        }(c.info.ID)
+    }
+
+    var best PeerInfo
+    for range candidates {
+        p := <-probes
+        if p.rtt > 0 {
+            if opts.Monitor != nil {
+                opts.Monitor.observe(p.id, PerfInd{RTT: p.rtt}, true)
+            } else {
+                cache.Put(p.id, PerfInd{RTT: p.rtt})
+            }
+        }
+        if p.rtt > 0 && (best.ID == "" || p.rtt < best.Perf.RTT) {
+            best = PeerInfo{ID: p.id, Perf: PerfInd{RTT: p.rtt}, PerfMethod: PerfMethodPing}
+        }
+    }
+    if best.ID != "" {
+        return best, nil
+    }
+
+    fallback := candidates[0]
+    best = PeerInfo{ID: fallback.info.ID, PerfMethod: PerfMethodNone}
+    if fallback.latency > 0 {
+        best.Perf = PerfInd{RTT: fallback.latency}
+    } else {
+        best.Perf = PerfInd{Unknown: true}
+    }
+    return best, nil
+}
@@ -0,0 +1,43 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestRankCandidates(test *testing.T) {
+    candidates := []budgetCandidate{
+        {info: peer.AddrInfo{ID: peer.ID("unknown")}},
+        {info: peer.AddrInfo{ID: peer.ID("latency")}, latency: time.Millisecond},
+        {info: peer.AddrInfo{ID: peer.ID("connected")}, connected: true},
+        {info: peer.AddrInfo{ID: peer.ID("cached-slow")}, cached: true,
+            perf: PerfInd{RTT: 50 * time.Millisecond}},
+        {info: peer.AddrInfo{ID: peer.ID("cached-fast")}, cached: true,
+            perf: PerfInd{RTT: 5 * time.Millisecond}},
+    }
+
+    rankCandidates(candidates)
+
+    expected := []peer.ID{"cached-fast", "cached-slow", "connected", "latency", "unknown"}
+    for i, id := range expected {
+        if candidates[i].info.ID != id {
+            test.Errorf("Candidate %d is %s, expected %s", i, candidates[i].info.ID, id)
+        }
+    }
+}
@@ -0,0 +1,104 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "context"
+    "sync/atomic"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    // Defaults used when DrainOpts fields are left as zero-values
+    DefaultDrainMaxPeers        = 256
+    DefaultDrainMaxAddrsPerPeer = 16
+)
+
+// Total peers and addresses discarded by DrainPeers() across all calls
+var (
+    discardedPeers uint64
+    discardedAddrs uint64
+)
+
+// Limits on how much of a discovery channel DrainPeers() will buffer
+type DrainOpts struct {
+    MaxPeers        int
+    MaxAddrsPerPeer int
+}
+
+// Counts of what a single DrainPeers() call kept and discarded
+type DrainStats struct {
+    Received        int
+    Kept            int
+    DiscardedPeers  int
+    DiscardedAddrs  int
+}
+
+// Reads a discovery channel until it is closed or the context is done,
+// keeping at most MaxPeers unique peers with at most MaxAddrsPerPeer
+// addresses each. Peers past the limit are still read from the channel
+// (so the producer is not blocked) but are discarded.
+func DrainPeers(ctx context.Context, peerChan <-chan peer.AddrInfo,
+    opts DrainOpts) ([]peer.AddrInfo, DrainStats) {
+
+    if opts.MaxPeers <= 0 {
+        opts.MaxPeers = DefaultDrainMaxPeers
+    }
+    if opts.MaxAddrsPerPeer <= 0 {
+        opts.MaxAddrsPerPeer = DefaultDrainMaxAddrsPerPeer
+    }
+
+    var stats DrainStats
+    var peers []peer.AddrInfo
+    seen := make(map[peer.ID]bool)
+
+    for {
+        select {
+        case p, ok := <-peerChan:
+            if !ok {
+                return peers, stats
+            }
+            stats.Received++
+
+            if seen[p.ID] {
+                continue
+            } else if len(peers) >= opts.MaxPeers {
+                stats.DiscardedPeers++
+                atomic.AddUint64(&discardedPeers, 1)
+                continue
+            }
+            seen[p.ID] = true
+
+            if len(p.Addrs) > opts.MaxAddrsPerPeer {
+                extra := len(p.Addrs) - opts.MaxAddrsPerPeer
+                stats.DiscardedAddrs += extra
+                atomic.AddUint64(&discardedAddrs, uint64(extra))
+                p.Addrs = p.Addrs[:opts.MaxAddrsPerPeer:opts.MaxAddrsPerPeer]
+            }
+
+            peers = append(peers, p)
+            stats.Kept++
+        case <-ctx.Done():
+            return peers, stats
+        }
+    }
+}
+
+// Returns the total number of peers and addresses discarded by
+// DrainPeers() since the program started
+func DrainDiscarded() (peers uint64, addrs uint64) {
+    return atomic.LoadUint64(&discardedPeers), atomic.LoadUint64(&discardedAddrs)
+}
@@ -0,0 +1,54 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "context"
+    "testing"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+func TestDrainPeers(test *testing.T) {
+    addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+    addrs := []multiaddr.Multiaddr{addr, addr, addr}
+
+    peerChan := make(chan peer.AddrInfo, 8)
+    peerChan <- peer.AddrInfo{ID: peer.ID("a"), Addrs: addrs}
+    peerChan <- peer.AddrInfo{ID: peer.ID("a"), Addrs: addrs}
+    peerChan <- peer.AddrInfo{ID: peer.ID("b"), Addrs: addrs}
+    peerChan <- peer.AddrInfo{ID: peer.ID("c"), Addrs: addrs}
+    close(peerChan)
+
+    peers, stats := DrainPeers(context.Background(), peerChan,
+        DrainOpts{MaxPeers: 2, MaxAddrsPerPeer: 2})
+
+    if len(peers) != 2 || peers[0].ID != "a" || peers[1].ID != "b" {
+        test.Fatalf("Expected peers a and b to be kept, got %v", peers)
+    }
+
+    for _, p := range peers {
+        if len(p.Addrs) != 2 {
+            test.Errorf("Expected peer %s to have 2 addresses, got %d", p.ID, len(p.Addrs))
+        }
+    }
+
+    expected := DrainStats{Received: 4, Kept: 2, DiscardedPeers: 1, DiscardedAddrs: 2}
+    if stats != expected {
+        test.Errorf("Expected stats %+v, got %+v", expected, stats)
+    }
+}
@@ -0,0 +1,133 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "bufio"
+    "context"
+    "io"
+    "log"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+// Details of the stream a request arrived on, carried by the context given
+// to a ContextHandler
+type StreamInfo struct {
+    Peer        peer.ID
+    Protocol    protocol.ID
+    LocalAddr   multiaddr.Multiaddr
+    RemoteAddr  multiaddr.Multiaddr
+
+    // Direction and open time of the stream and its connection
+    Stream      network.Stat
+    Conn        network.Stat
+
+    // Number of streams open on the connection when the request arrived
+    ConnStreams int
+}
+
+type streamInfoKey struct{}
+
+// Returns the StreamInfo carried by a ContextHandler's context
+func StreamInfoFromContext(ctx context.Context) (StreamInfo, bool) {
+    info, ok := ctx.Value(streamInfoKey{}).(StreamInfo)
+    return info, ok
+}
+
+// Returns the remote peer carried by a ContextHandler's context
+func PeerFromContext(ctx context.Context) (peer.ID, bool) {
+    info, ok := StreamInfoFromContext(ctx)
+    return info.Peer, ok
+}
+
+// A single framed request, as received by a ContextHandler
+type Request struct {
+    Data    []byte
+}
+
+// Response to a Request. If Err is set, the stream is reset instead of
+// Data being sent.
+type Response struct {
+    Data    []byte
+    Err     error
+}
+
+// Handles a request with a context carrying the StreamInfo and, if a timeout
+// was given to NewContextHandler(), a deadline
+type ContextHandler func(ctx context.Context, req Request) Response
+
+func newStreamInfo(stream network.Stream) StreamInfo {
+    conn := stream.Conn()
+    return StreamInfo{
+        Peer:           conn.RemotePeer(),
+        Protocol:       stream.Protocol(),
+        LocalAddr:      conn.LocalMultiaddr(),
+        RemoteAddr:     conn.RemoteMultiaddr(),
+        Stream:         stream.Stat(),
+        Conn:           conn.Stat(),
+        ConnStreams:    len(conn.GetStreams()),
+    }
+}
+
+// Returns a stream handler that answers any number of framed requests on a
+// stream (like FramedHandler()), passing each to 'handle' with a context
+// carrying the stream's details. If 'timeout' is non-zero, each request
+// must be answered within it: the context's deadline is set accordingly and
+// applied to the stream.
+func NewContextHandler(handle ContextHandler, timeout time.Duration) network.StreamHandler {
+    return func(stream network.Stream) {
+        base := context.WithValue(context.Background(), streamInfoKey{}, newStreamInfo(stream))
+        reader := bufio.NewReader(stream)
+
+        for {
+            req, err := ReadFrame(reader)
+            if err == io.EOF {
+                stream.Close()
+                return
+            } else if err != nil {
+                stream.Reset()
+                return
+            }
+
+            ctx, cancel := base, context.CancelFunc(func() {})
+            if timeout > 0 {
+                ctx, cancel = context.WithTimeout(base, timeout)
+                deadline, _ := ctx.Deadline()
+                stream.SetDeadline(deadline)
+            }
+
+            resp := handle(ctx, Request{Data: req})
+            if resp.Err == nil {
+                resp.Err = WriteFrame(stream, resp.Data)
+            }
+            cancel()
+
+            if resp.Err != nil {
+                log.Printf("ERROR: Unable to handle request\n%v\n", resp.Err)
+                stream.Reset()
+                return
+            }
+            if timeout > 0 {
+                stream.SetDeadline(time.Time{})
+            }
+        }
+    }
+}
@@ -17,7 +17,9 @@ package p2putil
 import (
     "context"
     "io/ioutil"
+    "math"
     "sort"
+    "sync"
     "time"
 
     "github.com/libp2p/go-libp2p-core/network"
@@ -29,60 +31,372 @@ import (
 
 // Performance indicator
 type PerfInd struct {
-    RTT time.Duration
-    // TODO: Add more fields than RTT
+    RTT         time.Duration
+    // Mean difference between consecutive RTT samples. Zero if only one
+    // sample was taken.
+    Jitter      time.Duration
+    // Fraction of pings that went unanswered, from 0 to 1. Zero if only one
+    // ping was sent.
+    Loss        float64
+    // Throughput recently observed to and from the peer, in bytes per
+    // second (see p2pnode.Node.BandwidthForPeer()). Zero if there was no
+    // traffic to measure.
+    Bandwidth   float64
+    // Load reported by the peer, if a PeerLoadFunc was given
+    Load        *PeerLoad
+
+    // Set if performance could not be measured (e.g. the peer does not
+    // support ping). Unknown performance is always ranked after any
+    // measured performance.
+    Unknown     bool
+}
+
+// Resource usage reported by a peer, each as a fraction of its capacity
+// from 0 to 1
+type PeerLoad struct {
+    CPU     float64
+    Memory  float64
 }
 
+// Asks a peer for its load. The protocol used is up to the application.
+type PeerLoadFunc func(ctx context.Context, id peer.ID) (PeerLoad, error)
+
+// Scores measured performance for ranking, lower being better. Never called
+// with Unknown performance, which always ranks last.
+type PerfScorer func(PerfInd) float64
+
+// Scorer used by LessThan(), GreaterThan() and Equal(), and by SortPeers()
+// unless SortPeersOpts.Score is set. Should be replaced before any peers are
+// sorted, not while sorting is underway.
+var PerfScore PerfScorer = DefaultPerfScore
+
+// Scores performance by RTT, penalized by jitter, loss and load, so a
+// peer with all of those at zero scores its RTT in nanoseconds. Jitter is
+// added twice over, as replies may take that much longer than the RTT.
+// Loss inflates the score by the expected number of attempts per answered
+// request, and load by the fraction of the busiest resource in use. The
+// bandwidth estimate is not used, as it reflects demand rather than the
+// capacity of the path.
+func DefaultPerfScore(perf PerfInd) float64 {
+    if perf.Loss >= 1 {
+        return math.Inf(1)
+    }
+
+    score := float64(perf.RTT + 2 * perf.Jitter) / (1 - perf.Loss)
+    if perf.Load != nil {
+        score *= 1 + math.Max(perf.Load.CPU, perf.Load.Memory)
+    }
+    return score
+}
+
+// Compares l and r by 'score', returning a negative number if l performs
+// better, positive if r does, and 0 if they are equal
+func comparePerf(l, r PerfInd, score PerfScorer) int {
+    if l.Unknown || r.Unknown {
+        switch {
+        case l.Unknown == r.Unknown:
+            return 0
+        case r.Unknown:
+            return -1
+        default:
+            return 1
+        }
+    }
+
+    ls, rs := score(l), score(r)
+    switch {
+    case ls < rs:
+        return -1
+    case ls > rs:
+        return 1
+    default:
+        return 0
+    }
+}
+
+// How a peer's performance indicator was obtained
+type PerfMethod string
+
+const (
+    PerfMethodPing  PerfMethod = "ping"
+    // Taken from a PerfCache, having been measured recently
+    PerfMethodCache PerfMethod = "cache"
+    PerfMethodNone  PerfMethod = "none"
+)
+
 // PeerInfo holds information relative peer performance and contact information
 type PeerInfo struct {
     ID          peer.ID
     Perf        PerfInd
+    PerfMethod  PerfMethod
     ServName    string
     ServHash    string
 }
 
-// Compares whether l performance is less than r performance
-// TODO: Figure out how to handle comparison if PerfInd contains more
-//       than a single metric
+// Compares whether l performance is less than r performance, i.e. whether
+// l scores lower by PerfScore
 func (l PerfInd) LessThan(r PerfInd) bool {
-    return l.RTT < r.RTT
+    return comparePerf(l, r, PerfScore) < 0
 }
 
 func (l PerfInd) GreaterThan(r PerfInd) bool {
-    return l.RTT > r.RTT
+    return r.LessThan(l)
 }
 
 func (l PerfInd) Equal(r PerfInd) bool {
-    return l.RTT == r.RTT
+    return comparePerf(l, r, PerfScore) == 0
+}
+
+const (
+    // Maximum number of peers SortPeers() pings at once
+    SortPeersConcurrency = 16
+
+    // Time each peer has to answer SortPeers()'s pings
+    SortPeersPingTimeout = time.Second
+)
+
+// Options for SortPeersWithOpts(), trading accuracy for speed. Zero-value
+// fields fall back to the defaults noted below.
+type SortPeersOpts struct {
+    // Time each peer has to answer all of its pings. Defaults to
+    // SortPeersPingTimeout.
+    Timeout     time.Duration
+    // Stops reading peers from the channel once this many have been
+    // accepted. Unlimited if 0.
+    MaxPeers    int
+    // Maximum number of peers pinged at once. Defaults to
+    // SortPeersConcurrency.
+    Concurrency int
+    // Number of pings sent to each peer, whose RTTs are averaged. Defaults
+    // to 1. Jitter and loss are only measured with more than 1.
+    PingCount   int
+    // Ranks measured peers. Defaults to PerfScore.
+    Score       PerfScorer
+    // If set, each peer that answers its pings is also asked for its load,
+    // within the same timeout
+    Load        PeerLoadFunc
+    // Measurements are taken from, and stored in, this cache, so peers
+    // measured recently aren't pinged again. Defaults to the node's
+    // NodePerfCache().
+    Cache       *PerfCache
+    // Pings every peer, ignoring and bypassing the cache
+    NoCache     bool
+    // If set, peers are ranked by the monitor's smoothed measurements
+    // instead of Cache's. Peers it has no measurement for are pinged, then
+    // tracked by it, so later calls find them without pinging.
+    Monitor     *PerfMonitor
+}
+
+func (opts SortPeersOpts) withDefaults() SortPeersOpts {
+    if opts.Timeout <= 0 {
+        opts.Timeout = SortPeersPingTimeout
+    }
+    if opts.Concurrency <= 0 {
+        opts.Concurrency = SortPeersConcurrency
+    }
+    if opts.PingCount <= 0 {
+        opts.PingCount = 1
+    }
+    if opts.Score == nil {
+        opts.Score = PerfScore
+    }
+    if opts.Monitor != nil {
+        opts.Cache = opts.Monitor.Cache()
+    }
+    return opts
 }
 
 // Get performance indicators and return sorted peers based on it
+//
+// Peers are pinged concurrently (up to SortPeersConcurrency at once) as they
+// arrive on 'peerChan', each within SortPeersPingTimeout, unless they were
+// measured by the node within the last DefaultPerfCacheTTL (see GetPerf()). Peers that do not
+// respond in time are still returned, with an Unknown performance indicator
+// and PerfMethodNone, ranked after all measured peers. Peers with equal
+// performance keep the order they arrived in.
+//
+// If the node tracks reputation (see p2pnode.Config.Reputation), peers with
+// an unacceptable reputation are skipped, measured RTTs are recorded, and
+// peers with equal performance are ranked by reputation.
 func SortPeers(peerChan <-chan peer.AddrInfo, node p2pnode.Node) []PeerInfo {
+    return SortPeersWithOpts(peerChan, node, SortPeersOpts{})
+}
+
+// Like SortPeers(), but with the timeout, number of peers, concurrency and
+// number of pings per peer set by 'opts'. If MaxPeers is reached, the rest
+// of 'peerChan' is left unread, so the caller should cancel the search
+// feeding it.
+func SortPeersWithOpts(peerChan <-chan peer.AddrInfo, node p2pnode.Node,
+    opts SortPeersOpts) []PeerInfo {
+
     var peers []PeerInfo
+    tracker := node.Reputation()
+    opts = opts.withDefaults()
+    if opts.Cache == nil {
+        opts.Cache = NodePerfCache(node)
+    }
 
-    // Set context with 1 second timeout for ping results for *all* peers.
-    //
-    // TODO: Move towards long-term solution to query a database for peer
-    //       latency info, or some type of cache-like datastructure that's
-    //       automatically updated, so we don't have to explicitly ping.
-    ctx, cancel := context.WithTimeout(node.Ctx, time.Second)
+    var mutex sync.Mutex
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, opts.Concurrency)
     for p := range peerChan {
-        responseChan := ping.Ping(ctx, node.Host, p.ID)
-        result := <-responseChan
-        if len(p.Addrs) == 0 || result.RTT == 0 {
+        if len(p.Addrs) == 0 {
             continue
+        } else if tracker != nil && !tracker.Acceptable(p.ID) {
+            continue
+        }
+
+        // Reserve the peer's place, so ties keep the order of arrival
+        mutex.Lock()
+        i := len(peers)
+        peers = append(peers, PeerInfo{ID: p.ID})
+        mutex.Unlock()
+
+        var cached PerfInd
+        var ok bool
+        if !opts.NoCache {
+            cached, ok = opts.Cache.GetPerf(p.ID)
+        }
+        if ok {
+            mutex.Lock()
+            peers[i].Perf, peers[i].PerfMethod = cached, PerfMethodCache
+            mutex.Unlock()
+        } else {
+            wg.Add(1)
+            sem <- struct{}{}
+            go func(id peer.ID) {
+                defer wg.Done()
+                defer func() { <-sem }()
+
+                perf, method := measurePeer(node, id, opts.Timeout, opts.PingCount, opts.Load)
+                if opts.Monitor != nil {
+                    opts.Monitor.observe(id, perf, true)
+                } else if !opts.NoCache {
+                    opts.Cache.Put(id, perf)
+                }
+                mutex.Lock()
+                peers[i].Perf, peers[i].PerfMethod = perf, method
+                mutex.Unlock()
+            }(p.ID)
+        }
+
+        if opts.MaxPeers > 0 && i + 1 >= opts.MaxPeers {
+            break
         }
-        peers = append(peers, PeerInfo{Perf: PerfInd{RTT: result.RTT}, ID: p.ID})
     }
-    cancel()
+    wg.Wait()
 
-    sort.Slice(peers, func(i, j int) bool {
-        return peers[i].Perf.LessThan(peers[j].Perf)
+    sort.SliceStable(peers, func(i, j int) bool {
+        cmp := comparePerf(peers[i].Perf, peers[j].Perf, opts.Score)
+        if tracker != nil && cmp == 0 {
+            return tracker.Better(peers[i].ID, peers[j].ID)
+        }
+        return cmp < 0
     })
 
     return peers
 }
 
+// Pings the peer up to 'count' times within 'timeout', returning the mean
+// RTT and jitter of the pings answered, and the fraction that weren't.
+// Measured RTTs are recorded by the node's reputation tracker, if any.
+func pingPeer(node p2pnode.Node, id peer.ID, timeout time.Duration,
+    count int) (PerfInd, PerfMethod) {
+
+    ctx, cancel := context.WithTimeout(node.Ctx, timeout)
+    defer cancel()
+
+    var samples []time.Duration
+    for result := range ping.Ping(ctx, node.Host(), id) {
+        if result.Error == nil && result.RTT > 0 {
+            samples = append(samples, result.RTT)
+        }
+        if len(samples) >= count || result.Error != nil {
+            break
+        }
+    }
+    if len(samples) == 0 {
+        return PerfInd{Unknown: true}, PerfMethodNone
+    }
+
+    perf := summarizePings(samples, count)
+    bw := node.BandwidthForPeer(id)
+    perf.Bandwidth = bw.RateIn + bw.RateOut
+    if tracker := node.Reputation(); tracker != nil {
+        tracker.RecordLatency(id, perf.RTT)
+    }
+    return perf, PerfMethodPing
+}
+
+// Computes the mean RTT, the mean difference between consecutive RTTs, and
+// the fraction of 'sent' pings missing from 'samples'
+func summarizePings(samples []time.Duration, sent int) PerfInd {
+    var perf PerfInd
+    var total, deviation time.Duration
+    for i, rtt := range samples {
+        total += rtt
+        if i > 0 {
+            diff := rtt - samples[i - 1]
+            if diff < 0 {
+                diff = -diff
+            }
+            deviation += diff
+        }
+    }
+
+    perf.RTT = total / time.Duration(len(samples))
+    if len(samples) > 1 {
+        perf.Jitter = deviation / time.Duration(len(samples) - 1)
+    }
+    if sent > len(samples) {
+        perf.Loss = float64(sent - len(samples)) / float64(sent)
+    }
+    return perf
+}
+
+// Like pingPeer(), but also asks peers that answered for their load, if
+// 'load' is set. Peers that fail to report their load are still ranked, by
+// their other measurements.
+func measurePeer(node p2pnode.Node, id peer.ID, timeout time.Duration,
+    count int, load PeerLoadFunc) (PerfInd, PerfMethod) {
+
+    perf, method := pingPeer(node, id, timeout, count)
+    if load == nil || perf.Unknown {
+        return perf, method
+    }
+
+    ctx, cancel := context.WithTimeout(node.Ctx, timeout)
+    defer cancel()
+    if reported, err := load(ctx, id); err == nil {
+        perf.Load = &reported
+    }
+    return perf, method
+}
+
+// Like SortPeers(), but splits off peers whose RTT exceeds 'maxRTT' (if
+// non-zero), or whose performance is unknown, as degraded. Both lists are
+// sorted, so latency-sensitive services can pick from 'acceptable' and only
+// fall back to 'degraded' deliberately.
+func SortPeersWithin(peerChan <-chan peer.AddrInfo, node p2pnode.Node,
+    maxRTT time.Duration) (acceptable []PeerInfo, degraded []PeerInfo) {
+
+    return partitionByRTT(SortPeers(peerChan, node), maxRTT)
+}
+
+// Splits peers into those within 'maxRTT' and the rest, preserving order
+func partitionByRTT(peers []PeerInfo,
+    maxRTT time.Duration) (acceptable []PeerInfo, degraded []PeerInfo) {
+
+    for _, p := range peers {
+        if maxRTT > 0 && (p.Perf.Unknown || p.Perf.RTT > maxRTT) {
+            degraded = append(degraded, p)
+        } else {
+            acceptable = append(acceptable, p)
+        }
+    }
+    return acceptable, degraded
+}
+
 // Read from stream
 func ReadMsg(stream network.Stream) (data []byte, err error) {
     data, err = ioutil.ReadAll(stream)
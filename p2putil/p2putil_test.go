@@ -0,0 +1,197 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "testing"
+    "time"
+
+    "github.com/PhysarumSM/common/p2pnode/testutil"
+)
+
+func TestPerfIndCompare(test *testing.T) {
+    fast := PerfInd{RTT: 10 * time.Millisecond}
+    slow := PerfInd{RTT: 100 * time.Millisecond}
+    unknown := PerfInd{Unknown: true}
+
+    testCases := []struct {
+        name    string
+        l, r    PerfInd
+        less    bool
+        greater bool
+        equal   bool
+    }{
+        {"Fast-Slow", fast, slow, true, false, false},
+        {"Slow-Fast", slow, fast, false, true, false},
+        {"Fast-Fast", fast, fast, false, false, true},
+        {"Slow-Unknown", slow, unknown, true, false, false},
+        {"Unknown-Fast", unknown, fast, false, true, false},
+        {"Unknown-Unknown", unknown, unknown, false, false, true},
+        {"Fast-Jittery", fast, PerfInd{RTT: 10 * time.Millisecond, Jitter: time.Millisecond},
+            true, false, false},
+        {"Lossy-Fast", PerfInd{RTT: 10 * time.Millisecond, Loss: 0.5}, fast, false, true, false},
+        {"Loaded-Fast", PerfInd{RTT: 10 * time.Millisecond, Load: &PeerLoad{CPU: 0.9}}, fast,
+            false, true, false},
+        {"Lost-Unknown", PerfInd{RTT: 10 * time.Millisecond, Loss: 1}, unknown, true, false, false},
+    }
+
+    for _, testCase := range testCases {
+        test.Run(testCase.name, func(test *testing.T) {
+            if testCase.l.LessThan(testCase.r) != testCase.less {
+                test.Errorf("LessThan() returned %v, expected %v",
+                    !testCase.less, testCase.less)
+            }
+            if testCase.l.GreaterThan(testCase.r) != testCase.greater {
+                test.Errorf("GreaterThan() returned %v, expected %v",
+                    !testCase.greater, testCase.greater)
+            }
+            if testCase.l.Equal(testCase.r) != testCase.equal {
+                test.Errorf("Equal() returned %v, expected %v",
+                    !testCase.equal, testCase.equal)
+            }
+        })
+    }
+}
+
+func TestPartitionByRTT(test *testing.T) {
+    peers := []PeerInfo{
+        {ID: "fast", Perf: PerfInd{RTT: 10 * time.Millisecond}},
+        {ID: "slow", Perf: PerfInd{RTT: 300 * time.Millisecond}},
+        {ID: "unknown", Perf: PerfInd{Unknown: true}},
+    }
+
+    acceptable, degraded := partitionByRTT(peers, 100 * time.Millisecond)
+    if len(acceptable) != 1 || acceptable[0].ID != "fast" {
+        test.Errorf("Acceptable peers are %v, expected only the fast peer", acceptable)
+    }
+    if len(degraded) != 2 || degraded[0].ID != "slow" || degraded[1].ID != "unknown" {
+        test.Errorf("Degraded peers are %v, expected the slow and unknown peers", degraded)
+    }
+
+    acceptable, degraded = partitionByRTT(peers, 0)
+    if len(acceptable) != len(peers) || len(degraded) != 0 {
+        test.Errorf("Zero threshold degraded %v, expected no peers", degraded)
+    }
+}
+
+func TestSortPeersOptsDefaults(test *testing.T) {
+    opts := SortPeersOpts{}.withDefaults()
+    if opts.Timeout != SortPeersPingTimeout || opts.Concurrency != SortPeersConcurrency ||
+        opts.PingCount != 1 || opts.MaxPeers != 0 || opts.Cache != nil ||
+        opts.Score == nil {
+        test.Errorf("Unexpected defaults %+v", opts)
+    }
+
+    set := SortPeersOpts{Timeout: time.Minute, MaxPeers: 3, Concurrency: 2, PingCount: 5,
+        Cache: NewPerfCache(time.Second)}
+    opts = set.withDefaults()
+    if opts.Timeout != set.Timeout || opts.MaxPeers != set.MaxPeers ||
+        opts.Concurrency != set.Concurrency || opts.PingCount != set.PingCount ||
+        opts.Cache != set.Cache {
+        test.Errorf("withDefaults() changed set options to %+v", opts)
+    }
+}
+
+func TestPerfCache(test *testing.T) {
+    cache := NewPerfCache(50 * time.Millisecond)
+    cache.Put("measured", PerfInd{RTT: 10 * time.Millisecond})
+    cache.Put("unknown", PerfInd{Unknown: true})
+
+    if perf, ok := cache.GetPerf("measured"); !ok || perf.RTT != 10 * time.Millisecond {
+        test.Errorf("GetPerf() returned %v, %v; expected the cached RTT", perf, ok)
+    }
+    if _, ok := cache.GetPerf("unknown"); ok {
+        test.Errorf("Unknown performance should not be cached")
+    }
+
+    time.Sleep(100 * time.Millisecond)
+    if _, ok := cache.GetPerf("measured"); ok {
+        test.Errorf("GetPerf() returned an expired measurement")
+    }
+}
+
+func TestPeerPerfStatsUpdate(test *testing.T) {
+    var stats PeerPerfStats
+    now := time.Now()
+
+    stats.update(PerfInd{RTT: 100 * time.Millisecond}, 0.5, now)
+    if stats.RTT != 100 * time.Millisecond || stats.Jitter != 0 || stats.Samples != 1 {
+        test.Fatalf("First sample should set the RTT, got %+v", stats)
+    }
+
+    stats.update(PerfInd{RTT: 200 * time.Millisecond}, 0.5, now)
+    if stats.RTT != 150 * time.Millisecond || stats.Jitter != 50 * time.Millisecond {
+        test.Errorf("Expected RTT 150ms and jitter 50ms, got %+v", stats)
+    }
+
+    stats.update(PerfInd{Unknown: true}, 0.5, now)
+    if stats.Failures != 1 || stats.Samples != 2 || stats.RTT != 150 * time.Millisecond {
+        test.Errorf("Unanswered ping should only count a failure, got %+v", stats)
+    }
+
+    stats.update(PerfInd{RTT: 150 * time.Millisecond}, 0.5, now)
+    if stats.Failures != 0 {
+        test.Errorf("Answered ping should reset failures, got %+v", stats)
+    }
+}
+
+func TestSummarizePings(test *testing.T) {
+    samples := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 15 * time.Millisecond}
+    perf := summarizePings(samples, 4)
+    if perf.RTT != 15 * time.Millisecond {
+        test.Errorf("Expected mean RTT of 15ms, got %v", perf.RTT)
+    }
+    if perf.Jitter != 7500 * time.Microsecond {
+        test.Errorf("Expected jitter of 7.5ms, got %v", perf.Jitter)
+    }
+    if perf.Loss != 0.25 {
+        test.Errorf("Expected loss of 0.25, got %v", perf.Loss)
+    }
+
+    single := summarizePings(samples[:1], 1)
+    if single.Jitter != 0 || single.Loss != 0 {
+        test.Errorf("Single sample should have no jitter or loss, got %+v", single)
+    }
+}
+
+func TestSortPeersCustomScore(test *testing.T) {
+    byLoss := PerfScorer(func(perf PerfInd) float64 { return perf.Loss })
+    lossy := PerfInd{RTT: time.Millisecond, Loss: 0.1}
+    slow := PerfInd{RTT: time.Second}
+    if comparePerf(slow, lossy, byLoss) >= 0 {
+        test.Errorf("Custom scorer should rank the lossless peer first")
+    }
+    if !lossy.LessThan(slow) {
+        test.Errorf("Default scorer should rank the faster peer first")
+    }
+}
+
+func TestNodePerfCache(test *testing.T) {
+    net, cleanup := testutil.NewNetwork(test, 2, nil)
+    defer cleanup()
+
+    cache := NodePerfCache(net.Nodes[0])
+    if NodePerfCache(net.Nodes[0]) != cache {
+        test.Errorf("NodePerfCache() returned different caches for the same node")
+    }
+
+    cache.Put(net.Peer(1), PerfInd{RTT: time.Millisecond})
+    if _, ok := GetPerf(net.Nodes[1], net.Peer(1)); ok {
+        test.Errorf("One node's measurement was served to another")
+    }
+    if _, ok := GetPerf(net.Nodes[0], net.Peer(1)); !ok {
+        test.Errorf("GetPerf() did not return the node's own measurement")
+    }
+}
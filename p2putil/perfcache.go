@@ -0,0 +1,126 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/PhysarumSM/common/p2pnode"
+)
+
+const (
+    // How long measurements stay in the caches returned by NodePerfCache()
+    DefaultPerfCacheTTL = 30 * time.Second
+
+    // Cache size past which expired measurements are pruned on insertion
+    perfCachePruneSize = 1024
+)
+
+// Caches used by SortPeers() calls that don't set SortPeersOpts.Cache, one
+// per local peer ID, as RTTs measured by one Node say little about another's
+var (
+    nodePerfCachesMutex sync.Mutex
+    nodePerfCaches      = make(map[peer.ID]*PerfCache)
+)
+
+type perfEntry struct {
+    perf        PerfInd
+    measured    time.Time
+}
+
+// Recent performance measurements of peers, each kept for a TTL, so peers
+// found again shortly after being measured don't need to be measured again
+type PerfCache struct {
+    mutex   sync.Mutex
+    ttl     time.Duration
+    entries map[peer.ID]perfEntry
+}
+
+func NewPerfCache(ttl time.Duration) *PerfCache {
+    return &PerfCache{ttl: ttl, entries: make(map[peer.ID]perfEntry)}
+}
+
+// Records a measurement of the peer, replacing any earlier one. Unknown
+// performance is not cached, so unresponsive peers are retried.
+func (cache *PerfCache) Put(id peer.ID, perf PerfInd) {
+    if perf.Unknown {
+        return
+    }
+
+    cache.mutex.Lock()
+    defer cache.mutex.Unlock()
+
+    now := time.Now()
+    if len(cache.entries) >= perfCachePruneSize {
+        for other, entry := range cache.entries {
+            if now.Sub(entry.measured) > cache.ttl {
+                delete(cache.entries, other)
+            }
+        }
+    }
+    cache.entries[id] = perfEntry{perf: perf, measured: now}
+}
+
+// Returns the peer's last measurement, if it has not expired
+func (cache *PerfCache) GetPerf(id peer.ID) (PerfInd, bool) {
+    cache.mutex.Lock()
+    defer cache.mutex.Unlock()
+
+    entry, ok := cache.entries[id]
+    if !ok {
+        return PerfInd{}, false
+    } else if time.Since(entry.measured) > cache.ttl {
+        delete(cache.entries, id)
+        return PerfInd{}, false
+    }
+    return entry.perf, true
+}
+
+// Drops the peer's measurement, e.g. after its connection changed
+func (cache *PerfCache) Forget(id peer.ID) {
+    cache.mutex.Lock()
+    defer cache.mutex.Unlock()
+    delete(cache.entries, id)
+}
+
+// Returns the cache of measurements taken by the Node, which SortPeers()
+// uses by default. The cache is dropped once the Node is closed.
+func NodePerfCache(node p2pnode.Node) *PerfCache {
+    id := node.Host().ID()
+
+    nodePerfCachesMutex.Lock()
+    defer nodePerfCachesMutex.Unlock()
+    cache, ok := nodePerfCaches[id]
+    if !ok {
+        cache = NewPerfCache(DefaultPerfCacheTTL)
+        nodePerfCaches[id] = cache
+        go func() {
+            <-node.Ctx.Done()
+            nodePerfCachesMutex.Lock()
+            defer nodePerfCachesMutex.Unlock()
+            delete(nodePerfCaches, id)
+        }()
+    }
+    return cache
+}
+
+// Returns the Node's last measurement of the peer in NodePerfCache(), if it
+// has not expired
+func GetPerf(node p2pnode.Node, id peer.ID) (PerfInd, bool) {
+    return NodePerfCache(node).GetPerf(id)
+}
@@ -0,0 +1,279 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/PhysarumSM/common/p2pnode"
+)
+
+const (
+    // Defaults used when PerfMonitorOpts fields are left as zero-values
+    DefaultPerfMonitorInterval = 10 * time.Second
+    DefaultPerfMonitorAlpha    = 0.125
+
+    // Number of intervals a monitored measurement stays valid for, so a
+    // round or two of lost pings doesn't evict a peer from the cache
+    perfMonitorTTLIntervals = 3
+)
+
+type PerfMonitorOpts struct {
+    // Time between rounds of pings to the tracked peers. Defaults to
+    // DefaultPerfMonitorInterval.
+    Interval    time.Duration
+    // Time each peer has to answer its ping. Defaults to
+    // SortPeersPingTimeout.
+    Timeout     time.Duration
+    // Maximum number of peers pinged at once. Defaults to
+    // SortPeersConcurrency.
+    Concurrency int
+    // Weight of each new sample in the smoothed RTT and jitter, between 0
+    // and 1. Defaults to DefaultPerfMonitorAlpha.
+    Alpha       float64
+    // If set, each peer that answers its ping is also asked for its load
+    Load        PeerLoadFunc
+}
+
+// Smoothed measurements of a peer tracked by a PerfMonitor
+type PeerPerfStats struct {
+    // Exponentially weighted moving average of the peer's RTT
+    RTT             time.Duration
+    // Exponentially weighted moving average of how far each RTT sample
+    // strayed from the smoothed RTT
+    Jitter          time.Duration
+    // Exponentially weighted moving average of the fraction of pings that
+    // went unanswered
+    Loss            float64
+    // Most recent throughput estimate and reported load
+    Bandwidth       float64
+    Load            *PeerLoad
+    // Number of RTT samples taken
+    Samples         int
+    // Number of consecutive pings the peer did not answer
+    Failures        int
+    LastMeasured    time.Time
+}
+
+// Folds a new measurement into the smoothed stats
+func (stats *PeerPerfStats) update(perf PerfInd, alpha float64, now time.Time) {
+    if perf.Unknown {
+        stats.Loss += alpha * (1 - stats.Loss)
+        stats.Failures++
+        return
+    }
+
+    stats.Loss += alpha * (perf.Loss - stats.Loss)
+    stats.Bandwidth, stats.Load = perf.Bandwidth, perf.Load
+    if stats.Samples == 0 {
+        stats.RTT = perf.RTT
+    } else {
+        deviation := perf.RTT - stats.RTT
+        if deviation < 0 {
+            deviation = -deviation
+        }
+        stats.Jitter += time.Duration(alpha * float64(deviation - stats.Jitter))
+        stats.RTT += time.Duration(alpha * float64(perf.RTT - stats.RTT))
+    }
+    stats.Samples++
+    stats.Failures = 0
+    stats.LastMeasured = now
+}
+
+// Returns the smoothed stats as a performance indicator
+func (stats *PeerPerfStats) perf() PerfInd {
+    return PerfInd{
+        RTT:        stats.RTT,
+        Jitter:     stats.Jitter,
+        Loss:       stats.Loss,
+        Bandwidth:  stats.Bandwidth,
+        Load:       stats.Load,
+    }
+}
+
+// PerfMonitor continuously pings a set of tracked peers in the background,
+// keeping smoothed RTT and jitter for each. Passing it as
+// SortPeersOpts.Monitor lets SortPeers() rank tracked peers without pinging
+// them, keeping probing off of the service resolution path.
+type PerfMonitor struct {
+    node    p2pnode.Node
+    opts    PerfMonitorOpts
+    cache   *PerfCache
+
+    mutex   sync.Mutex
+    peers   map[peer.ID]*PeerPerfStats
+
+    wake    chan struct{}
+    cancel  context.CancelFunc
+    done    chan struct{}
+}
+
+// Creates a PerfMonitor and starts monitoring in the background, until
+// Stop() is called or the node shuts down
+func NewPerfMonitor(node p2pnode.Node, opts PerfMonitorOpts) *PerfMonitor {
+    if opts.Interval <= 0 {
+        opts.Interval = DefaultPerfMonitorInterval
+    }
+    if opts.Timeout <= 0 {
+        opts.Timeout = SortPeersPingTimeout
+    }
+    if opts.Concurrency <= 0 {
+        opts.Concurrency = SortPeersConcurrency
+    }
+    if opts.Alpha <= 0 || opts.Alpha > 1 {
+        opts.Alpha = DefaultPerfMonitorAlpha
+    }
+
+    ctx, cancel := context.WithCancel(node.Ctx)
+    monitor := &PerfMonitor{
+        node:   node,
+        opts:   opts,
+        cache:  NewPerfCache(perfMonitorTTLIntervals * opts.Interval),
+        peers:  make(map[peer.ID]*PeerPerfStats),
+        wake:   make(chan struct{}, 1),
+        cancel: cancel,
+        done:   make(chan struct{}),
+    }
+    go monitor.run(ctx)
+    return monitor
+}
+
+// Starts monitoring the peer. Newly tracked peers are pinged right away,
+// rather than waiting for the next interval.
+func (monitor *PerfMonitor) Track(id peer.ID) {
+    monitor.mutex.Lock()
+    _, tracked := monitor.peers[id]
+    if !tracked {
+        monitor.peers[id] = &PeerPerfStats{}
+    }
+    monitor.mutex.Unlock()
+
+    if !tracked {
+        select {
+        case monitor.wake <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// Stops monitoring the peer and drops its measurements
+func (monitor *PerfMonitor) Untrack(id peer.ID) {
+    monitor.mutex.Lock()
+    delete(monitor.peers, id)
+    monitor.mutex.Unlock()
+    monitor.cache.Forget(id)
+}
+
+// Returns the smoothed measurements of a tracked peer
+func (monitor *PerfMonitor) Stats(id peer.ID) (PeerPerfStats, bool) {
+    monitor.mutex.Lock()
+    defer monitor.mutex.Unlock()
+
+    stats, ok := monitor.peers[id]
+    if !ok {
+        return PeerPerfStats{}, false
+    }
+    return *stats, true
+}
+
+// Returns the IDs of all tracked peers
+func (monitor *PerfMonitor) Peers() []peer.ID {
+    monitor.mutex.Lock()
+    defer monitor.mutex.Unlock()
+
+    ids := make([]peer.ID, 0, len(monitor.peers))
+    for id := range monitor.peers {
+        ids = append(ids, id)
+    }
+    return ids
+}
+
+// Returns the cache holding the smoothed performance of tracked peers
+// measured within the last few intervals
+func (monitor *PerfMonitor) Cache() *PerfCache {
+    return monitor.cache
+}
+
+// Stops monitoring and waits for the current round of pings to finish
+func (monitor *PerfMonitor) Stop() {
+    monitor.cancel()
+    <-monitor.done
+}
+
+// Folds a measurement of the peer into its stats and publishes the smoothed
+// result to the cache. Untracked peers are ignored, unless 'track' is set.
+func (monitor *PerfMonitor) observe(id peer.ID, perf PerfInd, track bool) {
+    monitor.mutex.Lock()
+    stats, ok := monitor.peers[id]
+    if !ok && !track {
+        monitor.mutex.Unlock()
+        return
+    } else if !ok {
+        stats = &PeerPerfStats{}
+        monitor.peers[id] = stats
+    }
+    stats.update(perf, monitor.opts.Alpha, time.Now())
+    smoothed, failures := stats.perf(), stats.Failures
+    monitor.mutex.Unlock()
+
+    if failures == 0 {
+        monitor.cache.Put(id, smoothed)
+    }
+}
+
+func (monitor *PerfMonitor) run(ctx context.Context) {
+    defer close(monitor.done)
+
+    ticker := time.NewTicker(monitor.opts.Interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        case <-monitor.wake:
+        }
+        monitor.probe(ctx)
+    }
+}
+
+// Pings every tracked peer once
+func (monitor *PerfMonitor) probe(ctx context.Context) {
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, monitor.opts.Concurrency)
+    for _, id := range monitor.Peers() {
+        if ctx.Err() != nil {
+            break
+        }
+
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(id peer.ID) {
+            defer wg.Done()
+            defer func() { <-sem }()
+
+            perf, _ := measurePeer(monitor.node, id, monitor.opts.Timeout, 1,
+                monitor.opts.Load)
+            if ctx.Err() == nil {
+                monitor.observe(id, perf, false)
+            }
+        }(id)
+    }
+    wg.Wait()
+}
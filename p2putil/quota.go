@@ -0,0 +1,186 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "errors"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    // Default accounting window used when QuotaPolicy.Window is 0
+    DefaultQuotaWindow = time.Minute
+)
+
+var (
+    // Returned by writes to a stream whose peer exceeded its byte quota
+    // under QuotaReject
+    ErrQuotaExceeded = errors.New("Peer exceeded its quota")
+)
+
+// What happens to a peer that exceeds its quota
+type QuotaAction int
+
+const (
+    // Reset new streams from the peer, and fail writes to existing ones,
+    // until the window ends (default)
+    QuotaReject QuotaAction = iota
+
+    // Delay new streams and writes until the window ends
+    QuotaThrottle
+)
+
+// Limits on what each peer may consume within a window. Zero-value limits
+// are unlimited.
+type QuotaPolicy struct {
+    Window      time.Duration
+    MaxRequests int
+    MaxBytes    int64
+    Action      QuotaAction
+
+    // Called (in its own goroutine) the first time a peer exceeds its quota
+    // in a window
+    OnExceeded  func(id peer.ID, usage QuotaUsage)
+}
+
+// What a peer has consumed in the current window
+type QuotaUsage struct {
+    WindowStart time.Time
+    Requests    int
+    Bytes       int64
+}
+
+type quotaWindow struct {
+    usage       QuotaUsage
+    exceeded    bool
+}
+
+// Accounts the requests (streams) and bytes served to each peer, and
+// enforces a QuotaPolicy on them
+type QuotaTracker struct {
+    mutex   sync.Mutex
+    policy  QuotaPolicy
+    peers   map[peer.ID]*quotaWindow
+}
+
+func NewQuotaTracker(policy QuotaPolicy) *QuotaTracker {
+    if policy.Window <= 0 {
+        policy.Window = DefaultQuotaWindow
+    }
+    return &QuotaTracker{
+        policy: policy,
+        peers:  make(map[peer.ID]*quotaWindow),
+    }
+}
+
+// Returns the peer's window, starting a new one if the last has ended.
+// Must be called with the mutex held.
+func (qt *QuotaTracker) window(id peer.ID, now time.Time) *quotaWindow {
+    w, ok := qt.peers[id]
+    if !ok || now.Sub(w.usage.WindowStart) >= qt.policy.Window {
+        w = &quotaWindow{usage: QuotaUsage{WindowStart: now}}
+        qt.peers[id] = w
+    }
+    return w
+}
+
+// Adds to the peer's usage. Returns how long until its window ends if this
+// pushes it over quota, or 0 if it is within quota.
+func (qt *QuotaTracker) charge(id peer.ID, requests int, bytes int64) time.Duration {
+    now := time.Now()
+
+    qt.mutex.Lock()
+    w := qt.window(id, now)
+    w.usage.Requests += requests
+    w.usage.Bytes += bytes
+
+    over := (qt.policy.MaxRequests > 0 && w.usage.Requests > qt.policy.MaxRequests) ||
+        (qt.policy.MaxBytes > 0 && w.usage.Bytes > qt.policy.MaxBytes)
+    firstExceeded := over && !w.exceeded
+    if over {
+        w.exceeded = true
+    }
+    usage := w.usage
+    qt.mutex.Unlock()
+
+    if !over {
+        return 0
+    }
+    if firstExceeded {
+        log.Printf("Peer %s exceeded its quota: %d requests, %d bytes\n",
+            id, usage.Requests, usage.Bytes)
+        if qt.policy.OnExceeded != nil {
+            go qt.policy.OnExceeded(id, usage)
+        }
+    }
+    return usage.WindowStart.Add(qt.policy.Window).Sub(now)
+}
+
+// Returns the peer's usage in the current window
+func (qt *QuotaTracker) Usage(id peer.ID) QuotaUsage {
+    qt.mutex.Lock()
+    defer qt.mutex.Unlock()
+    return qt.window(id, time.Now()).usage
+}
+
+// Forgets peers whose windows have ended, to bound memory use
+func (qt *QuotaTracker) Prune() {
+    now := time.Now()
+
+    qt.mutex.Lock()
+    defer qt.mutex.Unlock()
+    for id, w := range qt.peers {
+        if now.Sub(w.usage.WindowStart) >= qt.policy.Window {
+            delete(qt.peers, id)
+        }
+    }
+}
+
+// Counts bytes written to the peer against its quota
+type quotaStream struct {
+    network.Stream
+    tracker *QuotaTracker
+}
+
+func (s *quotaStream) Write(p []byte) (int, error) {
+    wait := s.tracker.charge(s.Conn().RemotePeer(), 0, int64(len(p)))
+    if wait > 0 {
+        if s.tracker.policy.Action != QuotaThrottle {
+            return 0, ErrQuotaExceeded
+        }
+        time.Sleep(wait)
+    }
+    return s.Stream.Write(p)
+}
+
+// Wraps a stream handler so each stream counts as a request against the
+// peer's quota, as does every byte written back to it
+func (qt *QuotaTracker) Handler(handler network.StreamHandler) network.StreamHandler {
+    return func(stream network.Stream) {
+        if wait := qt.charge(stream.Conn().RemotePeer(), 1, 0); wait > 0 {
+            if qt.policy.Action != QuotaThrottle {
+                stream.Reset()
+                return
+            }
+            time.Sleep(wait)
+        }
+        handler(&quotaStream{Stream: stream, tracker: qt})
+    }
+}
@@ -0,0 +1,57 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestQuotaTracker(test *testing.T) {
+    id := peer.ID("consumer")
+    exceeded := make(chan QuotaUsage, 1)
+    qt := NewQuotaTracker(QuotaPolicy{
+        Window:         50 * time.Millisecond,
+        MaxRequests:    2,
+        MaxBytes:       100,
+        OnExceeded:     func(_ peer.ID, usage QuotaUsage) { exceeded <- usage },
+    })
+
+    if qt.charge(id, 1, 60) > 0 || qt.charge(id, 1, 40) > 0 {
+        test.Fatalf("Usage within quota was reported as exceeding it")
+    }
+    if qt.charge(id, 1, 0) <= 0 {
+        test.Fatalf("Request past MaxRequests was not reported as exceeding quota")
+    }
+
+    select {
+    case usage := <-exceeded:
+        if usage.Requests != 3 || usage.Bytes != 100 {
+            test.Errorf("OnExceeded got %+v, expected 3 requests and 100 bytes", usage)
+        }
+    case <-time.After(time.Second):
+        test.Errorf("OnExceeded was not called")
+    }
+
+    time.Sleep(60 * time.Millisecond)
+    if qt.charge(id, 1, 0) > 0 {
+        test.Errorf("Quota was not reset once the window ended")
+    }
+    if usage := qt.Usage(id); usage.Requests != 1 {
+        test.Errorf("Usage() returned %d requests, expected 1", usage.Requests)
+    }
+}
@@ -0,0 +1,163 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "bytes"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+    // Returned by CheckRateLimited() for a RateLimitedFrame
+    ErrRateLimited = errors.New("Rate limited by peer")
+
+    // Frame (see WriteFrame()) sent back on streams refused by a
+    // RateLimiter, before they are closed
+    RateLimitedFrame = []byte("\x00rate-limited")
+)
+
+// Token bucket parameters: up to Burst requests at once, refilled at Rate
+// requests per second. A zero Rate is unlimited.
+type RateLimit struct {
+    Rate    float64
+    Burst   int
+}
+
+// Limits applied to each peer individually, and to all peers combined
+type RateLimitPolicy struct {
+    PerPeer RateLimit
+    Global  RateLimit
+}
+
+type tokenBucket struct {
+    limit   RateLimit
+    tokens  float64
+    last    time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+    if limit.Burst < 1 {
+        limit.Burst = 1
+    }
+    return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// Refills the bucket, and returns true if it has a token to take
+func (b *tokenBucket) ready(now time.Time) bool {
+    if b.limit.Rate <= 0 {
+        return true
+    }
+    b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+    if b.tokens > float64(b.limit.Burst) {
+        b.tokens = float64(b.limit.Burst)
+    }
+    b.last = now
+    return b.tokens >= 1
+}
+
+func (b *tokenBucket) take() {
+    if b.limit.Rate > 0 {
+        b.tokens--
+    }
+}
+
+// Returns true if the bucket is full, so can be forgotten
+func (b *tokenBucket) full(now time.Time) bool {
+    b.ready(now)
+    return b.limit.Rate <= 0 || b.tokens >= float64(b.limit.Burst)
+}
+
+// Enforces a RateLimitPolicy on inbound streams (requests)
+type RateLimiter struct {
+    mutex   sync.Mutex
+    policy  RateLimitPolicy
+    global  *tokenBucket
+    peers   map[peer.ID]*tokenBucket
+}
+
+func NewRateLimiter(policy RateLimitPolicy) *RateLimiter {
+    return &RateLimiter{
+        policy: policy,
+        global: newTokenBucket(policy.Global, time.Now()),
+        peers:  make(map[peer.ID]*tokenBucket),
+    }
+}
+
+// Takes a token for a request from the peer, returning false if either the
+// peer or all peers combined are over their limit. Refused requests do not
+// use up tokens.
+func (rl *RateLimiter) Allow(id peer.ID) bool {
+    now := time.Now()
+
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+
+    bucket, ok := rl.peers[id]
+    if !ok {
+        bucket = newTokenBucket(rl.policy.PerPeer, now)
+        rl.peers[id] = bucket
+    }
+
+    if !bucket.ready(now) || !rl.global.ready(now) {
+        return false
+    }
+    bucket.take()
+    rl.global.take()
+    return true
+}
+
+// Forgets peers whose buckets have refilled, to bound memory use
+func (rl *RateLimiter) Prune() {
+    now := time.Now()
+
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+    for id, bucket := range rl.peers {
+        if bucket.full(now) {
+            delete(rl.peers, id)
+        }
+    }
+}
+
+// Wraps a stream handler so each stream counts as a request against the
+// rate limits. Streams over the limits are answered with RateLimitedFrame
+// and closed, without invoking the handler.
+func (rl *RateLimiter) Handler(handler network.StreamHandler) network.StreamHandler {
+    return func(stream network.Stream) {
+        if !rl.Allow(stream.Conn().RemotePeer()) {
+            if err := WriteFrame(stream, RateLimitedFrame); err != nil {
+                stream.Reset()
+                return
+            }
+            stream.Close()
+            return
+        }
+        handler(stream)
+    }
+}
+
+// Returns ErrRateLimited if the frame read from a stream is
+// RateLimitedFrame, nil otherwise
+func CheckRateLimited(frame []byte) error {
+    if bytes.Equal(frame, RateLimitedFrame) {
+        return ErrRateLimited
+    }
+    return nil
+}
@@ -0,0 +1,66 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestRateLimiter(test *testing.T) {
+    a, b := peer.ID("peer-a"), peer.ID("peer-b")
+    rl := NewRateLimiter(RateLimitPolicy{
+        PerPeer:    RateLimit{Rate: 20, Burst: 2},
+        Global:     RateLimit{Rate: 20, Burst: 3},
+    })
+
+    if !rl.Allow(a) || !rl.Allow(a) {
+        test.Fatalf("Requests within the peer's burst were refused")
+    }
+    if rl.Allow(a) {
+        test.Errorf("Request past the peer's burst was allowed")
+    }
+    if !rl.Allow(b) {
+        test.Errorf("Request from another peer was refused")
+    }
+    if rl.Allow(b) {
+        test.Errorf("Request past the global burst was allowed")
+    }
+
+    time.Sleep(60 * time.Millisecond)
+    if !rl.Allow(a) {
+        test.Errorf("Request was refused after tokens were refilled")
+    }
+
+    test.Run("Unlimited", func(test *testing.T) {
+        rl := NewRateLimiter(RateLimitPolicy{})
+        for i := 0; i < 100; i++ {
+            if !rl.Allow(a) {
+                test.Fatalf("Request refused with no limits set")
+            }
+        }
+    })
+
+    test.Run("CheckRateLimited", func(test *testing.T) {
+        if CheckRateLimited(RateLimitedFrame) != ErrRateLimited {
+            test.Errorf("RateLimitedFrame not recognized")
+        }
+        if CheckRateLimited([]byte("rate-limited")) != nil {
+            test.Errorf("Application data mistaken for RateLimitedFrame")
+        }
+    })
+}
@@ -0,0 +1,116 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    // Number of random bytes in a resumption ticket
+    ticketNumBytes = 32
+)
+
+type sessionEntry struct {
+    owner   peer.ID
+    state   interface{}
+    expiry  time.Time
+}
+
+// SessionTickets lets a service restore application-level session state
+// for a peer that reconnects, without repeating its own handshake.
+//
+// When a session is established, the service calls Issue() to store the
+// session state and sends the returned ticket to the peer. If the peer later
+// reconnects and presents the ticket, Resume() returns the stored state.
+// Tickets are bound to the peer they were issued to, expire after a fixed
+// TTL, and can only be used once.
+type SessionTickets struct {
+    mutex       sync.Mutex
+    ttl         time.Duration
+    sessions    map[string]sessionEntry
+}
+
+func NewSessionTickets(ttl time.Duration) (*SessionTickets, error) {
+    if ttl <= 0 {
+        return nil, errors.New("Ticket TTL must be greater than 0")
+    }
+
+    return &SessionTickets{
+        ttl:        ttl,
+        sessions:   make(map[string]sessionEntry),
+    }, nil
+}
+
+// Stores session state for the given peer, returning a ticket the peer
+// can later present to resume the session
+func (st *SessionTickets) Issue(id peer.ID, state interface{}) (string, error) {
+    randBytes := make([]byte, ticketNumBytes)
+    if _, err := rand.Read(randBytes); err != nil {
+        return "", err
+    }
+    ticket := hex.EncodeToString(randBytes)
+
+    st.mutex.Lock()
+    defer st.mutex.Unlock()
+
+    // Opportunistically drop expired sessions
+    now := time.Now()
+    for t, entry := range st.sessions {
+        if now.After(entry.expiry) {
+            delete(st.sessions, t)
+        }
+    }
+
+    st.sessions[ticket] = sessionEntry{
+        owner:  id,
+        state:  state,
+        expiry: now.Add(st.ttl),
+    }
+
+    return ticket, nil
+}
+
+// Returns the session state for a ticket presented by the given peer.
+// The ticket is consumed, whether or not resumption succeeds.
+func (st *SessionTickets) Resume(id peer.ID, ticket string) (interface{}, error) {
+    st.mutex.Lock()
+    entry, ok := st.sessions[ticket]
+    delete(st.sessions, ticket)
+    st.mutex.Unlock()
+
+    if !ok {
+        return nil, errors.New("Unknown session ticket")
+    } else if entry.owner != id {
+        return nil, errors.New("Session ticket was issued to a different peer")
+    } else if time.Now().After(entry.expiry) {
+        return nil, errors.New("Session ticket has expired")
+    }
+
+    return entry.state, nil
+}
+
+// Invalidates a ticket without resuming it
+func (st *SessionTickets) Revoke(ticket string) {
+    st.mutex.Lock()
+    defer st.mutex.Unlock()
+    delete(st.sessions, ticket)
+}
@@ -0,0 +1,69 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestSessionTickets(test *testing.T) {
+    st, err := NewSessionTickets(time.Minute)
+    if err != nil {
+        test.Fatalf("NewSessionTickets() failed:\n%v", err)
+    }
+
+    test.Run("Resume", func(test *testing.T) {
+        ticket, err := st.Issue(peer.ID("alice"), "state")
+        if err != nil {
+            test.Fatalf("Issue() failed:\n%v", err)
+        }
+
+        state, err := st.Resume(peer.ID("alice"), ticket)
+        if err != nil || state != "state" {
+            test.Fatalf("Resume() returned %v, %v; expected \"state\"", state, err)
+        }
+
+        if _, err = st.Resume(peer.ID("alice"), ticket); err == nil {
+            test.Errorf("Resume() with a used ticket succeeded, expected it to fail")
+        }
+    })
+
+    test.Run("Resume-WrongPeer", func(test *testing.T) {
+        ticket, err := st.Issue(peer.ID("alice"), "state")
+        if err != nil {
+            test.Fatalf("Issue() failed:\n%v", err)
+        }
+
+        if _, err = st.Resume(peer.ID("mallory"), ticket); err == nil {
+            test.Errorf("Resume() by a different peer succeeded, expected it to fail")
+        }
+    })
+
+    test.Run("Resume-Expired", func(test *testing.T) {
+        st, _ := NewSessionTickets(time.Nanosecond)
+        ticket, err := st.Issue(peer.ID("alice"), "state")
+        if err != nil {
+            test.Fatalf("Issue() failed:\n%v", err)
+        }
+
+        time.Sleep(time.Millisecond)
+        if _, err = st.Resume(peer.ID("alice"), ticket); err == nil {
+            test.Errorf("Resume() with an expired ticket succeeded, expected it to fail")
+        }
+    })
+}
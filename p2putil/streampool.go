@@ -0,0 +1,317 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "bufio"
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/PhysarumSM/common/p2pnode"
+)
+
+const (
+    // Largest frame accepted by ReadFrame()
+    MaxFrameBytes = 4 * 1024 * 1024
+
+    // Defaults used when StreamPoolOpts fields are left as zero-values
+    DefaultPoolMaxIdlePerPeer   = 4
+    DefaultPoolMaxActivePerPeer = 16
+    DefaultPoolIdleTimeout      = time.Minute
+)
+
+// Writes a single length-prefixed frame. Unlike WriteMsg(), the stream is
+// left open, so multiple frames can be exchanged over it.
+func WriteFrame(w io.Writer, data []byte) error {
+    if len(data) > MaxFrameBytes {
+        return fmt.Errorf("Frame of %d bytes exceeds max of %d", len(data), MaxFrameBytes)
+    }
+
+    buf := make([]byte, binary.MaxVarintLen64 + len(data))
+    n := binary.PutUvarint(buf, uint64(len(data)))
+    n += copy(buf[n:], data)
+    _, err := w.Write(buf[:n])
+    return err
+}
+
+// Reads a single frame written by WriteFrame()
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+    length, err := binary.ReadUvarint(r)
+    if err != nil {
+        return nil, err
+    } else if length > MaxFrameBytes {
+        return nil, fmt.Errorf("Frame of %d bytes exceeds max of %d", length, MaxFrameBytes)
+    }
+
+    data := make([]byte, length)
+    _, err = io.ReadFull(r, data)
+    return data, err
+}
+
+// Returns a stream handler that answers any number of framed requests on a
+// stream, for use with StreamPool.Call(). The stream is reset if the
+// callback returns an error.
+func FramedHandler(handle func(peer.ID, []byte) ([]byte, error)) network.StreamHandler {
+    return func(stream network.Stream) {
+        reader := bufio.NewReader(stream)
+        for {
+            req, err := ReadFrame(reader)
+            if err == io.EOF {
+                stream.Close()
+                return
+            } else if err != nil {
+                stream.Reset()
+                return
+            }
+
+            resp, err := handle(stream.Conn().RemotePeer(), req)
+            if err != nil {
+                log.Printf("ERROR: Unable to handle request\n%v\n", err)
+                stream.Reset()
+                return
+            }
+
+            if err = WriteFrame(stream, resp); err != nil {
+                stream.Reset()
+                return
+            }
+        }
+    }
+}
+
+type StreamPoolOpts struct {
+    // Maximum number of idle streams kept per peer. Streams returned to a
+    // full pool are closed.
+    MaxIdlePerPeer   int
+
+    // Maximum number of calls in progress to each peer, each holding its
+    // own stream, so bursts of calls don't exhaust the peer's muxer stream
+    // limit. Further calls wait for one to finish.
+    MaxActivePerPeer int
+
+    // Idle streams unused for this long are closed rather than reused
+    IdleTimeout      time.Duration
+}
+
+type pooledStream struct {
+    stream      network.Stream
+    reader      *bufio.Reader
+    lastUsed    time.Time
+    // Set if the stream was taken from the idle pool, in which case the
+    // peer may have closed it in the meantime
+    reused      bool
+}
+
+// Limits the calls in progress to a peer. Dropped from the pool once no
+// caller holds it.
+type peerSemaphore struct {
+    slots   chan struct{}
+    users   int
+}
+
+// StreamPool reuses streams of a single protocol to each peer, so frequent
+// callers avoid negotiating a new stream per request and keep the number of
+// streams open to each peer bounded.
+type StreamPool struct {
+    node    p2pnode.Node
+    pid     protocol.ID
+    opts    StreamPoolOpts
+
+    mutex   sync.Mutex
+    idle    map[peer.ID][]*pooledStream
+    active  map[peer.ID]*peerSemaphore
+}
+
+func NewStreamPool(node p2pnode.Node, pid protocol.ID, opts StreamPoolOpts) *StreamPool {
+    if opts.MaxIdlePerPeer <= 0 {
+        opts.MaxIdlePerPeer = DefaultPoolMaxIdlePerPeer
+    }
+    if opts.MaxActivePerPeer <= 0 {
+        opts.MaxActivePerPeer = DefaultPoolMaxActivePerPeer
+    }
+    if opts.IdleTimeout <= 0 {
+        opts.IdleTimeout = DefaultPoolIdleTimeout
+    }
+
+    return &StreamPool{
+        node:   node,
+        pid:    pid,
+        opts:   opts,
+        idle:   make(map[peer.ID][]*pooledStream),
+        active: make(map[peer.ID]*peerSemaphore),
+    }
+}
+
+// Waits until fewer than MaxActivePerPeer calls to the peer are in
+// progress, returning a function that ends the caller's call
+func (pool *StreamPool) acquire(ctx context.Context, id peer.ID) (func(), error) {
+    pool.mutex.Lock()
+    sem, ok := pool.active[id]
+    if !ok {
+        sem = &peerSemaphore{slots: make(chan struct{}, pool.opts.MaxActivePerPeer)}
+        pool.active[id] = sem
+    }
+    sem.users++
+    pool.mutex.Unlock()
+
+    done := func() {
+        pool.mutex.Lock()
+        defer pool.mutex.Unlock()
+        sem.users--
+        if sem.users == 0 {
+            delete(pool.active, id)
+        }
+    }
+
+    select {
+    case sem.slots <- struct{}{}:
+        return func() {
+            <-sem.slots
+            done()
+        }, nil
+    case <-ctx.Done():
+        done()
+        return nil, ctx.Err()
+    }
+}
+
+// Returns an idle stream to the peer if one is available, or opens a new one
+func (pool *StreamPool) get(ctx context.Context, id peer.ID) (*pooledStream, error) {
+    pool.mutex.Lock()
+    for len(pool.idle[id]) > 0 {
+        streams := pool.idle[id]
+        ps := streams[len(streams)-1]
+        pool.idle[id] = streams[:len(streams)-1]
+
+        if time.Since(ps.lastUsed) > pool.opts.IdleTimeout {
+            ps.stream.Close()
+            continue
+        }
+
+        pool.mutex.Unlock()
+        ps.reused = true
+        return ps, nil
+    }
+    pool.mutex.Unlock()
+
+    return pool.open(ctx, id)
+}
+
+// Opens a new stream to the peer
+func (pool *StreamPool) open(ctx context.Context, id peer.ID) (*pooledStream, error) {
+    stream, err := pool.node.Host().NewStream(ctx, id, pool.pid)
+    if err != nil {
+        return nil, err
+    }
+    return &pooledStream{stream: stream, reader: bufio.NewReader(stream)}, nil
+}
+
+// Returns a healthy stream to the pool, closing it if the pool is full
+func (pool *StreamPool) put(id peer.ID, ps *pooledStream) {
+    ps.lastUsed = time.Now()
+
+    pool.mutex.Lock()
+    defer pool.mutex.Unlock()
+    if len(pool.idle[id]) >= pool.opts.MaxIdlePerPeer {
+        ps.stream.Close()
+        return
+    }
+    pool.idle[id] = append(pool.idle[id], ps)
+}
+
+// Sends a request to the peer over a pooled stream and returns its response.
+// The peer's handler should be created with FramedHandler(). Streams that
+// encounter an error are reset and evicted rather than returned to the pool.
+// If an idle stream fails (e.g. the peer closed it while it was idle), the
+// request is retried once over a new stream. At most MaxActivePerPeer calls
+// to the peer run at once; others wait, until 'ctx' is done.
+func (pool *StreamPool) Call(ctx context.Context, id peer.ID, req []byte) ([]byte, error) {
+    if ctx.Err() != nil {
+        return nil, ctx.Err()
+    }
+
+    release, err := pool.acquire(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+
+    ps, err := pool.get(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+
+    resp, err := pool.roundTrip(ctx, ps, req)
+    if err != nil && ps.reused && ctx.Err() == nil {
+        if ps, err = pool.open(ctx, id); err != nil {
+            return nil, err
+        }
+        resp, err = pool.roundTrip(ctx, ps, req)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    pool.put(id, ps)
+    return resp, nil
+}
+
+// Exchanges a request and response over the stream, resetting it on error
+func (pool *StreamPool) roundTrip(ctx context.Context, ps *pooledStream,
+    req []byte) ([]byte, error) {
+
+    if deadline, ok := ctx.Deadline(); ok {
+        ps.stream.SetDeadline(deadline)
+    } else {
+        ps.stream.SetDeadline(time.Time{})
+    }
+
+    if err := WriteFrame(ps.stream, req); err != nil {
+        ps.stream.Reset()
+        return nil, err
+    }
+
+    resp, err := ReadFrame(ps.reader)
+    if err != nil {
+        ps.stream.Reset()
+        if err == io.EOF {
+            err = errors.New("Stream closed by peer")
+        }
+        return nil, err
+    }
+    return resp, nil
+}
+
+// Closes all idle streams
+func (pool *StreamPool) Close() {
+    pool.mutex.Lock()
+    defer pool.mutex.Unlock()
+    for id, streams := range pool.idle {
+        for _, ps := range streams {
+            ps.stream.Close()
+        }
+        delete(pool.idle, id)
+    }
+}
@@ -0,0 +1,124 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package p2putil
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/binary"
+    "io"
+    "io/ioutil"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+    "github.com/libp2p/go-libp2p-core/protocol"
+
+    "github.com/PhysarumSM/common/p2pnode"
+    "github.com/PhysarumSM/common/p2pnode/testutil"
+)
+
+const testPoolProtocol = protocol.ID("/test/pool/1.0")
+
+func TestFrames(test *testing.T) {
+    var buf bytes.Buffer
+    msgs := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 1000)}
+    for _, msg := range msgs {
+        if err := WriteFrame(&buf, msg); err != nil {
+            test.Fatalf("WriteFrame() failed:\n%v", err)
+        }
+    }
+
+    reader := bufio.NewReader(&buf)
+    for _, msg := range msgs {
+        data, err := ReadFrame(reader)
+        if err != nil || !bytes.Equal(data, msg) {
+            test.Fatalf("ReadFrame() returned %q, %v; expected %q", data, err, msg)
+        }
+    }
+
+    if _, err := ReadFrame(reader); err != io.EOF {
+        test.Errorf("ReadFrame() at end returned %v, expected EOF", err)
+    }
+
+    test.Run("TooLarge", func(test *testing.T) {
+        if err := WriteFrame(ioutil.Discard, make([]byte, MaxFrameBytes + 1)); err == nil {
+            test.Errorf("WriteFrame() with oversized frame succeeded, expected it to fail")
+        }
+
+        header := make([]byte, binary.MaxVarintLen64)
+        n := binary.PutUvarint(header, MaxFrameBytes + 1)
+        if _, err := ReadFrame(bufio.NewReader(bytes.NewReader(header[:n]))); err == nil {
+            test.Errorf("ReadFrame() with oversized frame succeeded, expected it to fail")
+        }
+    })
+}
+
+func TestStreamPoolMaxActive(test *testing.T) {
+    pool := NewStreamPool(p2pnode.Node{}, testPoolProtocol, StreamPoolOpts{MaxActivePerPeer: 1})
+    id := peer.ID("peer")
+
+    release, err := pool.acquire(context.Background(), id)
+    if err != nil {
+        test.Fatalf("acquire() failed:\n%v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+    defer cancel()
+    if _, err = pool.acquire(ctx, id); err != context.DeadlineExceeded {
+        test.Errorf("acquire() past MaxActivePerPeer returned %v, expected it to wait for ctx", err)
+    }
+
+    release()
+    if release, err = pool.acquire(context.Background(), id); err != nil {
+        test.Fatalf("acquire() after release failed:\n%v", err)
+    }
+    release()
+
+    if len(pool.active) != 0 {
+        test.Errorf("Pool still tracks %d peers with no calls in progress", len(pool.active))
+    }
+}
+
+func TestStreamPoolStaleIdle(test *testing.T) {
+    net, cleanup := testutil.NewNetwork(test, 2, nil)
+    defer cleanup()
+
+    // Answers a single request per stream, then closes it, as a peer
+    // dropping idle streams would
+    net.Nodes[1].Host().SetStreamHandler(testPoolProtocol, func(stream network.Stream) {
+        req, err := ReadFrame(bufio.NewReader(stream))
+        if err != nil {
+            stream.Reset()
+            return
+        }
+        WriteFrame(stream, req)
+        stream.Close()
+    })
+
+    pool := NewStreamPool(net.Nodes[0], testPoolProtocol, StreamPoolOpts{})
+    defer pool.Close()
+
+    for i := 0; i < 3; i++ {
+        ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+        resp, err := pool.Call(ctx, net.Peer(1), []byte("hello"))
+        cancel()
+        if err != nil || string(resp) != "hello" {
+            test.Fatalf("Call %d returned %q, %v; expected the request echoed back", i, resp, err)
+        }
+    }
+}
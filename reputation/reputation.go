@@ -0,0 +1,279 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package reputation scores peers on their past behaviour, so flaky peers
+// can be avoided. Peers start at a score of 0, and lose points for each
+// connection that churns (closes shortly after opening), failed dial, and
+// protocol error, as well as for high latency. Penalties decay over time,
+// so peers recover once they behave.
+package reputation
+
+import (
+    "math"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/connmgr"
+    "github.com/libp2p/go-libp2p-core/control"
+    "github.com/libp2p/go-libp2p-core/host"
+    "github.com/libp2p/go-libp2p-core/network"
+    "github.com/libp2p/go-libp2p-core/peer"
+
+    "github.com/multiformats/go-multiaddr"
+)
+
+// Defaults used when Config fields are left as zero-values
+const (
+    DefaultHalfLife     = 30 * time.Minute
+    DefaultChurnWindow  = time.Minute
+    DefaultMinScore     = -100
+)
+
+// Weight given to each new latency sample in a peer's average
+const latencySmoothing = 0.2
+
+// Points deducted for each kind of event
+type Weights struct {
+    Churn           float64
+    DialFailure     float64
+    ProtocolError   float64
+
+    // Points deducted per second of average latency. Unlike the other
+    // penalties, this one does not decay.
+    Latency         float64
+}
+
+var DefaultWeights = Weights{
+    Churn:          5,
+    DialFailure:    10,
+    ProtocolError:  20,
+    Latency:        10,
+}
+
+type Config struct {
+    // Defaults to DefaultWeights if all weights are 0
+    Weights     Weights
+
+    // Time for penalties to decay to half their value
+    HalfLife    time.Duration
+
+    // Connections closed within ChurnWindow of opening count as churn
+    ChurnWindow time.Duration
+
+    // Peers scoring below MinScore are not Acceptable(). Must be negative.
+    MinScore    float64
+}
+
+// What is known of a peer, as returned by Tracker.Stats()
+type PeerStats struct {
+    Score           float64
+    Churn           int
+    DialFailures    int
+    ProtocolErrors  int
+    Latency         time.Duration
+    LastEvent       time.Time
+}
+
+type peerRecord struct {
+    penalty float64
+    updated time.Time
+    stats   PeerStats
+}
+
+// Tracks the reputation of peers
+type Tracker struct {
+    mutex   sync.Mutex
+    config  Config
+    peers   map[peer.ID]*peerRecord
+    opened  map[network.Conn]time.Time
+}
+
+func NewTracker(config Config) *Tracker {
+    if config.Weights == (Weights{}) {
+        config.Weights = DefaultWeights
+    }
+    if config.HalfLife <= 0 {
+        config.HalfLife = DefaultHalfLife
+    }
+    if config.ChurnWindow <= 0 {
+        config.ChurnWindow = DefaultChurnWindow
+    }
+    if config.MinScore >= 0 {
+        config.MinScore = DefaultMinScore
+    }
+
+    return &Tracker{
+        config: config,
+        peers:  make(map[peer.ID]*peerRecord),
+        opened: make(map[network.Conn]time.Time),
+    }
+}
+
+// Returns the peer's record with its penalty decayed to the present,
+// creating it if needed. Must be called with the mutex held.
+func (t *Tracker) record(id peer.ID, now time.Time) *peerRecord {
+    rec, ok := t.peers[id]
+    if !ok {
+        rec = &peerRecord{updated: now}
+        t.peers[id] = rec
+    }
+
+    halfLives := float64(now.Sub(rec.updated)) / float64(t.config.HalfLife)
+    rec.penalty *= math.Pow(0.5, halfLives)
+    rec.updated = now
+    return rec
+}
+
+// Deducts points from the peer, and counts the event
+func (t *Tracker) penalize(id peer.ID, points float64, count func(*PeerStats)) {
+    now := time.Now()
+
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    rec := t.record(id, now)
+    rec.penalty += points
+    rec.stats.LastEvent = now
+    count(&rec.stats)
+}
+
+func (t *Tracker) RecordChurn(id peer.ID) {
+    t.penalize(id, t.config.Weights.Churn, func(s *PeerStats) { s.Churn++ })
+}
+
+func (t *Tracker) RecordDialFailure(id peer.ID) {
+    t.penalize(id, t.config.Weights.DialFailure, func(s *PeerStats) { s.DialFailures++ })
+}
+
+// Records a malformed or otherwise invalid message from the peer. These are
+// application-specific, so must be reported by protocol handlers.
+func (t *Tracker) RecordProtocolError(id peer.ID) {
+    t.penalize(id, t.config.Weights.ProtocolError, func(s *PeerStats) { s.ProtocolErrors++ })
+}
+
+// Adds a latency sample to the peer's moving average
+func (t *Tracker) RecordLatency(id peer.ID, rtt time.Duration) {
+    now := time.Now()
+
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    rec := t.record(id, now)
+    if rec.stats.Latency == 0 {
+        rec.stats.Latency = rtt
+    } else {
+        rec.stats.Latency = time.Duration(latencySmoothing * float64(rtt) +
+            (1 - latencySmoothing) * float64(rec.stats.Latency))
+    }
+}
+
+// Returns the peer's current score. Unknown peers score 0.
+func (t *Tracker) Score(id peer.ID) float64 {
+    return t.Stats(id).Score
+}
+
+func (t *Tracker) Stats(id peer.ID) PeerStats {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    if _, ok := t.peers[id]; !ok {
+        return PeerStats{}
+    }
+
+    rec := t.record(id, time.Now())
+    stats := rec.stats
+    stats.Score = -rec.penalty - t.config.Weights.Latency * stats.Latency.Seconds()
+    return stats
+}
+
+// Returns true unless the peer's score is below the minimum
+func (t *Tracker) Acceptable(id peer.ID) bool {
+    return t.Score(id) >= t.config.MinScore
+}
+
+// Returns true if peer 'a' has a better reputation than peer 'b'
+func (t *Tracker) Better(a, b peer.ID) bool {
+    return t.Score(a) > t.Score(b)
+}
+
+// Forgets the peer, resetting its score
+func (t *Tracker) Forget(id peer.ID) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    delete(t.peers, id)
+}
+
+// Records churn on the host's connections
+func (t *Tracker) Attach(h host.Host) {
+    h.Network().Notify(&network.NotifyBundle{
+        ConnectedF: func(_ network.Network, conn network.Conn) {
+            t.mutex.Lock()
+            t.opened[conn] = time.Now()
+            t.mutex.Unlock()
+        },
+        DisconnectedF: func(_ network.Network, conn network.Conn) {
+            t.mutex.Lock()
+            opened, ok := t.opened[conn]
+            delete(t.opened, conn)
+            t.mutex.Unlock()
+
+            if ok && time.Since(opened) < t.config.ChurnWindow {
+                t.RecordChurn(conn.RemotePeer())
+            }
+        },
+    })
+}
+
+// Returns a connection gater refusing connections to and from peers that
+// are not Acceptable(), consulting 'next' (if not nil) for all others
+func (t *Tracker) Gater(next connmgr.ConnectionGater) connmgr.ConnectionGater {
+    return &gater{tracker: t, next: next}
+}
+
+type gater struct {
+    tracker *Tracker
+    next    connmgr.ConnectionGater
+}
+
+func (g *gater) InterceptPeerDial(id peer.ID) bool {
+    if !g.tracker.Acceptable(id) {
+        return false
+    }
+    return g.next == nil || g.next.InterceptPeerDial(id)
+}
+
+func (g *gater) InterceptAddrDial(id peer.ID, addr multiaddr.Multiaddr) bool {
+    return g.next == nil || g.next.InterceptAddrDial(id, addr)
+}
+
+func (g *gater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+    return g.next == nil || g.next.InterceptAccept(addrs)
+}
+
+func (g *gater) InterceptSecured(dir network.Direction, id peer.ID,
+    addrs network.ConnMultiaddrs) bool {
+
+    if !g.tracker.Acceptable(id) {
+        return false
+    }
+    return g.next == nil || g.next.InterceptSecured(dir, id, addrs)
+}
+
+func (g *gater) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+    if g.next == nil {
+        return true, 0
+    }
+    return g.next.InterceptUpgraded(conn)
+}
@@ -0,0 +1,82 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package reputation
+
+import (
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+    flakyPeer = peer.ID("flaky-peer")
+    goodPeer  = peer.ID("good-peer")
+)
+
+func TestTracker(test *testing.T) {
+    tracker := NewTracker(Config{HalfLife: 50 * time.Millisecond, MinScore: -25})
+
+    if score := tracker.Score(goodPeer); score != 0 {
+        test.Errorf("Unknown peer scored %v, expected 0", score)
+    }
+
+    tracker.RecordDialFailure(flakyPeer)
+    tracker.RecordProtocolError(flakyPeer)
+    if tracker.Acceptable(flakyPeer) {
+        test.Errorf("Peer scoring %v was acceptable", tracker.Score(flakyPeer))
+    }
+    if !tracker.Better(goodPeer, flakyPeer) {
+        test.Errorf("Flaky peer ranked at least as well as a good peer")
+    }
+
+    stats := tracker.Stats(flakyPeer)
+    if stats.DialFailures != 1 || stats.ProtocolErrors != 1 {
+        test.Errorf("Unexpected stats %+v", stats)
+    }
+
+    time.Sleep(100 * time.Millisecond)
+    if !tracker.Acceptable(flakyPeer) {
+        test.Errorf("Penalties did not decay, score is %v", tracker.Score(flakyPeer))
+    }
+
+    test.Run("Latency", func(test *testing.T) {
+        tracker.RecordLatency(goodPeer, 100 * time.Millisecond)
+        tracker.RecordLatency(goodPeer, 200 * time.Millisecond)
+
+        stats := tracker.Stats(goodPeer)
+        if stats.Latency != 120 * time.Millisecond {
+            test.Errorf("Average latency is %v, expected 120ms", stats.Latency)
+        }
+        if stats.Score >= 0 {
+            test.Errorf("Latency was not penalized, score is %v", stats.Score)
+        }
+    })
+
+    test.Run("Gater", func(test *testing.T) {
+        tracker.Forget(flakyPeer)
+        for i := 0; i < 3; i++ {
+            tracker.RecordDialFailure(flakyPeer)
+        }
+
+        gater := tracker.Gater(nil)
+        if gater.InterceptPeerDial(flakyPeer) {
+            test.Errorf("Dial to unacceptable peer was allowed")
+        }
+        if !gater.InterceptPeerDial(goodPeer) {
+            test.Errorf("Dial to acceptable peer was denied")
+        }
+    })
+}
@@ -16,7 +16,9 @@
 package util
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"time"
 )
 
@@ -26,16 +28,56 @@ type ExpoBackoff struct {
 	nextPeriod time.Duration
 }
 
-// Sleeps for some duration, where each invocation of this method
-// will exponentially increasing the duration.
-func (eb *ExpoBackoff) Sleep() {
+// Returns the next duration to wait, where each invocation of this method
+// will exponentially increase the duration (up to the max duration).
+func (eb *ExpoBackoff) Next() time.Duration {
 	eb.nextPeriod *= 2
 	if eb.nextPeriod < eb.initPeriod {
 		eb.nextPeriod = eb.initPeriod
 	} else if eb.nextPeriod > eb.maxPeriod {
 		eb.nextPeriod = eb.maxPeriod
 	}
-	time.Sleep(eb.nextPeriod)
+	return eb.nextPeriod
+}
+
+// Sleeps for some duration, where each invocation of this method
+// will exponentially increasing the duration.
+func (eb *ExpoBackoff) Sleep() {
+	time.Sleep(eb.Next())
+}
+
+// Same as Sleep(), but returns early with the context's error if the
+// context is done before the duration elapses.
+func (eb *ExpoBackoff) SleepContext(ctx context.Context) error {
+	return SleepContext(ctx, eb.Next())
+}
+
+// Sleeps for the given duration, or until the context is done, in which
+// case the context's error is returned.
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Randomly adjusts a duration by up to +/- 'fraction' of itself (e.g. 0.2
+// for +/- 20%), so that many clients backing off at once don't retry in
+// lockstep. Fraction is clamped to [0, 1].
+func Jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || d <= 0 {
+		return d
+	} else if fraction > 1 {
+		fraction = 1
+	}
+
+	delta := (rand.Float64() * 2 - 1) * fraction * float64(d)
+	return d + time.Duration(delta)
 }
 
 // Creates a new ExpoBackoff.
@@ -89,6 +131,18 @@ func (eba *ExpoBackoffAttempts) Attempt() bool {
 	}
 }
 
+// Same as Attempt(), but returns false early if the context is done, either
+// beforehand or while sleeping.
+func (eba *ExpoBackoffAttempts) AttemptContext(ctx context.Context) bool {
+	if eba.attempt >= eba.maxAttempts || ctx.Err() != nil {
+		return false
+	} else if eba.attempt > 0 && eba.backoff.SleepContext(ctx) != nil {
+		return false
+	}
+	eba.attempt += 1
+	return true
+}
+
 // Creates a new ExpoBackoffAttempts
 // Similar to ExpoBackoff, but limited in the number of times it can sleep
 // See example usage in comments for the Attempt() method
@@ -0,0 +1,95 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PhysarumSM/common/util"
+)
+
+func TestExpoBackoffNext(test *testing.T) {
+	eb, err := util.NewExpoBackoff(time.Second, 5*time.Second)
+	if err != nil {
+		test.Fatalf("NewExpoBackoff() failed:\n%v", err)
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second,
+		5 * time.Second, 5 * time.Second}
+	for i, exp := range expected {
+		if next := eb.Next(); next != exp {
+			test.Errorf("Next() call %d returned %v, expected %v", i, next, exp)
+		}
+	}
+}
+
+func TestSleepContext(test *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := util.SleepContext(ctx, time.Minute); err != context.Canceled {
+		test.Errorf("SleepContext() returned %v, expected %v", err, context.Canceled)
+	}
+	if time.Since(start) > time.Second {
+		test.Errorf("SleepContext() did not return promptly after cancellation")
+	}
+}
+
+func TestJitter(test *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := util.Jitter(10*time.Second, 0.2)
+		if d < 8*time.Second || d > 12*time.Second {
+			test.Fatalf("Jitter() returned %v, expected within 20%% of 10s", d)
+		}
+	}
+
+	if d := util.Jitter(10*time.Second, 0); d != 10*time.Second {
+		test.Errorf("Jitter() with 0 fraction returned %v, expected 10s", d)
+	}
+}
+
+func TestAttemptContext(test *testing.T) {
+	eba, err := util.NewExpoBackoffAttempts(time.Millisecond, time.Millisecond, 3)
+	if err != nil {
+		test.Fatalf("NewExpoBackoffAttempts() failed:\n%v", err)
+	}
+
+	attempts := 0
+	for eba.AttemptContext(context.Background()) {
+		attempts++
+	}
+	if attempts != 3 {
+		test.Errorf("AttemptContext() allowed %d attempts, expected 3", attempts)
+	}
+
+	eba, _ = util.NewExpoBackoffAttempts(time.Minute, time.Minute, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if !eba.AttemptContext(ctx) {
+		test.Fatalf("First AttemptContext() call was refused")
+	}
+	if eba.AttemptContext(ctx) {
+		test.Errorf("AttemptContext() allowed an attempt after the context was done")
+	}
+	if time.Since(start) > time.Second {
+		test.Errorf("AttemptContext() did not return promptly after the context was done")
+	}
+}
@@ -28,7 +28,12 @@ import (
 // possibly reduce readability and comprehension of the underlying type.
 type bootstrapAddrs []multiaddr.Multiaddr
 
-const ENV_KEY_BOOTSTRAPS = "P2P_BOOTSTRAPS"
+const (
+	ENV_KEY_BOOTSTRAPS = "P2P_BOOTSTRAPS"
+
+	// Upper bound on the number of addresses in a bootstrap list
+	MAX_BOOTSTRAPS = 1024
+)
 
 var (
 	// Stores the bootstrap multiaddrs
@@ -38,6 +43,12 @@ var (
 	// called multiple times. After the first call, it should simply
 	// return the slice of bootstrap addresses.
 	bootstrapsFlagLoaded = false
+
+	bootstrapUsage = "Multiaddress of a bootstrap node.\n" +
+		"This flag can be specified multiple times.\n" +
+		fmt.Sprintf("Alternatively, an environment variable named %s can\n"+
+			"be set with a space-separated list of bootstrap multiaddresses.",
+			ENV_KEY_BOOTSTRAPS)
 )
 
 func (addrs *bootstrapAddrs) String() string {
@@ -72,13 +83,7 @@ func GetBootstrapPointer() *bootstrapAddrs {
 // be an empty slice).
 func AddBootstrapFlags() (*[]multiaddr.Multiaddr, error) {
 	if !bootstrapsFlagLoaded {
-		flag.Var(&bootstraps, "bootstrap",
-			"Multiaddress of a bootstrap node.\n"+
-				"This flag can be specified multiple times.\n"+
-				fmt.Sprintf("Alternatively, an environment variable named %s can\n"+
-					"be set with a space-separated list of bootstrap multiaddresses.",
-					ENV_KEY_BOOTSTRAPS))
-
+		flag.Var(&bootstraps, "bootstrap", bootstrapUsage)
 		bootstrapsFlagLoaded = true
 	}
 
@@ -86,6 +91,22 @@ func AddBootstrapFlags() (*[]multiaddr.Multiaddr, error) {
 	return (*[]multiaddr.Multiaddr)(&bootstraps), nil
 }
 
+// Same as AddBootstrapFlags(), but adds the flag to the given FlagSet
+// rather than the global one. Each FlagSet has its own list of addresses.
+func AddBootstrapFlagsTo(fs *flag.FlagSet) (*[]multiaddr.Multiaddr, error) {
+	if f := fs.Lookup("bootstrap"); f != nil {
+		addrs, ok := f.Value.(*bootstrapAddrs)
+		if !ok {
+			return nil, fmt.Errorf("Flag 'bootstrap' already defined with another type")
+		}
+		return (*[]multiaddr.Multiaddr)(addrs), nil
+	}
+
+	addrs := new(bootstrapAddrs)
+	fs.Var(addrs, "bootstrap", bootstrapUsage)
+	return (*[]multiaddr.Multiaddr)(addrs), nil
+}
+
 // If the environment variable does not exist, or if there are errors during
 // parsing, return the 0-value of the return type.
 func GetEnvBootstraps() ([]multiaddr.Multiaddr, error) {
@@ -94,7 +115,7 @@ func GetEnvBootstraps() ([]multiaddr.Multiaddr, error) {
 		return nil, nil
 	}
 
-	bootstraps, err := StringsToMultiaddrs(strings.Fields(envStr))
+	bootstraps, err := ParseBootstrapList([]byte(envStr))
 	if err != nil {
 		err = fmt.Errorf("ERROR: Unable to parse environment variable %s.\n%w",
 			ENV_KEY_BOOTSTRAPS, err)
@@ -104,3 +125,36 @@ func GetEnvBootstraps() ([]multiaddr.Multiaddr, error) {
 
 	return bootstraps, nil
 }
+
+// Parses a whitespace-separated list of bootstrap multiaddresses. Lines
+// starting with '#' are treated as comments, and duplicates are dropped.
+// Each address must include the peer ID of the bootstrap (i.e. /p2p/...).
+func ParseBootstrapList(data []byte) ([]multiaddr.Multiaddr, error) {
+	var addrs bootstrapAddrs
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		for _, field := range strings.Fields(line) {
+			newAddr, err := multiaddr.NewMultiaddr(field)
+			if err != nil {
+				return nil, err
+			} else if _, err = newAddr.ValueForProtocol(multiaddr.P_P2P); err != nil {
+				return nil, fmt.Errorf("Bootstrap address %s is missing a peer ID", field)
+			}
+
+			if err = addrs.Set(field); err != nil {
+				return nil, err
+			}
+
+			if len(addrs) > MAX_BOOTSTRAPS {
+				return nil, fmt.Errorf("Bootstrap list exceeds %d addresses", MAX_BOOTSTRAPS)
+			}
+		}
+	}
+
+	return addrs, nil
+}
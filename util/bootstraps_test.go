@@ -16,6 +16,7 @@ package util_test
 
 import (
 	//"flag"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
@@ -152,3 +153,44 @@ func TestGetEnvBootstraps(test *testing.T) {
 			"none since no environment variable was set\n", len(bootstraps))
 	}
 }
+
+func TestParseBootstrapList(test *testing.T) {
+	// Same peer at many different ports, to exceed MAX_BOOTSTRAPS
+	var tooMany []string
+	for i := 0; i <= util.MAX_BOOTSTRAPS; i++ {
+		tooMany = append(tooMany, fmt.Sprintf(
+			"/ip4/10.11.17.15/tcp/%d/p2p/QmeZvvPZgrpgSLFyTYwCUEbyK6Ks8Cjm2GGrP2PA78zjAk", i+1))
+	}
+
+	testCases := []struct {
+		name      string
+		content   string
+		numAddrs  int
+		shouldErr bool
+	}{
+		// Negative test cases
+		{"BadAddr", testBadAddr, 0, true},
+		{"NoPeerID", "/ip4/10.11.17.15/tcp/4001", 0, true},
+		{"TooMany", strings.Join(tooMany, "\n"), 0, true},
+
+		// Positive test cases
+		{"Empty", "", 0, false},
+		{"Comments", "# " + testBadAddr + "\n" + testMultiAddr1, 1, false},
+		{"Duplicates", testMultiAddr1 + "\n" + testMultiAddr1, 1, false},
+		{"Multiple", testMultiAddr1 + " \t" + testMultiAddr2 + "\r\n", 2, false},
+	}
+
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			addrs, err := util.ParseBootstrapList([]byte(testCase.content))
+			if testCase.shouldErr && err == nil {
+				test.Errorf("Passed case (%s); Expected it to fail.", testCase.name)
+			} else if !testCase.shouldErr && err != nil {
+				test.Errorf("Failed case (%s); Expected it to pass.\n%v", testCase.name, err)
+			} else if len(addrs) != testCase.numAddrs {
+				test.Errorf("Case (%s) returned %d addresses, expected %d",
+					testCase.name, len(addrs), testCase.numAddrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"testing"
+
+	"github.com/PhysarumSM/common/util"
+)
+
+func TestCanonicalJSON(test *testing.T) {
+	type record struct {
+		Zeta  string            `json:"zeta"`
+		Alpha []int             `json:"alpha"`
+		Meta  map[string]string `json:"meta"`
+	}
+
+	value := record{
+		Zeta:  "<a&b>",
+		Alpha: []int{3, 1, 2},
+		Meta:  map[string]string{"y": "1", "b": "2"},
+	}
+	expected := `{"alpha":[3,1,2],"meta":{"b":"2","y":"1"},"zeta":"<a&b>"}`
+
+	data, err := util.CanonicalJSON(value)
+	if err != nil {
+		test.Fatalf("CanonicalJSON() failed with error:\n%v", err)
+	}
+	if string(data) != expected {
+		test.Errorf("Got %s, expected %s", data, expected)
+	}
+
+	// Equivalent generic value with keys inserted in a different order
+	generic := map[string]interface{}{
+		"zeta":  "<a&b>",
+		"meta":  map[string]interface{}{"y": "1", "b": "2"},
+		"alpha": []interface{}{3, 1, 2},
+	}
+	data, err = util.CanonicalJSON(generic)
+	if err != nil {
+		test.Fatalf("CanonicalJSON() failed with error:\n%v", err)
+	}
+	if string(data) != expected {
+		test.Errorf("Got %s, expected %s", data, expected)
+	}
+}
+
+func TestSignJSON(test *testing.T) {
+	priv, err := util.GeneratePrivKey("ed25519", 0)
+	if err != nil {
+		test.Fatalf("Unable to generate key:\n%v", err)
+	}
+
+	value := map[string]interface{}{"b": 1, "a": []string{"x"}}
+	sig, err := util.SignJSON(priv, value)
+	if err != nil {
+		test.Fatalf("SignJSON() failed with error:\n%v", err)
+	}
+
+	ok, err := util.VerifyJSON(priv.GetPublic(), value, sig)
+	if err != nil || !ok {
+		test.Errorf("VerifyJSON() rejected a valid signature: %v", err)
+	}
+
+	value["b"] = 2
+	ok, err = util.VerifyJSON(priv.GetPublic(), value, sig)
+	if err != nil || ok {
+		test.Errorf("VerifyJSON() accepted a signature over different data")
+	}
+}
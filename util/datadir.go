@@ -0,0 +1,178 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Current version of the data directory layout
+	DATA_DIR_VERSION = 1
+
+	// File within the data directory holding its layout version
+	DATA_DIR_VERSION_FILE = "VERSION"
+)
+
+// A migration upgrades a data directory from layout version 'From' to
+// version From+1. Migrations must be safe to re-run if interrupted.
+type dataDirMigration struct {
+	From    int
+	Migrate func(root string) error
+}
+
+// Migrations in order of the version they upgrade from. Version 0 refers
+// to data directories created before the layout was versioned.
+var dataDirMigrations = []dataDirMigration{
+	{From: 0, Migrate: migrateDataDirV0},
+}
+
+// DataDir is a versioned directory holding everything a node persists.
+// Layout (version 1):
+//  <root>/VERSION                - Layout version
+//  <root>/keys/                  - Private keys
+//  <root>/keys/identity.key      - Node identity key
+//  <root>/peerstore/             - Persistent peerstore datastore
+//  <root>/cache/                 - Caches that can be safely deleted
+//  <root>/cache/dns.json         - Cache of resolved DNS multiaddrs
+//  <root>/state.json             - Node state snapshot
+//  <root>/identity-history.json  - Signed history of identity rotations
+//
+// Programs that manage the identity key themselves can pass
+// IdentityKeyPath() to AddKeyFlags() as the default key file.
+type DataDir struct {
+	Root string
+}
+
+// Opens the data directory at 'root', creating it if it does not exist,
+// and migrating it to the current layout version if it is out of date.
+func OpenDataDir(root string) (*DataDir, error) {
+	root, err := ExpandTilde(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+
+	version, err := readDataDirVersion(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > DATA_DIR_VERSION {
+		return nil, fmt.Errorf("Data directory %s has layout version %d, "+
+			"which is newer than supported version %d", root, version, DATA_DIR_VERSION)
+	}
+
+	for _, migration := range dataDirMigrations {
+		if migration.From < version {
+			continue
+		}
+
+		log.Printf("Migrating data directory %s from version %d to %d\n",
+			root, migration.From, migration.From+1)
+		if err = migration.Migrate(root); err != nil {
+			return nil, fmt.Errorf("Unable to migrate data directory %s from version %d\n%w",
+				root, migration.From, err)
+		}
+
+		version = migration.From + 1
+		if err = writeDataDirVersion(root, version); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DataDir{Root: root}, nil
+}
+
+// Returns the layout version of the data directory, or 0 if unversioned
+func readDataDirVersion(root string) (int, error) {
+	content, err := ioutil.ReadFile(filepath.Join(root, DATA_DIR_VERSION_FILE))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("Data directory %s has an invalid version file", root)
+	}
+
+	return version, nil
+}
+
+func writeDataDirVersion(root string, version int) error {
+	return ioutil.WriteFile(filepath.Join(root, DATA_DIR_VERSION_FILE),
+		[]byte(strconv.Itoa(version)+"\n"), 0600)
+}
+
+// Version 0 is any unversioned directory. Its files may belong to something
+// else (e.g. a key at DefaultKeyPath(), or an existing directory the data
+// directory was pointed at), so nothing is moved: version 1 only adds the
+// subdirectories.
+func migrateDataDirV0(root string) error {
+	for _, dir := range []string{"keys", "peerstore", "cache"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the path of the named key file
+func (dd *DataDir) KeyPath(name string) string {
+	return filepath.Join(dd.Root, "keys", name)
+}
+
+// Returns the path of the persistent peerstore
+func (dd *DataDir) PeerstorePath() string {
+	return filepath.Join(dd.Root, "peerstore")
+}
+
+// Returns the path of the named cache file
+func (dd *DataDir) CachePath(name string) string {
+	return filepath.Join(dd.Root, "cache", name)
+}
+
+// Returns the path of the node's identity key
+func (dd *DataDir) IdentityKeyPath() string {
+	return dd.KeyPath(KEY_FILE_NAME)
+}
+
+// Returns the path of the cache of resolved DNS multiaddrs
+func (dd *DataDir) DNSCachePath() string {
+	return dd.CachePath("dns.json")
+}
+
+// Returns the path of the node state snapshot
+func (dd *DataDir) StatePath() string {
+	return filepath.Join(dd.Root, "state.json")
+}
+
+// Returns the path of the signed history of the node's identity rotations
+func (dd *DataDir) IdentityHistoryPath() string {
+	return filepath.Join(dd.Root, "identity-history.json")
+}
@@ -0,0 +1,80 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PhysarumSM/common/util"
+)
+
+func TestOpenDataDir(test *testing.T) {
+	root, err := ioutil.TempDir("", "datadir")
+	if err != nil {
+		test.Fatalf("Unable to create temp dir:\n%v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// Set up an unversioned (version 0) directory with a key in its root,
+	// as at DefaultKeyPath()
+	existingKey := filepath.Join(root, util.KEY_FILE_NAME)
+	if err = ioutil.WriteFile(existingKey, []byte("key"), 0600); err != nil {
+		test.Fatalf("Unable to create key file:\n%v", err)
+	}
+
+	test.Run("Migrate-V0", func(test *testing.T) {
+		dd, err := util.OpenDataDir(root)
+		if err != nil {
+			test.Fatalf("OpenDataDir() failed:\n%v", err)
+		}
+
+		if !util.FileExists(existingKey) {
+			test.Errorf("Migration moved %s, expected existing files to be left alone", existingKey)
+		}
+		if info, err := os.Stat(filepath.Dir(dd.KeyPath("node.key"))); err != nil || !info.IsDir() {
+			test.Errorf("Migration did not create the keys directory")
+		}
+
+		// Every persisted file is placed within the data directory
+		for _, path := range []string{dd.IdentityKeyPath(), dd.PeerstorePath(),
+			dd.DNSCachePath(), dd.StatePath(), dd.IdentityHistoryPath()} {
+
+			if !strings.HasPrefix(path, dd.Root+string(filepath.Separator)) {
+				test.Errorf("Path %s is outside the data directory %s", path, dd.Root)
+			}
+		}
+
+		// Re-opening an up-to-date directory should be a no-op
+		if _, err = util.OpenDataDir(root); err != nil {
+			test.Errorf("Re-opening data directory failed:\n%v", err)
+		}
+	})
+
+	test.Run("NewerVersion", func(test *testing.T) {
+		versionFile := filepath.Join(root, util.DATA_DIR_VERSION_FILE)
+		if err := ioutil.WriteFile(versionFile, []byte("999\n"), 0600); err != nil {
+			test.Fatalf("Unable to write version file:\n%v", err)
+		}
+
+		if _, err := util.OpenDataDir(root); err == nil {
+			test.Errorf("OpenDataDir() with newer version succeeded, expected it to fail")
+		}
+	})
+}
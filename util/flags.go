@@ -0,0 +1,62 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package util
+
+import (
+	"flag"
+	"io/ioutil"
+
+	"github.com/libp2p/go-libp2p-core/pnet"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Flags registered on their own FlagSet instead of the global one, so that
+// independent sets of flags (e.g. one per test) can be defined and parsed
+// concurrently
+type FlagContext struct {
+	*flag.FlagSet
+}
+
+// Returns a FlagContext whose Parse() returns errors rather than exiting
+func NewFlagContext(name string) *FlagContext {
+	return &FlagContext{flag.NewFlagSet(name, flag.ContinueOnError)}
+}
+
+// Returns a FlagContext for use in a single test, which does not print
+// usage or errors. Parse() the test's arguments with it, e.g.
+//
+//	fc := util.NewTestFlagContext()
+//	bootstraps, _ := fc.AddBootstrapFlags()
+//	err := fc.Parse([]string{"-bootstrap", addr})
+func NewTestFlagContext() *FlagContext {
+	fc := NewFlagContext("test")
+	fc.SetOutput(ioutil.Discard)
+	return fc
+}
+
+// See AddKeyFlags()
+func (fc *FlagContext) AddKeyFlags(defaultKeyFile string) (KeyFlags, error) {
+	return AddKeyFlagsTo(fc.FlagSet, defaultKeyFile)
+}
+
+// See AddBootstrapFlags()
+func (fc *FlagContext) AddBootstrapFlags() (*[]multiaddr.Multiaddr, error) {
+	return AddBootstrapFlagsTo(fc.FlagSet)
+}
+
+// See AddPSKFlag()
+func (fc *FlagContext) AddPSKFlag() (*pnet.PSK, error) {
+	return AddPSKFlagTo(fc.FlagSet)
+}
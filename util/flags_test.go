@@ -0,0 +1,72 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/PhysarumSM/common/util"
+)
+
+func TestFlagContext(test *testing.T) {
+	testCases := []struct {
+		name       string
+		args       []string
+		bootstraps int
+		psk        bool
+		fail       bool
+	}{
+		{"NoArgs", nil, 0, false, false},
+		{"OneBootstrap", []string{"-bootstrap", testMultiAddr1}, 1, false, false},
+		{"TwoBootstraps", []string{"-bootstrap", testMultiAddr1,
+			"-bootstrap", testMultiAddr2}, 2, false, false},
+		{"PSK", []string{"-psk", "passphrase"}, 0, true, false},
+		{"BadBootstrap", []string{"-bootstrap", testBadAddr}, 0, false, true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		test.Run(testCase.name, func(test *testing.T) {
+			test.Parallel()
+
+			fc := util.NewTestFlagContext()
+			bootstraps, err := fc.AddBootstrapFlags()
+			if err != nil {
+				test.Fatalf("ERROR: Unable to add bootstrap flags\n%v", err)
+			}
+			psk, err := fc.AddPSKFlag()
+			if err != nil {
+				test.Fatalf("ERROR: Unable to add PSK flag\n%v", err)
+			}
+			if _, err = fc.AddKeyFlags("/tmp/test.key"); err != nil {
+				test.Fatalf("ERROR: Unable to add key flags\n%v", err)
+			}
+
+			err = fc.Parse(testCase.args)
+			if testCase.fail {
+				if err == nil {
+					test.Fatalf("ERROR: Parsing %v succeeded, expected it to fail", testCase.args)
+				}
+				return
+			} else if err != nil {
+				test.Fatalf("ERROR: Unable to parse %v\n%v", testCase.args, err)
+			}
+
+			if len(*bootstraps) != testCase.bootstraps {
+				test.Errorf("ERROR: Parsed %d bootstraps, expected %d",
+					len(*bootstraps), testCase.bootstraps)
+			}
+			if (*psk != nil) != testCase.psk {
+				test.Errorf("ERROR: PSK set: %v, expected %v", *psk != nil, testCase.psk)
+			}
+		})
+	}
+}
+
+func TestFlagContextRepeatedAdd(test *testing.T) {
+	fc := util.NewTestFlagContext()
+	bootstraps, _ := fc.AddBootstrapFlags()
+	bootstraps2, err := fc.AddBootstrapFlags()
+	if err != nil || bootstraps != bootstraps2 {
+		test.Fatalf("ERROR: Subsequent calls to AddBootstrapFlags() on the same " +
+			"FlagContext should return the same value")
+	}
+}
@@ -0,0 +1,47 @@
+//go:build gofuzz
+// +build gofuzz
+
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Fuzz targets for go-fuzz (github.com/dvyukov/go-fuzz), e.g.
+//  go-fuzz-build -func FuzzKeyFile github.com/PhysarumSM/common/util
+//  go-fuzz -bin util-fuzz.zip -workdir fuzz/keyfile
+//
+// Each target returns 1 if the input parsed successfully (prioritizing it
+// in the corpus), and 0 otherwise.
+
+package util
+
+func FuzzKeyFile(data []byte) int {
+	if _, err := ParseKeyFileContents(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+func FuzzPSKFile(data []byte) int {
+	if _, err := ParsePSKFileContents(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+func FuzzBootstrapList(data []byte) int {
+	if _, err := ParseBootstrapList(data); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,121 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	// Name of the manifest written by GenerateKeyBatch()
+	KEY_MANIFEST_FILE = "manifest.json"
+
+	// Format of the key file names written by GenerateKeyBatch()
+	KEY_BATCH_FILE_FORMAT = "node-%04d.key"
+)
+
+// Describes a key file written by GenerateKeyBatch()
+type KeyManifestEntry struct {
+	File   string
+	PeerID string
+	Algo   string
+	// Hex-encoded SHA-256 digest of the marshalled public key
+	Fingerprint string
+}
+
+// Returns the hex-encoded SHA-256 digest of the marshalled public key
+func KeyFingerprint(pub crypto.PubKey) (string, error) {
+	raw, err := crypto.MarshalPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(raw)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// Generates n private keys with the given algorithm (RSA keys use
+// RSA_MIN_BITS bits) and stores them in dir, along with a manifest
+// (KEY_MANIFEST_FILE) listing each key file's peer ID and fingerprint, for
+// provisioning the identities of many nodes at once. Fails without writing
+// anything if dir already contains a manifest.
+func GenerateKeyBatch(n int, algo string, dir string) ([]KeyManifestEntry, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("Number of keys must be positive")
+	}
+
+	dir, err := ExpandTilde(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestPath := filepath.Join(dir, KEY_MANIFEST_FILE)
+	if FileExists(manifestPath) {
+		return nil, fmt.Errorf("Manifest already exists (%s).\n"+
+			"Delete it or use another directory before proceeding.", manifestPath)
+	}
+
+	manifest := make([]KeyManifestEntry, 0, n)
+	for i := 0; i < n; i++ {
+		priv, err := GeneratePrivKey(algo, RSA_MIN_BITS)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: Unable to generate key %d\n%w", i, err)
+		}
+
+		id, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint, err := KeyFingerprint(priv.GetPublic())
+		if err != nil {
+			return nil, err
+		}
+
+		file := fmt.Sprintf(KEY_BATCH_FILE_FORMAT, i)
+		if err = StorePrivKeyToFile(priv, filepath.Join(dir, file)); err != nil {
+			return nil, fmt.Errorf("ERROR: Unable to store key %d\n%w", i, err)
+		}
+
+		manifest = append(manifest, KeyManifestEntry{
+			File:        file,
+			PeerID:      id.Pretty(),
+			Algo:        algo,
+			Fingerprint: fingerprint,
+		})
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(manifestPath, append(content, '\n'), 0644); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,74 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/PhysarumSM/common/util"
+)
+
+func TestGenerateKeyBatch(test *testing.T) {
+	dir, err := ioutil.TempDir("", "keybatch")
+	if err != nil {
+		test.Fatalf("ERROR: Unable to create temp dir\n%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := util.GenerateKeyBatch(3, "Ed25519", dir)
+	if err != nil {
+		test.Fatalf("ERROR: GenerateKeyBatch() failed\n%v", err)
+	}
+	if len(manifest) != 3 {
+		test.Fatalf("ERROR: Expected 3 manifest entries, got %d", len(manifest))
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, util.KEY_MANIFEST_FILE))
+	if err != nil {
+		test.Fatalf("ERROR: Unable to read manifest\n%v", err)
+	}
+	var stored []util.KeyManifestEntry
+	if err = json.Unmarshal(content, &stored); err != nil || len(stored) != 3 {
+		test.Fatalf("ERROR: Manifest file does not match the returned manifest\n%v", err)
+	}
+
+	for _, entry := range manifest {
+		priv, err := util.LoadPrivKeyFromFile(filepath.Join(dir, entry.File))
+		if err != nil {
+			test.Fatalf("ERROR: Unable to load %s\n%v", entry.File, err)
+		}
+
+		id, _ := peer.IDFromPrivateKey(priv)
+		if id.Pretty() != entry.PeerID {
+			test.Errorf("ERROR: %s has peer ID %s, manifest lists %s",
+				entry.File, id.Pretty(), entry.PeerID)
+		}
+		fingerprint, _ := util.KeyFingerprint(priv.GetPublic())
+		if fingerprint != entry.Fingerprint {
+			test.Errorf("ERROR: %s fingerprint does not match the manifest", entry.File)
+		}
+	}
+
+	if _, err = util.GenerateKeyBatch(1, "Ed25519", dir); err == nil {
+		test.Errorf("ERROR: GenerateKeyBatch() overwrote an existing manifest")
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -29,6 +30,10 @@ import (
 
 const (
 	RSA_MIN_BITS = 2048
+
+	// Upper bound on the size of a key file, well above the size of any
+	// supported key type, to avoid parsing arbitrarily large input
+	MAX_KEY_FILE_BYTES = 64 * 1024
 )
 
 func GeneratePrivKey(algo string, bits int) (crypto.PrivKey, error) {
@@ -69,6 +74,10 @@ func StorePrivKeyToFile(priv crypto.PrivKey, keyFile string) error {
 			"Delete it or move it before proceeding.", keyFile)
 	}
 
+	if err = os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return err
+	}
+
 	file, err := os.Create(keyFile)
 	if err != nil {
 		return err
@@ -110,6 +119,15 @@ func LoadPrivKeyFromFile(keyFile string) (crypto.PrivKey, error) {
 		return nil, err
 	}
 
+	return ParseKeyFileContents(content)
+}
+
+// Parses the contents of a key file written by StorePrivKeyToFile()
+func ParseKeyFileContents(content []byte) (crypto.PrivKey, error) {
+	if len(content) > MAX_KEY_FILE_BYTES {
+		return nil, fmt.Errorf("Key file exceeds maximum size of %d bytes", MAX_KEY_FILE_BYTES)
+	}
+
 	// Strip new-line, then parse key type from key itself
 	contentStr := strings.TrimSpace(string(content))
 	spaceIdx := strings.IndexByte(contentStr, ' ')
@@ -122,18 +140,22 @@ func LoadPrivKeyFromFile(keyFile string) (crypto.PrivKey, error) {
 		return nil, err
 	}
 
-	keyB64 := contentStr[spaceIdx+1:]
-	keyRaw, err := crypto.ConfigDecodeKey(keyB64)
-	if err != nil {
-		return nil, err
-	}
-
 	// Unmarsall to create private key object
 	unmarshaller, ok := crypto.PrivKeyUnmarshallers[pb.KeyType(keyType)]
 	if !ok {
 		return nil, fmt.Errorf("Key file contains an unknown algorithm.")
 	}
 
+	keyB64 := strings.TrimSpace(contentStr[spaceIdx+1:])
+	if keyB64 == "" || strings.ContainsAny(keyB64, " \t\r\n") {
+		return nil, fmt.Errorf("Unable to load key file (may have been corrupted)")
+	}
+
+	keyRaw, err := crypto.ConfigDecodeKey(keyB64)
+	if err != nil {
+		return nil, err
+	}
+
 	return unmarshaller(keyRaw)
 }
 
@@ -149,6 +171,7 @@ type KeyFlags struct {
 // Takes a single parameter, the default key filename. This allows programs
 // to define different default key filenames, so that if two different
 // programs are run on the same host, they won't read from the same key.
+// If empty, DefaultKeyPath() is used with the program's name.
 //
 // Returns a struct containing pointers to the various variables that will
 // hold the parsed flag values once Parse() is called.
@@ -159,17 +182,39 @@ func AddKeyFlags(defaultKeyFile string) (KeyFlags, error) {
 		return KeyFlags{}, fmt.Errorf("Already parsed CLI flags, cannot add new flags")
 	}
 
+	return addKeyFlags(flag.CommandLine, defaultKeyFile)
+}
+
+// Same as AddKeyFlags(), but adds the flags to the given FlagSet rather
+// than the global one
+func AddKeyFlagsTo(fs *flag.FlagSet, defaultKeyFile string) (KeyFlags, error) {
+	if fs.Parsed() {
+		return KeyFlags{}, fmt.Errorf("Already parsed flags, cannot add new flags")
+	}
+
+	return addKeyFlags(fs, defaultKeyFile)
+}
+
+func addKeyFlags(fs *flag.FlagSet, defaultKeyFile string) (KeyFlags, error) {
+	if defaultKeyFile == "" {
+		keyPath, err := DefaultKeyPath(filepath.Base(os.Args[0]))
+		if err != nil {
+			return KeyFlags{}, err
+		}
+		defaultKeyFile = keyPath
+	}
+
 	keyFlags := KeyFlags{}
 
-	keyFlags.Algo = flag.String("algo", "RSA",
+	keyFlags.Algo = fs.String("algo", "RSA",
 		"Cryptographic algorithm to use for generating the key.\n"+
 			"Will be ignored if 'genkey' is false.\n"+
 			"Must be one of {RSA, Ed25519, Secp256k1, ECDSA}")
-	keyFlags.Bits = flag.Int("bits", 2048,
+	keyFlags.Bits = fs.Int("bits", 2048,
 		"Key length, in bits. Will be ignored if 'algo' is not RSA.")
-	keyFlags.Keyfile = flag.String("keyfile", defaultKeyFile,
+	keyFlags.Keyfile = fs.String("keyfile", defaultKeyFile,
 		"Location of private key to read from (or write to, if generating).")
-	keyFlags.Ephemeral = flag.Bool("ephemeral", false,
+	keyFlags.Ephemeral = fs.Bool("ephemeral", false,
 		"Generate a new key just for this run, and don't store it to file.\n"+
 			"If 'keyfile' is specified, it will be ignored.")
 
@@ -20,6 +20,8 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
@@ -113,7 +115,7 @@ func TestStoreKey(test *testing.T) {
 			if testCase.name == "ExistingFile" {
 				tmpFile = existingFile
 			} else {
-				tmpFile = "/tmp/tmp" + string(rand.Int())
+				tmpFile = "/tmp/tmp" + strconv.Itoa(rand.Int())
 			}
 
 			err = util.StorePrivKeyToFile(priv, tmpFile)
@@ -247,3 +249,47 @@ func TestCreateOrLoadKey(test *testing.T) {
 		}
 	})
 }
+
+func TestParseKeyFileContents(test *testing.T) {
+	priv, err := util.GeneratePrivKey("Ed25519", 0)
+	if err != nil {
+		test.Fatalf("Unable to generate test key:\n%v", err)
+	}
+
+	rawBytes, err := priv.Raw()
+	if err != nil {
+		test.Fatalf("Could not load raw bytes from test key")
+	}
+	keyB64 := crypto.ConfigEncodeKey(rawBytes)
+
+	testCases := []struct {
+		name      string
+		content   string
+		shouldErr bool
+	}{
+		// Negative test cases
+		{"Empty", "", true},
+		{"NoSpace", "1" + keyB64, true},
+		{"NoKey", "1 ", true},
+		{"BadType", "x " + keyB64, true},
+		{"UnknownType", "99 " + keyB64, true},
+		{"NegativeType", "-1 " + keyB64, true},
+		{"BadBase64", "1 !!!", true},
+		{"ExtraFields", "1 " + keyB64 + " " + keyB64, true},
+		{"TooLarge", "1 " + strings.Repeat("A", util.MAX_KEY_FILE_BYTES), true},
+
+		// Positive test cases
+		{"Valid", fmt.Sprintf("%d %s\n", priv.Type(), keyB64), false},
+	}
+
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			_, err := util.ParseKeyFileContents([]byte(testCase.content))
+			if testCase.shouldErr && err == nil {
+				test.Errorf("Passed case (%s); Expected it to fail.", testCase.name)
+			} else if !testCase.shouldErr && err != nil {
+				test.Errorf("Failed case (%s); Expected it to pass.\n%v", testCase.name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Name of the directory holding all PhysarumSM configuration
+	CONFIG_DIR_NAME = "physarumsm"
+
+	// Name of private key files within an application's config directory
+	KEY_FILE_NAME = "identity.key"
+)
+
+// Returns the platform-specific directory in which PhysarumSM programs
+// should store their configuration. This is:
+//  - Linux:   $XDG_CONFIG_HOME/physarumsm (or ~/.config/physarumsm)
+//  - macOS:   ~/Library/Application Support/physarumsm
+//  - Windows: %AppData%\physarumsm
+// The directory is not created by this function.
+func DefaultConfigDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("Unable to determine config directory\n%w", err)
+	}
+
+	return filepath.Join(configDir, CONFIG_DIR_NAME), nil
+}
+
+// Returns the default private key location for the given application,
+// within DefaultConfigDir()
+func DefaultKeyPath(appName string) (string, error) {
+	if appName == "" {
+		return "", fmt.Errorf("Application name cannot be empty")
+	}
+
+	configDir, err := DefaultConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, appName, KEY_FILE_NAME), nil
+}
@@ -0,0 +1,71 @@
+/* Copyright 2020 PhysarumSM Development Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/PhysarumSM/common/util"
+)
+
+func TestDefaultConfigDir(test *testing.T) {
+	if runtime.GOOS != "linux" {
+		test.Skip("XDG_CONFIG_HOME is only honoured on Linux")
+	}
+
+	orig, isSet := os.LookupEnv("XDG_CONFIG_HOME")
+	defer func() {
+		if isSet {
+			os.Setenv("XDG_CONFIG_HOME", orig)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+	}()
+
+	os.Setenv("XDG_CONFIG_HOME", "/tmp/xdg")
+	configDir, err := util.DefaultConfigDir()
+	if err != nil {
+		test.Fatalf("DefaultConfigDir() failed:\n%v", err)
+	}
+
+	if configDir != filepath.Join("/tmp/xdg", util.CONFIG_DIR_NAME) {
+		test.Errorf("DefaultConfigDir() returned %s, expected it under /tmp/xdg", configDir)
+	}
+}
+
+func TestDefaultKeyPath(test *testing.T) {
+	test.Run("EmptyAppName", func(test *testing.T) {
+		if _, err := util.DefaultKeyPath(""); err == nil {
+			test.Errorf("DefaultKeyPath() with empty app name succeeded, expected it to fail")
+		}
+	})
+
+	test.Run("AppName", func(test *testing.T) {
+		keyPath, err := util.DefaultKeyPath("myapp")
+		if err != nil {
+			test.Fatalf("DefaultKeyPath() failed:\n%v", err)
+		}
+
+		if filepath.Base(filepath.Dir(keyPath)) != "myapp" ||
+			filepath.Base(keyPath) != util.KEY_FILE_NAME {
+
+			test.Errorf("DefaultKeyPath() returned unexpected path %s", keyPath)
+		}
+	})
+}
@@ -20,7 +20,9 @@ import (
 	"flag"
 	"fmt"
 	"golang.org/x/crypto/sha3"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/libp2p/go-libp2p-core/pnet"
 )
@@ -38,6 +40,9 @@ const (
 	PSK_NUM_BYTES = 32
 
 	ENV_KEY_PSK = "P2P_PSK"
+
+	// Upper bound on the length of a PSK passphrase read from file
+	MAX_PSK_FILE_BYTES = 4096
 )
 
 var (
@@ -48,6 +53,14 @@ var (
 	// called multiple times. After the first call, it should simply
 	// return a pointer to the psk.
 	pskFlagLoaded = false
+
+	pskUsage = "Passphrase used to create a pre-shared key (PSK) used amongst nodes\n" +
+		"to form a private network. It is HIGHLY RECOMMENDED you use a\n" +
+		"passphrase you can easily memorize, or write it down somewhere safe.\n" +
+		"If you forget the passphrase, you will be unable to join new nodes\n" +
+		"and services to the same network.\n" +
+		fmt.Sprintf("Alternatively, an environment variable named %s can\n"+
+			"be set with the passphrase.", ENV_KEY_PSK)
 )
 
 // Generates a random PSK
@@ -110,15 +123,7 @@ func (pskVal *pskValue) Set(s string) error {
 // Sets the "-psk" flag and returns a pointer to a pre-shared key
 func AddPSKFlag() (*pnet.PSK, error) {
 	if !pskFlagLoaded {
-		flag.Var(&psk, "psk",
-			"Passphrase used to create a pre-shared key (PSK) used amongst nodes\n"+
-				"to form a private network. It is HIGHLY RECOMMENDED you use a\n"+
-				"passphrase you can easily memorize, or write it down somewhere safe.\n"+
-				"If you forget the passphrase, you will be unable to join new nodes\n"+
-				"and services to the same network.\n"+
-				fmt.Sprintf("Alternatively, an environment variable named %s can\n"+
-					"be set with the passphrase.", ENV_KEY_PSK))
-
+		flag.Var(&psk, "psk", pskUsage)
 		pskFlagLoaded = true
 	}
 
@@ -126,6 +131,22 @@ func AddPSKFlag() (*pnet.PSK, error) {
 	return &psk.hPsk, nil
 }
 
+// Same as AddPSKFlag(), but adds the flag to the given FlagSet rather than
+// the global one. Each FlagSet has its own pre-shared key.
+func AddPSKFlagTo(fs *flag.FlagSet) (*pnet.PSK, error) {
+	if f := fs.Lookup("psk"); f != nil {
+		val, ok := f.Value.(*pskValue)
+		if !ok {
+			return nil, fmt.Errorf("Flag 'psk' already defined with another type")
+		}
+		return &val.hPsk, nil
+	}
+
+	val := new(pskValue)
+	fs.Var(val, "psk", pskUsage)
+	return &val.hPsk, nil
+}
+
 // For enabling tests, ideally should not be used.
 // This is needed to return a pointer to type pskValue, a hidden type.
 // This enables tests for the Set() and String() functions above.
@@ -162,3 +183,36 @@ func GetEnvPSKString() string {
 func GetFlagPSKString() string {
 	return psk.sPsk
 }
+
+// Parses the contents of a PSK file, which holds a single passphrase on one
+// line. Surrounding whitespace is ignored. Unlike CreatePSK(), an empty
+// passphrase is an error rather than generating a random PSK.
+func ParsePSKFileContents(content []byte) (pnet.PSK, error) {
+	if len(content) > MAX_PSK_FILE_BYTES {
+		return nil, fmt.Errorf("PSK file exceeds maximum size of %d bytes", MAX_PSK_FILE_BYTES)
+	}
+
+	passphrase := strings.TrimSpace(string(content))
+	if passphrase == "" {
+		return nil, fmt.Errorf("PSK file does not contain a passphrase")
+	} else if strings.ContainsAny(passphrase, "\r\n") {
+		return nil, fmt.Errorf("PSK file must contain a single line")
+	}
+
+	return CreatePSK(passphrase)
+}
+
+// Reads and parses a PSK file, see ParsePSKFileContents()
+func LoadPSKFromFile(pskFile string) (pnet.PSK, error) {
+	pskFile, err := ExpandTilde(pskFile)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(pskFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePSKFileContents(content)
+}
@@ -19,6 +19,7 @@ import (
 	//"flag"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/PhysarumSM/common/util"
@@ -166,3 +167,35 @@ func TestGetEnvPSK(test *testing.T) {
 			"length 0 since no environment variable was set\n", len(psk))
 	}
 }
+
+func TestParsePSKFileContents(test *testing.T) {
+	testCases := []struct {
+		name      string
+		content   string
+		shouldErr bool
+	}{
+		// Negative test cases
+		{"Empty", "", true},
+		{"Whitespace", " \t\r\n", true},
+		{"MultiLine", "hello\nworld", true},
+		{"TooLarge", strings.Repeat("a", util.MAX_PSK_FILE_BYTES+1), true},
+
+		// Positive test cases
+		{"Valid", testPassphrase, false},
+		{"TrailingNewline", testPassphrase + "\n", false},
+	}
+
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			psk, err := util.ParsePSKFileContents([]byte(testCase.content))
+			if testCase.shouldErr && err == nil {
+				test.Errorf("Passed case (%s); Expected it to fail.", testCase.name)
+			} else if !testCase.shouldErr && err != nil {
+				test.Errorf("Failed case (%s); Expected it to pass.\n%v", testCase.name, err)
+			} else if !testCase.shouldErr && len(psk) != util.PSK_NUM_BYTES {
+				test.Errorf("Case (%s) returned a PSK of length %d, expected %d",
+					testCase.name, len(psk), util.PSK_NUM_BYTES)
+			}
+		})
+	}
+}